@@ -0,0 +1,212 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// activeRun is the coordinator's state for a test run that is waiting for
+// agents or running. Each active run has its own reserved set of agents.
+type activeRun struct {
+	testRun           *TestRun
+	agents            map[string]bool // Agents reserved for this run
+	phaseOrchestrator *PhaseOrchestrator
+	saturatedAgents   map[string]bool // Agents saturated during the run
+	participants      map[string]bool // Agents given a share of the load
+	excluded          map[string]bool // Agents that did not accept START, kept out of the run
+	finalReports      map[string]bool // Agents that sent their final metrics
+}
+
+// activateTestRun tracks a test run as active. Caller must hold c.mu.
+func (c *Coordinator) activateTestRun(testRun *TestRun) *activeRun {
+	run := &activeRun{
+		testRun:         testRun,
+		agents:          make(map[string]bool),
+		saturatedAgents: make(map[string]bool),
+		participants:    make(map[string]bool),
+		excluded:        make(map[string]bool),
+		finalReports:    make(map[string]bool),
+	}
+	c.activeRuns[testRun.ID] = run
+	return run
+}
+
+// deactivateTestRun stops tracking a run and releases its agents. Caller must hold c.mu.
+func (c *Coordinator) deactivateTestRun(testRunID string) *activeRun {
+	run, exists := c.activeRuns[testRunID]
+	if !exists {
+		return nil
+	}
+
+	if run.phaseOrchestrator != nil {
+		run.phaseOrchestrator.Stop()
+		run.phaseOrchestrator = nil
+	}
+
+	for agentID := range run.agents {
+		c.releaseAgent(run, agentID)
+	}
+	delete(c.activeRuns, testRunID)
+
+	return run
+}
+
+// reserveAgent reserves an agent for a run. Caller must hold c.mu.
+func (c *Coordinator) reserveAgent(run *activeRun, agentID string) {
+	run.agents[agentID] = true
+	c.agentReservations[agentID] = run.testRun.ID
+	if agent, exists := c.connectedAgents[agentID]; exists {
+		agent.TestRunID = run.testRun.ID
+	}
+}
+
+// releaseAgent returns an agent to the free pool. Caller must hold c.mu.
+func (c *Coordinator) releaseAgent(run *activeRun, agentID string) {
+	delete(run.agents, agentID)
+	if c.agentReservations[agentID] == run.testRun.ID {
+		delete(c.agentReservations, agentID)
+	}
+	if agent, exists := c.connectedAgents[agentID]; exists && agent.TestRunID == run.testRun.ID {
+		agent.TestRunID = ""
+		agent.RampUpExecution = nil
+	}
+}
+
+// reservedAgents returns the connected agents reserved for a run, ordered by
+// ID. Caller must hold c.mu.
+func (c *Coordinator) reservedAgents(testRunID string) []*AgentInfo {
+	run, exists := c.activeRuns[testRunID]
+	if !exists {
+		return nil
+	}
+
+	agents := make([]*AgentInfo, 0, len(run.agents))
+	for agentID := range run.agents {
+		if agent, connected := c.connectedAgents[agentID]; connected {
+			agents = append(agents, agent)
+		}
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].ID < agents[j].ID
+	})
+	return agents
+}
+
+// activeRunForAgent returns the run an agent is reserved for, if any. Caller must hold c.mu.
+func (c *Coordinator) activeRunForAgent(agentID string) *activeRun {
+	testRunID, reserved := c.agentReservations[agentID]
+	if !reserved {
+		return nil
+	}
+	return c.activeRuns[testRunID]
+}
+
+// tryStartTestRun reserves the run's agents and starts it if enough matching
+// agents are free, leaving excluded agents alone. It returns the number of
+// agents available to the run. Caller must hold c.mu.
+func (c *Coordinator) tryStartTestRun(run *activeRun, excluded map[string]bool) (int, bool) {
+	agents := c.selectAgentsExcluding(run.testRun, excluded)
+	if len(agents) < run.testRun.AgentCount || len(agents) == 0 {
+		return len(agents), false
+	}
+
+	c.startWithAgents(run, agents)
+	return len(agents), true
+}
+
+// startWithAgents reserves agents for a pending run and starts it. Caller must hold c.mu.
+func (c *Coordinator) startWithAgents(run *activeRun, agents []*AgentInfo) {
+	for _, agent := range agents {
+		c.reserveAgent(run, agent.ID)
+	}
+	run.testRun.MarkRunning()
+
+	go c.startTestRun(run.testRun)
+}
+
+// startWaitingRuns starts waiting and queued runs, in queue order, that now
+// have enough free agents. A run that cannot start yet holds its matching
+// agents back from the runs behind it. Caller must hold c.mu.
+func (c *Coordinator) startWaitingRuns() {
+	held := make(map[string]bool)
+	for _, run := range c.pendingRuns() {
+		status := run.testRun.Status
+		available, started := c.tryStartTestRun(run, held)
+		if started {
+			LogInfo("Starting %s test run: %s (%d agents now available)", status, run.testRun.Name, available)
+			continue
+		}
+		LogInfo("Test run still %s: %s (%d/%d matching agents free)",
+			status, run.testRun.Name, available, run.testRun.AgentCount)
+
+		for _, agent := range c.selectAgentsExcluding(run.testRun, held) {
+			held[agent.ID] = true
+		}
+	}
+}
+
+// sortedActiveRuns returns the active runs, oldest first. Caller must hold c.mu.
+func (c *Coordinator) sortedActiveRuns() []*activeRun {
+	runs := make([]*activeRun, 0, len(c.activeRuns))
+	for _, run := range c.activeRuns {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runStartedBefore(runs[i].testRun, runs[j].testRun)
+	})
+	return runs
+}
+
+// activeRunSummaries describes the active runs for status endpoints. Caller must hold c.mu.
+func (c *Coordinator) activeRunSummaries() []gin.H {
+	summaries := make([]gin.H, 0, len(c.activeRuns))
+	for _, run := range c.sortedActiveRuns() {
+		agentIDs := make([]string, 0, len(run.agents))
+		for agentID := range run.agents {
+			agentIDs = append(agentIDs, agentID)
+		}
+		sort.Strings(agentIDs)
+
+		summaries = append(summaries, gin.H{
+			"id":     run.testRun.ID,
+			"name":   run.testRun.Name,
+			"status": run.testRun.Status,
+			"agents": agentIDs,
+		})
+	}
+	return summaries
+}
+
+// scheduleCompletion finishes a running test when its duration has elapsed
+func (c *Coordinator) scheduleCompletion(testRun *TestRun) time.Duration {
+	duration, err := time.ParseDuration(testRun.TestPlan.Duration)
+	if err != nil {
+		LogWarn("Invalid duration %s, using 1m", testRun.TestPlan.Duration)
+		duration = time.Minute
+	}
+
+	started := testRun.runningSince
+	if started.IsZero() && testRun.StartedAt != nil {
+		started = *testRun.StartedAt
+	}
+	if !started.IsZero() {
+		duration -= time.Since(started)
+	}
+	if duration < 0 {
+		duration = 0
+	}
+
+	time.AfterFunc(duration, func() {
+		c.finishTestRun(testRun.ID)
+	})
+	return duration
+}
+
+func runStartedBefore(a, b *TestRun) bool {
+	if a.StartedAt == nil || b.StartedAt == nil {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.StartedAt.Before(*b.StartedAt)
+}
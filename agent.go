@@ -9,19 +9,33 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/spf13/cobra"
 )
 
+// agentIdleTimeout is how long a START waits for a previous test to wind down
+const agentIdleTimeout = 10 * time.Second
+
+// Final metrics are acknowledged by the coordinator and retried until they are
+const (
+	finalMetricsAttempts = 3
+	finalMetricsTimeout  = 5 * time.Second
+	inFlightDrainTimeout = 10 * time.Second // Longest wait for requests in flight at the end of a run
+)
+
 type Agent struct {
 	id               string
 	region           string
 	masterHost       string
 	masterPort       int
 	concurrency      int
+	weight           float64
+	labels           map[string]string
 	keepAlive        bool
 	natsConn         *nats.Conn
 	httpClient       *http.Client
@@ -33,6 +47,32 @@ type Agent struct {
 	testCompleted    bool
 	rampUpExecution  *RampUpExecution
 	rampUpCalculator *RampUpCalculator
+	calibration      *AgentCalibration
+
+	// Host resource telemetry
+	resourceSampler *resourceSampler
+	inFlight        int64 // Requests currently in flight, updated atomically
+
+	// Per-run load share assigned by the coordinator
+	runConcurrency int
+	runRPS         float64 // Share of the target request rate, enforced from the start time
+	runRateLimiter chan struct{}
+	runRateStop    chan struct{}
+	runStopCh      chan struct{} // Closed to end the current test early
+
+	// Durable publishing through the coordinator's JetStream
+	js              nats.JetStreamContext // nil when the coordinator has no JetStream
+	durableMu       sync.Mutex
+	durableBuffer   []durableMessage // Not yet stored, replayed in order after reconnecting
+	durableSeq      uint64           // Last buffer order assigned
+	durableFlushing bool             // A flush is replaying the buffer
+
+	// TLS and credentials for the coordinator's NATS server
+	natsSecurity NATSConfig
+	commands     *commandVerifier // Accepts only commands the coordinator signed
+
+	// Safety policies checked before connecting to a target
+	targets *targetGuard
 
 	// Phase execution state
 	currentPhase *PhaseInfo
@@ -48,16 +88,26 @@ type Agent struct {
 }
 
 type AgentMetrics struct {
-	AgentID      string           `json:"agent_id"`
-	Timestamp    string           `json:"timestamp"`
-	Requests     int64            `json:"requests"`
-	Errors       int64            `json:"errors"`
-	AvgLatencyMs float64          `json:"avg_latency_ms"`
-	MinLatencyMs float64          `json:"min_latency_ms"`
-	MaxLatencyMs float64          `json:"max_latency_ms"`
-	StatusCodes  map[string]int64 `json:"status_codes"`
+	AgentID      string            `json:"agent_id"`
+	TestRunID    string            `json:"test_run_id,omitempty"`
+	Timestamp    string            `json:"timestamp"`
+	Requests     int64             `json:"requests"`
+	Errors       int64             `json:"errors"`
+	AvgLatencyMs float64           `json:"avg_latency_ms"`
+	MinLatencyMs float64           `json:"min_latency_ms"`
+	MaxLatencyMs float64           `json:"max_latency_ms"`
+	StatusCodes  map[string]int64  `json:"status_codes"`
+	Latency      *LatencyHistogram `json:"latency_histogram,omitempty"`
+	Final        bool              `json:"final,omitempty"` // Authoritative snapshot sent once the agent has finished the run
+	SessionID    string            `json:"session_id"`      // Identifies this agent process
+	Seq          uint64            `json:"seq"`             // Last interval reported for the run
+	Interval     *TelemetryDelta   `json:"interval,omitempty"`
 	mu           sync.Mutex
 	totalLatency float64
+
+	// Telemetry for the interval not yet reported
+	interval  *TelemetryDelta
+	finalSent bool
 }
 
 func runAgent(cmd *cobra.Command, args []string) error {
@@ -73,6 +123,31 @@ func runAgent(cmd *cobra.Command, args []string) error {
 	devMode, _ := cmd.Flags().GetBool("dev")
 	rateLimit, _ := cmd.Flags().GetInt("rate-limit")
 	defaultThinkTimeStr, _ := cmd.Flags().GetString("default-think-time")
+	weight, _ := cmd.Flags().GetFloat64("weight")
+	labelPairs, _ := cmd.Flags().GetStringArray("label")
+	calibrate, _ := cmd.Flags().GetBool("calibrate")
+	calibrateDurationStr, _ := cmd.Flags().GetString("calibrate-duration")
+
+	labels, err := parseLabels(labelPairs)
+	if err != nil {
+		return err
+	}
+	natsSecurity, err := natsSecurityFromFlags(cmd, config.NATS)
+	if err != nil {
+		return err
+	}
+	coordinatorKey, _ := cmd.Flags().GetString("coordinator-key")
+	if coordinatorKey == "" {
+		coordinatorKey = config.Signing.CoordinatorKey
+	}
+	commands, err := newCommandVerifier(coordinatorKey)
+	if err != nil {
+		return err
+	}
+	localSafety, err := newSafetyPolicy(&config.Safety)
+	if err != nil {
+		return fmt.Errorf("invalid safety policy: %w", err)
+	}
 
 	if masterHost == "" {
 		masterHost = config.Server.Host
@@ -122,6 +197,9 @@ func runAgent(cmd *cobra.Command, args []string) error {
 	if id == "" {
 		id = fmt.Sprintf("agent-%d", time.Now().Unix())
 	}
+	if !validAgentID(id) {
+		return fmt.Errorf("invalid agent ID %q: use letters, digits, '_' and '-'", id)
+	}
 
 	agent := &Agent{
 		id:               id,
@@ -129,13 +207,22 @@ func runAgent(cmd *cobra.Command, args []string) error {
 		masterHost:       masterHost,
 		masterPort:       masterPort,
 		concurrency:      concurrency,
+		weight:           weight,
+		labels:           labels,
 		keepAlive:        keepAlive,
 		devMode:          devMode,
 		rateLimit:        rateLimit,
 		defaultThinkTime: defaultThinkTime,
+		resourceSampler:  newResourceSampler(),
+		natsSecurity:     natsSecurity,
+		commands:         commands,
+		targets:          newTargetGuard(localSafety),
 		metrics: &AgentMetrics{
 			AgentID:     id,
+			SessionID:   uuid.New().String(),
 			StatusCodes: make(map[string]int64),
+			Latency:     NewLatencyHistogram(),
+			interval:    newTelemetryDelta(),
 		},
 	}
 
@@ -147,6 +234,27 @@ func runAgent(cmd *cobra.Command, args []string) error {
 
 	agent.setupHTTPClient()
 
+	if calibrate {
+		calibrateDuration := defaultCalibrationDuration
+		if calibrateDurationStr != "" {
+			if d, err := time.ParseDuration(calibrateDurationStr); err == nil {
+				calibrateDuration = d
+			} else {
+				LogWarn("Invalid calibrate-duration '%s', using %s: %v", calibrateDurationStr, calibrateDuration, err)
+			}
+		}
+
+		LogInfo("Calibrating agent for %s with %d workers...", calibrateDuration, concurrency)
+		calibration, err := RunCalibration(concurrency, keepAlive, calibrateDuration)
+		if err != nil {
+			LogWarn("Calibration failed: %v", err)
+		} else {
+			agent.calibration = calibration
+			LogInfo("Calibration: %.0f req/s, %.2fms avg latency, %.1fus CPU per request, %d bytes per goroutine",
+				calibration.MaxRPS, calibration.AvgLatencyMs, calibration.CPUPerRequestUs, calibration.GoroutineOverheadBytes)
+		}
+	}
+
 	LogInfo("Attempting to connect to coordinator at %s:%d...", masterHost, masterPort)
 
 	if err := agent.connectToCoordinator(); err != nil {
@@ -191,6 +299,7 @@ func (a *Agent) setupHTTPClient() {
 		DisableKeepAlives: !a.keepAlive,
 		MaxIdleConns:      a.concurrency,
 		IdleConnTimeout:   30 * time.Second,
+		DialContext:       a.dialTarget,
 	}
 
 	a.httpClient = &http.Client{
@@ -204,22 +313,28 @@ func (a *Agent) connectToCoordinator() error {
 	natsURL := fmt.Sprintf("nats://%s:%d", a.masterHost, a.masterPort)
 
 	// Set connection options with timeout
+	// Reconnect for as long as the agent runs, backing off between attempts
 	opts := []nats.Option{
 		nats.Timeout(5 * time.Second),
-		nats.ReconnectWait(2 * time.Second),
-		nats.MaxReconnects(3),
+		nats.MaxReconnects(-1),
+		nats.CustomReconnectDelay(reconnectDelay),
 		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
 			LogWarn("Disconnected from coordinator: %v", err)
 		}),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
 			LogInfo("Reconnected to coordinator")
+			go a.rejoinCoordinator()
 		}),
 		nats.ClosedHandler(func(nc *nats.Conn) {
 			LogInfo("Connection to coordinator closed")
 		}),
 	}
+	securityOpts, err := a.natsSecurityOptions()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, securityOpts...)
 
-	var err error
 	a.natsConn, err = nats.Connect(natsURL, opts...)
 	if err != nil {
 		return fmt.Errorf("coordinator unavailable: %w", err)
@@ -231,20 +346,16 @@ func (a *Agent) connectToCoordinator() error {
 	}
 
 	LogInfo("Successfully connected to coordinator")
-	return nil
+	a.setupDurablePublishing()
+	return a.fetchCoordinatorKey()
 }
 
 func (a *Agent) subscribeToTestCommands() {
 	// Global test commands (for backward compatibility and non-phase tests)
-	a.natsConn.Subscribe("armonite.test.command", func(msg *nats.Msg) {
-		a.handleTestCommand(msg)
-	})
+	a.natsConn.Subscribe("armonite.test.command", a.verifiedCommand(a.handleTestCommand))
 
 	// Agent-specific commands for phase coordination
-	agentSpecificSubject := fmt.Sprintf("armonite.agent.%s.command", a.id)
-	a.natsConn.Subscribe(agentSpecificSubject, func(msg *nats.Msg) {
-		a.handleAgentCommand(msg)
-	})
+	a.natsConn.Subscribe(agentSubject(a.id, "command"), a.verifiedCommand(a.handleAgentCommand))
 }
 
 func (a *Agent) handleTestCommand(msg *nats.Msg) {
@@ -256,25 +367,80 @@ func (a *Agent) handleTestCommand(msg *nats.Msg) {
 
 	switch command.Command {
 	case "START":
+		ack := CommandAck{AgentID: a.id, TestRunID: command.TestRunID, Command: command.Command}
+
+		// A previous test may still be winding down after its run was released
+		if !a.waitUntilIdle(agentIdleTimeout) {
+			LogWarn("Ignoring test plan %s: still running the previous test", command.TestPlan.Name)
+			ack.Error = "still running the previous test"
+			a.respondAck(msg, ack)
+			return
+		}
+		// Running a run twice would replace the metrics already reported for it
+		if a.finishedRun(command.TestRunID) {
+			LogWarn("Ignoring test plan %s: already finished test run %s", command.TestPlan.Name, command.TestRunID)
+			ack.Error = "already finished this test run"
+			a.respondAck(msg, ack)
+			return
+		}
+		// Secrets of the agent's region; already loaded when the run was prepared
+		plan, err := command.TestPlan.ForRegion(a.region)
+		if err != nil {
+			plan = command.TestPlan
+		}
+		if err := a.loadSecrets(command.TestRunID, plan); err != nil {
+			LogWarn("Ignoring test plan %s: %v", command.TestPlan.Name, err)
+			ack.Error = err.Error()
+			a.respondAck(msg, ack)
+			return
+		}
+		if err := a.applySafety(command, plan); err != nil {
+			LogWarn("Ignoring test plan %s: %v", command.TestPlan.Name, err)
+			ack.Error = err.Error()
+			a.respondAck(msg, ack)
+			return
+		}
 		if command.TestRunID != "" {
 			LogInfo("Received test plan: %s (Test Run ID: %s)", command.TestPlan.Name, command.TestRunID)
+			a.mu.Lock()
 			a.currentTestRunID = command.TestRunID
+			a.mu.Unlock()
 		} else {
 			LogInfo("Received test plan: %s", command.TestPlan.Name)
 		}
+		ack.Ready = true
+		a.respondAck(msg, ack)
+
+		concurrency := a.concurrency
+		if command.Allocation != nil {
+			concurrency = command.Allocation.Concurrency
+		}
 		LogInfo("Test configuration - Duration: %s, Concurrency: %d, Endpoints: %d",
-			command.TestPlan.Duration, a.concurrency, len(command.TestPlan.Endpoints))
+			command.TestPlan.Duration, concurrency, len(command.TestPlan.Endpoints))
 		LogInfo("Starting test execution...")
 		a.sendExecutionUpdate("starting", fmt.Sprintf("Starting test execution: %s", command.TestPlan.Name))
-		a.executeTestPlan(&command.TestPlan)
+		a.executeTestPlan(&command.TestPlan, command.Allocation, parseStartTime(command.StartTime))
 	case "STOP":
-		if command.TestRunID != "" && command.TestRunID != a.currentTestRunID {
-			LogDebug("Ignoring stop command for different test run: %s (current: %s)", command.TestRunID, a.currentTestRunID)
+		// An agent not running the run has nothing to stop, which is what was asked
+		ack := CommandAck{AgentID: a.id, TestRunID: command.TestRunID, Command: command.Command, Ready: true}
+		if current := a.testRunID(); command.TestRunID != "" && command.TestRunID != current {
+			LogDebug("Ignoring stop command for different test run: %s (current: %s)", command.TestRunID, current)
+			a.respondAck(msg, ack)
 			return
 		}
 		LogInfo("Received test stop command")
 		a.sendExecutionUpdate("stopping", "Received stop command from coordinator")
+		a.mu.RLock()
+		running := a.running
+		a.mu.RUnlock()
 		a.stopTest()
+		a.respondAck(msg, ack)
+
+		// A running test reports when its workers exit; phase-orchestrated
+		// runs have no such test and report now
+		if !running {
+			go a.sendFinalMetrics()
+		}
 	}
 }
 
@@ -286,14 +452,41 @@ func (a *Agent) handleAgentCommand(msg *nats.Msg) {
 	}
 
 	switch command.Command {
+	case "PREPARE":
+		go a.handlePrepare(msg, command)
+	case "START":
+		// Per-agent START carries this agent's share of the plan load. The test
+		// runs outside the callback so later commands are still delivered.
+		go a.handleTestCommand(msg)
+	case "STOP":
+		a.handleTestCommand(msg)
+	case "REBALANCE":
+		a.rebalance(command.Allocation)
 	case "START_PHASE":
 		a.executePhase(command.CurrentPhase)
 	case "STOP_PHASE":
 		a.stopCurrentPhase()
+	case "PREFLIGHT":
+		go a.handlePreflight(msg, command)
+	case "CALIBRATE":
+		go a.handleCalibrationCommand(msg)
+	case "REGISTER":
+		// The coordinator lost track of this agent, e.g. after missed heartbeats
+		go a.registerWithCoordinator()
 	}
 }
 
-func (a *Agent) executeTestPlan(plan *TestPlan) {
+// executeTestPlan runs a test plan until its duration has elapsed or it is
+// stopped. With a non-zero startAt, load begins at that time.
+func (a *Agent) executeTestPlan(plan *TestPlan, allocation *AgentAllocation, startAt time.Time) {
+	// Apply this agent's region overrides, such as a regional base URL
+	regional, err := plan.ForRegion(a.region)
+	if err != nil {
+		LogError("Failed to apply region overrides: %v", err)
+	} else {
+		plan = &regional
+	}
+
 	a.mu.Lock()
 	if a.running {
 		a.mu.Unlock()
@@ -303,26 +496,58 @@ func (a *Agent) executeTestPlan(plan *TestPlan) {
 	a.testStarted = true
 	a.testCompleted = false
 	a.currentPlan = plan
-	a.resetMetrics()
+	a.resetMetrics(a.currentTestRunID)
+
+	// Use the coordinator's share of the plan load, or our own concurrency
+	// for plans without a global concurrency
+	concurrency := a.concurrency
+	if allocation != nil && allocation.Concurrency > 0 {
+		concurrency = allocation.Concurrency
+	}
+	a.runConcurrency = concurrency
+	a.runRPS = 0
+	if allocation != nil {
+		a.runRPS = allocation.TargetRPS
+	}
+	a.rampUpExecution = nil // Until the start time
 
 	// Initialize ramp-up strategy
 	var rampUpStrategy RampUpStrategy
 	if plan.RampUpStrategy != nil {
 		rampUpStrategy = *plan.RampUpStrategy
+		if allocation != nil {
+			// Phase concurrency is a plan-wide total like plan concurrency
+			rampUpStrategy = scaleRampUpStrategy(rampUpStrategy, concurrency, plan.Concurrency)
+		}
 	} else {
 		// Use default immediate ramp-up if no strategy specified
 		rampUpStrategy = CreateDefaultRampUp()
 	}
 
 	// Create and initialize ramp-up calculator
-	var err error
-	a.rampUpCalculator, err = NewRampUpCalculator(rampUpStrategy, a.concurrency)
+	a.rampUpCalculator, err = NewRampUpCalculator(rampUpStrategy, concurrency)
 	if err != nil {
 		LogWarn("Failed to create ramp-up calculator: %v, using immediate ramp-up", err)
-		a.rampUpCalculator, _ = NewRampUpCalculator(CreateDefaultRampUp(), a.concurrency)
+		a.rampUpCalculator, _ = NewRampUpCalculator(CreateDefaultRampUp(), concurrency)
+	}
+
+	stopCh := make(chan struct{})
+	a.runStopCh = stopCh
+	a.mu.Unlock()
+
+	// Begin with the other agents at the coordinator's common start time
+	if wait := time.Until(startAt); wait > 0 {
+		LogInfo("Starting in %s at the common start time", wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-stopCh:
+		}
 	}
 
-	// Start ramp-up execution
+	// Enforce this agent's share of the plan's target request rate, and start
+	// ramp-up execution. A rebalance while waiting may have changed the share.
+	a.mu.Lock()
+	a.setRunRate(a.runRPS)
 	a.rampUpExecution = a.rampUpCalculator.Start()
 	a.mu.Unlock()
 
@@ -340,30 +565,48 @@ func (a *Agent) executeTestPlan(plan *TestPlan) {
 
 	// Start workers with dynamic concurrency based on ramp-up strategy
 	var wg sync.WaitGroup
-	stopCh := make(chan struct{})
-	requestCh := make(chan Endpoint, a.concurrency*10) // Buffered channel for requests
-	workerControlCh := make(chan int, 1)               // Channel to control number of active workers
+	requestCh := make(chan Endpoint, concurrency*10) // Buffered channel for requests
 
 	// Start request generator
-	go a.generateRequests(plan.Endpoints, requestCh, stopCh)
-
-	// Start ramp-up controller goroutine
-	go a.rampUpController(workerControlCh, stopCh, &wg)
+	go a.generateRequests(prepareEndpoints(plan.Endpoints), requestCh, stopCh)
+
+	// Workers are only ever added; workers above the target throttle themselves
+	startedWorkers := 0
+	growWorkers := func(target int) {
+		if target > startedWorkers {
+			a.startWorkers(startedWorkers, target-startedWorkers, requestCh, stopCh, &wg)
+			startedWorkers = target
+		}
+	}
 
 	// Start with initial number of workers
+	a.mu.RLock()
 	initialConcurrency := a.rampUpCalculator.GetCurrentConcurrency(a.rampUpExecution)
-	a.startWorkers(initialConcurrency, requestCh, stopCh, &wg)
-	workerControlCh <- initialConcurrency
+	a.mu.RUnlock()
+	growWorkers(initialConcurrency)
+
+	// Start ramp-up controller goroutine, counted so the wait covers late workers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.rampUpController(growWorkers, stopCh)
+	}()
 
-	// Stop after duration
+	// Stop after duration, unless stopped early
 	time.AfterFunc(duration, func() {
-		close(stopCh)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.runStopCh == stopCh {
+			close(stopCh)
+			a.runStopCh = nil
+		}
 	})
 
 	wg.Wait()
 
 	a.mu.Lock()
 	a.running = false
+	a.stopRunRate()
 	if !a.testCompleted {
 		a.testCompleted = true
 	}
@@ -376,6 +619,7 @@ func (a *Agent) executeTestPlan(plan *TestPlan) {
 	a.mu.Unlock()
 
 	LogInfo("Load test completed")
+	a.sendFinalMetrics()
 	a.sendExecutionUpdate("completed", fmt.Sprintf("Test completed: %d requests, %d errors", requests, errors))
 }
 
@@ -400,7 +644,7 @@ func (a *Agent) generateRequests(endpoints []Endpoint, requestCh chan<- Endpoint
 }
 
 // rampUpController manages dynamic worker scaling based on ramp-up strategy
-func (a *Agent) rampUpController(workerControlCh <-chan int, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+func (a *Agent) rampUpController(growWorkers func(int), stopCh <-chan struct{}) {
 	ticker := time.NewTicker(1 * time.Second) // Check ramp-up status every second
 	defer ticker.Stop()
 
@@ -410,8 +654,6 @@ func (a *Agent) rampUpController(workerControlCh <-chan int, stopCh <-chan struc
 		select {
 		case <-stopCh:
 			return
-		case workers := <-workerControlCh:
-			currentWorkers = workers
 		case <-ticker.C:
 			a.mu.RLock()
 			if a.rampUpCalculator != nil && a.rampUpExecution != nil {
@@ -419,8 +661,7 @@ func (a *Agent) rampUpController(workerControlCh <-chan int, stopCh <-chan struc
 
 				if targetConcurrency != currentWorkers {
 					LogDebug("Ramp-up adjustment: %d -> %d workers", currentWorkers, targetConcurrency)
-					// Note: For simplicity, we're not dynamically adding/removing workers during execution
-					// Instead, we log the target concurrency change for monitoring
+					growWorkers(targetConcurrency)
 					currentWorkers = targetConcurrency
 				}
 
@@ -428,7 +669,7 @@ func (a *Agent) rampUpController(workerControlCh <-chan int, stopCh <-chan struc
 				if a.rampUpCalculator.IsComplete(a.rampUpExecution) {
 					remaining := a.rampUpCalculator.GetRemainingDuration(a.rampUpExecution)
 					if remaining <= 0 {
-						LogInfo("Ramp-up phase completed, running at full concurrency: %d", a.concurrency)
+						LogInfo("Ramp-up phase completed, running at full concurrency: %d", a.runConcurrency)
 					}
 				}
 			}
@@ -437,9 +678,9 @@ func (a *Agent) rampUpController(workerControlCh <-chan int, stopCh <-chan struc
 	}
 }
 
-// startWorkers starts the specified number of worker goroutines
-func (a *Agent) startWorkers(count int, requestCh <-chan Endpoint, stopCh <-chan struct{}, wg *sync.WaitGroup) {
-	for i := 0; i < count; i++ {
+// startWorkers starts count worker goroutines numbered from first
+func (a *Agent) startWorkers(first, count int, requestCh <-chan Endpoint, stopCh <-chan struct{}, wg *sync.WaitGroup) {
+	for i := first; i < first+count; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
@@ -480,7 +721,7 @@ func (a *Agent) worker(workerID int, requestCh <-chan Endpoint, stopCh <-chan st
 			a.waitForRateLimit()
 
 			// Execute each HTTP request in its own goroutine
-			go a.executeRequest(endpoint)
+			a.startRequest(endpoint)
 
 			// Apply think time (endpoint-specific or default)
 			thinkTime := a.getEffectiveThinkTime(endpoint)
@@ -491,7 +732,31 @@ func (a *Agent) worker(workerID int, requestCh <-chan Endpoint, stopCh <-chan st
 	}
 }
 
+// startRequest sends a request in the background, counted as in flight
+// before it is scheduled so waitForInFlight cannot miss it
+func (a *Agent) startRequest(endpoint Endpoint) {
+	atomic.AddInt64(&a.inFlight, 1)
+	go a.executeRequest(endpoint)
+}
+
+// waitForInFlight waits for requests sent before the workers stopped, up to timeout
+func (a *Agent) waitForInFlight(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&a.inFlight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func (a *Agent) executeRequest(endpoint Endpoint) {
+	defer atomic.AddInt64(&a.inFlight, -1)
+
+	endpoint, err := resolveEndpoint(endpoint)
+	if err != nil {
+		LogDebug("Failed to resolve endpoint templates: %v", err)
+		a.recordError()
+		return
+	}
+
 	start := time.Now()
 
 	var body io.Reader
@@ -531,6 +796,7 @@ func (a *Agent) recordRequest(statusCode int, latency time.Duration) {
 	latencyMs := float64(latency.Milliseconds())
 
 	a.metrics.Requests++
+	a.metrics.Latency.Record(latency)
 	a.metrics.totalLatency += latencyMs
 	a.metrics.AvgLatencyMs = a.metrics.totalLatency / float64(a.metrics.Requests)
 
@@ -550,6 +816,7 @@ func (a *Agent) recordRequest(statusCode int, latency time.Duration) {
 
 	statusStr := fmt.Sprintf("%d", statusCode)
 	a.metrics.StatusCodes[statusStr]++
+	a.metrics.interval.recordRequest(statusStr, latency, latencyMs)
 }
 
 func (a *Agent) recordError() {
@@ -557,12 +824,22 @@ func (a *Agent) recordError() {
 	defer a.metrics.mu.Unlock()
 
 	a.metrics.Errors++
+	a.metrics.interval.Errors++
 }
 
-func (a *Agent) resetMetrics() {
+// resetMetrics starts counting for a test run
+func (a *Agent) resetMetrics(testRunID string) {
 	a.metrics.mu.Lock()
 	defer a.metrics.mu.Unlock()
 
+	// PREPARE and START both reset the counters; intervals keep counting
+	// through a run and start again for the next one
+	if a.metrics.TestRunID != testRunID {
+		a.metrics.Seq = 0
+		a.metrics.finalSent = false
+	}
+	a.metrics.TestRunID = testRunID
+	a.metrics.interval = newTelemetryDelta()
 	a.metrics.Requests = 0
 	a.metrics.Errors = 0
 	a.metrics.AvgLatencyMs = 0
@@ -570,6 +847,7 @@ func (a *Agent) resetMetrics() {
 	a.metrics.MaxLatencyMs = 0
 	a.metrics.totalLatency = 0
 	a.metrics.StatusCodes = make(map[string]int64)
+	a.metrics.Latency = NewLatencyHistogram()
 }
 
 func (a *Agent) startMetricsReporting() {
@@ -596,25 +874,87 @@ func (a *Agent) startMetricsReporting() {
 	}()
 }
 
+// reportMetrics sends the interval since the last report. Intervals are sent
+// from PREPARE until the final metrics, including intervals without requests.
 func (a *Agent) reportMetrics() {
 	a.metrics.mu.Lock()
-	if a.metrics.Requests == 0 {
+	if a.metrics.TestRunID == "" || a.metrics.finalSent {
 		a.metrics.mu.Unlock()
 		return
 	}
+	delta := a.metrics.takeInterval()
+	metricsData, err := json.Marshal(delta)
+	a.metrics.mu.Unlock()
+
+	if err != nil {
+		LogError("Failed to marshal metrics: %v", err)
+		return
+	}
+
+	msgID := a.durableMessageID(delta.TestRunID, fmt.Sprintf("%d", delta.Seq))
+	if err := a.publishDurable(agentSubject(a.id, agentTelemetryKind), metricsData, msgID); err != nil {
+		LogWarn("Metrics interval %d buffered: %v", delta.Seq, err)
+	}
+}
 
+// takeInterval numbers the current interval and starts the next one. Caller
+// must hold m.mu.
+func (m *AgentMetrics) takeInterval() *TelemetryDelta {
+	m.Seq++
+	delta := m.interval
+	delta.AgentID = m.AgentID
+	delta.TestRunID = m.TestRunID
+	delta.SessionID = m.SessionID
+	delta.Seq = m.Seq
+	delta.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	m.interval = newTelemetryDelta()
+	return delta
+}
+
+// sendFinalMetrics sends the authoritative metrics of the finished run,
+// including runs with no requests, and waits for the coordinator to confirm them
+func (a *Agent) sendFinalMetrics() {
+	a.waitForInFlight(inFlightDrainTimeout)
+
+	// The last interval travels with the final metrics so the intervals
+	// add up to them
+	a.metrics.mu.Lock()
+	if a.metrics.TestRunID != "" && !a.metrics.finalSent {
+		a.metrics.Interval = a.metrics.takeInterval()
+	}
+	a.metrics.finalSent = true
 	a.metrics.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	a.metrics.Final = true
 	metricsData, err := json.Marshal(a.metrics)
+	a.metrics.Final = false
+	a.metrics.Interval = nil
+	testRunID := a.metrics.TestRunID
 	a.metrics.mu.Unlock()
 
 	if err != nil {
-		LogError("Failed to marshal metrics: %v", err)
+		LogError("Failed to marshal final metrics: %v", err)
+		return
+	}
+
+	// Once stored, the final metrics reach the coordinator; if the stream
+	// cannot be reached they are replayed after reconnecting
+	if a.hasDurablePublishing() {
+		if err := a.publishDurable(agentSubject(a.id, agentFinalKind), metricsData, a.durableMessageID(testRunID, "final")); err != nil {
+			LogWarn("Final metrics buffered until the coordinator is reachable: %v", err)
+			return
+		}
+		LogInfo("Final metrics reported to coordinator")
 		return
 	}
 
-	if err := a.natsConn.Publish("armonite.telemetry", metricsData); err != nil {
-		LogError("Failed to publish metrics: %v", err)
+	for attempt := 1; attempt <= finalMetricsAttempts; attempt++ {
+		if _, err = a.natsConn.Request(agentSubject(a.id, agentFinalKind), metricsData, finalMetricsTimeout); err == nil {
+			LogInfo("Final metrics reported to coordinator")
+			return
+		}
+		LogWarn("Final metrics not confirmed (attempt %d/%d): %v", attempt, finalMetricsAttempts, err)
 	}
+	LogError("Giving up on reporting final metrics: %v", err)
 }
 
 func (a *Agent) executePhase(phase *PhaseInfo) {
@@ -661,9 +1001,11 @@ func (a *Agent) executePhase(phase *PhaseInfo) {
 	}
 
 	// Send completion to coordinator
-	subject := fmt.Sprintf("armonite.phase.complete.%s", a.currentTestRunID)
-	if err := a.natsConn.Publish(subject, data); err != nil {
-		LogError("Failed to send phase completion: %v", err)
+	testRunID := a.testRunID()
+	subject := agentSubject(a.id, agentPhaseKind+"."+testRunID)
+	msgID := a.durableMessageID(testRunID, fmt.Sprintf("phase-%d", phase.PhaseIndex))
+	if err := a.publishDurable(subject, data, msgID); err != nil {
+		LogWarn("Phase completion buffered: %v", err)
 	}
 
 	LogInfo("Agent %s completed phase %d", a.id, phase.PhaseIndex)
@@ -690,7 +1032,7 @@ func (a *Agent) executePhaseLoad(concurrency int, duration time.Duration, phase
 	// Start request generator if we have a current plan
 	a.mu.RLock()
 	if a.currentPlan != nil {
-		go a.generateRequests(a.currentPlan.Endpoints, requestCh, stopCh)
+		go a.generateRequests(prepareEndpoints(a.currentPlan.Endpoints), requestCh, stopCh)
 	}
 	a.mu.RUnlock()
 
@@ -723,7 +1065,7 @@ func (a *Agent) phaseWorker(workerID int, requestCh <-chan Endpoint, stopCh <-ch
 			a.waitForRateLimit()
 
 			// Execute HTTP request
-			go a.executeRequest(endpoint)
+			a.startRequest(endpoint)
 
 			// Apply think time (endpoint-specific or default)
 			thinkTime := a.getEffectiveThinkTime(endpoint)
@@ -751,11 +1093,11 @@ func (a *Agent) sendExecutionUpdate(status, message string) {
 		"agent_id":  a.id,
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 		"status":    status,
-		"message":   message,
+		"message":   redactSecrets(message),
 	}
 
-	if a.currentTestRunID != "" {
-		update["test_run_id"] = a.currentTestRunID
+	if testRunID := a.testRunID(); testRunID != "" {
+		update["test_run_id"] = testRunID
 	}
 
 	data, err := json.Marshal(update)
@@ -764,8 +1106,25 @@ func (a *Agent) sendExecutionUpdate(status, message string) {
 		return
 	}
 
-	if err := a.natsConn.Publish("armonite.agent.status", data); err != nil {
-		LogError("Failed to send execution update: %v", err)
+	if err := a.publishDurable(agentSubject(a.id, agentExecutionKind), data, ""); err != nil {
+		LogWarn("Execution update buffered: %v", err)
+	}
+}
+
+// waitUntilIdle waits up to timeout for the current test to finish
+func (a *Agent) waitUntilIdle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		a.mu.RLock()
+		running := a.running
+		a.mu.RUnlock()
+		if !running {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
 }
 
@@ -773,7 +1132,13 @@ func (a *Agent) stopTest() {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	a.running = false
+	// End the current test; executeTestPlan clears running once workers exit
+	if a.runStopCh != nil {
+		close(a.runStopCh)
+		a.runStopCh = nil
+	} else {
+		a.running = false
+	}
 	a.testCompleted = true
 
 	// Stop any running phase
@@ -808,6 +1173,122 @@ func (a *Agent) waitForRateLimit() {
 	if a.rateLimiter != nil {
 		<-a.rateLimiter
 	}
+
+	// The per-run limiter is replaced on rebalance, so wait on the current one
+	for {
+		a.mu.RLock()
+		runRateLimiter, runRateStop := a.runRateLimiter, a.runRateStop
+		a.mu.RUnlock()
+
+		if runRateLimiter == nil {
+			return
+		}
+
+		select {
+		case <-runRateLimiter:
+			return
+		case <-runRateStop:
+			// Limiter replaced or removed, check again
+		}
+	}
+}
+
+// setRunRate replaces the per-run rate limiter; rps <= 0 removes it. Caller must hold a.mu.
+func (a *Agent) setRunRate(rps float64) {
+	a.stopRunRate()
+	if rps <= 0 {
+		return
+	}
+
+	a.runRateLimiter = make(chan struct{}, int(rps)+1)
+	a.runRateStop = make(chan struct{})
+	go fillRateLimiter(a.runRateLimiter, rps, a.runRateStop)
+	LogInfo("Target rate for this agent: %.1f req/s", rps)
+}
+
+// stopRunRate removes the per-run rate limiter. Caller must hold a.mu.
+func (a *Agent) stopRunRate() {
+	if a.runRateStop != nil {
+		close(a.runRateStop)
+		a.runRateStop = nil
+	}
+	a.runRateLimiter = nil
+}
+
+// rebalance applies a new share of the plan load to the running test. The
+// ramp-up keeps its original start time, scaled to the new share. Before the
+// common start time, the new share replaces the one START gave.
+func (a *Agent) rebalance(allocation *AgentAllocation) {
+	if allocation == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.running || a.rampUpCalculator == nil {
+		LogDebug("Ignoring rebalance: no test running")
+		return
+	}
+
+	if a.rampUpExecution == nil {
+		strategy := scaleRampUpStrategy(a.rampUpCalculator.strategy, allocation.Concurrency, a.runConcurrency)
+		calculator, err := NewRampUpCalculator(strategy, allocation.Concurrency)
+		if err != nil {
+			LogWarn("Failed to apply rebalance: %v", err)
+			return
+		}
+		LogInfo("Rebalanced by coordinator before the start: %d -> %d workers", a.runConcurrency, allocation.Concurrency)
+		a.rampUpCalculator = calculator
+		a.runConcurrency = allocation.Concurrency
+		a.runRPS = allocation.TargetRPS
+		return
+	}
+
+	strategy := scaleRampUpStrategy(a.rampUpExecution.Strategy, allocation.Concurrency, a.runConcurrency)
+	calculator, err := NewRampUpCalculator(strategy, allocation.Concurrency)
+	if err != nil {
+		LogWarn("Failed to apply rebalance: %v", err)
+		return
+	}
+
+	LogInfo("Rebalanced by coordinator: %d -> %d workers", a.runConcurrency, allocation.Concurrency)
+	a.rampUpCalculator = calculator
+	a.rampUpExecution.Strategy = strategy
+	a.rampUpExecution.MaxConcurrency = allocation.Concurrency
+	a.runConcurrency = allocation.Concurrency
+	a.runRPS = allocation.TargetRPS
+	a.setRunRate(allocation.TargetRPS)
+}
+
+// fillRateLimiter adds tokens to limiter at rps until stopCh is closed.
+// High rates are filled in batches to keep the ticker interval reasonable.
+func fillRateLimiter(limiter chan struct{}, rps float64, stopCh <-chan struct{}) {
+	interval := time.Duration(float64(time.Second) / rps)
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	perTick := rps * interval.Seconds()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var owed float64
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			owed += perTick
+			for ; owed >= 1; owed-- {
+				select {
+				case limiter <- struct{}{}:
+				default:
+					// Limiter is full, drop the token
+				}
+			}
+		}
+	}
 }
 
 // getEffectiveThinkTime returns the think time to use, preferring endpoint-specific over default
@@ -2,40 +2,51 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats.go"
 )
 
 type AgentRegistration struct {
-	AgentID     string `json:"agent_id"`
-	Region      string `json:"region"`
-	Concurrency int    `json:"concurrency"`
-	Status      string `json:"status"`
-	Timestamp   string `json:"timestamp"`
-	Action      string `json:"action"` // "register", "unregister"
+	AgentID     string            `json:"agent_id"`
+	Region      string            `json:"region"`
+	Concurrency int               `json:"concurrency"`
+	Weight      float64           `json:"weight,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Status      string            `json:"status"`
+	Timestamp   string            `json:"timestamp"`
+	Action      string            `json:"action"`                // "register", "unregister"
+	Calibration *AgentCalibration `json:"calibration,omitempty"` // Set when the agent ran --calibrate
+	Run         *AgentRunState    `json:"run,omitempty"`         // The run the agent is taking part in, if any
 }
 
 type AgentHeartbeat struct {
-	AgentID   string `json:"agent_id"`
-	Timestamp string `json:"timestamp"`
+	AgentID   string              `json:"agent_id"`
+	Timestamp string              `json:"timestamp"`
+	Resources *AgentResourceUsage `json:"resources,omitempty"`
 }
 
 type AgentExecutionUpdate struct {
 	AgentID   string `json:"agent_id"`
-	Status    string `json:"status"` // "starting", "running", "stopping", "completed"
+	Status    string `json:"status"` // "starting", "running", "stopping", "completed", "target_blocked"
 	Message   string `json:"message"`
 	Timestamp string `json:"timestamp"`
+	TestRunID string `json:"test_run_id,omitempty"`
 }
 
 func (c *Coordinator) startAgentRegistration() {
 	// Subscribe to agent registration messages
-	_, err := c.natsConn.Subscribe("armonite.agent.register", func(msg *nats.Msg) {
+	_, err := c.natsConn.Subscribe(agentSubject("*", agentRegisterKind), func(msg *nats.Msg) {
 		var registration AgentRegistration
 		if err := json.Unmarshal(msg.Data, &registration); err != nil {
 			LogError("Failed to unmarshal agent registration: %v", err)
 			return
 		}
+		if !checkSender(msg, registration.AgentID) {
+			return
+		}
 
 		switch registration.Action {
 		case "register":
@@ -50,12 +61,15 @@ func (c *Coordinator) startAgentRegistration() {
 	}
 
 	// Subscribe to agent heartbeats
-	_, err = c.natsConn.Subscribe("armonite.agent.heartbeat", func(msg *nats.Msg) {
+	_, err = c.natsConn.Subscribe(agentSubject("*", agentHeartbeatKind), func(msg *nats.Msg) {
 		var heartbeat AgentHeartbeat
 		if err := json.Unmarshal(msg.Data, &heartbeat); err != nil {
 			LogError("Failed to unmarshal agent heartbeat: %v", err)
 			return
 		}
+		if !checkSender(msg, heartbeat.AgentID) {
+			return
+		}
 
 		c.handleAgentHeartbeat(heartbeat)
 	})
@@ -64,13 +78,16 @@ func (c *Coordinator) startAgentRegistration() {
 		return
 	}
 
-	// Subscribe to agent execution updates
-	_, err = c.natsConn.Subscribe("armonite.agent.execution", func(msg *nats.Msg) {
+	// Subscribe to agent execution updates, kept in a stream while the coordinator is away
+	err = c.subscribeDurable(eventsStream, executionConsumer, executionSubjects, func(msg *nats.Msg) {
 		var update AgentExecutionUpdate
 		if err := json.Unmarshal(msg.Data, &update); err != nil {
 			LogError("Failed to unmarshal execution update: %v", err)
 			return
 		}
+		if !checkSender(msg, update.AgentID) {
+			return
+		}
 
 		c.handleAgentExecutionUpdate(update)
 	})
@@ -96,39 +113,51 @@ func (c *Coordinator) handleAgentRegistration(registration AgentRegistration) {
 		ConnectedAt: now,
 		LastSeen:    now,
 		Concurrency: registration.Concurrency,
+		Weight:      registration.Weight,
+		Labels:      registration.Labels,
+		Calibration: registration.Calibration,
 	}
 
-	// Initialize ramp-up execution if there's a current test run with ramp-up strategy
-	if c.currentTestRun != nil && c.currentTestRun.TestPlan.RampUpStrategy != nil {
-		calculator, err := NewRampUpCalculator(*c.currentTestRun.TestPlan.RampUpStrategy, registration.Concurrency)
-		if err == nil {
-			agentInfo.RampUpExecution = calculator.Start()
-		}
+	// Check if this is a new agent; a re-registering agent keeps its reservation
+	existing, exists := c.connectedAgents[registration.AgentID]
+	if exists {
+		agentInfo.RampUpExecution = existing.RampUpExecution
 	}
-
-	// Check if this is a new agent
-	_, exists := c.connectedAgents[registration.AgentID]
+	agentInfo.TestRunID = c.agentReservations[registration.AgentID]
 	c.connectedAgents[registration.AgentID] = agentInfo
 
+	// An agent back from a disconnect returns to its run rather than taking new work
+	if c.resumeAgentRun(registration.AgentID, registration.Run) {
+		return
+	}
+
 	if !exists {
 		LogInfo("Agent registered: %s (region: %s, concurrency: %d)",
 			registration.AgentID, registration.Region, registration.Concurrency)
 		LogInfo("Total connected agents: %d", len(c.connectedAgents))
 
-		// Check if there's a waiting test run that can now start
-		if c.currentTestRun != nil && c.currentTestRun.Status == TestRunStatusWaiting {
-			if len(c.connectedAgents) >= c.currentTestRun.AgentCount {
-				LogInfo("Starting waiting test run: %s (%d agents now available)",
-					c.currentTestRun.Name, len(c.connectedAgents))
-				go c.startTestRun(c.currentTestRun)
-			} else {
-				LogInfo("Test run still waiting for agents: %s (%d/%d connected)",
-					c.currentTestRun.Name, len(c.connectedAgents), c.currentTestRun.AgentCount)
-			}
-		} else if c.currentTestRun == nil {
+		if len(c.activeRuns) == 0 {
 			LogInfo("Agent registered - coordinator idle, waiting for test run")
-		} else {
-			LogInfo("Agent joined during active test: %s", registration.AgentID)
+			return
+		}
+
+		// A waiting test run may now be able to start
+		c.startWaitingRuns()
+
+		// Otherwise the agent may join a running test
+		for _, run := range c.sortedActiveRuns() {
+			if c.agentReservations[registration.AgentID] != "" {
+				break
+			}
+			if registration.Run != nil && registration.Run.TestRunID == run.testRun.ID {
+				continue // The agent already finished its part of this run
+			}
+			if run.testRun.Status == TestRunStatusRunning {
+				c.rebalanceTestRun(run.testRun, TestRunEventAgentJoined, registration.AgentID)
+			}
+		}
+		if testRunID := c.agentReservations[registration.AgentID]; testRunID != "" {
+			LogInfo("Agent %s reserved for test run %s", registration.AgentID, testRunID)
 		}
 	} else {
 		LogDebug("Agent re-registered: %s", registration.AgentID)
@@ -140,19 +169,72 @@ func (c *Coordinator) handleAgentUnregistration(registration AgentRegistration)
 	defer c.mu.Unlock()
 
 	if _, exists := c.connectedAgents[registration.AgentID]; exists {
-		delete(c.connectedAgents, registration.AgentID)
+		c.removeAgent(registration.AgentID)
 		LogInfo("Agent unregistered: %s", registration.AgentID)
 		LogInfo("Total connected agents: %d", len(c.connectedAgents))
 	}
 }
 
+// removeAgent drops a departed agent, releasing it from its run and
+// rebalancing that run's load. Caller must hold c.mu.
+func (c *Coordinator) removeAgent(agentID string) {
+	delete(c.connectedAgents, agentID)
+
+	run := c.activeRunForAgent(agentID)
+	if run == nil {
+		return
+	}
+	c.releaseAgent(run, agentID)
+	c.rebalanceTestRun(run.testRun, TestRunEventAgentLeft, agentID)
+}
+
 func (c *Coordinator) handleAgentHeartbeat(heartbeat AgentHeartbeat) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if agent, exists := c.connectedAgents[heartbeat.AgentID]; exists {
-		agent.LastSeen = time.Now()
-		LogDebug("Heartbeat from agent: %s", heartbeat.AgentID)
+	agent, exists := c.connectedAgents[heartbeat.AgentID]
+	if !exists {
+		// Dropped after missed heartbeats; have it register again with its
+		// run. Heartbeats buffered during a disconnect arrive late in a burst.
+		if sent, err := time.Parse(time.RFC3339, heartbeat.Timestamp); err == nil && time.Since(sent) < staleHeartbeatAge {
+			LogInfo("Heartbeat from unregistered agent %s, asking it to register", heartbeat.AgentID)
+			c.sendAgentCommand(heartbeat.AgentID, TestStartCommand{Command: "REGISTER"})
+		}
+		return
+	}
+
+	agent.LastSeen = time.Now()
+	LogDebug("Heartbeat from agent: %s", heartbeat.AgentID)
+
+	if heartbeat.Resources == nil {
+		return
+	}
+
+	reasons := c.config.Saturation.Evaluate(heartbeat.Resources)
+	wasSaturated := agent.Saturated
+	agent.Resources = heartbeat.Resources
+	agent.Saturated = len(reasons) > 0
+	agent.SaturationReasons = reasons
+
+	if !agent.Saturated {
+		if wasSaturated {
+			LogInfo("Agent %s is no longer saturated", agent.ID)
+		}
+		return
+	}
+
+	if !wasSaturated {
+		LogWarn("Agent %s is saturated: %s", agent.ID, strings.Join(reasons, ", "))
+	}
+
+	// An overloaded load generator distorts latency, so remember it for the run's results
+	if run := c.activeRunForAgent(agent.ID); run != nil && run.testRun.Status == TestRunStatusRunning {
+		if !run.saturatedAgents[agent.ID] {
+			run.saturatedAgents[agent.ID] = true
+			run.testRun.Warnings = append(run.testRun.Warnings, fmt.Sprintf(
+				"agent %s was saturated during the run (%s); its latency numbers are suspect",
+				agent.ID, strings.Join(reasons, ", ")))
+		}
 	}
 }
 
@@ -160,6 +242,15 @@ func (c *Coordinator) handleAgentExecutionUpdate(update AgentExecutionUpdate) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// A blocked target is recorded on the run; the agent's state is unchanged
+	if update.Status == TestRunEventTargetBlocked {
+		LogWarn("Agent %s blocked a target: %s", update.AgentID, update.Message)
+		if testRun, exists := c.testRuns[update.TestRunID]; exists {
+			testRun.AddEvent(TestRunEvent{Type: TestRunEventTargetBlocked, AgentID: update.AgentID, Message: update.Message})
+		}
+		return
+	}
+
 	// Update agent execution state
 	if agent, exists := c.connectedAgents[update.AgentID]; exists {
 		agent.ExecutionState = update.Status
@@ -201,7 +292,7 @@ func (c *Coordinator) cleanupStaleAgents() {
 
 	for agentID, agent := range c.connectedAgents {
 		if now.Sub(agent.LastSeen) > staleThreshold {
-			delete(c.connectedAgents, agentID)
+			c.removeAgent(agentID)
 			LogWarn("Removed stale agent: %s (last seen: %s)",
 				agentID, agent.LastSeen.Format("15:04:05"))
 			LogInfo("Total connected agents: %d", len(c.connectedAgents))
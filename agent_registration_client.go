@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"sync/atomic"
 	"time"
 )
 
@@ -10,9 +11,13 @@ func (a *Agent) registerWithCoordinator() error {
 		AgentID:     a.id,
 		Region:      a.region,
 		Concurrency: a.concurrency,
+		Weight:      a.weight,
+		Labels:      a.labels,
 		Status:      "ready",
 		Timestamp:   time.Now().UTC().Format(time.RFC3339),
 		Action:      "register",
+		Calibration: a.calibration,
+		Run:         a.runState(),
 	}
 
 	data, err := json.Marshal(registration)
@@ -20,12 +25,16 @@ func (a *Agent) registerWithCoordinator() error {
 		return err
 	}
 
-	err = a.natsConn.Publish("armonite.agent.register", data)
+	err = a.natsConn.Publish(agentSubject(a.id, agentRegisterKind), data)
 	if err != nil {
 		return err
 	}
 
-	LogInfo("Registered with coordinator")
+	if registration.Run != nil {
+		LogInfo("Registered with coordinator (test run %s %s)", registration.Run.TestRunID, registration.Run.State)
+	} else {
+		LogInfo("Registered with coordinator")
+	}
 	return nil
 }
 
@@ -45,7 +54,7 @@ func (a *Agent) unregisterFromCoordinator() {
 		return
 	}
 
-	if err := a.natsConn.Publish("armonite.agent.register", data); err != nil {
+	if err := a.natsConn.Publish(agentSubject(a.id, agentRegisterKind), data); err != nil {
 		LogError("Failed to unregister: %v", err)
 	} else {
 		LogInfo("Unregistered from coordinator")
@@ -53,7 +62,9 @@ func (a *Agent) unregisterFromCoordinator() {
 }
 
 func (a *Agent) startHeartbeat() {
-	ticker := time.NewTicker(30 * time.Second)
+	// Heartbeats carry resource telemetry, so send them often enough to
+	// notice saturation while a test is running
+	ticker := time.NewTicker(10 * time.Second)
 	go func() {
 		defer ticker.Stop()
 		for range ticker.C {
@@ -66,6 +77,7 @@ func (a *Agent) sendHeartbeat() {
 	heartbeat := AgentHeartbeat{
 		AgentID:   a.id,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Resources: a.resourceSampler.Sample(atomic.LoadInt64(&a.inFlight)),
 	}
 
 	data, err := json.Marshal(heartbeat)
@@ -74,7 +86,7 @@ func (a *Agent) sendHeartbeat() {
 		return
 	}
 
-	if err := a.natsConn.Publish("armonite.agent.heartbeat", data); err != nil {
+	if err := a.natsConn.Publish(agentSubject(a.id, agentHeartbeatKind), data); err != nil {
 		LogDebug("Failed to send heartbeat: %v", err)
 	} else {
 		LogDebug("Sent heartbeat to coordinator")
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// States of the run an agent reports when it registers
+const (
+	AgentRunPrepared = "prepared" // Prepared but never started, e.g. START was lost while disconnected
+	AgentRunRunning  = "running"
+	AgentRunFinished = "finished" // Final metrics sent
+)
+
+// TestRunEventAgentResumed is recorded when an agent returns to a run after a disconnect
+const TestRunEventAgentResumed = "agent_resumed"
+
+// staleHeartbeatAge is the age past which a heartbeat was held back by a
+// disconnect rather than just sent
+const staleHeartbeatAge = 15 * time.Second
+
+// AgentRunState is the run an agent is taking part in, as it reports when it
+// registers or re-registers
+type AgentRunState struct {
+	TestRunID string `json:"test_run_id"`
+	SessionID string `json:"session_id"` // Telemetry session; the same session continues its sequence numbers
+	State     string `json:"state"`      // prepared, running or finished
+}
+
+// resumeAgentRun returns a re-registering agent to the run it reports. An
+// agent still running its share carries on; one that never got its START runs
+// the rest of the test. Its telemetry session continues, so intervals it
+// reported before are not counted again. It returns whether the agent is back
+// in the run. Caller must hold c.mu.
+func (c *Coordinator) resumeAgentRun(agentID string, state *AgentRunState) bool {
+	if state == nil || state.State == AgentRunFinished {
+		return false
+	}
+
+	run, active := c.activeRuns[state.TestRunID]
+	if !active {
+		if state.State == AgentRunRunning {
+			LogWarn("Agent %s is still running test run %s, which is no longer active; stopping it", agentID, state.TestRunID)
+			c.sendAgentCommand(agentID, TestStartCommand{TestRunID: state.TestRunID, Command: "STOP"})
+		}
+		return false
+	}
+	if reservedFor, reserved := c.agentReservations[agentID]; reserved && reservedFor != run.testRun.ID {
+		return false
+	}
+	if run.excluded[agentID] {
+		// It did not accept START; if it started after all, it was told to stop
+		if state.State == AgentRunRunning {
+			c.sendAgentCommand(agentID, TestStartCommand{TestRunID: state.TestRunID, Command: "STOP"})
+		}
+		return false
+	}
+
+	testRun := run.testRun
+	switch testRun.Status {
+	case TestRunStatusCompleting:
+		// Stopping agents and waiting for final metrics covers it again
+		if state.State == AgentRunRunning {
+			c.reserveAgent(run, agentID)
+			run.participants[agentID] = true
+		}
+		return state.State == AgentRunRunning
+	case TestRunStatusRunning:
+	default:
+		return false
+	}
+
+	duration, err := time.ParseDuration(testRun.TestPlan.Duration)
+	if err != nil {
+		return false
+	}
+	remaining := (duration - time.Since(testRun.runningSince)).Truncate(time.Second)
+
+	var share AgentAllocation
+	for _, allocation := range testRun.Allocation {
+		if allocation.AgentID == agentID {
+			share = allocation
+		}
+	}
+	constant := testRun.TestPlan.Rebalance == RebalanceConstant && run.phaseOrchestrator == nil
+
+	switch state.State {
+	case AgentRunRunning:
+		if run.agents[agentID] && share.Concurrency > 0 {
+			LogDebug("Agent %s re-registered while running test run %s", agentID, testRun.Name)
+			return true
+		}
+		c.reserveAgent(run, agentID)
+		run.participants[agentID] = true
+		if share.Concurrency == 0 && constant {
+			// Its load went to the other agents when it was dropped; take it back
+			c.rebalanceTestRun(testRun, TestRunEventAgentResumed, agentID)
+			return true
+		}
+		testRun.AddEvent(TestRunEvent{
+			Type:    TestRunEventAgentResumed,
+			AgentID: agentID,
+			Message: fmt.Sprintf("agent %s reconnected with %s remaining and carries on with its share", agentID, remaining),
+		})
+
+	case AgentRunPrepared:
+		if run.phaseOrchestrator != nil || remaining < minRebalanceRemaining {
+			return false
+		}
+		if share.Concurrency == 0 {
+			if !constant {
+				return false
+			}
+			c.rebalanceTestRun(testRun, TestRunEventAgentJoined, agentID)
+			return c.agentReservations[agentID] == testRun.ID
+		}
+
+		c.reserveAgent(run, agentID)
+		run.participants[agentID] = true
+
+		latePlan := testRun.TestPlan
+		latePlan.Duration = remaining.String()
+		latePlan.RampUpStrategy = nil
+		c.sendLateStart(testRun, latePlan, share, time.Now().UTC().Format(time.RFC3339))
+		testRun.AddEvent(TestRunEvent{
+			Type:    TestRunEventAgentResumed,
+			AgentID: agentID,
+			Message: fmt.Sprintf("agent %s reconnected before starting; it runs its share for the remaining %s", agentID, remaining),
+		})
+
+	default:
+		return false
+	}
+
+	LogInfo("Agent %s resumed test run %s with %s remaining", agentID, testRun.Name, remaining)
+	return true
+}
+
+// sendAgentCommand publishes a command to one agent without waiting for an acknowledgement
+func (c *Coordinator) sendAgentCommand(agentID string, command TestStartCommand) {
+	data, err := json.Marshal(command)
+	if err != nil {
+		LogError("Failed to marshal %s command: %v", command.Command, err)
+		return
+	}
+
+	subject := fmt.Sprintf("armonite.agent.%s.command", agentID)
+	if err := c.signer.publish(c.natsConn, subject, data); err != nil {
+		LogError("Failed to send %s command to agent %s: %v", command.Command, agentID, err)
+	}
+}
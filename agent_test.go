@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// testAgent is an agent connected to an in-process NATS server
+func testAgent(t *testing.T, id string) *Agent {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	GetLogger() // Agents set up logging before their goroutines start
+	a := &Agent{
+		id:              id,
+		concurrency:     2,
+		natsConn:        nc,
+		resourceSampler: newResourceSampler(),
+		targets:         newTargetGuard(nil),
+		metrics: &AgentMetrics{
+			AgentID:     id,
+			StatusCodes: make(map[string]int64),
+			Latency:     NewLatencyHistogram(),
+			interval:    newTelemetryDelta(),
+		},
+	}
+	a.setupHTTPClient()
+	return a
+}
+
+// testCommandMsg encodes a command as the coordinator sends it
+func testCommandMsg(t *testing.T, command TestStartCommand) *nats.Msg {
+	t.Helper()
+	data, err := json.Marshal(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &nats.Msg{Subject: agentSubject("a1", "command"), Data: data}
+}
+
+// Per-agent START runs outside the subscription callback, so STOP arrives
+// while it is still setting up. Run with -race.
+func TestAgentStopDuringStart(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	a := testAgent(t, "a1")
+	plan := TestPlan{Name: "plan", Duration: "10s", Endpoints: []Endpoint{{Method: "GET", URL: target.URL}}}
+	start := testCommandMsg(t, TestStartCommand{Command: "START", TestRunID: "r1", TestPlan: plan})
+	stop := testCommandMsg(t, TestStartCommand{Command: "STOP", TestRunID: "r1"})
+
+	done := make(chan struct{})
+	go func() {
+		a.handleTestCommand(start) // As handleAgentCommand runs it
+		close(done)
+	}()
+
+	// Keep stopping until the run has started and stopped
+	deadline := time.After(5 * time.Second)
+	for {
+		a.handleAgentCommand(stop)
+		select {
+		case <-done:
+			if a.testRunID() != "r1" {
+				t.Errorf("test run = %q, want r1", a.testRunID())
+			}
+			return
+		case <-deadline:
+			t.Fatal("STOP did not end the run")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestAgentRebalanceBeforeStart(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	a := testAgent(t, "a1")
+	a.concurrency = 10
+	plan := TestPlan{Name: "plan", Duration: "10s", Concurrency: 8, Endpoints: []Endpoint{{Method: "GET", URL: target.URL}}}
+	startAt := time.Now().Add(300 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		a.executeTestPlan(&plan, &AgentAllocation{AgentID: "a1", Concurrency: 4, TargetRPS: 40}, startAt)
+		close(done)
+	}()
+	defer func() {
+		a.stopTest()
+		<-done
+	}()
+
+	// Waiting for the start time, the new share replaces the first
+	time.Sleep(100 * time.Millisecond)
+	a.rebalance(&AgentAllocation{AgentID: "a1", Concurrency: 6, TargetRPS: 60})
+
+	time.Sleep(time.Until(startAt) + 100*time.Millisecond)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.rampUpExecution == nil {
+		t.Fatal("test did not start")
+	}
+	if a.runConcurrency != 6 || a.rampUpExecution.MaxConcurrency != 6 || a.runRPS != 60 {
+		t.Errorf("running %d workers (max %d) at %.0f req/s, want the rebalanced 6 at 60",
+			a.runConcurrency, a.rampUpExecution.MaxConcurrency, a.runRPS)
+	}
+}
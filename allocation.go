@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Allocation strategies for splitting a plan's global load across agents
+const (
+	AllocationEven     = "even"     // Equal shares
+	AllocationWeight   = "weight"   // Proportional to each agent's --weight
+	AllocationCapacity = "capacity" // Proportional to calibrated max RPS
+)
+
+// AgentAllocation is one agent's share of a plan's global load
+type AgentAllocation struct {
+	AgentID     string  `json:"agent_id"`
+	Region      string  `json:"region,omitempty"`
+	Weight      float64 `json:"weight"`
+	Concurrency int     `json:"concurrency"`
+	TargetRPS   float64 `json:"target_rps,omitempty"`
+}
+
+// allocateLoad splits plan concurrency and target RPS across agents. Plan
+// concurrency is treated as the global total; each agent's share is capped at
+// its own registered concurrency and the excess moves to agents with headroom.
+// A plan without concurrency keeps the legacy behaviour of every agent running
+// at its own concurrency. Plans with regions are split between regions first.
+func allocateLoad(plan TestPlan, agents []*AgentInfo) ([]AgentAllocation, []string) {
+	if len(plan.Regions) > 0 {
+		return allocateRegions(plan, agents)
+	}
+
+	sorted := make([]*AgentInfo, len(agents))
+	copy(sorted, agents)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	weights, warnings := allocationWeights(plan.Allocation, sorted)
+
+	allocations := make([]AgentAllocation, len(sorted))
+	caps := make([]int, len(sorted))
+	for i, agent := range sorted {
+		allocations[i] = AgentAllocation{
+			AgentID:     agent.ID,
+			Weight:      weights[i],
+			Concurrency: agent.Concurrency,
+		}
+		caps[i] = agent.Concurrency
+	}
+
+	if plan.Concurrency > 0 {
+		shares, unallocated := splitConcurrency(plan.Concurrency, weights, caps)
+		for i := range allocations {
+			allocations[i].Concurrency = shares[i]
+		}
+		if unallocated > 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"plan concurrency %d exceeds the combined capacity of the selected agents; %d workers could not be allocated",
+				plan.Concurrency, unallocated))
+		}
+	}
+
+	if plan.TargetRPS > 0 {
+		var totalWeight float64
+		for i, allocation := range allocations {
+			if allocation.Concurrency > 0 {
+				totalWeight += weights[i]
+			}
+		}
+		for i := range allocations {
+			if allocations[i].Concurrency > 0 && totalWeight > 0 {
+				allocations[i].TargetRPS = plan.TargetRPS * weights[i] / totalWeight
+			}
+		}
+	}
+
+	return allocations, warnings
+}
+
+// allocationWeights returns each agent's relative weight for the strategy
+func allocationWeights(strategy string, agents []*AgentInfo) ([]float64, []string) {
+	weights := make([]float64, len(agents))
+	var warnings []string
+
+	switch strategy {
+	case AllocationWeight:
+		for i, agent := range agents {
+			weights[i] = agent.Weight
+			if weights[i] <= 0 {
+				weights[i] = 1
+			}
+		}
+	case AllocationCapacity:
+		// Fall back to registered concurrency unless every agent is calibrated,
+		// since RPS and worker counts are not comparable
+		calibrated := true
+		for _, agent := range agents {
+			if agent.Calibration == nil || agent.Calibration.MaxRPS <= 0 {
+				calibrated = false
+				break
+			}
+		}
+		if !calibrated {
+			warnings = append(warnings,
+				"capacity allocation requested but not every agent is calibrated; splitting by agent concurrency")
+		}
+		for i, agent := range agents {
+			if calibrated {
+				weights[i] = agent.Calibration.MaxRPS
+			} else {
+				weights[i] = float64(agent.Concurrency)
+			}
+			if weights[i] <= 0 {
+				weights[i] = 1
+			}
+		}
+	default:
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	return weights, warnings
+}
+
+// splitConcurrency distributes total workers proportionally to weights using
+// largest remainders, respecting per-agent caps. It returns the shares and
+// the number of workers that did not fit under the caps.
+func splitConcurrency(total int, weights []float64, caps []int) ([]int, int) {
+	shares := make([]int, len(weights))
+	remaining := total
+
+	for remaining > 0 {
+		// Agents that can still take workers
+		var active []int
+		var totalWeight float64
+		for i := range weights {
+			if shares[i] < caps[i] {
+				active = append(active, i)
+				totalWeight += weights[i]
+			}
+		}
+		if len(active) == 0 || totalWeight <= 0 {
+			break
+		}
+
+		type remainder struct {
+			index int
+			frac  float64
+		}
+		remainders := make([]remainder, 0, len(active))
+		distributed := 0
+		for _, i := range active {
+			exact := float64(remaining) * weights[i] / totalWeight
+			whole := int(math.Floor(exact))
+			shares[i] += whole
+			distributed += whole
+			remainders = append(remainders, remainder{index: i, frac: exact - float64(whole)})
+		}
+
+		sort.SliceStable(remainders, func(a, b int) bool {
+			return remainders[a].frac > remainders[b].frac
+		})
+		for k := 0; distributed < remaining && k < len(remainders); k++ {
+			shares[remainders[k].index]++
+			distributed++
+		}
+
+		// Give back anything above the caps and go round again
+		remaining = 0
+		for _, i := range active {
+			if shares[i] > caps[i] {
+				remaining += shares[i] - caps[i]
+				shares[i] = caps[i]
+			}
+		}
+	}
+
+	return shares, remaining
+}
+
+// scaleConcurrency converts a plan-level concurrency into an agent's share
+func scaleConcurrency(value, share, total int) int {
+	if total <= 0 || value <= 0 {
+		return value
+	}
+	scaled := int(math.Round(float64(value) * float64(share) / float64(total)))
+	if scaled < 1 && share > 0 {
+		scaled = 1
+	}
+	return scaled
+}
+
+// scaleRampUpStrategy scales phase concurrency from plan totals to an agent's share
+func scaleRampUpStrategy(strategy RampUpStrategy, share, total int) RampUpStrategy {
+	if total <= 0 || len(strategy.Phases) == 0 {
+		return strategy
+	}
+
+	scaled := strategy
+	scaled.Phases = make([]RampPhase, len(strategy.Phases))
+	for i, phase := range strategy.Phases {
+		phase.Concurrency = scaleConcurrency(phase.Concurrency, share, total)
+		scaled.Phases[i] = phase
+	}
+	return scaled
+}
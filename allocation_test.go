@@ -0,0 +1,212 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitConcurrency(t *testing.T) {
+	tests := []struct {
+		name            string
+		total           int
+		weights         []float64
+		caps            []int
+		wantShares      []int
+		wantUnallocated int
+	}{
+		{
+			name:       "even split",
+			total:      9,
+			weights:    []float64{1, 1, 1},
+			caps:       []int{100, 100, 100},
+			wantShares: []int{3, 3, 3},
+		},
+		{
+			name:       "largest remainder takes the odd worker",
+			total:      10,
+			weights:    []float64{1, 2},
+			caps:       []int{100, 100},
+			wantShares: []int{3, 7},
+		},
+		{
+			name:       "weights not summing to the total",
+			total:      7,
+			weights:    []float64{0.5, 0.25, 0.25},
+			caps:       []int{100, 100, 100},
+			wantShares: []int{3, 2, 2}, // 3.5, 1.75, 1.75: the larger remainders win
+		},
+		{
+			name:       "cap below the share moves the excess",
+			total:      10,
+			weights:    []float64{1, 1},
+			caps:       []int{2, 100},
+			wantShares: []int{2, 8},
+		},
+		{
+			name:       "excess moves in proportion to the remaining weights",
+			total:      12,
+			weights:    []float64{1, 1, 2},
+			caps:       []int{1, 100, 100},
+			wantShares: []int{1, 4, 7},
+		},
+		{
+			name:            "caps below the total leave workers unallocated",
+			total:           10,
+			weights:         []float64{1, 1},
+			caps:            []int{2, 3},
+			wantShares:      []int{2, 3},
+			wantUnallocated: 5,
+		},
+		{
+			name:       "zero-capacity agent gets nothing",
+			total:      6,
+			weights:    []float64{1, 1, 1},
+			caps:       []int{0, 100, 100},
+			wantShares: []int{0, 3, 3},
+		},
+		{
+			name:            "every agent at zero capacity",
+			total:           4,
+			weights:         []float64{1, 1},
+			caps:            []int{0, 0},
+			wantShares:      []int{0, 0},
+			wantUnallocated: 4,
+		},
+		{
+			name:       "total below the agent count",
+			total:      2,
+			weights:    []float64{1, 1, 1},
+			caps:       []int{100, 100, 100},
+			wantShares: []int{1, 1, 0},
+		},
+		{
+			name:       "total below the agent count favours the heavier agent",
+			total:      1,
+			weights:    []float64{1, 3},
+			caps:       []int{100, 100},
+			wantShares: []int{0, 1},
+		},
+		{
+			name:       "zero total",
+			total:      0,
+			weights:    []float64{1, 1},
+			caps:       []int{100, 100},
+			wantShares: []int{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares, unallocated := splitConcurrency(tt.total, tt.weights, tt.caps)
+			if !reflect.DeepEqual(shares, tt.wantShares) {
+				t.Errorf("shares = %v, want %v", shares, tt.wantShares)
+			}
+			if unallocated != tt.wantUnallocated {
+				t.Errorf("unallocated = %d, want %d", unallocated, tt.wantUnallocated)
+			}
+
+			sum := unallocated
+			for i, share := range shares {
+				sum += share
+				if share > tt.caps[i] {
+					t.Errorf("share %d of agent %d exceeds its cap %d", share, i, tt.caps[i])
+				}
+			}
+			if sum != tt.total {
+				t.Errorf("shares and unallocated add up to %d, want %d", sum, tt.total)
+			}
+		})
+	}
+}
+
+func TestAllocateLoad(t *testing.T) {
+	// agents builds agents whose IDs sort in reverse of the order given, with
+	// weights 1, 2, ...
+	agents := func(concurrency ...int) []*AgentInfo {
+		list := make([]*AgentInfo, len(concurrency))
+		for i, c := range concurrency {
+			list[i] = &AgentInfo{ID: string(rune('c' - i)), Concurrency: c, Weight: float64(i + 1)}
+		}
+		return list
+	}
+
+	tests := []struct {
+		name        string
+		plan        TestPlan
+		agents      []*AgentInfo
+		want        []int     // Concurrency by agent ID order
+		wantRPS     []float64 // Target RPS by agent ID order, if checked
+		wantWarning string
+	}{
+		{
+			name:   "no plan concurrency keeps each agent's own",
+			plan:   TestPlan{},
+			agents: agents(5, 7),
+			want:   []int{7, 5},
+		},
+		{
+			name:   "even split sorted by agent ID",
+			plan:   TestPlan{Concurrency: 10},
+			agents: agents(100, 100),
+			want:   []int{5, 5},
+		},
+		{
+			name:   "weight allocation",
+			plan:   TestPlan{Concurrency: 9, Allocation: AllocationWeight},
+			agents: agents(100, 100),
+			want:   []int{6, 3},
+		},
+		{
+			name:        "leftover is reported",
+			plan:        TestPlan{Concurrency: 10},
+			agents:      agents(2, 3),
+			want:        []int{3, 2},
+			wantWarning: "5 workers could not be allocated",
+		},
+		{
+			name:    "rate goes only to agents with workers",
+			plan:    TestPlan{Concurrency: 1, TargetRPS: 50},
+			agents:  agents(100, 100),
+			want:    []int{1, 0},
+			wantRPS: []float64{50, 0},
+		},
+		{
+			name:        "capacity without calibration falls back to concurrency",
+			plan:        TestPlan{Concurrency: 6, Allocation: AllocationCapacity},
+			agents:      agents(1, 2),
+			want:        []int{2, 1},
+			wantWarning: "not every agent is calibrated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocations, warnings := allocateLoad(tt.plan, tt.agents)
+
+			got := make([]int, len(allocations))
+			rps := make([]float64, len(allocations))
+			for i, allocation := range allocations {
+				if i > 0 && allocations[i-1].AgentID > allocation.AgentID {
+					t.Errorf("allocations not sorted by agent ID: %v", allocations)
+				}
+				got[i] = allocation.Concurrency
+				rps[i] = allocation.TargetRPS
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("concurrency = %v, want %v", got, tt.want)
+			}
+			if tt.wantRPS != nil && !reflect.DeepEqual(rps, tt.wantRPS) {
+				t.Errorf("target RPS = %v, want %v", rps, tt.wantRPS)
+			}
+
+			joined := strings.Join(warnings, "\n")
+			if tt.wantWarning == "" && joined != "" {
+				t.Errorf("unexpected warnings: %s", joined)
+			}
+			if !strings.Contains(joined, tt.wantWarning) {
+				t.Errorf("warnings %q do not mention %q", joined, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// API key roles, each allowed everything the previous one is
+const (
+	RoleViewer   = "viewer"   // Read test runs, results, agents and schedules
+	RoleOperator = "operator" // Create, start, stop and schedule test runs
+	RoleAdmin    = "admin"    // Delete history, manage API keys and settings
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+const (
+	apiKeyPrefix       = "arm_"
+	apiKeyCookie       = "armonite_api_key" // Set by the UI login, sent to the API by the browser
+	apiKeyContextKey   = "api_key"
+	apiKeyTouchEvery   = time.Minute // How often last_used_at is written for a key in use
+	bootstrapAPIKeyTag = "bootstrap"
+)
+
+// APIKey grants its role to whoever presents it. Only a hash of the key is kept.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	KeyHash    string     `json:"-"`
+	Prefix     string     `json:"prefix"` // Start of the key, to tell keys apart
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// APIKeyRequest is the body of POST /api/v1/api-keys
+type APIKeyRequest struct {
+	Name string `json:"name" binding:"required"`
+	Role string `json:"role" binding:"required"`
+}
+
+func validRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// roleAllows reports whether role grants everything required does
+func roleAllows(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// createAPIKey stores a new key and returns it with its secret, which is shown only once
+func createAPIKey(database *Database, name, role string) (*APIKey, string, error) {
+	if !validRole(role) {
+		return nil, "", fmt.Errorf("invalid role %q: must be viewer, operator or admin", role)
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Role:      role,
+		KeyHash:   hashAPIKey(secret),
+		Prefix:    secret[:len(apiKeyPrefix)+8],
+		CreatedAt: time.Now(),
+	}
+	if err := database.SaveAPIKey(key); err != nil {
+		return nil, "", fmt.Errorf("failed to save API key: %w", err)
+	}
+	return key, secret, nil
+}
+
+var (
+	errAPIKeyNotFound = errors.New("API key not found")
+	errLastAdminKey   = errors.New("cannot revoke the last admin key")
+)
+
+// revokeAPIKey deletes a key, keeping at least one admin key, and returns it
+func revokeAPIKey(database *Database, id string) (*APIKey, error) {
+	keys, err := database.ListAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var target *APIKey
+	admins := 0
+	for _, key := range keys {
+		if key.ID == id {
+			target = key
+		}
+		if key.Role == RoleAdmin {
+			admins++
+		}
+	}
+	if target == nil {
+		return nil, errAPIKeyNotFound
+	}
+	if target.Role == RoleAdmin && admins == 1 {
+		return nil, errLastAdminKey
+	}
+
+	_, err = database.DeleteAPIKey(id)
+	return target, err
+}
+
+// ensureBootstrapAPIKey creates an admin key when authentication is enabled
+// and there are no keys, so the API can be used at all
+func (c *Coordinator) ensureBootstrapAPIKey() error {
+	if !c.config.API.AuthEnabled {
+		LogWarn("API authentication disabled; anyone who can reach the API can start and delete test runs")
+		return nil
+	}
+
+	count, err := c.database.CountAPIKeys("")
+	if err != nil {
+		return fmt.Errorf("failed to count API keys: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, secret, err := createAPIKey(c.database, bootstrapAPIKeyTag, RoleAdmin)
+	if err != nil {
+		return err
+	}
+	LogWarn("No API keys found; created admin key %q: %s", bootstrapAPIKeyTag, secret)
+	LogWarn("Store it now, it is not shown again. Create other keys with POST /api/v1/api-keys or 'armonite api-key create'")
+	return nil
+}
+
+// requestAPIKey returns the key presented as a bearer token, an X-API-Key
+// header or the UI's cookie
+func requestAPIKey(ctx *gin.Context) string {
+	if header := ctx.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if key := ctx.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if key, err := ctx.Cookie(apiKeyCookie); err == nil {
+		return key
+	}
+	return ""
+}
+
+// authenticateAPIKey looks up the key a request presents
+func (c *Coordinator) authenticateAPIKey(ctx *gin.Context) (*APIKey, error) {
+	secret := requestAPIKey(ctx)
+	if secret == "" {
+		return nil, fmt.Errorf("no API key presented")
+	}
+
+	key, err := c.database.GetAPIKeyByHash(hashAPIKey(secret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("invalid API key")
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchEvery {
+		if err := c.database.TouchAPIKey(key.ID, now); err != nil {
+			LogDebug("Failed to record use of API key %s: %v", key.Name, err)
+		}
+	}
+	return key, nil
+}
+
+// requireRole admits requests presenting an API key with at least role
+func (c *Coordinator) requireRole(role string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.config.API.AuthEnabled {
+			ctx.Next()
+			return
+		}
+
+		key, err := c.authenticateAPIKey(ctx)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required", "details": err.Error()})
+			return
+		}
+		ctx.Set(apiKeyContextKey, key) // Also identifies refused requests in the audit log
+		if !roleAllows(key.Role, role) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "Insufficient permissions",
+				"details": fmt.Sprintf("%s role required, API key %q has %s", role, key.Name, key.Role),
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// corsMiddleware lets browsers call the API from the coordinator's UI and the
+// configured origins. Requests from other origins get no CORS headers.
+func (c *Coordinator) corsMiddleware(methods string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		origin := ctx.GetHeader("Origin")
+		ctx.Header("Vary", "Origin")
+
+		switch {
+		case origin == "":
+		case contains(c.config.API.CORSOrigins, "*"):
+			// Any origin, but without the UI's cookie
+			ctx.Header("Access-Control-Allow-Origin", "*")
+		case c.corsOriginAllowed(origin, ctx.Request.Host):
+			ctx.Header("Access-Control-Allow-Origin", origin)
+			ctx.Header("Access-Control-Allow-Credentials", "true")
+		}
+		ctx.Header("Access-Control-Allow-Methods", methods)
+		ctx.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+
+		if ctx.Request.Method == "OPTIONS" {
+			ctx.AbortWithStatus(204)
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// corsOriginAllowed reports whether origin is configured or is the UI served
+// alongside the API on requestHost
+func (c *Coordinator) corsOriginAllowed(origin, requestHost string) bool {
+	for _, allowed := range c.config.API.CORSOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	if !c.config.Server.EnableUI {
+		return false
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := requestHost
+	if h, _, found := strings.Cut(requestHost, ":"); found {
+		host = h
+	}
+	return strings.EqualFold(originURL.Hostname(), host) && originURL.Port() == strconv.Itoa(c.uiPort())
+}
+
+// uiPort is the port of the web UI, next to the API's
+func (c *Coordinator) uiPort() int {
+	uiPort := c.config.Server.HTTPPort + 1
+	if uiPort == 1 {
+		uiPort = 8081
+	}
+	return uiPort
+}
+
+func (c *Coordinator) handleListAPIKeys(ctx *gin.Context) {
+	keys, err := c.database.ListAPIKeys()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys", "details": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+		"count":    len(keys),
+	})
+}
+
+func (c *Coordinator) handleCreateAPIKey(ctx *gin.Context) {
+	var req APIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+	if !validRole(req.Role) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role", "details": "role must be viewer, operator or admin"})
+		return
+	}
+
+	key, secret, err := createAPIKey(c.database, req.Name, req.Role)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key", "details": err.Error()})
+		return
+	}
+
+	LogInfo("API key created: %s (%s)", key.Name, key.Role)
+	auditTarget(ctx, key.ID)
+	auditSummary(ctx, "created %s key %q (%s)", key.Role, key.Name, key.Prefix)
+	ctx.JSON(http.StatusCreated, gin.H{
+		"api_key": key,
+		"key":     secret,
+		"message": "Store the key now, it is not shown again",
+	})
+}
+
+func (c *Coordinator) handleDeleteAPIKey(ctx *gin.Context) {
+	id := ctx.Param("id")
+	key, err := revokeAPIKey(c.database, id)
+	switch err {
+	case nil:
+	case errAPIKeyNotFound:
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	case errLastAdminKey:
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Cannot revoke API key", "details": err.Error()})
+		return
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key", "details": err.Error()})
+		return
+	}
+
+	LogInfo("API key revoked: %s", id)
+	auditSummary(ctx, "revoked %s key %q (%s)", key.Role, key.Name, key.Prefix)
+	ctx.JSON(http.StatusOK, gin.H{"message": "API key revoked", "id": id})
+}
+
+// openCLIDatabase opens the coordinator's database for the api-key commands
+func openCLIDatabase() (*Database, error) {
+	database, err := NewDatabase(globalConfig.Database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return database, nil
+}
+
+func createAPIKeyCommand(cmd *cobra.Command, args []string) error {
+	name, _ := cmd.Flags().GetString("name")
+	role, _ := cmd.Flags().GetString("role")
+
+	database, err := openCLIDatabase()
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	key, secret, err := createAPIKey(database, name, role)
+	if err != nil {
+		return err
+	}
+	recordCLIAudit(database, "api_key.create", key.ID, fmt.Sprintf("created %s key %q (%s)", key.Role, key.Name, key.Prefix))
+
+	fmt.Printf("Created %s API key %q (%s)\n", key.Role, key.Name, key.ID)
+	fmt.Printf("Key: %s\n", secret)
+	fmt.Println("Store the key now, it is not shown again")
+	return nil
+}
+
+func listAPIKeysCommand(cmd *cobra.Command, args []string) error {
+	database, err := openCLIDatabase()
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	keys, err := database.ListAPIKeys()
+	if err != nil {
+		return fmt.Errorf("failed to list API keys: %w", err)
+	}
+
+	for _, key := range keys {
+		lastUsed := "never"
+		if key.LastUsedAt != nil {
+			lastUsed = key.LastUsedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%s  %-8s  %s...  %-20s  last used %s\n", key.ID, key.Role, key.Prefix, key.Name, lastUsed)
+	}
+	fmt.Printf("%d API keys\n", len(keys))
+	return nil
+}
+
+func revokeAPIKeyCommand(cmd *cobra.Command, args []string) error {
+	database, err := openCLIDatabase()
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	key, err := revokeAPIKey(database, args[0])
+	if err != nil {
+		return err
+	}
+	recordCLIAudit(database, "api_key.revoke", key.ID, fmt.Sprintf("revoked %s key %q (%s)", key.Role, key.Name, key.Prefix))
+	fmt.Printf("Revoked API key %s\n", args[0])
+	return nil
+}
@@ -59,11 +59,16 @@ func printStartupBanner(config *Config) {
 		colorBold  = "\033[1m"
 	)
 
+	scheme := "http"
+	if config.HTTPS.Enabled {
+		scheme = "https"
+	}
+
 	fmt.Printf("%s🚀 COORDINATOR STARTING%s\n", colorBold, colorReset)
 	fmt.Printf("═══════════════════════════════════════════════════════════════\n")
-	fmt.Printf("%s🌐 HTTP Server:%s http://%s:%d\n", colorCyan, colorReset, config.Server.Host, config.Server.HTTPPort)
-	fmt.Printf("%s📋 API Info:%s http://%s:%d/\n", colorCyan, colorReset, config.Server.Host, config.Server.HTTPPort)
-	fmt.Printf("%s🔌 API Endpoint:%s http://%s:%d/api/v1/status\n", colorCyan, colorReset, config.Server.Host, config.Server.HTTPPort)
+	fmt.Printf("%s🌐 HTTP Server:%s %s://%s:%d\n", colorCyan, colorReset, scheme, config.Server.Host, config.Server.HTTPPort)
+	fmt.Printf("%s📋 API Info:%s %s://%s:%d/\n", colorCyan, colorReset, scheme, config.Server.Host, config.Server.HTTPPort)
+	fmt.Printf("%s🔌 API Endpoint:%s %s://%s:%d/api/v1/status\n", colorCyan, colorReset, scheme, config.Server.Host, config.Server.HTTPPort)
 	fmt.Printf("%s✅ Ready for agents!%s\n", colorGreen, colorReset)
 	fmt.Println("═══════════════════════════════════════════════════════════════")
 	fmt.Println()
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	auditTargetKey     = "audit_target"
+	auditSummaryKey    = "audit_summary"
+	auditErrorBodySize = 1024 // Bytes of an error response kept to find its message
+	auditDefaultLimit  = 100
+	auditMaxLimit      = 1000
+)
+
+// AuditEntry records one control-plane action and who took it. Entries are
+// only ever added.
+type AuditEntry struct {
+	ID           uint      `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+	Actor        string    `json:"actor"`                   // API key name, client certificate subject or "anonymous"
+	APIKeyID     string    `json:"api_key_id,omitempty"`    // Key the request presented
+	Role         string    `json:"role,omitempty"`          // Role of that key
+	ClientCert   string    `json:"client_cert,omitempty"`   // Subject of a verified client certificate
+	SourceIP     string    `json:"source_ip"`               // Address the request came from
+	ForwardedFor string    `json:"forwarded_for,omitempty"` // X-Forwarded-For as sent, which the client controls
+	Action       string    `json:"action"`                  // Such as test_run.delete
+	Target       string    `json:"target,omitempty"`        // ID or name acted on
+	Summary      string    `json:"summary,omitempty"`
+	Status       int       `json:"status"` // HTTP status of the response; 0 for CLI commands
+}
+
+// AuditFilter selects audit entries; empty fields match everything
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Target   string
+	SourceIP string
+	Since    *time.Time
+	Until    *time.Time
+	Limit    int
+	Offset   int
+}
+
+// auditWriter keeps the start of error responses for the entry's summary
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(data []byte) (int, error) {
+	if w.Status() >= http.StatusBadRequest && w.body.Len() < auditErrorBodySize {
+		w.body.Write(data[:min(len(data), auditErrorBodySize-w.body.Len())])
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// audit records action once the request has been handled, refused or not.
+// Place it before the role check so denied attempts are recorded too;
+// requests without valid credentials are not, so they cannot fill the log.
+func (c *Coordinator) audit(action string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		writer := &auditWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		ctx.Next()
+
+		status := writer.Status()
+		if status == http.StatusUnauthorized {
+			return
+		}
+
+		entry := &AuditEntry{
+			Timestamp:    time.Now().UTC(),
+			Actor:        "anonymous",
+			SourceIP:     ctx.RemoteIP(),
+			ForwardedFor: ctx.GetHeader("X-Forwarded-For"),
+			Action:       action,
+			Target:       ctx.GetString(auditTargetKey),
+			Summary:      ctx.GetString(auditSummaryKey),
+			Status:       status,
+		}
+		if entry.Target == "" {
+			entry.Target = ctx.Param("id")
+		}
+		if entry.Target == "" {
+			entry.Target = ctx.Param("name")
+		}
+		if entry.Summary == "" && status >= http.StatusBadRequest {
+			entry.Summary = errorResponseSummary(writer.body.Bytes())
+		}
+
+		if tls := ctx.Request.TLS; tls != nil && len(tls.VerifiedChains) > 0 {
+			entry.ClientCert = tls.PeerCertificates[0].Subject.String()
+			entry.Actor = entry.ClientCert
+		}
+		if value, ok := ctx.Get(apiKeyContextKey); ok {
+			key := value.(*APIKey)
+			entry.Actor = key.Name
+			entry.APIKeyID = key.ID
+			entry.Role = key.Role
+		}
+
+		if err := c.database.SaveAuditEntry(entry); err != nil {
+			LogError("Failed to record audit entry %s by %s: %v", action, entry.Actor, err)
+		}
+	}
+}
+
+// recordCLIAudit records an action taken with a CLI command on the
+// coordinator host, by the operating system user who ran it
+func recordCLIAudit(database *Database, action, target, summary string) {
+	actor := "cli"
+	if current, err := user.Current(); err == nil {
+		actor = "cli:" + current.Username
+	}
+
+	entry := &AuditEntry{
+		Timestamp: time.Now().UTC(),
+		Actor:     actor,
+		SourceIP:  "local",
+		Action:    action,
+		Target:    target,
+		Summary:   summary,
+	}
+	if err := database.SaveAuditEntry(entry); err != nil {
+		LogError("Failed to record audit entry %s by %s: %v", action, actor, err)
+	}
+}
+
+// errorResponseSummary returns the error message of a JSON error response
+func errorResponseSummary(body []byte) string {
+	var response struct {
+		Error   string `json:"error"`
+		Details string `json:"details"`
+	}
+	if json.Unmarshal(body, &response) != nil || response.Error == "" {
+		return ""
+	}
+	if response.Details != "" {
+		return response.Error + ": " + response.Details
+	}
+	return response.Error
+}
+
+// auditTarget names what a request acted on when it is not in the path,
+// such as a test run it created
+func auditTarget(ctx *gin.Context, target string) {
+	ctx.Set(auditTargetKey, target)
+}
+
+// auditSummary describes what a request did for its audit entry
+func auditSummary(ctx *gin.Context, format string, args ...interface{}) {
+	ctx.Set(auditSummaryKey, fmt.Sprintf(format, args...))
+}
+
+func (c *Coordinator) handleListAudit(ctx *gin.Context) {
+	filter := AuditFilter{
+		Actor:    ctx.Query("actor"),
+		Action:   ctx.Query("action"),
+		Target:   ctx.Query("target"),
+		SourceIP: ctx.Query("source_ip"),
+		Limit:    auditDefaultLimit,
+	}
+
+	for param, bound := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := ctx.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " parameter", "details": "expected an RFC 3339 time"})
+			return
+		}
+		parsed = parsed.UTC() // Entries are stored in UTC
+		*bound = &parsed
+	}
+	for param, bound := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		value := ctx.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " parameter"})
+			return
+		}
+		*bound = parsed
+	}
+	if filter.Limit == 0 || filter.Limit > auditMaxLimit {
+		filter.Limit = auditMaxLimit
+	}
+
+	entries, total, err := c.database.ListAuditEntries(filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit entries", "details": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"count":   len(entries),
+		"total":   total,
+	})
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+)
+
+// defaultCalibrationDuration keeps on-demand calibration well inside the
+// API server's write timeout
+const defaultCalibrationDuration = 5 * time.Second
+
+// AgentCalibration is the result of an agent's self-calibration benchmark
+type AgentCalibration struct {
+	MaxRPS                 float64 `json:"max_rps"`                  // Sustained requests/s against a local target
+	AvgLatencyMs           float64 `json:"avg_latency_ms"`           // Mean latency during the benchmark
+	CPUPerRequestUs        float64 `json:"cpu_per_request_us"`       // Process CPU time per request
+	GoroutineOverheadBytes int64   `json:"goroutine_overhead_bytes"` // Memory cost of one idle goroutine
+	Concurrency            int     `json:"concurrency"`              // Workers used for the benchmark
+	Duration               string  `json:"duration"`
+	NumCPU                 int     `json:"num_cpu"`
+	CalibratedAt           string  `json:"calibrated_at"`
+}
+
+// CalibrationReport is an agent's reply to an on-demand CALIBRATE command
+type CalibrationReport struct {
+	AgentID     string            `json:"agent_id"`
+	Calibration *AgentCalibration `json:"calibration,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// RunCalibration benchmarks how fast this host can generate requests. The
+// target runs in-process and competes for the same CPU, so MaxRPS is a
+// conservative lower bound of what the agent can deliver.
+func RunCalibration(concurrency int, keepAlive bool, duration time.Duration) (*AgentCalibration, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if duration <= 0 {
+		duration = defaultCalibrationDuration
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start calibration target: %w", err)
+	}
+	server := &http.Server{Handler: NewTargetServer(DefaultTargetConfig())}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DisableKeepAlives:   !keepAlive,
+			MaxIdleConns:        concurrency,
+			MaxIdleConnsPerHost: concurrency,
+			IdleConnTimeout:     30 * time.Second,
+		},
+		Timeout: 10 * time.Second,
+	}
+	defer client.CloseIdleConnections()
+
+	url := fmt.Sprintf("http://%s/", listener.Addr().String())
+
+	var requests, errors, totalLatencyNs int64
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+
+	cpuStart := processCPUTime()
+	start := time.Now()
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stopCh:
+					return
+				default:
+				}
+
+				requestStart := time.Now()
+				resp, err := client.Get(url)
+				if err != nil {
+					atomic.AddInt64(&errors, 1)
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+
+				atomic.AddInt64(&requests, 1)
+				atomic.AddInt64(&totalLatencyNs, int64(time.Since(requestStart)))
+			}
+		}()
+	}
+
+	time.Sleep(duration)
+	close(stopCh)
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	cpuUsed := processCPUTime() - cpuStart
+
+	if requests == 0 {
+		return nil, fmt.Errorf("calibration produced no successful requests (%d errors)", errors)
+	}
+
+	calibration := &AgentCalibration{
+		MaxRPS:                 float64(requests) / elapsed.Seconds(),
+		AvgLatencyMs:           float64(totalLatencyNs) / float64(requests) / 1e6,
+		CPUPerRequestUs:        float64(cpuUsed.Microseconds()) / float64(requests),
+		GoroutineOverheadBytes: measureGoroutineOverhead(1000),
+		Concurrency:            concurrency,
+		Duration:               duration.String(),
+		NumCPU:                 runtime.NumCPU(),
+		CalibratedAt:           time.Now().UTC().Format(time.RFC3339),
+	}
+
+	return calibration, nil
+}
+
+// measureGoroutineOverhead estimates the stack memory held by one parked goroutine
+func measureGoroutineOverhead(count int) int64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	blockCh := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(count)
+	for i := 0; i < count; i++ {
+		go func() {
+			started.Done()
+			<-blockCh
+		}()
+	}
+	started.Wait()
+
+	runtime.ReadMemStats(&after)
+	close(blockCh)
+
+	overhead := int64(after.StackInuse) - int64(before.StackInuse)
+	if overhead < 0 {
+		return 0
+	}
+	return overhead / int64(count)
+}
+
+// estimatePlanRPS estimates the request rate a plan asks of one agent running
+// the given number of workers. It returns 0 when the plan has no think time,
+// meaning the agent is asked to go as fast as it can.
+func estimatePlanRPS(plan TestPlan, concurrency int, defaultThinkTime time.Duration) float64 {
+	if len(plan.Endpoints) == 0 || concurrency <= 0 {
+		return 0
+	}
+
+	var totalThinkTime time.Duration
+	for _, endpoint := range plan.Endpoints {
+		thinkTime := defaultThinkTime
+		if endpoint.ThinkTime != "" {
+			if d, err := time.ParseDuration(endpoint.ThinkTime); err == nil {
+				thinkTime = d
+			}
+		}
+		if thinkTime <= 0 {
+			return 0
+		}
+		totalThinkTime += thinkTime
+	}
+
+	avgThinkTime := totalThinkTime / time.Duration(len(plan.Endpoints))
+	return float64(concurrency) / avgThinkTime.Seconds()
+}
+
+// capacityWarnings compares what a plan asks of each agent with what the
+// agent measured during calibration
+func capacityWarnings(plan TestPlan, agents []*AgentInfo) []string {
+	var warnings []string
+	for _, agent := range agents {
+		if agent.Calibration == nil || agent.Calibration.MaxRPS <= 0 {
+			continue
+		}
+
+		demand := estimatePlanRPS(plan, agent.Concurrency, 0)
+		switch {
+		case demand == 0:
+			warnings = append(warnings, fmt.Sprintf(
+				"agent %s: plan has no think time, load will be capped by the agent at about %.0f req/s",
+				agent.ID, agent.Calibration.MaxRPS))
+		case demand > agent.Calibration.MaxRPS:
+			warnings = append(warnings, fmt.Sprintf(
+				"agent %s: plan asks for about %.0f req/s but the agent was calibrated at %.0f req/s",
+				agent.ID, demand, agent.Calibration.MaxRPS))
+		}
+	}
+	return warnings
+}
+
+// handleCalibrationCommand runs an on-demand calibration requested by the coordinator
+func (a *Agent) handleCalibrationCommand(msg *nats.Msg) {
+	report := CalibrationReport{AgentID: a.id}
+
+	a.mu.RLock()
+	running := a.running
+	a.mu.RUnlock()
+
+	if running {
+		report.Error = "agent is running a test"
+	} else {
+		LogInfo("Running on-demand calibration...")
+		calibration, err := RunCalibration(a.concurrency, a.keepAlive, defaultCalibrationDuration)
+		if err != nil {
+			report.Error = err.Error()
+		} else {
+			a.mu.Lock()
+			a.calibration = calibration
+			a.mu.Unlock()
+			report.Calibration = calibration
+			LogInfo("Calibration finished: %.0f req/s, %.1fus CPU per request",
+				calibration.MaxRPS, calibration.CPUPerRequestUs)
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		LogError("Failed to marshal calibration report: %v", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		LogError("Failed to send calibration report: %v", err)
+	}
+}
+
+func (c *Coordinator) handleCalibrateAgent(ctx *gin.Context) {
+	agentID := ctx.Param("id")
+
+	c.mu.RLock()
+	_, exists := c.connectedAgents[agentID]
+	c.mu.RUnlock()
+
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return
+	}
+
+	command := TestStartCommand{Command: "CALIBRATE"}
+	data, err := json.Marshal(command)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode calibration command"})
+		return
+	}
+
+	subject := fmt.Sprintf("armonite.agent.%s.command", agentID)
+	msg, err := c.signer.request(c.natsConn, subject, data, defaultCalibrationDuration+15*time.Second)
+	if err != nil {
+		ctx.JSON(http.StatusGatewayTimeout, gin.H{"error": "Agent did not report calibration", "details": err.Error()})
+		return
+	}
+
+	var report CalibrationReport
+	if err := json.Unmarshal(msg.Data, &report); err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": "Invalid calibration report", "details": err.Error()})
+		return
+	}
+
+	if report.Error != "" {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Calibration failed", "details": report.Error})
+		return
+	}
+	if report.Calibration == nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": "Invalid calibration report", "details": "report has no calibration"})
+		return
+	}
+
+	c.mu.Lock()
+	if agent, exists := c.connectedAgents[agentID]; exists {
+		agent.Calibration = report.Calibration
+	}
+	c.mu.Unlock()
+
+	LogInfo("Agent %s calibrated at %.0f req/s", agentID, report.Calibration.MaxRPS)
+	auditSummary(ctx, "calibrated agent %s at %.0f req/s", agentID, report.Calibration.MaxRPS)
+
+	ctx.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func TestHandleCalibrateAgent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	signer, err := loadCommandSigner(filepath.Join(t.TempDir(), "signing.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		reply      string
+		wantStatus int
+		wantMaxRPS float64 // Stored on the agent; 0 when nothing is stored
+	}{
+		{"calibrated", `{"agent_id":"a1","calibration":{"max_rps":1200}}`, http.StatusOK, 1200},
+		{"calibration failed", `{"agent_id":"a1","error":"benchmark failed"}`, http.StatusConflict, 0},
+		{"report without a calibration", `{"agent_id":"a1"}`, http.StatusBadGateway, 0},
+		{"malformed report", `not json`, http.StatusBadGateway, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, err := nc.Subscribe(agentSubject("a1", "command"), func(msg *nats.Msg) {
+				msg.Respond([]byte(tt.reply))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Unsubscribe()
+
+			c := &Coordinator{
+				natsConn:        nc,
+				signer:          signer,
+				connectedAgents: map[string]*AgentInfo{"a1": {ID: "a1"}},
+			}
+			router := gin.New()
+			router.POST("/agents/:id/calibrate", c.handleCalibrateAgent)
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/agents/a1/calibrate", nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			calibration := c.connectedAgents["a1"].Calibration
+			switch {
+			case tt.wantMaxRPS == 0 && calibration != nil:
+				t.Errorf("stored calibration %+v, want none", calibration)
+			case tt.wantMaxRPS != 0 && (calibration == nil || calibration.MaxRPS != tt.wantMaxRPS):
+				t.Errorf("stored calibration %+v, want max_rps %.0f", calibration, tt.wantMaxRPS)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Headers carrying a command's signature
+const (
+	commandTimestampHeader = "Armonite-Timestamp"
+	commandNonceHeader     = "Armonite-Nonce"
+	commandSignatureHeader = "Armonite-Signature"
+)
+
+// commandMaxAge is how far a command's timestamp may be from an agent's clock
+const commandMaxAge = 30 * time.Second
+
+// coordinatorKeySubject is where agents without a pinned key ask for the
+// coordinator's public key
+const coordinatorKeySubject = "armonite.coordinator.key"
+
+// commandSigner signs the commands the coordinator sends to agents
+type commandSigner struct {
+	key ed25519.PrivateKey
+}
+
+// loadCommandSigner reads the coordinator's ed25519 key from a PKCS#8 PEM
+// file, generating it on first start
+func loadCommandSigner(path string) (*commandSigner, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return generateCommandSigner(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an ed25519 key", path)
+	}
+	return &commandSigner{key: key}, nil
+}
+
+func generateCommandSigner(path string) (*commandSigner, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signing key: %w", err)
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create signing key directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write signing key %s: %w", path, err)
+	}
+	LogInfo("Generated command signing key in %s", path)
+	return &commandSigner{key: key}, nil
+}
+
+// publicKey returns the key agents verify commands with, base64 encoded
+func (s *commandSigner) publicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// signedMessage wraps a command with a timestamp, a nonce and a signature
+// over both, the subject and the payload
+func (s *commandSigner) signedMessage(subject string, data []byte) (*nats.Msg, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate command nonce: %w", err)
+	}
+
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	nonceHex := hex.EncodeToString(nonce)
+	signature := ed25519.Sign(s.key, commandSigningPayload(subject, timestamp, nonceHex, data))
+
+	msg.Header.Set(commandTimestampHeader, timestamp)
+	msg.Header.Set(commandNonceHeader, nonceHex)
+	msg.Header.Set(commandSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	return msg, nil
+}
+
+// publish sends a signed command
+func (s *commandSigner) publish(natsConn *nats.Conn, subject string, data []byte) error {
+	msg, err := s.signedMessage(subject, data)
+	if err != nil {
+		return err
+	}
+	return natsConn.PublishMsg(msg)
+}
+
+// request sends a signed command and waits for the agent's reply
+func (s *commandSigner) request(natsConn *nats.Conn, subject string, data []byte, timeout time.Duration) (*nats.Msg, error) {
+	msg, err := s.signedMessage(subject, data)
+	if err != nil {
+		return nil, err
+	}
+	return natsConn.RequestMsg(msg, timeout)
+}
+
+// commandSigningPayload is what a command's signature covers. The subject
+// binds a command to the agent it was sent to.
+func commandSigningPayload(subject, timestamp, nonce string, data []byte) []byte {
+	payload := make([]byte, 0, len(subject)+len(timestamp)+len(nonce)+len(data)+3)
+	payload = append(payload, subject...)
+	payload = append(payload, '\n')
+	payload = append(payload, timestamp...)
+	payload = append(payload, '\n')
+	payload = append(payload, nonce...)
+	payload = append(payload, '\n')
+	return append(payload, data...)
+}
+
+// setupCommandSigning loads the signing key and answers agents asking for
+// its public key. Agent permissions keep other clients from answering.
+func (c *Coordinator) setupCommandSigning() error {
+	signer, err := loadCommandSigner(c.config.Signing.KeyFile)
+	if err != nil {
+		return err
+	}
+	c.signer = signer
+
+	publicKey := []byte(signer.publicKey())
+	if _, err := c.natsConn.Subscribe(coordinatorKeySubject, func(msg *nats.Msg) {
+		if err := msg.Respond(publicKey); err != nil {
+			LogWarn("Failed to send command signing key: %v", err)
+		}
+	}); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", coordinatorKeySubject, err)
+	}
+
+	LogInfo("Commands are signed with key %s", signer.publicKey())
+	return nil
+}
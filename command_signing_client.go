@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// coordinatorKeyTimeout bounds the wait for the coordinator's public key
+const coordinatorKeyTimeout = 5 * time.Second
+
+// commandVerifier accepts only commands signed by the coordinator, each at
+// most once
+type commandVerifier struct {
+	mu     sync.Mutex
+	key    ed25519.PublicKey
+	pinned bool                 // Set at startup; the key the coordinator serves is not trusted
+	seen   map[string]time.Time // Nonces of accepted commands, by when they expire
+	now    func() time.Time
+}
+
+// newCommandVerifier pins pinnedKey when set. Otherwise the key is fetched
+// from the coordinator once connected.
+func newCommandVerifier(pinnedKey string) (*commandVerifier, error) {
+	verifier := &commandVerifier{seen: make(map[string]time.Time), now: time.Now}
+	if pinnedKey == "" {
+		return verifier, nil
+	}
+
+	key, err := parseCommandKey(pinnedKey)
+	if err != nil {
+		return nil, err
+	}
+	verifier.key = key
+	verifier.pinned = true
+	return verifier, nil
+}
+
+// parseCommandKey decodes a base64 ed25519 public key
+func parseCommandKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid coordinator key %q: expected a base64 ed25519 public key", encoded)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// verify checks a command's signature, age and nonce
+func (v *commandVerifier) verify(msg *nats.Msg) error {
+	timestamp := msg.Header.Get(commandTimestampHeader)
+	nonce := msg.Header.Get(commandNonceHeader)
+	encodedSignature := msg.Header.Get(commandSignatureHeader)
+	if timestamp == "" || nonce == "" || encodedSignature == "" {
+		return fmt.Errorf("command is not signed")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.key == nil {
+		return fmt.Errorf("no coordinator key to verify the command with")
+	}
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil || !ed25519.Verify(v.key, commandSigningPayload(msg.Subject, timestamp, nonce, msg.Data), signature) {
+		return fmt.Errorf("invalid command signature")
+	}
+
+	sentAt, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid command timestamp %q", timestamp)
+	}
+	now := v.now()
+	if age := now.Sub(sentAt); age > commandMaxAge || age < -commandMaxAge {
+		return fmt.Errorf("command timestamp %s is outside the %s window", timestamp, commandMaxAge)
+	}
+
+	for seenNonce, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, seenNonce)
+		}
+	}
+	if _, replayed := v.seen[nonce]; replayed {
+		return fmt.Errorf("command was replayed")
+	}
+	// A nonce can only be replayed while its timestamp is in the window
+	v.seen[nonce] = sentAt.Add(commandMaxAge)
+	return nil
+}
+
+// setKey trusts the key the coordinator serves unless one was pinned. It
+// returns false when a served key does not match the pinned one.
+func (v *commandVerifier) setKey(key ed25519.PublicKey) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.pinned {
+		return bytes.Equal(v.key, key)
+	}
+	v.key = key
+	return true
+}
+
+// fetchCoordinatorKey asks the coordinator for the key its commands are
+// signed with. Only the coordinator may answer on the subject.
+func (a *Agent) fetchCoordinatorKey() error {
+	msg, err := a.natsConn.Request(coordinatorKeySubject, nil, coordinatorKeyTimeout)
+	if err != nil {
+		return fmt.Errorf("coordinator did not send its command signing key: %w", err)
+	}
+	key, err := parseCommandKey(string(msg.Data))
+	if err != nil {
+		return err
+	}
+
+	if !a.commands.setKey(key) {
+		LogError("Coordinator signs commands with %s, not the pinned key; its commands will be rejected", string(msg.Data))
+		return nil
+	}
+	LogDebug("Verifying commands with coordinator key %s", string(msg.Data))
+	return nil
+}
+
+// verifiedCommand passes only commands signed by the coordinator to handler
+func (a *Agent) verifiedCommand(handler nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		if err := a.commands.verify(msg); err != nil {
+			LogWarn("Rejected command on %s: %v", msg.Subject, err)
+			return
+		}
+		handler(msg)
+	}
+}
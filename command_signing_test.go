@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// testSignedCommand signs a command as the coordinator would, at a given time
+func testSignedCommand(t *testing.T, key ed25519.PrivateKey, subject string, data []byte, at time.Time, nonce string) *nats.Msg {
+	t.Helper()
+	timestamp := at.UTC().Format(time.RFC3339Nano)
+	signature := ed25519.Sign(key, commandSigningPayload(subject, timestamp, nonce, data))
+
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set(commandTimestampHeader, timestamp)
+	msg.Header.Set(commandNonceHeader, nonce)
+	msg.Header.Set(commandSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	return msg
+}
+
+// testVerifier trusts key and reads the time from clock
+func testVerifier(t *testing.T, key ed25519.PrivateKey, clock *time.Time) *commandVerifier {
+	t.Helper()
+	verifier, err := newCommandVerifier(base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.now = func() time.Time { return *clock }
+	return verifier
+}
+
+func TestCommandVerifierVerify(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	subject := agentSubject("a1", "command")
+	data := []byte(`{"command":"START"}`)
+
+	tests := []struct {
+		name    string
+		msg     func() *nats.Msg
+		wantErr string // Empty when the command is accepted
+	}{
+		{
+			name: "valid",
+			msg:  func() *nats.Msg { return testSignedCommand(t, key, subject, data, now, "n1") },
+		},
+		{
+			name: "oldest accepted",
+			msg:  func() *nats.Msg { return testSignedCommand(t, key, subject, data, now.Add(-commandMaxAge), "n1") },
+		},
+		{
+			name: "newest accepted",
+			msg:  func() *nats.Msg { return testSignedCommand(t, key, subject, data, now.Add(commandMaxAge), "n1") },
+		},
+		{
+			name: "too old",
+			msg: func() *nats.Msg {
+				return testSignedCommand(t, key, subject, data, now.Add(-commandMaxAge-time.Nanosecond), "n1")
+			},
+			wantErr: "outside the 30s window",
+		},
+		{
+			name: "too far in the future",
+			msg: func() *nats.Msg {
+				return testSignedCommand(t, key, subject, data, now.Add(commandMaxAge+time.Nanosecond), "n1")
+			},
+			wantErr: "outside the 30s window",
+		},
+		{
+			name: "unsigned",
+			msg: func() *nats.Msg {
+				msg := nats.NewMsg(subject)
+				msg.Data = data
+				return msg
+			},
+			wantErr: "not signed",
+		},
+		{
+			name: "missing nonce",
+			msg: func() *nats.Msg {
+				msg := testSignedCommand(t, key, subject, data, now, "n1")
+				msg.Header.Del(commandNonceHeader)
+				return msg
+			},
+			wantErr: "not signed",
+		},
+		{
+			name: "tampered payload",
+			msg: func() *nats.Msg {
+				msg := testSignedCommand(t, key, subject, data, now, "n1")
+				msg.Data = []byte(`{"command":"START","url":"http://elsewhere"}`)
+				return msg
+			},
+			wantErr: "invalid command signature",
+		},
+		{
+			name: "redirected to another agent",
+			msg: func() *nats.Msg {
+				msg := testSignedCommand(t, key, subject, data, now, "n1")
+				msg.Subject = agentSubject("a2", "command")
+				return msg
+			},
+			wantErr: "invalid command signature",
+		},
+		{
+			name: "tampered timestamp",
+			msg: func() *nats.Msg {
+				msg := testSignedCommand(t, key, subject, data, now.Add(-time.Hour), "n1")
+				msg.Header.Set(commandTimestampHeader, now.Format(time.RFC3339Nano))
+				return msg
+			},
+			wantErr: "invalid command signature",
+		},
+		{
+			name: "tampered nonce",
+			msg: func() *nats.Msg {
+				msg := testSignedCommand(t, key, subject, data, now, "n1")
+				msg.Header.Set(commandNonceHeader, "n2")
+				return msg
+			},
+			wantErr: "invalid command signature",
+		},
+		{
+			name:    "signed with another key",
+			msg:     func() *nats.Msg { return testSignedCommand(t, otherKey, subject, data, now, "n1") },
+			wantErr: "invalid command signature",
+		},
+		{
+			name: "signature not base64",
+			msg: func() *nats.Msg {
+				msg := testSignedCommand(t, key, subject, data, now, "n1")
+				msg.Header.Set(commandSignatureHeader, "not base64!")
+				return msg
+			},
+			wantErr: "invalid command signature",
+		},
+		{
+			name: "signed timestamp that does not parse",
+			msg: func() *nats.Msg {
+				signature := ed25519.Sign(key, commandSigningPayload(subject, "yesterday", "n1", data))
+				msg := nats.NewMsg(subject)
+				msg.Data = data
+				msg.Header.Set(commandTimestampHeader, "yesterday")
+				msg.Header.Set(commandNonceHeader, "n1")
+				msg.Header.Set(commandSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+				return msg
+			},
+			wantErr: "invalid command timestamp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := now
+			err := testVerifier(t, key, &clock).verify(tt.msg())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("verify: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verify error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommandVerifierReplay(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	verifier := testVerifier(t, key, &clock)
+	subject := agentSubject("a1", "command")
+
+	first := testSignedCommand(t, key, subject, []byte("stop"), clock, "n1")
+	if err := verifier.verify(first); err != nil {
+		t.Fatalf("first delivery: %v", err)
+	}
+	if err := verifier.verify(first); err == nil || !strings.Contains(err.Error(), "replayed") {
+		t.Errorf("replay error = %v, want a replay rejection", err)
+	}
+
+	// The same command with a new nonce is a new command
+	if err := verifier.verify(testSignedCommand(t, key, subject, []byte("stop"), clock, "n2")); err != nil {
+		t.Errorf("new nonce: %v", err)
+	}
+
+	// Still a replay at the end of the window
+	clock = clock.Add(commandMaxAge)
+	if err := verifier.verify(first); err == nil || !strings.Contains(err.Error(), "replayed") {
+		t.Errorf("replay at the end of the window: %v, want a replay rejection", err)
+	}
+
+	// Past the window the timestamp rejects it, and the nonce is forgotten
+	clock = clock.Add(time.Nanosecond)
+	if err := verifier.verify(first); err == nil || !strings.Contains(err.Error(), "outside") {
+		t.Errorf("replay after the window: %v, want a timestamp rejection", err)
+	}
+	if err := verifier.verify(testSignedCommand(t, key, subject, nil, clock, "n3")); err != nil {
+		t.Fatalf("command after the window: %v", err)
+	}
+	if _, kept := verifier.seen["n1"]; kept || len(verifier.seen) != 1 {
+		t.Errorf("seen nonces = %v, want only n3", verifier.seen)
+	}
+}
+
+func TestCommandVerifierKeys(t *testing.T) {
+	coordinatorPublic, coordinatorKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherPublic, _, _ := ed25519.GenerateKey(rand.Reader)
+	subject := agentSubject("a1", "command")
+
+	// Without a pinned key nothing is accepted until the coordinator's arrives
+	verifier, err := newCommandVerifier("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &commandSigner{key: coordinatorKey}
+	msg, err := signer.signedMessage(subject, []byte("start"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifier.verify(msg); err == nil || !strings.Contains(err.Error(), "no coordinator key") {
+		t.Errorf("verify without a key: %v, want a missing key error", err)
+	}
+	if !verifier.setKey(coordinatorPublic) {
+		t.Fatal("unpinned verifier refused the served key")
+	}
+	if err := verifier.verify(msg); err != nil {
+		t.Errorf("verify after fetching the key: %v", err)
+	}
+
+	// A pinned key is not replaced by the one the coordinator serves
+	pinned, err := newCommandVerifier(signer.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned.setKey(otherPublic) {
+		t.Error("pinned verifier accepted a different served key")
+	}
+	if !pinned.setKey(coordinatorPublic) {
+		t.Error("pinned verifier refused its own key")
+	}
+
+	for _, encoded := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := newCommandVerifier(encoded); err == nil {
+			t.Errorf("newCommandVerifier(%q) succeeded, want an error", encoded)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// finalReportGracePeriod is how long the coordinator waits for agents' final
+// metrics after a run's duration has elapsed or it was stopped
+const finalReportGracePeriod = 15 * time.Second
+
+// handleFinalMetrics receives the final metrics snapshot each agent sends
+// when it finishes its share of a run
+func (c *Coordinator) handleFinalMetrics(msg *nats.Msg) {
+	var metrics AgentMetrics
+	if err := json.Unmarshal(msg.Data, &metrics); err != nil {
+		LogError("Failed to unmarshal final metrics: %v", err)
+		return
+	}
+	if !checkSender(msg, metrics.AgentID) {
+		return
+	}
+
+	// Queued on the internal subject ahead of the results request made
+	// when the run completes, so the snapshot is always included
+	c.handleTelemetryUpdate(&metrics)
+
+	// Agents without JetStream wait for a reply; stored messages are
+	// acknowledged to the stream instead
+	if msg.Reply != "" && !isStreamMessage(msg) {
+		if err := msg.Respond([]byte("ok")); err != nil {
+			LogWarn("Failed to acknowledge final metrics from agent %s: %v", metrics.AgentID, err)
+		}
+	}
+
+	c.recordFinalReport(metrics.TestRunID, metrics.AgentID)
+}
+
+// recordFinalReport notes an agent's final metrics and completes the run
+// once every agent taking part has reported
+func (c *Coordinator) recordFinalReport(testRunID, agentID string) {
+	c.mu.Lock()
+	run, active := c.activeRuns[testRunID]
+	if !active {
+		c.mu.Unlock()
+		return
+	}
+	run.finalReports[agentID] = true
+	done := run.allReported()
+	c.mu.Unlock()
+
+	LogDebug("Final metrics received from agent %s for test run %s", agentID, run.testRun.Name)
+	if done {
+		LogInfo("All agents reported final metrics for test run: %s", run.testRun.Name)
+		c.completeTestRun(testRunID)
+	}
+}
+
+// allReported reports whether every agent that took part in the run has sent
+// its final metrics
+func (run *activeRun) allReported() bool {
+	if len(run.participants) == 0 {
+		return false
+	}
+	for agentID := range run.participants {
+		if !run.finalReports[agentID] {
+			return false
+		}
+	}
+	return true
+}
+
+// finishTestRun runs when a test's duration has elapsed. Agents end their
+// share on their own; agents of phase-orchestrated runs are told to stop.
+func (c *Coordinator) finishTestRun(testRunID string) {
+	c.mu.Lock()
+	run, active := c.activeRuns[testRunID]
+	if !active || run.testRun.Status != TestRunStatusRunning {
+		c.mu.Unlock()
+		return
+	}
+	run.testRun.Status = TestRunStatusCompleting
+	orchestrated := run.phaseOrchestrator != nil
+	c.mu.Unlock()
+
+	if orchestrated {
+		c.sendStopCommands(run.testRun)
+	}
+	c.awaitFinalReports(testRunID)
+}
+
+// awaitFinalReports completes a run once all its agents have sent their final
+// metrics, or when the grace period runs out
+func (c *Coordinator) awaitFinalReports(testRunID string) {
+	c.mu.RLock()
+	run, active := c.activeRuns[testRunID]
+	done := active && run.allReported()
+	c.mu.RUnlock()
+
+	if done {
+		c.completeTestRun(testRunID)
+		return
+	}
+
+	time.AfterFunc(finalReportGracePeriod, func() {
+		c.completeTestRun(testRunID)
+	})
+}
@@ -6,16 +6,26 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/nats-io/nkeys"
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server   ServerConfig   `yaml:"server" json:"server"`
-	Database DatabaseConfig `yaml:"database" json:"database"`
-	Logging  LoggingConfig  `yaml:"logging" json:"logging"`
-	Output   OutputConfig   `yaml:"output" json:"output"`
-	Defaults DefaultsConfig `yaml:"defaults" json:"defaults"`
+	Server     ServerConfig     `yaml:"server" json:"server"`
+	Database   DatabaseConfig   `yaml:"database" json:"database"`
+	Logging    LoggingConfig    `yaml:"logging" json:"logging"`
+	Output     OutputConfig     `yaml:"output" json:"output"`
+	Defaults   DefaultsConfig   `yaml:"defaults" json:"defaults"`
+	Saturation SaturationConfig `yaml:"saturation" json:"saturation"`
+	JetStream  JetStreamConfig  `yaml:"jetstream" json:"jetstream"`
+	NATS       NATSConfig       `yaml:"nats" json:"nats"`
+	Signing    SigningConfig    `yaml:"signing" json:"signing"`
+	API        APIConfig        `yaml:"api" json:"api"`
+	HTTPS      HTTPSConfig      `yaml:"https" json:"https"`
+	Secrets    SecretsConfig    `yaml:"secrets" json:"secrets"`
+	Safety     SafetyConfig     `yaml:"safety" json:"safety"`
 }
 
 type ServerConfig struct {
@@ -53,6 +63,105 @@ type DefaultsConfig struct {
 	MinAgents         int    `yaml:"min_agents" json:"min_agents"`
 }
 
+// SaturationConfig holds the agent resource thresholds above which an agent is
+// flagged as saturated and its results marked as suspect. Zero disables a check.
+type SaturationConfig struct {
+	CPUPercent    float64 `yaml:"cpu_percent" json:"cpu_percent"`
+	MaxRSSMB      int     `yaml:"max_rss_mb" json:"max_rss_mb"`
+	MaxGoroutines int     `yaml:"max_goroutines" json:"max_goroutines"`
+	MaxOpenFDs    int     `yaml:"max_open_fds" json:"max_open_fds"`
+	GCPauseMs     float64 `yaml:"gc_pause_ms" json:"gc_pause_ms"`
+}
+
+// JetStreamConfig controls durable messaging on the embedded NATS server.
+// Telemetry, phase completions and agent execution updates are kept in
+// streams on disk so they survive brief disconnects. Off unless enabled.
+type JetStreamConfig struct {
+	Enabled  bool   `yaml:"enabled" json:"enabled"`
+	StoreDir string `yaml:"store_dir" json:"store_dir"` // Where stream data is kept
+	MaxAge   string `yaml:"max_age" json:"max_age"`     // How long stream messages are kept
+}
+
+// NATSConfig secures connections to the coordinator's embedded NATS server.
+// The coordinator and agents read the same section: the coordinator uses the
+// server certificate and the credentials it accepts, agents the CA, client
+// certificate and credentials they present.
+type NATSConfig struct {
+	TLS           bool   `yaml:"tls" json:"tls"`
+	CertFile      string `yaml:"cert_file" json:"cert_file"`           // Coordinator's server certificate
+	KeyFile       string `yaml:"key_file" json:"key_file"`             // Coordinator's server key
+	CAFile        string `yaml:"ca_file" json:"ca_file"`               // CA for agent certificates, and for the coordinator's certificate on agents
+	VerifyClients bool   `yaml:"verify_clients" json:"verify_clients"` // Require agents to present a certificate signed by ca_file (mTLS)
+
+	// Agent side of TLS
+	ClientCertFile string `yaml:"client_cert_file" json:"client_cert_file"`
+	ClientKeyFile  string `yaml:"client_key_file" json:"client_key_file"`
+	ServerName     string `yaml:"server_name" json:"server_name"` // Name in the coordinator's certificate, if not the host agents connect to
+
+	// Authentication. With none set any client may connect as an agent.
+	Agents       []NATSAgentCredential `yaml:"agents" json:"agents"`                 // Credentials bound to one agent ID each
+	Token        string                `yaml:"token" json:"-"`                       // Shared secret agents present
+	AgentNKeys   []string              `yaml:"agent_nkeys" json:"agent_nkeys"`       // Public NKeys of the agents allowed to connect
+	NKeySeedFile string                `yaml:"nkey_seed_file" json:"nkey_seed_file"` // Agent's NKey seed
+}
+
+// NATSAgentCredential is a token or NKey only the agent with ID may connect with
+type NATSAgentCredential struct {
+	ID    string `yaml:"id" json:"id"`
+	Token string `yaml:"token" json:"-"`
+	NKey  string `yaml:"nkey" json:"nkey"`
+}
+
+// SigningConfig holds the ed25519 key the coordinator signs agent commands with
+type SigningConfig struct {
+	KeyFile        string `yaml:"key_file" json:"key_file"`               // Coordinator's private key, generated if missing
+	CoordinatorKey string `yaml:"coordinator_key" json:"coordinator_key"` // Agent: coordinator's public key (base64) to pin
+}
+
+// APIConfig controls access to the HTTP API and web UI
+type APIConfig struct {
+	AuthEnabled bool     `yaml:"auth_enabled" json:"auth_enabled"` // Require an API key; an admin key is created on first start
+	CORSOrigins []string `yaml:"cors_origins" json:"cors_origins"` // Browser origins allowed besides the coordinator's UI; "*" allows any without cookies
+}
+
+// HTTPSConfig serves the HTTP API and web UI over TLS on their usual ports
+type HTTPSConfig struct {
+	Enabled           bool   `yaml:"enabled" json:"enabled"`
+	CertFile          string `yaml:"cert_file" json:"cert_file"`                     // Reloaded when it changes
+	KeyFile           string `yaml:"key_file" json:"key_file"`                       // Reloaded when it changes
+	ClientCAFile      string `yaml:"client_ca_file" json:"client_ca_file"`           // Verify client certificates signed by this CA
+	RequireClientCert bool   `yaml:"require_client_cert" json:"require_client_cert"` // Reject clients without such a certificate
+	RedirectHTTP      bool   `yaml:"redirect_http" json:"redirect_http"`             // Redirect plain HTTP on the same ports to HTTPS
+}
+
+// SecretsConfig holds the key the coordinator encrypts stored secrets with.
+// ARMONITE_SECRETS_KEY, a base64 32-byte key, takes precedence over the file.
+type SecretsConfig struct {
+	KeyFile string `yaml:"key_file" json:"key_file"` // Generated if missing and the variable is not set
+}
+
+// SafetyConfig limits the targets test runs may send load to and how much.
+// The coordinator checks plans against it when runs are created and sends it
+// to agents with each run; agents check it again, together with their own,
+// before every connection they open.
+type SafetyConfig struct {
+	AllowTargets []string      `yaml:"allow_targets" json:"allow_targets,omitempty"` // Hosts, *.domain patterns and CIDRs; when set, nothing else may be targeted
+	DenyTargets  []string      `yaml:"deny_targets" json:"deny_targets,omitempty"`   // Never targeted, even when allowed
+	Limits       []TargetLimit `yaml:"limits" json:"limits,omitempty"`
+}
+
+// TargetLimit caps the load a plan may put on hosts matching a pattern. Zero
+// leaves a value unlimited.
+type TargetLimit struct {
+	Host               string  `yaml:"host" json:"host"` // Host, *.domain pattern, CIDR or "*"
+	MaxConcurrency     int     `yaml:"max_concurrency" json:"max_concurrency,omitempty"`
+	MaxRPS             float64 `yaml:"max_rps" json:"max_rps,omitempty"`
+	MaxDuration        string  `yaml:"max_duration" json:"max_duration,omitempty"`
+	ConfirmConcurrency int     `yaml:"confirm_concurrency" json:"confirm_concurrency,omitempty"` // Above these, runs must be created with confirm: true
+	ConfirmRPS         float64 `yaml:"confirm_rps" json:"confirm_rps,omitempty"`
+	ConfirmDuration    string  `yaml:"confirm_duration" json:"confirm_duration,omitempty"`
+}
+
 var globalConfig *Config
 
 func LoadConfig(configPath string) (*Config, error) {
@@ -86,6 +195,27 @@ func LoadConfig(configPath string) (*Config, error) {
 			KeepAlive:         true,
 			MinAgents:         1,
 		},
+		Saturation: SaturationConfig{
+			CPUPercent: 90,
+			GCPauseMs:  100,
+		},
+		JetStream: JetStreamConfig{
+			Enabled:  false,
+			StoreDir: "./armonite-jetstream",
+			MaxAge:   "24h",
+		},
+		Signing: SigningConfig{
+			KeyFile: "./armonite-signing.key",
+		},
+		API: APIConfig{
+			AuthEnabled: true,
+		},
+		HTTPS: HTTPSConfig{
+			RedirectHTTP: true,
+		},
+		Secrets: SecretsConfig{
+			KeyFile: "./armonite-secrets.key",
+		},
 	}
 
 	if configPath == "" {
@@ -163,6 +293,54 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid default concurrency: %d", c.Defaults.Concurrency)
 	}
 
+	// Validate saturation thresholds
+	if c.Saturation.CPUPercent < 0 || c.Saturation.CPUPercent > 100 {
+		return fmt.Errorf("invalid saturation cpu_percent: %.0f", c.Saturation.CPUPercent)
+	}
+
+	// Validate JetStream settings
+	if c.JetStream.Enabled {
+		if c.JetStream.StoreDir == "" {
+			return fmt.Errorf("jetstream store_dir is required when jetstream is enabled")
+		}
+		if maxAge, err := time.ParseDuration(c.JetStream.MaxAge); err != nil || maxAge <= 0 {
+			return fmt.Errorf("invalid jetstream max_age: %q", c.JetStream.MaxAge)
+		}
+	}
+
+	// Validate NATS security settings
+	if err := c.NATS.Validate(); err != nil {
+		return err
+	}
+
+	// Validate HTTPS settings
+	if c.HTTPS.Enabled && (c.HTTPS.CertFile == "" || c.HTTPS.KeyFile == "") {
+		return fmt.Errorf("https cert_file and key_file are required when https is enabled")
+	}
+	if c.HTTPS.RequireClientCert && c.HTTPS.ClientCAFile == "" {
+		return fmt.Errorf("https client_ca_file is required when require_client_cert is enabled")
+	}
+
+	// Validate command signing settings
+	if c.Signing.KeyFile == "" {
+		return fmt.Errorf("signing key_file is required")
+	}
+	if c.Signing.CoordinatorKey != "" {
+		if _, err := parseCommandKey(c.Signing.CoordinatorKey); err != nil {
+			return err
+		}
+	}
+
+	// Validate secret store settings
+	if c.Secrets.KeyFile == "" && os.Getenv(secretsKeyEnv) == "" {
+		return fmt.Errorf("secrets key_file is required unless %s is set", secretsKeyEnv)
+	}
+
+	// Validate the target safety policy
+	if _, err := newSafetyPolicy(&c.Safety); err != nil {
+		return fmt.Errorf("invalid safety policy: %w", err)
+	}
+
 	// Create output directory if it doesn't exist
 	if err := os.MkdirAll(c.Output.Directory, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory %s: %w", c.Output.Directory, err)
@@ -171,6 +349,52 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// Validate checks that certificate files come in pairs, agent NKeys are
+// public user keys and each bound credential belongs to one agent
+func (n *NATSConfig) Validate() error {
+	if (n.CertFile == "") != (n.KeyFile == "") {
+		return fmt.Errorf("nats cert_file and key_file must be set together")
+	}
+	if (n.ClientCertFile == "") != (n.ClientKeyFile == "") {
+		return fmt.Errorf("nats client_cert_file and client_key_file must be set together")
+	}
+	if n.VerifyClients && n.CAFile == "" {
+		return fmt.Errorf("nats ca_file is required when verify_clients is enabled")
+	}
+	shared := make(map[string]bool)
+	for _, key := range n.AgentNKeys {
+		if !nkeys.IsValidPublicUserKey(key) {
+			return fmt.Errorf("invalid nats agent nkey: %q", key)
+		}
+		shared[key] = true
+	}
+
+	ids := make(map[string]bool)
+	credentials := make(map[string]bool)
+	for _, agent := range n.Agents {
+		if !validAgentID(agent.ID) {
+			return fmt.Errorf("invalid nats agent id %q: use letters, digits, '_' and '-'", agent.ID)
+		}
+		if ids[agent.ID] {
+			return fmt.Errorf("nats agent %s is listed twice", agent.ID)
+		}
+		ids[agent.ID] = true
+
+		if (agent.Token == "") == (agent.NKey == "") {
+			return fmt.Errorf("nats agent %s needs exactly one of token and nkey", agent.ID)
+		}
+		if agent.NKey != "" && !nkeys.IsValidPublicUserKey(agent.NKey) {
+			return fmt.Errorf("invalid nkey for nats agent %s: %q", agent.ID, agent.NKey)
+		}
+		credential := agent.Token + agent.NKey
+		if credentials[credential] || shared[credential] || (agent.Token != "" && agent.Token == n.Token) {
+			return fmt.Errorf("nats agent %s shares its credential with another agent", agent.ID)
+		}
+		credentials[credential] = true
+	}
+	return nil
+}
+
 func (c *Config) GetServerAddress() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
 }
@@ -185,11 +409,17 @@ func GetConfig() *Config {
 		if err != nil {
 			log.Printf("Failed to load config, using defaults: %v", err)
 			return &Config{
-				Server:   ServerConfig{Host: "0.0.0.0", Port: 4222},
-				Database: DatabaseConfig{DSN: "./armonite.db", MaxOpen: 25, MaxIdle: 5, MaxLifetime: "1h"},
-				Logging:  LoggingConfig{Level: "info", Format: "text"},
-				Output:   OutputConfig{Directory: "./results", Formats: []string{"json"}, Filename: "armonite-results"},
-				Defaults: DefaultsConfig{Concurrency: 100, Duration: "1m", BroadcastInterval: "5s", TelemetryInterval: "5s", KeepAlive: true},
+				Server:     ServerConfig{Host: "0.0.0.0", Port: 4222},
+				Database:   DatabaseConfig{DSN: "./armonite.db", MaxOpen: 25, MaxIdle: 5, MaxLifetime: "1h"},
+				Logging:    LoggingConfig{Level: "info", Format: "text"},
+				Output:     OutputConfig{Directory: "./results", Formats: []string{"json"}, Filename: "armonite-results"},
+				Defaults:   DefaultsConfig{Concurrency: 100, Duration: "1m", BroadcastInterval: "5s", TelemetryInterval: "5s", KeepAlive: true},
+				Saturation: SaturationConfig{CPUPercent: 90, GCPauseMs: 100},
+				JetStream:  JetStreamConfig{Enabled: false, StoreDir: "./armonite-jetstream", MaxAge: "24h"},
+				Signing:    SigningConfig{KeyFile: "./armonite-signing.key"},
+				API:        APIConfig{AuthEnabled: true},
+				HTTPS:      HTTPSConfig{RedirectHTTP: true},
+				Secrets:    SecretsConfig{KeyFile: "./armonite-secrets.key"},
 			}
 		}
 		globalConfig = config
@@ -235,5 +465,26 @@ func CreateDefaultConfig() *Config {
 			TelemetryInterval: "5s",
 			KeepAlive:         true,
 		},
+		Saturation: SaturationConfig{
+			CPUPercent: 90,
+			GCPauseMs:  100,
+		},
+		JetStream: JetStreamConfig{
+			Enabled:  false,
+			StoreDir: "./armonite-jetstream",
+			MaxAge:   "24h",
+		},
+		Signing: SigningConfig{
+			KeyFile: "./armonite-signing.key",
+		},
+		API: APIConfig{
+			AuthEnabled: true,
+		},
+		HTTPS: HTTPSConfig{
+			RedirectHTTP: true,
+		},
+		Secrets: SecretsConfig{
+			KeyFile: "./armonite-secrets.key",
+		},
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -17,10 +18,18 @@ import (
 type TestPlan struct {
 	Name           string          `yaml:"name" json:"name"`
 	Duration       string          `yaml:"duration" json:"duration"`
-	Concurrency    int             `yaml:"concurrency" json:"concurrency"`
+	Concurrency    int             `yaml:"concurrency" json:"concurrency"`                               // Global total, split across agents
+	TargetRPS      float64         `yaml:"target_rps,omitempty" json:"target_rps,omitempty"`             // Global request rate, split across agents
+	Allocation     string          `yaml:"allocation,omitempty" json:"allocation,omitempty"`             // even (default), weight or capacity
+	Rebalance      string          `yaml:"rebalance,omitempty" json:"rebalance,omitempty"`               // none (default) or constant
+	Regions        []PlanRegion    `yaml:"regions,omitempty" json:"regions,omitempty"`                   // Per-region load weights and endpoint overrides
 	RampUp         string          `yaml:"ramp_up,omitempty" json:"ramp_up,omitempty"`                   // Legacy field for backwards compatibility
 	RampUpStrategy *RampUpStrategy `yaml:"ramp_up_strategy,omitempty" json:"ramp_up_strategy,omitempty"` // New structured ramp-up
 	Endpoints      []Endpoint      `yaml:"endpoints" json:"endpoints"`
+
+	// Waiting for agents
+	WaitTimeout        string `yaml:"wait_timeout,omitempty" json:"wait_timeout,omitempty"`                 // Stop waiting for min_agents after this long
+	StartWithAvailable bool   `yaml:"start_with_available,omitempty" json:"start_with_available,omitempty"` // On wait timeout, start with the agents found instead of failing
 }
 
 type Endpoint struct {
@@ -29,20 +38,30 @@ type Endpoint struct {
 	Headers   map[string]string      `yaml:"headers"`
 	Body      map[string]interface{} `yaml:"body"`
 	ThinkTime string                 `yaml:"think_time"`
+	Templates bool                   `yaml:"templates,omitempty"` // Render {{ }} actions for every request
+
+	static bool // Resolved once for the run by prepareEndpoints
 }
 
 type Coordinator struct {
 	natsServer        *server.Server
 	natsConn          *nats.Conn
+	js                nats.JetStreamContext // Durable streams; nil when JetStream is disabled
+	natsAuth          *natsAuthenticator    // Admits the coordinator's connection and authenticated agents
+	signer            *commandSigner        // Signs commands to agents
+	secrets           *secretBox            // Encrypts the secret store
+	safety            *safetyPolicy         // Targets and load test runs may use; nil allows any
 	port              int
 	host              string
 	config            *Config
 	database          *Database
 	connectedAgents   map[string]*AgentInfo
 	testRuns          map[string]*TestRun
-	currentTestRun    *TestRun
+	activeRuns        map[string]*activeRun    // Waiting and running test runs, keyed by test run ID
+	agentReservations map[string]string        // agent ID -> test run ID the agent is reserved for
+	preflights        map[string]string        // agent ID -> test run ID the agent is running a preflight of
 	agentResults      map[string][]AgentResult // keyed by test run ID
-	phaseOrchestrator *PhaseOrchestrator       // For coordinated phase execution
+	schedules         map[string]*Schedule     // Recurring test runs, keyed by schedule ID
 	mu                sync.RWMutex
 }
 
@@ -52,8 +71,17 @@ type AgentInfo struct {
 	ConnectedAt     time.Time
 	LastSeen        time.Time
 	Concurrency     int
+	Weight          float64           // Relative share for weight-based allocation
+	Labels          map[string]string // Arbitrary key=value labels for agent selectors
 	ExecutionState  string
-	RampUpExecution *RampUpExecution // Current ramp-up state
+	RampUpExecution *RampUpExecution  // Current ramp-up state
+	TestRunID       string            // Test run the agent is reserved for, if any
+	Calibration     *AgentCalibration // Self-calibration result, if reported
+
+	// Resource telemetry from the latest heartbeat
+	Resources         *AgentResourceUsage
+	Saturated         bool
+	SaturationReasons []string
 }
 
 func runCoordinator(cmd *cobra.Command, args []string) error {
@@ -76,13 +104,24 @@ func runCoordinator(cmd *cobra.Command, args []string) error {
 		config.Server.EnableUI = true
 	}
 
+	safety, err := newSafetyPolicy(&config.Safety)
+	if err != nil {
+		return fmt.Errorf("invalid safety policy: %w", err)
+	}
+
 	coordinator := &Coordinator{
+		safety:          safety,
 		port:            config.Server.Port,
 		host:            config.Server.Host,
 		config:          config,
 		connectedAgents: make(map[string]*AgentInfo),
 		testRuns:        make(map[string]*TestRun),
 		agentResults:    make(map[string][]AgentResult),
+
+		activeRuns:        make(map[string]*activeRun),
+		agentReservations: make(map[string]string),
+		preflights:        make(map[string]string),
+		schedules:         make(map[string]*Schedule),
 	}
 
 	// Initialize database
@@ -91,11 +130,17 @@ func runCoordinator(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 	coordinator.database = db
+	if err := coordinator.ensureBootstrapAPIKey(); err != nil {
+		return fmt.Errorf("failed to set up API keys: %w", err)
+	}
 
 	// Load existing test runs from database
 	if err := coordinator.loadTestRunsFromDatabase(); err != nil {
 		LogWarn("Failed to load test runs from database: %v", err)
 	}
+	if err := coordinator.loadSchedulesFromDatabase(); err != nil {
+		LogWarn("Failed to load schedules from database: %v", err)
+	}
 
 	if err := coordinator.startNATSServer(); err != nil {
 		return fmt.Errorf("failed to start NATS server: %w", err)
@@ -105,18 +150,28 @@ func runCoordinator(cmd *cobra.Command, args []string) error {
 	if err := coordinator.connectToNATS(); err != nil {
 		return fmt.Errorf("failed to connect to NATS: %w", err)
 	}
+	if err := coordinator.setupCommandSigning(); err != nil {
+		return fmt.Errorf("failed to set up command signing: %w", err)
+	}
+	if err := coordinator.setupSecrets(); err != nil {
+		return fmt.Errorf("failed to set up secrets: %w", err)
+	}
+	if err := coordinator.setupJetStream(); err != nil {
+		LogWarn("JetStream unavailable, telemetry and events are not kept across disconnects: %v", err)
+	}
 
 	coordinator.startAgentRegistration()
 	coordinator.startInternalMessageHandler() // Start internal message handler first
 	coordinator.startTelemetryCollection()
 	coordinator.startStatusDisplay()
+	coordinator.startScheduler()
 	coordinator.startHTTPServer()
 
 	// Print startup banner with ASCII art
 	printStartupBanner(config)
 
 	LogInfo("Coordinator ready - waiting for test plans via HTTP API")
-	LogInfo("API server: http://%s:%d", config.Server.Host, config.Server.HTTPPort)
+	LogInfo("API server: %s://%s:%d", coordinator.scheme(), config.Server.Host, config.Server.HTTPPort)
 	LogInfo("Create test plans: POST /api/v1/test-runs")
 	LogInfo("Start tests: POST /api/v1/test-runs/{id}/start")
 	if config.Server.EnableUI {
@@ -124,7 +179,7 @@ func runCoordinator(cmd *cobra.Command, args []string) error {
 		if uiPort == 1 {
 			uiPort = 8081
 		}
-		LogInfo("Web UI available at: http://%s:%d/ui", config.Server.Host, uiPort)
+		LogInfo("Web UI available at: %s://%s:%d/ui", coordinator.scheme(), config.Server.Host, uiPort)
 	}
 
 	// Wait for interrupt signal
@@ -142,6 +197,13 @@ func (c *Coordinator) startNATSServer() error {
 		Host: c.host,
 		Port: c.port,
 	}
+	if c.config.JetStream.Enabled {
+		opts.JetStream = true
+		opts.StoreDir = c.config.JetStream.StoreDir
+	}
+	if err := c.configureNATSSecurity(opts); err != nil {
+		return err
+	}
 
 	LogDebug("Starting NATS server on %s:%d", c.host, c.port)
 
@@ -172,48 +234,50 @@ func (c *Coordinator) startNATSServer() error {
 	}
 }
 
+// connectToNATS connects in-process, so the coordinator needs neither TLS nor
+// the agents' credentials
 func (c *Coordinator) connectToNATS() error {
 	var err error
-	c.natsConn, err = nats.Connect(fmt.Sprintf("nats://%s:%d", c.host, c.port))
+	c.natsConn, err = nats.Connect("", nats.InProcessServer(c.natsServer),
+		nats.UserInfo(coordinatorNATSUser, c.natsAuth.coordinatorPassword),
+		nats.CustomInboxPrefix(coordinatorInboxPrefix))
 	return err
 }
 
+// startTelemetryCollection receives agents' interval deltas and final metrics.
+// With JetStream they come from one durable consumer, in the order each agent
+// sent them, including telemetry an agent buffered while disconnected.
 func (c *Coordinator) startTelemetryCollection() {
-	// Use dedicated goroutine for telemetry processing
-	go func() {
-		// Rate limiting state - local to this goroutine
-		lastUpdate := make(map[string]time.Time)
-
-		// Subscribe to telemetry messages
-		_, err := c.natsConn.Subscribe("armonite.telemetry", func(msg *nats.Msg) {
-			var metrics AgentMetrics
-			if err := json.Unmarshal(msg.Data, &metrics); err != nil {
-				LogError("Failed to unmarshal telemetry: %v", err)
-				return
-			}
-
-			// Rate limiting: only process updates from same agent if >1 second has passed
-			now := time.Now()
-			if lastTime, exists := lastUpdate[metrics.AgentID]; exists && now.Sub(lastTime) < time.Second {
-				return // Skip this update to prevent flooding
-			}
-			lastUpdate[metrics.AgentID] = now
-
-			LogDebug("Processing telemetry from agent %s: requests=%d, errors=%d, avg_latency=%.2fms",
-				metrics.AgentID, metrics.Requests, metrics.Errors, metrics.AvgLatencyMs)
-
-			// Send telemetry update via NATS to internal handler
-			c.handleTelemetryUpdate(metrics)
-		})
+	err := c.subscribeDurable(telemetryStream, telemetryConsumer, telemetrySubjects, func(msg *nats.Msg) {
+		if strings.HasSuffix(msg.Subject, "."+agentFinalKind) {
+			c.handleFinalMetrics(msg)
+			return
+		}
 
-		if err != nil {
-			LogError("Failed to subscribe to telemetry: %v", err)
+		var delta TelemetryDelta
+		if err := json.Unmarshal(msg.Data, &delta); err != nil {
+			LogError("Failed to unmarshal telemetry: %v", err)
 			return
 		}
+		if !checkSender(msg, delta.AgentID) {
+			return
+		}
+		if delta.Seq == 0 {
+			LogDebug("Ignoring telemetry without a sequence number from agent %s", delta.AgentID)
+			return
+		}
+
+		LogDebug("Processing telemetry interval %d from agent %s: requests=%d, errors=%d",
+			delta.Seq, delta.AgentID, delta.Requests, delta.Errors)
 
-		// Keep this goroutine alive
-		select {}
-	}()
+		// Send telemetry update via NATS to internal handler; every interval
+		// counts towards run totals and repeats are ignored there
+		c.handleTelemetryDelta(&delta)
+	})
+
+	if err != nil {
+		LogError("Failed to subscribe to telemetry: %v", err)
+	}
 }
 
 // Removed old broadcasting functions - replaced with workflow-based approach
@@ -228,11 +292,13 @@ func (c *Coordinator) shutdown() {
 		c.natsConn = nil
 	}
 
-	// Stop phase orchestrator if running
+	// Stop phase orchestrators of active runs
 	c.mu.Lock()
-	if c.phaseOrchestrator != nil {
-		c.phaseOrchestrator.Stop()
-		c.phaseOrchestrator = nil
+	for _, run := range c.activeRuns {
+		if run.phaseOrchestrator != nil {
+			run.phaseOrchestrator.Stop()
+			run.phaseOrchestrator = nil
+		}
 	}
 	c.mu.Unlock()
 
@@ -263,17 +329,51 @@ func (c *Coordinator) loadTestRunsFromDatabase() error {
 		return err
 	}
 
+	// Active runs are kept however old they are, so the queue survives restarts
+	activeTestRuns, err := c.database.ListTestRunsByStatus(TestRunStatusRunning, TestRunStatusWaiting, TestRunStatusQueued)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]bool, len(testRuns))
+	for _, testRun := range testRuns {
+		loaded[testRun.ID] = true
+	}
+	for _, testRun := range activeTestRuns {
+		if !loaded[testRun.ID] {
+			testRuns = append(testRuns, testRun)
+		}
+	}
+
 	for _, testRun := range testRuns {
 		c.testRuns[testRun.ID] = testRun
 
-		// If we find a running test run, set it as current
-		if testRun.Status == TestRunStatusRunning || testRun.Status == TestRunStatusWaiting {
-			c.currentTestRun = testRun
+		// Track runs that were active when the coordinator stopped
+		if testRun.IsActive() {
+			run := c.activateTestRun(testRun)
 
-			// Load agent results for the current test run
+			// Load agent results for the active test run
 			if agentResults, err := c.database.GetAgentResults(testRun.ID); err == nil {
 				c.agentResults[testRun.ID] = agentResults
 			}
+
+			// Complete running tests when they were due to end, and expire
+			// queued ones at their deadline
+			switch testRun.Status {
+			case TestRunStatusRunning:
+				// Keep the run's agents reserved for it as they reconnect
+				for _, allocation := range testRun.Allocation {
+					if allocation.Concurrency > 0 {
+						c.reserveAgent(run, allocation.AgentID)
+						run.participants[allocation.AgentID] = true
+					}
+				}
+				c.scheduleCompletion(testRun)
+			case TestRunStatusQueued:
+				c.scheduleQueueDeadline(testRun)
+			}
+			if testRun.IsPending() {
+				c.scheduleWaitTimeout(testRun)
+			}
 		}
 	}
 
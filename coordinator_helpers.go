@@ -8,7 +8,7 @@ import (
 )
 
 // handleTelemetryUpdate processes telemetry via internal message passing
-func (c *Coordinator) handleTelemetryUpdate(metrics AgentMetrics) {
+func (c *Coordinator) handleTelemetryUpdate(metrics *AgentMetrics) {
 	// Publish telemetry update as internal message
 	telemetryUpdate := map[string]interface{}{
 		"type":    "telemetry_update",
@@ -29,7 +29,8 @@ func (c *Coordinator) handleTelemetryUpdate(metrics AgentMetrics) {
 func (c *Coordinator) startInternalMessageHandler() {
 	go func() {
 		// Local state for this goroutine - no mutexes needed
-		agentResults := make(map[string][]AgentResult) // testRunID -> results
+		agentResults := make(map[string][]AgentResult)           // testRunID -> results
+		telemetry := make(map[string]map[string]*agentTelemetry) // testRunID -> agentID -> reported intervals
 
 		_, err := c.natsConn.Subscribe("armonite.coordinator.internal", func(msg *nats.Msg) {
 			var update map[string]interface{}
@@ -44,8 +45,10 @@ func (c *Coordinator) startInternalMessageHandler() {
 			}
 
 			switch updateType {
+			case "telemetry_delta":
+				c.processTelemetryDelta(update, agentResults, telemetry)
 			case "telemetry_update":
-				c.processTelemetryUpdate(update, agentResults)
+				c.processTelemetryUpdate(update, agentResults, telemetry)
 			case "test_run_started":
 				// Clear results for new test run
 				testRunID := update["test_run_id"].(string)
@@ -56,6 +59,7 @@ func (c *Coordinator) startInternalMessageHandler() {
 				if results, exists := agentResults[testRunID]; exists {
 					go c.saveAgentResultsToDatabase(testRunID, results)
 				}
+				delete(telemetry, testRunID)
 			case "get_agent_results":
 				// Handle request for agent results
 				testRunID, _ := update["test_run_id"].(string)
@@ -88,7 +92,51 @@ func (c *Coordinator) startInternalMessageHandler() {
 	}()
 }
 
-func (c *Coordinator) processTelemetryUpdate(update map[string]interface{}, agentResults map[string][]AgentResult) {
+// processTelemetryDelta adds an agent's reporting interval to its run totals
+func (c *Coordinator) processTelemetryDelta(update map[string]interface{}, agentResults map[string][]AgentResult, telemetry map[string]map[string]*agentTelemetry) {
+	deltaData, ok := update["delta"]
+	if !ok {
+		return
+	}
+
+	deltaBytes, err := json.Marshal(deltaData)
+	if err != nil {
+		return
+	}
+
+	var delta TelemetryDelta
+	if err := json.Unmarshal(deltaBytes, &delta); err != nil {
+		return
+	}
+
+	c.getTestRunInfo(delta.AgentID, delta.TestRunID, false, func(testRunID, region string) {
+		if testRunID == "" {
+			return
+		}
+
+		reported := agentTelemetryFor(telemetry, testRunID, delta.AgentID)
+		session, isNew := reported.session(delta.SessionID)
+		if isNew && len(reported.sessions) > 1 {
+			LogWarn("Agent %s restarted during test run %s (session %s)", delta.AgentID, testRunID, delta.SessionID)
+		}
+
+		skipped, counted := session.apply(&delta)
+		if !counted {
+			LogDebug("Ignoring repeated telemetry interval %d from agent %s", delta.Seq, delta.AgentID)
+			return
+		}
+		if skipped > 0 {
+			LogWarn("Telemetry from agent %s for test run %s is missing %d interval(s) before interval %d",
+				delta.AgentID, testRunID, skipped, delta.Seq)
+		}
+
+		c.updateAgentResult(agentResults, testRunID, reported.result(delta.AgentID, region))
+	})
+}
+
+// processTelemetryUpdate reconciles an agent's totals with its final metrics
+// snapshot, which is authoritative over the intervals received
+func (c *Coordinator) processTelemetryUpdate(update map[string]interface{}, agentResults map[string][]AgentResult, telemetry map[string]map[string]*agentTelemetry) {
 	metricsData, ok := update["metrics"]
 	if !ok {
 		return
@@ -104,59 +152,94 @@ func (c *Coordinator) processTelemetryUpdate(update map[string]interface{}, agen
 	if err := json.Unmarshal(metricsBytes, &metrics); err != nil {
 		return
 	}
+	if !metrics.Final {
+		return // Periodic telemetry arrives as interval deltas
+	}
 
-	// Get current test run ID via NATS query (non-blocking)
-	c.getCurrentTestRunInfo(func(testRunID, region string) {
+	// Find the test run these metrics belong to
+	c.getTestRunInfo(metrics.AgentID, metrics.TestRunID, true, func(testRunID, region string) {
 		if testRunID == "" {
 			return
 		}
 
-		// Initialize results slice for this test run if needed
-		if _, exists := agentResults[testRunID]; !exists {
-			agentResults[testRunID] = make([]AgentResult, 0)
+		reported := agentTelemetryFor(telemetry, testRunID, metrics.AgentID)
+		if reported.final {
+			return // Final metrics are sent until confirmed
 		}
+		reported.final = true
 
-		// Create new agent result
-		newResult := AgentResult{
-			AgentID:      metrics.AgentID,
-			Region:       region,
-			Requests:     metrics.Requests,
-			Errors:       metrics.Errors,
-			AvgLatencyMs: metrics.AvgLatencyMs,
-			MinLatencyMs: metrics.MinLatencyMs,
-			MaxLatencyMs: metrics.MaxLatencyMs,
-			StatusCodes:  metrics.StatusCodes,
+		session, _ := reported.session(metrics.SessionID)
+		if metrics.Interval != nil {
+			session.apply(metrics.Interval)
 		}
-
-		// Find existing agent result or append new one
-		found := false
-		for i, agent := range agentResults[testRunID] {
-			if agent.AgentID == metrics.AgentID {
-				agentResults[testRunID][i] = newResult
-				found = true
-				break
-			}
+		matched := session.reconcile(&metrics)
+
+		result := reported.result(metrics.AgentID, region)
+		if result.MissingIntervals > 0 {
+			LogWarn("Agent %s lost %d telemetry interval(s) for test run %s; its totals come from its final metrics",
+				metrics.AgentID, result.MissingIntervals, testRunID)
+		} else if !matched {
+			LogWarn("Intervals from agent %s for test run %s did not add up to its final metrics; using the final metrics",
+				metrics.AgentID, testRunID)
 		}
+		c.updateAgentResult(agentResults, testRunID, result)
+	})
+}
 
-		if !found {
-			agentResults[testRunID] = append(agentResults[testRunID], newResult)
+// agentTelemetryFor returns what an agent reported for a run, creating it on first use
+func agentTelemetryFor(telemetry map[string]map[string]*agentTelemetry, testRunID, agentID string) *agentTelemetry {
+	if telemetry[testRunID] == nil {
+		telemetry[testRunID] = make(map[string]*agentTelemetry)
+	}
+	reported, exists := telemetry[testRunID][agentID]
+	if !exists {
+		reported = newAgentTelemetry()
+		telemetry[testRunID][agentID] = reported
+	}
+	return reported
+}
+
+// updateAgentResult replaces an agent's entry in the run's results
+func (c *Coordinator) updateAgentResult(agentResults map[string][]AgentResult, testRunID string, result AgentResult) {
+	found := false
+	for i, agent := range agentResults[testRunID] {
+		if agent.AgentID == result.AgentID {
+			agentResults[testRunID][i] = result
+			found = true
+			break
 		}
+	}
 
-		// Periodically save to database (async)
-		go c.saveAgentResultsToDatabase(testRunID, agentResults[testRunID])
-	})
+	if !found {
+		agentResults[testRunID] = append(agentResults[testRunID], result)
+	}
+
+	// Periodically save to database (async)
+	go c.saveAgentResultsToDatabase(testRunID, agentResults[testRunID])
 }
 
-func (c *Coordinator) getCurrentTestRunInfo(callback func(testRunID, region string)) {
+// getTestRunInfo routes telemetry to the active run the agent reports, falling
+// back to the run the agent is reserved for. Telemetry for no active run is dropped.
+func (c *Coordinator) getTestRunInfo(agentID, reportedRunID string, final bool, callback func(testRunID, region string)) {
 	// This would normally query via NATS, but for now use a minimal lock
 	c.mu.RLock()
 	testRunID := ""
-	if c.currentTestRun != nil {
-		testRunID = c.currentTestRun.ID
+	if _, active := c.activeRuns[reportedRunID]; active {
+		testRunID = reportedRunID
+	} else if testRun, exists := c.testRuns[reportedRunID]; exists && final &&
+		(testRun.Status == TestRunStatusRunning || testRun.Status == TestRunStatusCompleting) {
+		// A final snapshot still counts while its run is being completed
+		testRunID = reportedRunID
+	} else if run := c.activeRunForAgent(agentID); run != nil {
+		testRunID = run.testRun.ID
+	}
+	region := ""
+	if agent, exists := c.connectedAgents[agentID]; exists {
+		region = agent.Region
 	}
 	c.mu.RUnlock()
 
-	callback(testRunID, "")
+	callback(testRunID, region)
 }
 
 // getAgentResultsViaMessage retrieves agent results using message passing
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"sort"
 	"time"
 )
 
@@ -12,55 +12,96 @@ func (c *Coordinator) startStatusDisplay() {
 
 func (c *Coordinator) broadcastTestStart(testRun *TestRun) error {
 	c.mu.Lock()
-	agentCount := len(c.connectedAgents)
+	run, active := c.activeRuns[testRun.ID]
+	if !active {
+		c.mu.Unlock()
+		return errRunNotActive
+	}
+	agentList := c.reservedAgents(testRun.ID)
+
+	// Agents left without workers are not part of this run and are released
+	// for other runs
+	allocations, _ := allocateLoad(testRun.TestPlan, agentList)
+	for _, allocation := range allocations {
+		if allocation.Concurrency <= 0 {
+			c.releaseAgent(run, allocation.AgentID)
+		}
+	}
+	c.mu.Unlock()
+
+	// Agents load the plan and acknowledge they are ready before anything starts
+	ready, err := c.prepareAgents(testRun, allocations)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	run, active = c.activeRuns[testRun.ID]
+	if !active {
+		c.mu.Unlock()
+		return errRunNotActive
+	}
+
+	// Split the plan's global load across the ready agents and record the split on the run
+	allocations, warnings := allocateLoad(testRun.TestPlan, ready)
+	testRun.setAllocation(allocations)
+	for _, warning := range warnings {
+		LogWarn("Allocation warning for test run %s: %s", testRun.Name, warning)
+		testRun.Warnings = append(testRun.Warnings, warning)
+	}
+
+	allocatedAgents := make(map[string]*AgentInfo)
+	for _, allocation := range allocations {
+		if allocation.Concurrency > 0 {
+			allocatedAgents[allocation.AgentID] = c.connectedAgents[allocation.AgentID]
+			run.participants[allocation.AgentID] = true
+		} else {
+			c.releaseAgent(run, allocation.AgentID)
+		}
+	}
 
 	// Initialize ramp-up execution for all agents if strategy is defined
 	if testRun.TestPlan.RampUpStrategy != nil {
-		for _, agent := range c.connectedAgents {
-			if agent.RampUpExecution == nil {
-				calculator, calcErr := NewRampUpCalculator(*testRun.TestPlan.RampUpStrategy, agent.Concurrency)
-				if calcErr == nil {
-					agent.RampUpExecution = calculator.Start()
-					LogDebug("Initialized ramp-up execution for agent %s with strategy %s",
-						agent.ID, testRun.TestPlan.RampUpStrategy.Type)
-				}
+		for _, allocation := range allocations {
+			agent := allocatedAgents[allocation.AgentID]
+			if agent == nil {
+				continue
+			}
+			strategy := scaleRampUpStrategy(*testRun.TestPlan.RampUpStrategy, allocation.Concurrency, testRun.TestPlan.Concurrency)
+			calculator, calcErr := NewRampUpCalculator(strategy, allocation.Concurrency)
+			if calcErr == nil {
+				agent.RampUpExecution = calculator.Start()
+				LogDebug("Initialized ramp-up execution for agent %s with strategy %s",
+					agent.ID, testRun.TestPlan.RampUpStrategy.Type)
 			}
 		}
 	}
 
+	// All agents start together, shortly after the last of them is ready
+	startAt := time.Now().Add(startLeadTime)
+	testRun.runningSince = startAt
+
 	// Check if we need phase orchestration for custom strategies with specific modes
 	needsPhaseOrchestration := c.hasSequentialPhases(testRun.TestPlan.RampUpStrategy)
 
 	if needsPhaseOrchestration {
 		// Start phase orchestration instead of simple broadcast
-		c.phaseOrchestrator = NewPhaseOrchestrator(testRun.ID, &testRun.TestPlan, c.natsConn, c.connectedAgents)
+		orchestrator := NewPhaseOrchestrator(testRun.ID, &testRun.TestPlan, c.natsConn, c.js, c.signer, allocatedAgents, allocations)
+		run.phaseOrchestrator = orchestrator
 		c.mu.Unlock()
 
-		LogInfo("Starting phase orchestration for test run: %s", testRun.Name)
-		c.phaseOrchestrator.Start()
+		LogInfo("Starting phase orchestration for test run %s at %s", testRun.Name, startAt.UTC().Format(time.RFC3339Nano))
+		time.AfterFunc(time.Until(startAt), orchestrator.Start)
 
 		return nil
 	}
 	c.mu.Unlock()
 
-	// Use standard broadcast for non-sequential tests
-	testStart := TestStartCommand{
-		TestRunID: testRun.ID,
-		TestPlan:  testRun.TestPlan,
-		StartTime: time.Now().UTC().Format(time.RFC3339),
-		Command:   "START",
-	}
-
-	data, err := json.Marshal(testStart)
-	if err != nil {
-		return err
-	}
-
-	if err := c.natsConn.Publish("armonite.test.command", data); err != nil {
+	// Send each agent its own share of the load and the common start time
+	if err := c.sendStartCommands(testRun, allocations, startAt); err != nil {
 		return err
 	}
 
-	LogInfo("Test start command sent to %d agents for test run: %s", agentCount, testRun.Name)
 	if testRun.TestPlan.RampUpStrategy != nil {
 		LogInfo("Ramp-up strategy enabled: %s (duration: %s)",
 			testRun.TestPlan.RampUpStrategy.Type, testRun.TestPlan.RampUpStrategy.Duration)
@@ -92,20 +133,86 @@ func (c *Coordinator) completeTestRun(testRunID string) {
 		return
 	}
 
-	// Stop phase orchestration if it's running
-	if c.phaseOrchestrator != nil {
-		c.phaseOrchestrator.Stop()
-		c.phaseOrchestrator = nil
+	// A run is completed once, whether by its timer or after a stop; this
+	// also stops phase orchestration and releases the run's agents
+	run := c.deactivateTestRun(testRunID)
+	if run == nil {
+		c.mu.Unlock()
+		return
+	}
+	saturated := run.saturatedAgents
+
+	agentRegions := make(map[string]string, len(testRun.Allocation))
+	for _, allocation := range testRun.Allocation {
+		agentRegions[allocation.AgentID] = allocation.Region
 	}
 
 	c.mu.Unlock()
 
-	// Collect results from agent data
-	agentResults := c.agentResults[testRunID]
+	// Collect results from the telemetry handler, which holds the agents'
+	// final snapshots, falling back to results loaded from the database
+	var agentResults []AgentResult
+	c.getAgentResultsViaMessage(testRunID, func(results []AgentResult) {
+		agentResults = results
+	})
+	if len(agentResults) == 0 {
+		agentResults = c.agentResults[testRunID]
+	}
 	if agentResults == nil {
 		agentResults = []AgentResult{}
 	}
 
+	// Agents that never sent final metrics are marked missing; their results,
+	// if any, are only as recent as their last periodic update
+	var missingAgents []string
+	for agentID := range run.participants {
+		if run.finalReports[agentID] {
+			continue
+		}
+		missingAgents = append(missingAgents, agentID)
+
+		found := false
+		for i := range agentResults {
+			if agentResults[i].AgentID == agentID {
+				agentResults[i].Missing = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			agentResults = append(agentResults, AgentResult{
+				AgentID:     agentID,
+				Region:      agentRegions[agentID],
+				StatusCodes: map[string]int64{},
+				Missing:     true,
+			})
+		}
+	}
+	sort.Strings(missingAgents)
+	if len(missingAgents) > 0 {
+		LogWarn("Test run %s completed without final metrics from %d agents: %v", testRun.Name, len(missingAgents), missingAgents)
+	}
+
+	// Mark results from saturated load generators as suspect
+	var saturatedAgents []string
+	for i := range agentResults {
+		if saturated[agentResults[i].AgentID] {
+			agentResults[i].Saturated = true
+		}
+	}
+	for agentID := range saturated {
+		saturatedAgents = append(saturatedAgents, agentID)
+	}
+	sort.Strings(saturatedAgents)
+
+	missingIntervals := 0
+	for _, result := range agentResults {
+		missingIntervals += result.MissingIntervals
+	}
+	if missingIntervals > 0 {
+		LogWarn("Test run %s lost %d telemetry interval(s) in transit", testRun.Name, missingIntervals)
+	}
+
 	// Calculate aggregate results
 	var totalRequests, totalErrors int64
 	var totalLatency float64
@@ -155,6 +262,12 @@ func (c *Coordinator) completeTestRun(testRunID string) {
 		}
 	}
 
+	// Region percentiles come from the merged histograms, which are not kept afterwards
+	regions := aggregateRegions(agentResults)
+	for i := range agentResults {
+		agentResults[i].Latency = nil
+	}
+
 	results := &TestRunResults{
 		TotalRequests:  totalRequests,
 		TotalErrors:    totalErrors,
@@ -165,6 +278,16 @@ func (c *Coordinator) completeTestRun(testRunID string) {
 		RequestsPerSec: requestsPerSec,
 		StatusCodes:    statusCodes,
 		AgentResults:   agentResults,
+		Regions:        regions,
+
+		Suspect:         len(saturatedAgents) > 0,
+		SaturatedAgents: saturatedAgents,
+		MissingAgents:   missingAgents,
+
+		MissingIntervals: missingIntervals,
+
+		AgentsRequested: testRun.AgentCount,
+		AgentsUsed:      testRun.AgentsUsed,
 	}
 
 	testRun.Complete(results)
@@ -173,21 +296,30 @@ func (c *Coordinator) completeTestRun(testRunID string) {
 	if err := c.database.SaveTestRun(testRun); err != nil {
 		LogError("Failed to save completed test run to database: %v", err)
 	}
-
-	// Clear current test run if this is it
-	if c.currentTestRun != nil && c.currentTestRun.ID == testRunID {
-		c.currentTestRun = nil
-	}
+	c.saveAgentResultsToDatabase(testRunID, agentResults)
 
 	LogInfo("Test run completed: %s", testRun.Name)
+
+	// Released agents may let waiting runs start
+	c.mu.Lock()
+	c.startWaitingRuns()
+	c.mu.Unlock()
 }
 
 type TestStartCommand struct {
 	TestRunID    string     `json:"test_run_id,omitempty"`
 	TestPlan     TestPlan   `json:"test_plan,omitempty"`
-	StartTime    string     `json:"start_time,omitempty"`
-	Command      string     `json:"command"` // START, STOP, START_PHASE, STOP_PHASE
+	StartTime    string     `json:"start_time,omitempty"` // When agents begin a START, RFC 3339
+	Command      string     `json:"command"`              // PREPARE, START, STOP, START_PHASE, STOP_PHASE, PREFLIGHT, CALIBRATE
 	CurrentPhase *PhaseInfo `json:"current_phase,omitempty"`
+
+	// Allocation is this agent's share of the plan load, sent on per-agent START
+	Allocation *AgentAllocation `json:"allocation,omitempty"`
+
+	// Safety policy agents enforce for the run, and whether the run was
+	// created with confirm: true
+	Safety    *SafetyConfig `json:"safety,omitempty"`
+	Confirmed bool          `json:"confirmed,omitempty"`
 }
 
 type PhaseInfo struct {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{"* * * *", "expected 5 fields"},
+		{"* * * * * *", "expected 5 fields"},
+		{"", "expected 5 fields"},
+		{"60 * * * *", "minute: 60 is outside 0-59"},
+		{"* 24 * * *", "hour: 24 is outside 0-23"},
+		{"* * 0 * *", "day of month: 0 is outside 1-31"},
+		{"* * 32 * *", "day of month: 32 is outside 1-31"},
+		{"* * * 13 *", "month: 13 is outside 1-12"},
+		{"* * * * 8", "day of week: 8 is outside 0-7"},
+		{"*/0 * * * *", "minute: invalid step"},
+		{"*/x * * * *", "minute: invalid step"},
+		{"30-10 * * * *", "minute: range \"30-10\" is backwards"},
+		{"1-x * * * *", "minute: invalid value \"x\""},
+		{"a * * * *", "minute: invalid value \"a\""},
+		{"* * * foo *", "month: invalid value \"foo\""},
+		{"* * * * mon-funday", "day of week: invalid value \"funday\""},
+		{"1,,2 * * * *", "minute: invalid value \"\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			if err == nil {
+				t.Fatalf("ParseCron(%q) succeeded, want an error", tt.expr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseCron(%q) error = %q, want it to contain %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04", value)
+		if err != nil {
+			t.Fatalf("bad test time %q: %v", value, err)
+		}
+		return parsed
+	}
+
+	// 2024-01-01 is a Monday; 2024 is a leap year
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string // Empty when the expression never fires
+	}{
+		{"every minute", "* * * * *", "2024-01-01 10:07", "2024-01-01 10:08"},
+		{"strictly after a fire time", "0 * * * *", "2024-01-01 10:00", "2024-01-01 11:00"},
+		{"step", "*/15 * * * *", "2024-01-01 10:07", "2024-01-01 10:15"},
+		{"step from a start value", "5/20 * * * *", "2024-01-01 10:06", "2024-01-01 10:25"},
+		{"step wraps to the next hour", "5/20 * * * *", "2024-01-01 10:45", "2024-01-01 11:05"},
+		{"range", "0 9-17 * * *", "2024-01-01 12:30", "2024-01-01 13:00"},
+		{"range wraps to the next day", "0 9-17 * * *", "2024-01-01 17:30", "2024-01-02 09:00"},
+		{"range with step", "0 9-17/4 * * *", "2024-01-01 09:00", "2024-01-01 13:00"},
+		{"list", "0 0 1,15 * *", "2024-01-02 00:00", "2024-01-15 00:00"},
+		{"list rolls over the month", "0 0 1,15 * *", "2024-01-15 00:00", "2024-02-01 00:00"},
+		{"month without the day is skipped", "0 0 31 * *", "2024-01-31 12:00", "2024-03-31 00:00"},
+		{"year rollover", "0 0 1 1 *", "2024-06-10 08:00", "2025-01-01 00:00"},
+		{"last minute of the year", "59 23 31 12 *", "2024-12-31 23:58", "2024-12-31 23:59"},
+		{"leap day", "0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"never fires", "0 0 30 2 *", "2024-01-01 00:00", ""},
+		{"day of month only", "0 0 13 * *", "2024-01-01 00:00", "2024-01-13 00:00"},
+		{"day of week only", "0 0 * * fri", "2024-01-01 00:00", "2024-01-05 00:00"},
+		{"day of month or day of week", "0 0 13 * fri", "2024-01-06 00:00", "2024-01-12 00:00"},
+		{"day of month before day of week", "0 0 13 * fri", "2024-01-12 00:00", "2024-01-13 00:00"},
+		{"day of week with ? for day of month", "0 0 ? * 1", "2024-01-01 00:00", "2024-01-08 00:00"},
+		{"7 is Sunday", "0 0 * * 7", "2024-01-01 00:00", "2024-01-07 00:00"},
+		{"0 is Sunday", "0 0 * * 0", "2024-01-01 00:00", "2024-01-07 00:00"},
+		{"weekday range", "30 8 * * mon-fri", "2024-01-05 09:00", "2024-01-08 08:30"},
+		{"month names", "0 12 1 jun-aug *", "2024-01-01 00:00", "2024-06-01 12:00"},
+		{"month names are case-insensitive", "0 12 1 JUN *", "2024-01-01 00:00", "2024-06-01 12:00"},
+		{"@hourly", "@hourly", "2024-01-01 10:07", "2024-01-01 11:00"},
+		{"@daily", "@daily", "2024-01-01 10:07", "2024-01-02 00:00"},
+		{"@weekly", "@weekly", "2024-01-01 10:07", "2024-01-07 00:00"},
+		{"@monthly", "@monthly", "2024-01-01 10:07", "2024-02-01 00:00"},
+		{"@yearly", "@yearly", "2024-01-01 10:07", "2025-01-01 00:00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+
+			from := at(tt.from).Add(30 * time.Second) // Seconds past the minute are dropped
+			got := schedule.Next(from)
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next(%s) = %s, want no fire time", tt.from, got.Format("2006-01-02 15:04"))
+				}
+				return
+			}
+			if want := at(tt.want); !got.Equal(want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got.Format("2006-01-02 15:04"), tt.want)
+			}
+		})
+	}
+}
+
+func TestCronNextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	schedule, err := ParseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := schedule.Next(time.Date(2024, 1, 1, 10, 0, 0, 0, loc))
+	want := time.Date(2024, 1, 2, 9, 0, 0, 0, loc)
+	if !got.Equal(want) || got.Location() != loc {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -23,6 +24,82 @@ type DBTestRun struct {
 	AgentCount  int        `json:"agent_count"`
 	Parameters  string     `gorm:"type:text" json:"parameters"` // JSON serialized
 	Results     string     `gorm:"type:text" json:"results"`    // JSON serialized
+	Warnings    string     `gorm:"type:text" json:"warnings"`   // JSON serialized
+	Allocation  string     `gorm:"type:text" json:"allocation"` // JSON serialized
+	Events      string     `gorm:"type:text" json:"events"`     // JSON serialized
+	Selector    string     `gorm:"type:text" json:"selector"`   // JSON serialized
+
+	Priority      int        `json:"priority"`
+	QueuedAt      *time.Time `json:"queued_at,omitempty"`
+	QueueDeadline *time.Time `json:"queue_deadline,omitempty"`
+	ScheduleID    string     `gorm:"index" json:"schedule_id"`
+	AgentsUsed    int        `json:"agents_used"`
+	FailureReason string     `json:"failure_reason"`
+	Confirmed     bool       `json:"confirmed"`
+}
+
+type DBSchedule struct {
+	ID            string     `gorm:"primaryKey" json:"id"`
+	Name          string     `gorm:"not null" json:"name"`
+	TestPlan      string     `gorm:"type:text" json:"test_plan"` // JSON serialized
+	Cron          string     `gorm:"not null" json:"cron"`
+	TimeZone      string     `json:"time_zone"`
+	MinAgents     int        `json:"min_agents"`
+	Parameters    string     `gorm:"type:text" json:"parameters"` // JSON serialized
+	Selector      string     `gorm:"type:text" json:"selector"`   // JSON serialized
+	Overlap       string     `json:"overlap"`
+	Priority      int        `json:"priority"`
+	QueueTimeout  string     `json:"queue_timeout"`
+	Enabled       bool       `json:"enabled"`
+	Confirmed     bool       `json:"confirmed"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	LastFireAt    *time.Time `json:"last_fire_at,omitempty"`
+	NextFireAt    *time.Time `json:"next_fire_at,omitempty"`
+	LastTestRunID string     `json:"last_test_run_id"`
+	LastOutcome   string     `json:"last_outcome"`
+	LastMessage   string     `json:"last_message"`
+}
+
+type DBAPIKey struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"not null" json:"name"`
+	Role       string     `gorm:"not null" json:"role"`
+	KeyHash    string     `gorm:"uniqueIndex;not null" json:"key_hash"` // SHA-256 of the key; the key itself is never stored
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type DBSecret struct {
+	Name      string    `gorm:"primaryKey" json:"name"`
+	Value     []byte    `gorm:"not null" json:"-"` // AES-GCM nonce and ciphertext; the value itself is never stored
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type DBAuditEntry struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Timestamp    time.Time `gorm:"not null;index" json:"timestamp"`
+	Actor        string    `gorm:"index" json:"actor"`
+	APIKeyID     string    `json:"api_key_id"`
+	Role         string    `json:"role"`
+	ClientCert   string    `json:"client_cert"`
+	SourceIP     string    `gorm:"index" json:"source_ip"`
+	ForwardedFor string    `json:"forwarded_for"`
+	Action       string    `gorm:"not null;index" json:"action"`
+	Target       string    `gorm:"index" json:"target"`
+	Summary      string    `gorm:"type:text" json:"summary"`
+	Status       int       `json:"status"`
+}
+
+// The audit log is append-only
+func (*DBAuditEntry) BeforeUpdate(*gorm.DB) error {
+	return errAuditAppendOnly
+}
+
+func (*DBAuditEntry) BeforeDelete(*gorm.DB) error {
+	return errAuditAppendOnly
 }
 
 type DBAgentResult struct {
@@ -36,9 +113,15 @@ type DBAgentResult struct {
 	MinLatencyMs float64   `json:"min_latency_ms"`
 	MaxLatencyMs float64   `json:"max_latency_ms"`
 	StatusCodes  string    `gorm:"type:text" json:"status_codes"` // JSON serialized
+	Saturated    bool      `json:"saturated"`
+	Missing      bool      `json:"missing"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	MissingIntervals int `json:"missing_intervals"`
 }
 
+var errAuditAppendOnly = errors.New("audit entries cannot be changed or deleted")
+
 type Database struct {
 	db *gorm.DB
 }
@@ -82,7 +165,7 @@ func NewDatabase(config DatabaseConfig) (*Database, error) {
 }
 
 func (d *Database) migrate() error {
-	return d.db.AutoMigrate(&DBTestRun{}, &DBAgentResult{})
+	return d.db.AutoMigrate(&DBTestRun{}, &DBAgentResult{}, &DBSchedule{}, &DBAPIKey{}, &DBSecret{}, &DBAuditEntry{})
 }
 
 func (d *Database) Close() error {
@@ -115,6 +198,30 @@ func (d *Database) SaveTestRun(testRun *TestRun) error {
 		resultsJSON = string(resultsBytes)
 	}
 
+	warningsJSON, err := json.Marshal(testRun.Warnings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal warnings: %w", err)
+	}
+
+	allocationJSON, err := json.Marshal(testRun.Allocation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal allocation: %w", err)
+	}
+
+	eventsJSON, err := json.Marshal(testRun.Events)
+	if err != nil {
+		return fmt.Errorf("failed to marshal events: %w", err)
+	}
+
+	var selectorJSON string
+	if testRun.Selector != nil {
+		selectorBytes, err := json.Marshal(testRun.Selector)
+		if err != nil {
+			return fmt.Errorf("failed to marshal selector: %w", err)
+		}
+		selectorJSON = string(selectorBytes)
+	}
+
 	dbTestRun := DBTestRun{
 		ID:          testRun.ID,
 		Name:        testRun.Name,
@@ -127,6 +234,18 @@ func (d *Database) SaveTestRun(testRun *TestRun) error {
 		AgentCount:  testRun.AgentCount,
 		Parameters:  string(parametersJSON),
 		Results:     resultsJSON,
+		Warnings:    string(warningsJSON),
+		Allocation:  string(allocationJSON),
+		Events:      string(eventsJSON),
+		Selector:    selectorJSON,
+
+		Priority:      testRun.Priority,
+		QueuedAt:      testRun.QueuedAt,
+		QueueDeadline: testRun.QueueDeadline,
+		ScheduleID:    testRun.ScheduleID,
+		AgentsUsed:    testRun.AgentsUsed,
+		FailureReason: testRun.FailureReason,
+		Confirmed:     testRun.Confirmed,
 	}
 
 	return d.db.Save(&dbTestRun).Error
@@ -168,6 +287,30 @@ func (d *Database) ListTestRuns(limit, offset int) ([]*TestRun, error) {
 	return testRuns, nil
 }
 
+// ListTestRunsByStatus returns every test run in one of the given statuses, oldest first
+func (d *Database) ListTestRunsByStatus(statuses ...TestRunStatus) ([]*TestRun, error) {
+	names := make([]string, len(statuses))
+	for i, status := range statuses {
+		names[i] = string(status)
+	}
+
+	var dbTestRuns []DBTestRun
+	if err := d.db.Where("status IN ?", names).Order("created_at ASC").Find(&dbTestRuns).Error; err != nil {
+		return nil, err
+	}
+
+	testRuns := make([]*TestRun, len(dbTestRuns))
+	for i, dbTestRun := range dbTestRuns {
+		testRun, err := d.convertDBTestRun(&dbTestRun)
+		if err != nil {
+			return nil, err
+		}
+		testRuns[i] = testRun
+	}
+
+	return testRuns, nil
+}
+
 func (d *Database) DeleteTestRun(id string) error {
 	// Delete agent results first
 	if err := d.db.Delete(&DBAgentResult{}, "test_run_id = ?", id).Error; err != nil {
@@ -274,7 +417,11 @@ func (d *Database) SaveAgentResults(testRunID string, results []AgentResult) err
 			MinLatencyMs: result.MinLatencyMs,
 			MaxLatencyMs: result.MaxLatencyMs,
 			StatusCodes:  string(statusCodesJSON),
+			Saturated:    result.Saturated,
+			Missing:      result.Missing,
 			UpdatedAt:    time.Now(),
+
+			MissingIntervals: result.MissingIntervals,
 		}
 
 		if err := d.db.Create(&dbResult).Error; err != nil {
@@ -307,6 +454,10 @@ func (d *Database) GetAgentResults(testRunID string) ([]AgentResult, error) {
 			MinLatencyMs: dbResult.MinLatencyMs,
 			MaxLatencyMs: dbResult.MaxLatencyMs,
 			StatusCodes:  statusCodes,
+			Saturated:    dbResult.Saturated,
+			Missing:      dbResult.Missing,
+
+			MissingIntervals: dbResult.MissingIntervals,
 		}
 	}
 
@@ -335,6 +486,35 @@ func (d *Database) convertDBTestRun(dbTestRun *DBTestRun) (*TestRun, error) {
 		}
 	}
 
+	var warnings []string
+	if dbTestRun.Warnings != "" {
+		if err := json.Unmarshal([]byte(dbTestRun.Warnings), &warnings); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal warnings: %w", err)
+		}
+	}
+
+	var allocation []AgentAllocation
+	if dbTestRun.Allocation != "" {
+		if err := json.Unmarshal([]byte(dbTestRun.Allocation), &allocation); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal allocation: %w", err)
+		}
+	}
+
+	var events []TestRunEvent
+	if dbTestRun.Events != "" {
+		if err := json.Unmarshal([]byte(dbTestRun.Events), &events); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal events: %w", err)
+		}
+	}
+
+	var selector *AgentSelector
+	if dbTestRun.Selector != "" {
+		selector = &AgentSelector{}
+		if err := json.Unmarshal([]byte(dbTestRun.Selector), selector); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal selector: %w", err)
+		}
+	}
+
 	return &TestRun{
 		ID:          dbTestRun.ID,
 		Name:        dbTestRun.Name,
@@ -347,5 +527,316 @@ func (d *Database) convertDBTestRun(dbTestRun *DBTestRun) (*TestRun, error) {
 		Results:     results,
 		AgentCount:  dbTestRun.AgentCount,
 		Parameters:  parameters,
+		Warnings:    warnings,
+		Allocation:  allocation,
+		Events:      events,
+		Selector:    selector,
+
+		Priority:      dbTestRun.Priority,
+		QueuedAt:      dbTestRun.QueuedAt,
+		QueueDeadline: dbTestRun.QueueDeadline,
+		ScheduleID:    dbTestRun.ScheduleID,
+		AgentsUsed:    dbTestRun.AgentsUsed,
+		FailureReason: dbTestRun.FailureReason,
+		Confirmed:     dbTestRun.Confirmed,
 	}, nil
 }
+
+// Schedule operations
+func (d *Database) SaveSchedule(schedule *Schedule) error {
+	testPlanJSON, err := json.Marshal(schedule.TestPlan)
+	if err != nil {
+		return fmt.Errorf("failed to marshal test plan: %w", err)
+	}
+
+	parametersJSON, err := json.Marshal(schedule.Parameters)
+	if err != nil {
+		return fmt.Errorf("failed to marshal parameters: %w", err)
+	}
+
+	var selectorJSON string
+	if schedule.Selector != nil {
+		selectorBytes, err := json.Marshal(schedule.Selector)
+		if err != nil {
+			return fmt.Errorf("failed to marshal selector: %w", err)
+		}
+		selectorJSON = string(selectorBytes)
+	}
+
+	dbSchedule := DBSchedule{
+		ID:            schedule.ID,
+		Name:          schedule.Name,
+		TestPlan:      string(testPlanJSON),
+		Cron:          schedule.Cron,
+		TimeZone:      schedule.TimeZone,
+		MinAgents:     schedule.MinAgents,
+		Parameters:    string(parametersJSON),
+		Selector:      selectorJSON,
+		Overlap:       schedule.Overlap,
+		Priority:      schedule.Priority,
+		QueueTimeout:  schedule.QueueTimeout,
+		Enabled:       schedule.Enabled,
+		Confirmed:     schedule.Confirmed,
+		CreatedAt:     schedule.CreatedAt,
+		UpdatedAt:     schedule.UpdatedAt,
+		LastFireAt:    schedule.LastFireAt,
+		NextFireAt:    schedule.NextFireAt,
+		LastTestRunID: schedule.LastTestRunID,
+		LastOutcome:   schedule.LastOutcome,
+		LastMessage:   schedule.LastMessage,
+	}
+
+	return d.db.Save(&dbSchedule).Error
+}
+
+func (d *Database) ListSchedules() ([]*Schedule, error) {
+	var dbSchedules []DBSchedule
+	if err := d.db.Order("created_at ASC").Find(&dbSchedules).Error; err != nil {
+		return nil, err
+	}
+
+	schedules := make([]*Schedule, len(dbSchedules))
+	for i, dbSchedule := range dbSchedules {
+		var testPlan TestPlan
+		if err := json.Unmarshal([]byte(dbSchedule.TestPlan), &testPlan); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal test plan: %w", err)
+		}
+
+		var parameters map[string]interface{}
+		if dbSchedule.Parameters != "" {
+			if err := json.Unmarshal([]byte(dbSchedule.Parameters), &parameters); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal parameters: %w", err)
+			}
+		}
+
+		var selector *AgentSelector
+		if dbSchedule.Selector != "" {
+			selector = &AgentSelector{}
+			if err := json.Unmarshal([]byte(dbSchedule.Selector), selector); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal selector: %w", err)
+			}
+		}
+
+		schedules[i] = &Schedule{
+			ID:            dbSchedule.ID,
+			Name:          dbSchedule.Name,
+			TestPlan:      testPlan,
+			Cron:          dbSchedule.Cron,
+			TimeZone:      dbSchedule.TimeZone,
+			MinAgents:     dbSchedule.MinAgents,
+			Parameters:    parameters,
+			Selector:      selector,
+			Overlap:       dbSchedule.Overlap,
+			Priority:      dbSchedule.Priority,
+			QueueTimeout:  dbSchedule.QueueTimeout,
+			Enabled:       dbSchedule.Enabled,
+			Confirmed:     dbSchedule.Confirmed,
+			CreatedAt:     dbSchedule.CreatedAt,
+			UpdatedAt:     dbSchedule.UpdatedAt,
+			LastFireAt:    dbSchedule.LastFireAt,
+			NextFireAt:    dbSchedule.NextFireAt,
+			LastTestRunID: dbSchedule.LastTestRunID,
+			LastOutcome:   dbSchedule.LastOutcome,
+			LastMessage:   dbSchedule.LastMessage,
+		}
+	}
+
+	return schedules, nil
+}
+
+func (d *Database) DeleteSchedule(id string) error {
+	return d.db.Delete(&DBSchedule{}, "id = ?", id).Error
+}
+
+// API key operations
+func (d *Database) SaveAPIKey(key *APIKey) error {
+	dbKey := DBAPIKey{
+		ID:         key.ID,
+		Name:       key.Name,
+		Role:       key.Role,
+		KeyHash:    key.KeyHash,
+		Prefix:     key.Prefix,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+	return d.db.Save(&dbKey).Error
+}
+
+func (d *Database) ListAPIKeys() ([]*APIKey, error) {
+	var dbKeys []DBAPIKey
+	if err := d.db.Order("created_at ASC").Find(&dbKeys).Error; err != nil {
+		return nil, err
+	}
+
+	keys := make([]*APIKey, len(dbKeys))
+	for i := range dbKeys {
+		keys[i] = convertDBAPIKey(&dbKeys[i])
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByHash returns the key with the given hash, or nil if there is none
+func (d *Database) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	var dbKey DBAPIKey
+	err := d.db.Where("key_hash = ?", hash).Limit(1).Find(&dbKey).Error
+	if err != nil || dbKey.ID == "" {
+		return nil, err
+	}
+	return convertDBAPIKey(&dbKey), nil
+}
+
+func (d *Database) TouchAPIKey(id string, usedAt time.Time) error {
+	return d.db.Model(&DBAPIKey{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
+
+// DeleteAPIKey returns the number of keys deleted
+func (d *Database) DeleteAPIKey(id string) (int64, error) {
+	result := d.db.Delete(&DBAPIKey{}, "id = ?", id)
+	return result.RowsAffected, result.Error
+}
+
+func (d *Database) CountAPIKeys(role string) (int64, error) {
+	var count int64
+	query := d.db.Model(&DBAPIKey{})
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
+	err := query.Count(&count).Error
+	return count, err
+}
+
+func convertDBAPIKey(dbKey *DBAPIKey) *APIKey {
+	return &APIKey{
+		ID:         dbKey.ID,
+		Name:       dbKey.Name,
+		Role:       dbKey.Role,
+		KeyHash:    dbKey.KeyHash,
+		Prefix:     dbKey.Prefix,
+		CreatedAt:  dbKey.CreatedAt,
+		LastUsedAt: dbKey.LastUsedAt,
+	}
+}
+
+// Secret operations
+func (d *Database) SaveSecret(secret *Secret, sealed []byte) error {
+	dbSecret := DBSecret{
+		Name:      secret.Name,
+		Value:     sealed,
+		CreatedAt: secret.CreatedAt,
+		UpdatedAt: secret.UpdatedAt,
+	}
+	return d.db.Save(&dbSecret).Error
+}
+
+func (d *Database) ListSecrets() ([]*Secret, error) {
+	var dbSecrets []DBSecret
+	if err := d.db.Order("name ASC").Find(&dbSecrets).Error; err != nil {
+		return nil, err
+	}
+
+	secrets := make([]*Secret, len(dbSecrets))
+	for i := range dbSecrets {
+		secrets[i] = convertDBSecret(&dbSecrets[i])
+	}
+	return secrets, nil
+}
+
+// GetSecret returns a secret and its sealed value, or nil if there is none
+func (d *Database) GetSecret(name string) (*Secret, []byte, error) {
+	var dbSecret DBSecret
+	err := d.db.Where("name = ?", name).Limit(1).Find(&dbSecret).Error
+	if err != nil || dbSecret.Name == "" {
+		return nil, nil, err
+	}
+	return convertDBSecret(&dbSecret), dbSecret.Value, nil
+}
+
+// DeleteSecret returns the number of secrets deleted
+func (d *Database) DeleteSecret(name string) (int64, error) {
+	result := d.db.Delete(&DBSecret{}, "name = ?", name)
+	return result.RowsAffected, result.Error
+}
+
+func convertDBSecret(dbSecret *DBSecret) *Secret {
+	return &Secret{
+		Name:      dbSecret.Name,
+		CreatedAt: dbSecret.CreatedAt,
+		UpdatedAt: dbSecret.UpdatedAt,
+	}
+}
+
+// Audit operations. There are deliberately none that change or delete entries.
+func (d *Database) SaveAuditEntry(entry *AuditEntry) error {
+	dbEntry := DBAuditEntry{
+		Timestamp:    entry.Timestamp,
+		Actor:        entry.Actor,
+		APIKeyID:     entry.APIKeyID,
+		Role:         entry.Role,
+		ClientCert:   entry.ClientCert,
+		SourceIP:     entry.SourceIP,
+		ForwardedFor: entry.ForwardedFor,
+		Action:       entry.Action,
+		Target:       entry.Target,
+		Summary:      entry.Summary,
+		Status:       entry.Status,
+	}
+	if err := d.db.Create(&dbEntry).Error; err != nil {
+		return err
+	}
+	entry.ID = dbEntry.ID
+	return nil
+}
+
+// ListAuditEntries returns the entries matching filter, newest first, and
+// how many match in total
+func (d *Database) ListAuditEntries(filter AuditFilter) ([]*AuditEntry, int64, error) {
+	query := d.db.Model(&DBAuditEntry{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if filter.SourceIP != "" {
+		query = query.Where("source_ip = ?", filter.SourceIP)
+	}
+	if filter.Since != nil {
+		query = query.Where("timestamp >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("timestamp < ?", *filter.Until)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var dbEntries []DBAuditEntry
+	err := query.Order("timestamp DESC, id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&dbEntries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]*AuditEntry, len(dbEntries))
+	for i, dbEntry := range dbEntries {
+		entries[i] = &AuditEntry{
+			ID:           dbEntry.ID,
+			Timestamp:    dbEntry.Timestamp,
+			Actor:        dbEntry.Actor,
+			APIKeyID:     dbEntry.APIKeyID,
+			Role:         dbEntry.Role,
+			ClientCert:   dbEntry.ClientCert,
+			SourceIP:     dbEntry.SourceIP,
+			ForwardedFor: dbEntry.ForwardedFor,
+			Action:       dbEntry.Action,
+			Target:       dbEntry.Target,
+			Summary:      dbEntry.Summary,
+			Status:       dbEntry.Status,
+		}
+	}
+	return entries, total, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// endpointTemplateFuncs are the functions available inside {{ }} actions in
+// the URLs, header values and string body fields of endpoints with templates
+// enabled
+var endpointTemplateFuncs = template.FuncMap{
+	"uuid": func() string {
+		return uuid.New().String()
+	},
+	"now": func() string {
+		return time.Now().UTC().Format(time.RFC3339)
+	},
+	"timestamp": func() int64 {
+		return time.Now().Unix()
+	},
+	"randInt": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + rand.Intn(max-min)
+	},
+}
+
+// endpointTemplateCache avoids re-parsing the same template on every request
+var endpointTemplateCache sync.Map // text -> *template.Template
+
+// isEndpointTemplate reports whether a value contains template actions
+func isEndpointTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// parseEndpointTemplate parses a single endpoint template value
+func parseEndpointTemplate(text string) (*template.Template, error) {
+	if cached, ok := endpointTemplateCache.Load(text); ok {
+		return cached.(*template.Template), nil
+	}
+
+	tmpl, err := template.New("endpoint").Funcs(endpointTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	endpointTemplateCache.Store(text, tmpl)
+	return tmpl, nil
+}
+
+// renderEndpointTemplate resolves template actions in a value, returning
+// plain values unchanged
+func renderEndpointTemplate(text string) (string, error) {
+	if !isEndpointTemplate(text) {
+		return text, nil
+	}
+
+	tmpl, err := parseEndpointTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// resolveEndpointValue renders a value's templates, if enabled, then its
+// secret references. Secret values are never parsed as templates.
+func resolveEndpointValue(text string, templates bool) (string, error) {
+	if templates {
+		rendered, err := renderEndpointTemplate(text)
+		if err != nil {
+			return "", err
+		}
+		text = rendered
+	}
+	return substituteSecrets(text)
+}
+
+// prepareEndpoints resolves a run's endpoints once. Endpoints without
+// templates are resolved here, secrets included, and sent as they are; those
+// with templates are rendered for every request. An endpoint that fails to
+// resolve is left to fail the same way on each request.
+func prepareEndpoints(endpoints []Endpoint) []Endpoint {
+	prepared := make([]Endpoint, len(endpoints))
+	for i, endpoint := range endpoints {
+		if !endpoint.Templates {
+			if resolved, err := resolveEndpoint(endpoint); err == nil {
+				endpoint = resolved
+				endpoint.static = true
+			}
+		}
+		prepared[i] = endpoint
+	}
+	return prepared
+}
+
+// resolveEndpoint returns a copy of the endpoint with its templates and
+// secret references resolved. Endpoints prepared as static are returned
+// untouched.
+func resolveEndpoint(endpoint Endpoint) (Endpoint, error) {
+	if endpoint.static {
+		return endpoint, nil
+	}
+	resolved := endpoint
+
+	url, err := resolveEndpointValue(endpoint.URL, endpoint.Templates)
+	if err != nil {
+		return endpoint, fmt.Errorf("url: %w", err)
+	}
+	resolved.URL = url
+
+	if len(endpoint.Headers) > 0 {
+		resolved.Headers = make(map[string]string, len(endpoint.Headers))
+		for key, value := range endpoint.Headers {
+			rendered, err := resolveEndpointValue(value, endpoint.Templates)
+			if err != nil {
+				return endpoint, fmt.Errorf("headers.%s: %w", key, err)
+			}
+			resolved.Headers[key] = rendered
+		}
+	}
+
+	if endpoint.Body != nil {
+		body, err := resolveBodyValue(endpoint.Body, "body", endpoint.Templates)
+		if err != nil {
+			return endpoint, err
+		}
+		resolved.Body = body.(map[string]interface{})
+	}
+
+	return resolved, nil
+}
+
+// resolveBodyValue walks a decoded JSON body and resolves every string in it
+func resolveBodyValue(value interface{}, path string, templates bool) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		rendered, err := resolveEndpointValue(v, templates)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return rendered, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered, err := resolveBodyValue(item, path+"."+key, templates)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered, err := resolveBodyValue(item, fmt.Sprintf("%s[%d]", path, i), templates)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	default:
+		return value, nil
+	}
+}
@@ -26,16 +26,24 @@ type CoordinatorStatus struct {
 }
 
 type AgentStatusInfo struct {
-	ID             string    `json:"id"`
-	Region         string    `json:"region"`
-	Concurrency    int       `json:"concurrency"`
-	ConnectedAt    time.Time `json:"connected_at"`
-	LastSeen       time.Time `json:"last_seen"`
-	Requests       int64     `json:"requests"`
-	Errors         int64     `json:"errors"`
-	AvgLatency     float64   `json:"avg_latency_ms"`
-	Status         string    `json:"status"`
-	ExecutionState string    `json:"execution_state"`
+	ID             string            `json:"id"`
+	Region         string            `json:"region"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Concurrency    int               `json:"concurrency"`
+	ConnectedAt    time.Time         `json:"connected_at"`
+	LastSeen       time.Time         `json:"last_seen"`
+	Requests       int64             `json:"requests"`
+	Errors         int64             `json:"errors"`
+	AvgLatency     float64           `json:"avg_latency_ms"`
+	Status         string            `json:"status"`
+	ExecutionState string            `json:"execution_state"`
+	TestRunID      string            `json:"test_run_id,omitempty"` // Test run the agent is reserved for
+	Calibration    *AgentCalibration `json:"calibration,omitempty"`
+
+	// Host resource telemetry and saturation flag
+	Resources         *AgentResourceUsage `json:"resources,omitempty"`
+	Saturated         bool                `json:"saturated"`
+	SaturationReasons []string            `json:"saturation_reasons,omitempty"`
 }
 
 func (c *Coordinator) startHTTPServer() {
@@ -65,45 +73,69 @@ func (c *Coordinator) startAPIServer() {
 	})
 
 	// Add CORS middleware
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type")
+	router.Use(c.corsMiddleware("GET, POST, PUT, DELETE, OPTIONS"))
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	})
+	// Roles required by the API endpoints. Control-plane actions are audited
+	// before the role check, so refused attempts are recorded as well.
+	viewer := c.requireRole(RoleViewer)
+	operator := c.requireRole(RoleOperator)
+	admin := c.requireRole(RoleAdmin)
 
 	// API endpoints
 	api := router.Group("/api/v1")
 	{
 		// Coordinator status (not test status)
-		api.GET("/status", c.handleCoordinatorStatus)
-		api.GET("/agents", c.handleAgents)
+		api.GET("/status", viewer, c.handleCoordinatorStatus)
+		api.GET("/agents", viewer, c.handleAgents)
+		api.POST("/agents/:id/calibrate", c.audit("agent.calibrate"), operator, c.handleCalibrateAgent)
+
+		// Test plan validation
+		api.POST("/test-plans/validate", viewer, c.handleValidateTestPlan)
 
 		// Test run management
-		api.POST("/test-runs", c.handleCreateTestRun)
-		api.GET("/test-runs", c.handleListTestRuns)
-		api.GET("/test-runs/:id", c.handleGetTestRun)
-		api.GET("/test-runs/:id/results", c.handleGetTestRunResults)
-		api.POST("/test-runs/:id/start", c.handleStartTestRun)
-		api.POST("/test-runs/:id/stop", c.handleStopTestRun)
-		api.POST("/test-runs/:id/rerun", c.handleRerunTestRun)
-		api.DELETE("/test-runs/:id", c.handleDeleteTestRun)
+		api.POST("/test-runs", c.audit("test_run.create"), operator, c.handleCreateTestRun)
+		api.GET("/test-runs", viewer, c.handleListTestRuns)
+		api.GET("/test-runs/:id", viewer, c.handleGetTestRun)
+		api.GET("/test-runs/:id/results", viewer, c.handleGetTestRunResults)
+		api.POST("/test-runs/:id/start", c.audit("test_run.start"), operator, c.handleStartTestRun)
+		api.POST("/test-runs/:id/stop", c.audit("test_run.stop"), operator, c.handleStopTestRun)
+		api.POST("/test-runs/:id/rerun", c.audit("test_run.rerun"), operator, c.handleRerunTestRun)
+		api.POST("/test-runs/:id/preflight", c.audit("test_run.preflight"), operator, c.handlePreflightTestRun)
+		api.DELETE("/test-runs/:id", c.audit("test_run.delete"), admin, c.handleDeleteTestRun)
 
 		// Bulk deletion endpoints
-		api.DELETE("/test-runs", c.handleBulkDeleteTestRuns)
-		api.GET("/test-runs/stats", c.handleTestRunStats)
+		api.DELETE("/test-runs", c.audit("test_run.bulk_delete"), admin, c.handleBulkDeleteTestRuns)
+		api.GET("/test-runs/stats", viewer, c.handleTestRunStats)
+
+		// Queue of test runs waiting to start
+		api.GET("/queue", viewer, c.handleGetQueue)
+
+		// Recurring test runs
+		api.POST("/schedules", c.audit("schedule.create"), operator, c.handleCreateSchedule)
+		api.GET("/schedules", viewer, c.handleListSchedules)
+		api.GET("/schedules/:id", viewer, c.handleGetSchedule)
+		api.PUT("/schedules/:id", c.audit("schedule.update"), operator, c.handleUpdateSchedule)
+		api.DELETE("/schedules/:id", c.audit("schedule.delete"), admin, c.handleDeleteSchedule)
+		api.POST("/schedules/:id/trigger", c.audit("schedule.trigger"), operator, c.handleTriggerSchedule)
 
 		// Test connection endpoint
-		api.POST("/test-connection", c.handleTestConnection)
+		api.POST("/test-connection", operator, c.handleTestConnection)
+
+		// API keys
+		api.GET("/api-keys", admin, c.handleListAPIKeys)
+		api.POST("/api-keys", c.audit("api_key.create"), admin, c.handleCreateAPIKey)
+		api.DELETE("/api-keys/:id", c.audit("api_key.revoke"), admin, c.handleDeleteAPIKey)
+
+		// Secrets test plans refer to as ${secret:name}; values are never returned
+		api.GET("/secrets", operator, c.handleListSecrets)
+		api.PUT("/secrets/:name", c.audit("secret.put"), admin, c.handlePutSecret)
+		api.DELETE("/secrets/:name", c.audit("secret.delete"), admin, c.handleDeleteSecret)
+
+		// Audit log of the actions above
+		api.GET("/audit", admin, c.handleListAudit)
 
 		// Legacy endpoints (deprecated)
-		api.GET("/metrics", c.handleMetrics)
+		api.GET("/metrics", viewer, c.handleMetrics)
 	}
 
 	// Root endpoint - basic info
@@ -133,7 +165,7 @@ func (c *Coordinator) startAPIServer() {
 		MaxHeaderBytes: 1 << 20, // 1MB
 	}
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if err := c.listenAndServe(server); err != nil && err != http.ErrServerClosed {
 		LogError("Failed to start API server: %v", err)
 	}
 }
@@ -146,18 +178,13 @@ func (c *Coordinator) startUIServer() {
 	router.Use(gin.Recovery()) // Only use recovery middleware, no request logging
 
 	// Add CORS middleware
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type")
+	router.Use(c.corsMiddleware("GET, POST, OPTIONS"))
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	})
+	// Sign in with an API key before anything else is served
+	router.Use(c.requireUILogin())
+	router.GET("/login", c.handleUILoginPage)
+	router.POST("/login", c.handleUILogin)
+	router.POST("/logout", c.handleUILogout)
 
 	// Get embedded UI filesystem
 	uiFS, err := GetEmbeddedUI()
@@ -196,7 +223,11 @@ func (c *Coordinator) startUIServer() {
 	LogDebug("UI server starting on port %d", uiPort)
 	LogInfo("React UI served from embedded filesystem")
 
-	if err := router.Run(":" + strconv.Itoa(uiPort)); err != nil {
+	server := &http.Server{
+		Addr:    ":" + strconv.Itoa(uiPort),
+		Handler: router,
+	}
+	if err := c.listenAndServe(server); err != nil && err != http.ErrServerClosed {
 		LogError("Failed to start UI server: %v", err)
 	}
 }
@@ -218,14 +249,8 @@ func (c *Coordinator) handleCoordinatorStatus(ctx *gin.Context) {
 		"total_test_runs":  len(c.testRuns),
 	}
 
-	// Add current test run info if one is active
-	if c.currentTestRun != nil {
-		status["current_test_run"] = gin.H{
-			"id":     c.currentTestRun.ID,
-			"name":   c.currentTestRun.Name,
-			"status": c.currentTestRun.Status,
-		}
-	}
+	// Test runs waiting for agents or running, each on its own agents
+	status["active_test_runs"] = c.activeRunSummaries()
 
 	ctx.JSON(http.StatusOK, status)
 }
@@ -254,6 +279,7 @@ func (c *Coordinator) handleRootInfo(ctx *gin.Context) {
 			"coordinator": []string{
 				"GET /api/v1/status",
 				"GET /api/v1/agents",
+				"POST /api/v1/agents/{id}/calibrate",
 				"GET /health",
 			},
 			"test_runs": []string{
@@ -264,11 +290,24 @@ func (c *Coordinator) handleRootInfo(ctx *gin.Context) {
 				"POST /api/v1/test-runs/{id}/start",
 				"POST /api/v1/test-runs/{id}/stop",
 				"POST /api/v1/test-runs/{id}/rerun",
+				"POST /api/v1/test-runs/{id}/preflight",
 				"DELETE /api/v1/test-runs/{id}",
 				"DELETE /api/v1/test-runs",
 				"GET /api/v1/test-runs/stats",
+				"GET /api/v1/queue",
 				"POST /api/v1/test-connection",
 			},
+			"schedules": []string{
+				"POST /api/v1/schedules",
+				"GET /api/v1/schedules",
+				"GET /api/v1/schedules/{id}",
+				"PUT /api/v1/schedules/{id}",
+				"DELETE /api/v1/schedules/{id}",
+				"POST /api/v1/schedules/{id}/trigger",
+			},
+			"test_plans": []string{
+				"POST /api/v1/test-plans/validate",
+			},
 		},
 		"documentation": "Distributed load testing coordinator with test run management",
 	})
@@ -292,16 +331,16 @@ func (c *Coordinator) getCoordinatorStatus() CoordinatorStatus {
 		executionState := "idle"
 		if agentInfo.ExecutionState != "" {
 			executionState = agentInfo.ExecutionState
-		} else if c.currentTestRun != nil && c.currentTestRun.Status == TestRunStatusRunning {
-			// If test is running but no specific execution state, assume running
+		} else if run := c.activeRunForAgent(agentInfo.ID); run != nil && run.testRun.Status == TestRunStatusRunning {
+			// If the agent's test is running but no specific execution state, assume running
 			executionState = "running"
 		}
 
-		// Get metrics for this agent from current test run
+		// Get metrics for this agent from the test run it is reserved for
 		var requests, errors int64
 		var avgLatency float64
-		if c.currentTestRun != nil {
-			if results, exists := c.agentResults[c.currentTestRun.ID]; exists {
+		if agentInfo.TestRunID != "" {
+			if results, exists := c.agentResults[agentInfo.TestRunID]; exists {
 				for _, result := range results {
 					if result.AgentID == agentInfo.ID {
 						requests = result.Requests
@@ -316,6 +355,7 @@ func (c *Coordinator) getCoordinatorStatus() CoordinatorStatus {
 		agents = append(agents, AgentStatusInfo{
 			ID:             agentInfo.ID,
 			Region:         agentInfo.Region,
+			Labels:         agentInfo.Labels,
 			Concurrency:    agentInfo.Concurrency,
 			ConnectedAt:    agentInfo.ConnectedAt,
 			LastSeen:       agentInfo.LastSeen,
@@ -324,17 +364,21 @@ func (c *Coordinator) getCoordinatorStatus() CoordinatorStatus {
 			AvgLatency:     avgLatency,
 			Status:         status,
 			ExecutionState: executionState,
+			TestRunID:      agentInfo.TestRunID,
+			Calibration:    agentInfo.Calibration,
+
+			Resources:         agentInfo.Resources,
+			Saturated:         agentInfo.Saturated,
+			SaturationReasons: agentInfo.SaturationReasons,
 		})
 	}
 
-	// Calculate totals from current test run
+	// Calculate totals across active test runs
 	var totalRequests, totalErrors int64
-	if c.currentTestRun != nil {
-		if results, exists := c.agentResults[c.currentTestRun.ID]; exists {
-			for _, result := range results {
-				totalRequests += result.Requests
-				totalErrors += result.Errors
-			}
+	for testRunID := range c.activeRuns {
+		for _, result := range c.agentResults[testRunID] {
+			totalRequests += result.Requests
+			totalErrors += result.Errors
 		}
 	}
 
@@ -348,20 +392,22 @@ func (c *Coordinator) getCoordinatorStatus() CoordinatorStatus {
 	var testRunning, testCompleted bool
 	var startTime *time.Time
 
-	if c.currentTestRun != nil {
-		switch c.currentTestRun.Status {
+	// Any running test makes the coordinator running, reporting the earliest start
+	for _, run := range c.sortedActiveRuns() {
+		switch run.testRun.Status {
 		case TestRunStatusRunning:
 			coordinatorStatus = "running"
 			testRunning = true
-			startTime = c.currentTestRun.StartedAt
-		case TestRunStatusCompleted, TestRunStatusFailed, TestRunStatusCancelled:
-			coordinatorStatus = "completed"
-			testCompleted = true
-			startTime = c.currentTestRun.StartedAt
-		case TestRunStatusWaiting:
-			coordinatorStatus = "waiting_for_agents"
+			if startTime == nil {
+				startTime = run.testRun.StartedAt
+			}
+		case TestRunStatusWaiting, TestRunStatusQueued:
+			if !testRunning {
+				coordinatorStatus = "waiting_for_agents"
+			}
 		}
-	} else if len(c.connectedAgents) > 0 {
+	}
+	if len(c.activeRuns) == 0 && len(c.connectedAgents) > 0 {
 		coordinatorStatus = "ready"
 	}
 
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadCheckInterval is how often the certificate files are checked for changes
+const certReloadCheckInterval = 10 * time.Second
+
+// tlsHandshakeRecord is the first byte of a TLS ClientHello
+const tlsHandshakeRecord = 0x16
+
+// certReloader serves the certificate in certFile and keyFile, loading it
+// again once either file changes
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	checkedAt   time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *certReloader) load() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return nil
+}
+
+// getCertificate implements tls.Config.GetCertificate. A certificate that
+// fails to load, e.g. halfway through being replaced, leaves the previous
+// one in use until the next check.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checkedAt) < certReloadCheckInterval {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+
+	certInfo, certErr := os.Stat(r.certFile)
+	keyInfo, keyErr := os.Stat(r.keyFile)
+	if certErr != nil || keyErr != nil {
+		return r.cert, nil
+	}
+	if certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime) {
+		return r.cert, nil
+	}
+
+	if err := r.load(); err != nil {
+		LogWarn("Keeping the current HTTPS certificate: %v", err)
+		return r.cert, nil
+	}
+	LogInfo("Reloaded HTTPS certificate from %s", r.certFile)
+	return r.cert, nil
+}
+
+// httpsTLSConfig builds the TLS configuration shared by the API and UI servers
+func httpsTLSConfig(config HTTPSConfig) (*tls.Config, error) {
+	reloader, err := newCertReloader(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}
+	if config.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in client CA %s", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if config.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConfig, nil
+}
+
+// listenAndServe serves server on its address, over HTTPS when configured.
+// With redirect_http, plain HTTP requests on the same port are redirected to
+// HTTPS.
+func (c *Coordinator) listenAndServe(server *http.Server) error {
+	if !c.config.HTTPS.Enabled {
+		return server.ListenAndServe()
+	}
+
+	tlsConfig, err := httpsTLSConfig(c.config.HTTPS)
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = tlsConfig
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	if !c.config.HTTPS.RedirectHTTP {
+		return server.Serve(tls.NewListener(listener, tlsConfig))
+	}
+
+	split := newProtocolSplitListener(listener, tlsConfig)
+	redirect := &http.Server{
+		Handler:           http.HandlerFunc(redirectToHTTPS),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go redirect.Serve(split.plain)
+	return server.Serve(split.secure)
+}
+
+// redirectToHTTPS sends a plain HTTP request to the same URL over HTTPS
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	target := "https://" + r.Host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusPermanentRedirect)
+}
+
+// protocolSplitListener hands TLS connections to one listener and plain
+// ones to another, going by the first byte the client sends
+type protocolSplitListener struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	secure    *connListener
+	plain     *connListener
+}
+
+func newProtocolSplitListener(listener net.Listener, tlsConfig *tls.Config) *protocolSplitListener {
+	split := &protocolSplitListener{
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		secure:    newConnListener(listener.Addr()),
+		plain:     newConnListener(listener.Addr()),
+	}
+	go split.acceptLoop()
+	return split
+}
+
+func (s *protocolSplitListener) acceptLoop() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			s.secure.close(err)
+			s.plain.close(err)
+			return
+		}
+		go s.route(conn)
+	}
+}
+
+// route peeks at the first byte without holding up other connections
+func (s *protocolSplitListener) route(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	peeked := &peekedConn{Conn: conn, reader: reader}
+	if first[0] == tlsHandshakeRecord {
+		s.secure.deliver(tls.Server(peeked, s.tlsConfig))
+		return
+	}
+	s.plain.deliver(peeked)
+}
+
+// peekedConn reads the bytes already peeked before the rest of the connection
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// connListener is a net.Listener fed with connections accepted elsewhere
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+	err   error
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *connListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *connListener) close(err error) {
+	l.once.Do(func() {
+		l.err = err
+		close(l.done)
+	})
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func (l *connListener) Close() error {
+	l.close(net.ErrClosed)
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// scheme is how the API and UI are reached
+func (c *Coordinator) scheme() string {
+	if c.config.HTTPS.Enabled {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Durable streams on the coordinator's embedded JetStream
+const (
+	telemetryStream = "ARMONITE_TELEMETRY" // Interval deltas and final metrics, in the order agents sent them
+	eventsStream    = "ARMONITE_EVENTS"    // Phase completions and agent execution updates
+
+	telemetryConsumer = "coordinator-telemetry"
+	executionConsumer = "coordinator-execution"
+
+	// Agents replaying buffered messages within this window are not stored twice
+	streamDuplicateWindow = 10 * time.Minute
+)
+
+// Subjects the coordinator's durable consumers read
+var (
+	telemetrySubjects = []string{agentSubject("*", agentTelemetryKind), agentSubject("*", agentFinalKind)}
+	executionSubjects = []string{agentSubject("*", agentExecutionKind)}
+)
+
+// durableStreams returns the streams the coordinator keeps, with messages
+// kept for maxAge
+func durableStreams(maxAge time.Duration) []*nats.StreamConfig {
+	return []*nats.StreamConfig{
+		{
+			Name:       telemetryStream,
+			Subjects:   telemetrySubjects,
+			Storage:    nats.FileStorage,
+			MaxAge:     maxAge,
+			Duplicates: streamDuplicateWindow,
+		},
+		{
+			Name:       eventsStream,
+			Subjects:   append([]string{agentSubject("*", agentPhaseKind+".*")}, executionSubjects...),
+			Storage:    nats.FileStorage,
+			MaxAge:     maxAge,
+			Duplicates: streamDuplicateWindow,
+		},
+	}
+}
+
+// setupJetStream creates or updates the durable streams. Without JetStream
+// the coordinator falls back to plain subscriptions.
+func (c *Coordinator) setupJetStream() error {
+	if !c.config.JetStream.Enabled {
+		LogInfo("JetStream disabled; telemetry and events are not kept across disconnects")
+		return nil
+	}
+
+	maxAge, err := time.ParseDuration(c.config.JetStream.MaxAge)
+	if err != nil {
+		return fmt.Errorf("invalid jetstream max_age: %w", err)
+	}
+
+	js, err := c.natsConn.JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to get JetStream context: %w", err)
+	}
+
+	for _, stream := range durableStreams(maxAge) {
+		if _, err := js.StreamInfo(stream.Name); errors.Is(err, nats.ErrStreamNotFound) {
+			_, err = js.AddStream(stream)
+			if err != nil {
+				return fmt.Errorf("failed to create stream %s: %w", stream.Name, err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to look up stream %s: %w", stream.Name, err)
+		} else if _, err := js.UpdateStream(stream); err != nil {
+			return fmt.Errorf("failed to update stream %s: %w", stream.Name, err)
+		}
+	}
+
+	c.js = js
+	LogInfo("JetStream streams ready in %s", c.config.JetStream.StoreDir)
+	return nil
+}
+
+// subscribeDurable delivers the messages of a stream to handler through a
+// durable consumer, which resumes where it left off after a restart. Each
+// message is acknowledged once handled. Without JetStream, handler gets the
+// subjects' messages as they are published.
+func (c *Coordinator) subscribeDurable(stream, consumer string, subjects []string, handler nats.MsgHandler) error {
+	if c.js == nil {
+		for _, subject := range subjects {
+			if _, err := c.natsConn.Subscribe(subject, handler); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// A consumer left from a release with other subjects cannot be bound
+	if info, err := c.js.ConsumerInfo(stream, consumer); err == nil && !sameSubjects(consumerSubjects(info.Config), subjects) {
+		LogInfo("Replacing consumer %s of stream %s: its subjects changed", consumer, stream)
+		if err := c.js.DeleteConsumer(stream, consumer); err != nil {
+			return fmt.Errorf("failed to replace consumer %s: %w", consumer, err)
+		}
+	}
+
+	_, err := c.js.Subscribe("", func(msg *nats.Msg) {
+		handler(msg)
+		if err := msg.Ack(); err != nil {
+			LogWarn("Failed to acknowledge %s message: %v", msg.Subject, err)
+		}
+	}, nats.BindStream(stream), nats.Durable(consumer), nats.ConsumerFilterSubjects(subjects...),
+		nats.DeliverAll(), nats.ManualAck(), nats.AckExplicit())
+	return err
+}
+
+// consumerSubjects returns the subjects a consumer reads
+func consumerSubjects(config nats.ConsumerConfig) []string {
+	if config.FilterSubject != "" {
+		return []string{config.FilterSubject}
+	}
+	return config.FilterSubjects
+}
+
+// sameSubjects reports whether two subject lists hold the same subjects
+func sameSubjects(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool, len(a))
+	for _, subject := range a {
+		seen[subject] = true
+	}
+	for _, subject := range b {
+		if !seen[subject] {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribeStream delivers a subject's stored and new messages to handler
+// through a consumer that lasts as long as the subscription
+func subscribeStream(natsConn *nats.Conn, js nats.JetStreamContext, subject string, handler nats.MsgHandler) (*nats.Subscription, error) {
+	if js == nil {
+		return natsConn.Subscribe(subject, handler)
+	}
+	return js.Subscribe(subject, handler, nats.DeliverAll(), nats.AckNone())
+}
+
+// isStreamMessage reports whether msg was delivered by a JetStream consumer,
+// whose reply subject is for acknowledgements rather than responses
+func isStreamMessage(msg *nats.Msg) bool {
+	_, err := msg.Metadata()
+	return err == nil
+}
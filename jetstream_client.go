@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Durable publishing limits
+const (
+	durablePublishTimeout = 2 * time.Second // Wait for the stream to confirm a message
+	durableBufferLimit    = 10000           // Messages kept while the coordinator is unreachable
+	durableFlushBatch     = 100             // Buffered messages taken per flush pass
+)
+
+// errDurableBuffered means a message was queued behind earlier buffered messages
+var errDurableBuffered = errors.New("queued behind buffered messages")
+
+// durableMessage is a message not yet confirmed by the coordinator's stream
+type durableMessage struct {
+	subject string
+	data    []byte
+	msgID   string // Lets the stream drop a copy it already stored
+	seq     uint64 // Buffer order, so a flush removes only what it stored
+}
+
+// setupDurablePublishing uses the coordinator's JetStream when it has the
+// telemetry stream. Otherwise messages are published as before and are lost
+// while disconnected.
+func (a *Agent) setupDurablePublishing() {
+	js, err := a.natsConn.JetStream()
+	if err == nil {
+		_, err = js.StreamInfo(telemetryStream)
+	}
+
+	a.durableMu.Lock()
+	defer a.durableMu.Unlock()
+	if err != nil {
+		a.js = nil
+		LogInfo("Coordinator has no JetStream streams (%v); telemetry is not buffered while disconnected", err)
+		return
+	}
+	a.js = js
+	LogDebug("Publishing telemetry to the coordinator's JetStream")
+}
+
+// publishDurable publishes a message so that it is stored by the coordinator's
+// stream. While earlier messages are buffered, or when this one cannot be
+// stored now, it is buffered and a background flush replays the buffer in
+// order, so senders never wait on it. An error means the message is buffered
+// rather than stored.
+func (a *Agent) publishDurable(subject string, data []byte, msgID string) error {
+	message := durableMessage{subject: subject, data: data, msgID: msgID}
+
+	a.durableMu.Lock()
+	if len(a.durableBuffer) > 0 {
+		a.bufferDurable(message)
+		a.durableMu.Unlock()
+		go a.flushDurableBuffer()
+		return errDurableBuffered
+	}
+	js := a.js
+	a.durableMu.Unlock()
+
+	if err := a.storeDurable(js, message); err != nil {
+		a.durableMu.Lock()
+		a.bufferDurable(message)
+		a.durableMu.Unlock()
+		go a.flushDurableBuffer()
+		return err
+	}
+	return nil
+}
+
+// replayDurableBuffer sends the messages buffered while disconnected
+func (a *Agent) replayDurableBuffer() {
+	a.durableMu.Lock()
+	buffered := len(a.durableBuffer)
+	a.durableMu.Unlock()
+	if buffered == 0 {
+		return
+	}
+
+	stored, err := a.flushDurableBuffer()
+	if err != nil {
+		LogWarn("Replayed %d buffered messages before failing: %v", stored, err)
+		return
+	}
+	if stored > 0 {
+		LogInfo("Replayed %d buffered messages to the coordinator", stored)
+	}
+}
+
+// flushDurableBuffer stores buffered messages in order, a batch at a time,
+// until the buffer is empty or one fails, and returns how many it stored.
+// The lock is only held between publishes, so senders keep appending while
+// it waits on the stream. Only one flush runs at a time; others return at once.
+func (a *Agent) flushDurableBuffer() (int, error) {
+	a.durableMu.Lock()
+	if a.durableFlushing {
+		a.durableMu.Unlock()
+		return 0, nil
+	}
+	a.durableFlushing = true
+	a.durableMu.Unlock()
+
+	stored := 0
+	for {
+		a.durableMu.Lock()
+		batch := append([]durableMessage(nil), a.durableBuffer[:min(len(a.durableBuffer), durableFlushBatch)]...)
+		js := a.js
+		if len(batch) == 0 {
+			a.durableBuffer = nil
+			a.durableFlushing = false
+			a.durableMu.Unlock()
+			return stored, nil
+		}
+		a.durableMu.Unlock()
+
+		var sent uint64
+		var err error
+		for _, message := range batch {
+			if err = a.storeDurable(js, message); err != nil {
+				break
+			}
+			sent = message.seq
+			stored++
+		}
+
+		a.durableMu.Lock()
+		a.dropDurableThrough(sent)
+		if err != nil {
+			a.durableFlushing = false
+			a.durableMu.Unlock()
+			return stored, err
+		}
+		a.durableMu.Unlock()
+	}
+}
+
+// dropDurableThrough removes stored messages from the front of the buffer.
+// Messages dropped to make room while they were being published are already
+// gone. Caller must hold a.durableMu.
+func (a *Agent) dropDurableThrough(seq uint64) {
+	i := 0
+	for i < len(a.durableBuffer) && a.durableBuffer[i].seq <= seq {
+		i++
+	}
+	a.durableBuffer = a.durableBuffer[i:]
+}
+
+// storeDurable publishes one message and waits for the stream to confirm it.
+// Without JetStream it is only published.
+func (a *Agent) storeDurable(js nats.JetStreamContext, message durableMessage) error {
+	if js == nil {
+		return a.natsConn.Publish(message.subject, message.data)
+	}
+
+	opts := []nats.PubOpt{nats.AckWait(durablePublishTimeout)}
+	if message.msgID != "" {
+		opts = append(opts, nats.MsgId(message.msgID))
+	}
+	_, err := js.Publish(message.subject, message.data, opts...)
+	return err
+}
+
+// bufferDurable keeps a message for replay, dropping the oldest once the
+// buffer is full. Caller must hold a.durableMu.
+func (a *Agent) bufferDurable(message durableMessage) {
+	if len(a.durableBuffer) >= durableBufferLimit {
+		LogWarn("Telemetry buffer full, dropping oldest message on %s", a.durableBuffer[0].subject)
+		a.durableBuffer = a.durableBuffer[1:]
+	}
+	a.durableSeq++
+	message.seq = a.durableSeq
+	a.durableBuffer = append(a.durableBuffer, message)
+}
+
+// hasDurablePublishing reports whether messages are stored by the coordinator's JetStream
+func (a *Agent) hasDurablePublishing() bool {
+	a.durableMu.Lock()
+	defer a.durableMu.Unlock()
+	return a.js != nil
+}
+
+// durableMessageID names a run message from this agent process
+func (a *Agent) durableMessageID(testRunID, kind string) string {
+	return fmt.Sprintf("%s.%s.%s", a.metrics.SessionID, testRunID, kind)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// bufferedSubjects lists the subjects waiting in an agent's buffer
+func bufferedSubjects(a *Agent) []string {
+	a.durableMu.Lock()
+	defer a.durableMu.Unlock()
+
+	subjects := make([]string, len(a.durableBuffer))
+	for i, message := range a.durableBuffer {
+		subjects[i] = message.subject
+	}
+	return subjects
+}
+
+func TestDropDurableThrough(t *testing.T) {
+	a := &Agent{}
+	for _, subject := range []string{"a", "b", "c", "d"} {
+		a.bufferDurable(durableMessage{subject: subject})
+	}
+
+	// A flush stored a and b while the buffer overflowed and dropped a
+	a.durableBuffer = a.durableBuffer[1:]
+	a.dropDurableThrough(2)
+	if got := bufferedSubjects(a); len(got) != 2 || got[0] != "c" || got[1] != "d" {
+		t.Errorf("buffer = %v, want [c d]", got)
+	}
+
+	// Nothing stored leaves the buffer alone
+	a.dropDurableThrough(0)
+	if got := bufferedSubjects(a); len(got) != 2 {
+		t.Errorf("buffer = %v, want [c d]", got)
+	}
+}
+
+func TestPublishDurableBuffersAndReplaysInOrder(t *testing.T) {
+	// A server without JetStream: stream publishes fail at once with no responders
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first message fails and later ones queue behind it
+	a := &Agent{natsConn: nc, js: js}
+	if err := a.publishDurable("test.1", []byte("1"), ""); err == nil {
+		t.Fatal("publish without a stream succeeded")
+	}
+	for _, subject := range []string{"test.2", "test.3"} {
+		if err := a.publishDurable(subject, []byte(subject), ""); err == nil {
+			t.Fatalf("publish of %s behind the buffer succeeded", subject)
+		}
+	}
+	if got := bufferedSubjects(a); len(got) != 3 {
+		t.Fatalf("buffer = %v, want all three messages", got)
+	}
+
+	// The stream is still unavailable, so the buffer is kept
+	a.replayDurableBuffer()
+	if got := bufferedSubjects(a); len(got) != 3 {
+		t.Fatalf("buffer after a failed replay = %v, want all three messages", got)
+	}
+
+	// Plain publishing succeeds: the buffer replays in order. Subscribing
+	// only now keeps the failed stream publishes out of what is received.
+	received, err := nc.SubscribeSync("test.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.durableMu.Lock()
+	a.js = nil
+	a.durableMu.Unlock()
+	a.replayDurableBuffer()
+
+	// A background flush may have taken the buffer ahead of the replay
+	deadline := time.Now().Add(5 * time.Second)
+	for len(bufferedSubjects(a)) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := bufferedSubjects(a); len(got) != 0 {
+		t.Fatalf("buffer after replay = %v, want empty", got)
+	}
+
+	// With the buffer empty, messages are published directly again
+	if err := a.publishDurable("test.4", []byte("4"), ""); err != nil {
+		t.Errorf("publish after replay: %v", err)
+	}
+	for _, want := range []string{"test.1", "test.2", "test.3", "test.4"} {
+		msg, err := received.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", want, err)
+		}
+		if msg.Subject != want {
+			t.Errorf("received %s, want %s", msg.Subject, want)
+		}
+	}
+}
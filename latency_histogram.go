@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// latencyBucketGrowth is the ratio between consecutive histogram bucket
+// bounds, giving percentiles within about 2.5% of the true value
+const latencyBucketGrowth = 1.05
+
+var latencyBucketLogGrowth = math.Log(latencyBucketGrowth)
+
+// LatencyHistogram is a compact, mergeable latency distribution. Buckets are
+// log-spaced over microseconds so histograms from many agents can be summed
+// and percentiles computed afterwards.
+type LatencyHistogram struct {
+	Counts map[int]int64 `json:"counts"`
+	Total  int64         `json:"total"`
+}
+
+// NewLatencyHistogram creates an empty histogram
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{Counts: make(map[int]int64)}
+}
+
+// Record adds one latency observation
+func (h *LatencyHistogram) Record(latency time.Duration) {
+	us := float64(latency.Microseconds())
+	bucket := 0
+	if us > 1 {
+		bucket = int(math.Log(us) / latencyBucketLogGrowth)
+	}
+	h.Counts[bucket]++
+	h.Total++
+}
+
+// Merge adds every observation from other into h
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	if other == nil {
+		return
+	}
+	for bucket, count := range other.Counts {
+		h.Counts[bucket] += count
+	}
+	h.Total += other.Total
+}
+
+// Percentile returns the latency in milliseconds at percentile p (0-100)
+func (h *LatencyHistogram) Percentile(p float64) float64 {
+	if h == nil || h.Total == 0 {
+		return 0
+	}
+
+	buckets := make([]int, 0, len(h.Counts))
+	for bucket := range h.Counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+
+	rank := int64(math.Ceil(p / 100 * float64(h.Total)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen int64
+	for _, bucket := range buckets {
+		seen += h.Counts[bucket]
+		if seen >= rank {
+			return latencyBucketMidpointMs(bucket)
+		}
+	}
+	return latencyBucketMidpointMs(buckets[len(buckets)-1])
+}
+
+// latencyBucketMidpointMs returns the geometric midpoint of a bucket in milliseconds
+func latencyBucketMidpointMs(bucket int) float64 {
+	if bucket == 0 {
+		return 0.001
+	}
+	us := math.Pow(latencyBucketGrowth, float64(bucket)) * math.Sqrt(latencyBucketGrowth)
+	return math.Round(us) / 1000
+}
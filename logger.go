@@ -102,7 +102,7 @@ func (l *Logger) log(level LogLevel, message string) {
 	entry := LogEntry{
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 		Level:     level.String(),
-		Message:   message,
+		Message:   redactSecrets(message),
 	}
 
 	// Add file and line info for debug and error levels
@@ -47,6 +47,12 @@ var agentCmd = &cobra.Command{
 	RunE:  runAgent,
 }
 
+var targetCmd = &cobra.Command{
+	Use:   "target",
+	Short: "Start a configurable HTTP target server for calibration and offline testing",
+	RunE:  runTarget,
+}
+
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Configuration management commands",
@@ -58,6 +64,45 @@ var generateConfigCmd = &cobra.Command{
 	RunE:  generateConfig,
 }
 
+var apiKeyCmd = &cobra.Command{
+	Use:   "api-key",
+	Short: "Manage API keys for the HTTP API and web UI",
+}
+
+var createAPIKeyCmd = &cobra.Command{
+	Use:          "create",
+	Short:        "Create an API key",
+	RunE:         createAPIKeyCommand,
+	SilenceUsage: true,
+}
+
+var listAPIKeysCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API keys",
+	RunE:  listAPIKeysCommand,
+}
+
+var revokeAPIKeyCmd = &cobra.Command{
+	Use:          "revoke <id>",
+	Short:        "Revoke an API key",
+	Args:         cobra.ExactArgs(1),
+	RunE:         revokeAPIKeyCommand,
+	SilenceUsage: true,
+}
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Test plan commands",
+}
+
+var validatePlanCmd = &cobra.Command{
+	Use:          "validate <test-plan.yaml>",
+	Short:        "Validate a test plan file",
+	Args:         cobra.ExactArgs(1),
+	RunE:         validatePlan,
+	SilenceUsage: true,
+}
+
 func init() {
 	// Global flags
 	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "Path to configuration file")
@@ -86,10 +131,35 @@ func init() {
 	agentCmd.Flags().Bool("dev", false, "Enable development mode (sets sensible resource limits)")
 	agentCmd.Flags().Int("rate-limit", 0, "Maximum requests per second (0 = unlimited)")
 	agentCmd.Flags().String("default-think-time", "", "Default think time between requests (e.g., '200ms')")
+	agentCmd.Flags().Float64("weight", 1, "Relative share of plan load for weight-based allocation")
+	agentCmd.Flags().StringArray("label", nil, "Agent label as key=value, repeatable (e.g., --label zone=eu-west-1a)")
+	agentCmd.Flags().Bool("calibrate", false, "Run a self-calibration benchmark before registering")
+	agentCmd.Flags().String("calibrate-duration", "", "Duration of the calibration benchmark (e.g., '10s')")
+	agentCmd.Flags().Bool("nats-tls", false, "Connect to the coordinator over TLS")
+	agentCmd.Flags().String("nats-ca", "", "CA certificate for verifying the coordinator")
+	agentCmd.Flags().String("nats-cert", "", "Client certificate for mTLS")
+	agentCmd.Flags().String("nats-key", "", "Client key for mTLS")
+	agentCmd.Flags().String("nats-token", "", "Token for authenticating with the coordinator")
+	agentCmd.Flags().String("nats-nkey-seed", "", "NKey seed file for authenticating with the coordinator")
+	agentCmd.Flags().String("coordinator-key", "", "Coordinator's command signing key (base64 ed25519) to pin")
+
+	// Target flags
+	targetCmd.Flags().StringP("file", "f", "", "Path to target server YAML file")
+	targetCmd.Flags().String("listen", "", "Listen address (e.g., ':9090')")
 
 	// Config commands
 	configCmd.AddCommand(generateConfigCmd)
 
+	// Plan commands
+	validatePlanCmd.Flags().Int("min-agents", 0, "Number of agents the plan will run on")
+	planCmd.AddCommand(validatePlanCmd)
+
+	// API key commands
+	createAPIKeyCmd.Flags().String("name", "", "Name of the API key")
+	createAPIKeyCmd.Flags().String("role", RoleViewer, "Role of the API key (viewer, operator, admin)")
+	createAPIKeyCmd.MarkFlagRequired("name")
+	apiKeyCmd.AddCommand(createAPIKeyCmd, listAPIKeysCmd, revokeAPIKeyCmd)
+
 	// Version command
 	var versionCmd = &cobra.Command{
 		Use:   "version",
@@ -99,7 +169,7 @@ func init() {
 		},
 	}
 
-	rootCmd.AddCommand(coordinatorCmd, agentCmd, configCmd, versionCmd)
+	rootCmd.AddCommand(coordinatorCmd, agentCmd, targetCmd, configCmd, planCmd, apiKeyCmd, versionCmd)
 }
 
 func generateConfig(cmd *cobra.Command, args []string) error {
@@ -130,6 +200,28 @@ func generateConfig(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func validatePlan(cmd *cobra.Command, args []string) error {
+	plan, err := LoadTestPlanFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	minAgents, _ := cmd.Flags().GetInt("min-agents")
+	if minAgents == 0 {
+		minAgents = globalConfig.Defaults.MinAgents
+	}
+
+	if errs := ValidateTestPlan(*plan, minAgents); errs != nil {
+		for _, e := range errs {
+			fmt.Printf("  %s: %s\n", e.Field, e.Message)
+		}
+		return fmt.Errorf("test plan %s is invalid (%d errors)", args[0], len(errs))
+	}
+
+	fmt.Printf("Test plan %s is valid\n", args[0])
+	return nil
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// coordinatorNATSUser is the user the coordinator's own connection authenticates as
+const coordinatorNATSUser = "armonite-coordinator"
+
+// coordinatorInboxPrefix keeps replies to the coordinator's requests out of
+// the inboxes agents may subscribe to
+const coordinatorInboxPrefix = "_INBOX_coordinator"
+
+// agentIDPattern keeps agent IDs to a single NATS subject token
+var agentIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+func validAgentID(id string) bool {
+	return agentIDPattern.MatchString(id)
+}
+
+// Kinds of message an agent publishes under its own ID
+const (
+	agentRegisterKind  = "register"
+	agentHeartbeatKind = "heartbeat"
+	agentExecutionKind = "execution"
+	agentTelemetryKind = "telemetry"
+	agentFinalKind     = "telemetry.final"
+	agentPhaseKind     = "phase.complete"
+	agentSecretsKind   = "secrets"
+)
+
+// agentSubject is the subject for a kind of message to or from an agent.
+// Agents' permissions only let them publish under their own ID, so the ID in
+// the subject identifies the connection that sent a message.
+func agentSubject(agentID, kind string) string {
+	return "armonite.agent." + agentID + "." + kind
+}
+
+// agentInboxPrefix is where an agent receives replies to its requests
+func agentInboxPrefix(agentID string) string {
+	return "_INBOX." + agentID
+}
+
+// senderAgentID returns the agent ID of an agentSubject, or "" for other subjects
+func senderAgentID(subject string) string {
+	tokens := strings.SplitN(subject, ".", 4)
+	if len(tokens) < 4 || tokens[0] != "armonite" || tokens[1] != "agent" {
+		return ""
+	}
+	return tokens[2]
+}
+
+// checkSender reports whether a message names the agent whose subject it was
+// published on, and logs it otherwise
+func checkSender(msg *nats.Msg, agentID string) bool {
+	sender := senderAgentID(msg.Subject)
+	if agentID == "" || agentID != sender {
+		LogWarn("Rejected message on %s claiming to be from agent %q", msg.Subject, agentID)
+		return false
+	}
+	return true
+}
+
+// agentPermissions limits an agent to publishing registrations, heartbeats,
+// telemetry and status under its ID, and to receiving its commands and
+// replies. It can reply to the coordinator's requests but never send commands.
+// An agent without credentials of its own ("") may use any ID except those
+// in boundIDs.
+func agentPermissions(agentID string, boundIDs []string) *server.Permissions {
+	id := agentID
+	if id == "" {
+		id = "*"
+	}
+
+	publish := []string{
+		"armonite.ping",
+		coordinatorKeySubject,
+		"$JS.API.STREAM.INFO." + telemetryStream, // Checked before publishing durably
+	}
+	for _, kind := range []string{agentRegisterKind, agentHeartbeatKind, agentExecutionKind, agentTelemetryKind, agentFinalKind, agentPhaseKind + ".*", agentSecretsKind} {
+		publish = append(publish, agentSubject(id, kind))
+	}
+	permissions := &server.Permissions{
+		Publish: &server.SubjectPermission{Allow: publish},
+		Subscribe: &server.SubjectPermission{
+			Allow: []string{
+				"armonite.test.command",
+				agentSubject(id, "command"),
+				agentInboxPrefix(id) + ".>",
+			},
+		},
+		Response: &server.ResponsePermission{}, // Acknowledgements to START, preflight and calibration requests
+	}
+
+	if agentID == "" {
+		for _, bound := range boundIDs {
+			permissions.Publish.Deny = append(permissions.Publish.Deny, agentSubject(bound, ">"))
+			permissions.Subscribe.Deny = append(permissions.Subscribe.Deny, agentSubject(bound, ">"), agentInboxPrefix(bound)+".>")
+		}
+	}
+	return permissions
+}
+
+// natsAuthenticator admits the coordinator's own connection with every
+// permission and agents presenting a configured token or NKey with
+// agentPermissions. Credentials in nats.agents bind a connection to one agent
+// ID; the shared token and agent_nkeys do not. Certificates are verified by
+// the TLS handshake.
+type natsAuthenticator struct {
+	coordinatorPassword string
+	token               string
+	agentNKeys          map[string]bool
+	boundTokens         map[string]string // Token to agent ID
+	boundNKeys          map[string]string // NKey to agent ID
+	boundIDs            []string
+}
+
+func newNATSAuthenticator(config NATSConfig) (*natsAuthenticator, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate coordinator credentials: %w", err)
+	}
+
+	auth := &natsAuthenticator{
+		coordinatorPassword: hex.EncodeToString(secret),
+		token:               config.Token,
+		agentNKeys:          make(map[string]bool),
+		boundTokens:         make(map[string]string),
+		boundNKeys:          make(map[string]string),
+	}
+	for _, key := range config.AgentNKeys {
+		auth.agentNKeys[key] = true
+	}
+	for _, agent := range config.Agents {
+		if agent.Token != "" {
+			auth.boundTokens[agent.Token] = agent.ID
+		}
+		if agent.NKey != "" {
+			auth.boundNKeys[agent.NKey] = agent.ID
+		}
+		auth.boundIDs = append(auth.boundIDs, agent.ID)
+	}
+	return auth, nil
+}
+
+// Check implements server.Authentication
+func (a *natsAuthenticator) Check(client server.ClientAuthentication) bool {
+	opts := client.GetOpts()
+	if opts.Username == coordinatorNATSUser {
+		if !secretsEqual(opts.Password, a.coordinatorPassword) {
+			LogWarn("Rejected NATS connection from %s: invalid coordinator credentials", client.RemoteAddress())
+			return false
+		}
+		client.RegisterUser(&server.User{Username: coordinatorNATSUser})
+		return true
+	}
+
+	agentID, ok := a.agentIdentity(client, opts)
+	if !ok {
+		LogWarn("Rejected NATS connection from %s: missing or invalid agent credentials", client.RemoteAddress())
+		return false
+	}
+	username := "agent"
+	if agentID != "" {
+		username = "agent:" + agentID
+	}
+	client.RegisterUser(&server.User{Username: username, Permissions: agentPermissions(agentID, a.boundIDs)})
+	return true
+}
+
+// open reports whether agents may connect without credentials
+func (a *natsAuthenticator) open() bool {
+	return a.token == "" && len(a.agentNKeys) == 0 && len(a.boundIDs) == 0
+}
+
+// agentIdentity checks an agent's token or NKey signature and returns the
+// agent ID its credentials are bound to, or "" for shared credentials.
+// Without any configured any client is admitted.
+func (a *natsAuthenticator) agentIdentity(client server.ClientAuthentication, opts *server.ClientOpts) (string, bool) {
+	if a.open() {
+		return "", true
+	}
+	if opts.Token != "" {
+		for token, agentID := range a.boundTokens {
+			if secretsEqual(opts.Token, token) {
+				return agentID, true
+			}
+		}
+		if a.token != "" && secretsEqual(opts.Token, a.token) {
+			return "", true
+		}
+	}
+
+	agentID, bound := a.boundNKeys[opts.Nkey]
+	if opts.Nkey == "" || !bound && !a.agentNKeys[opts.Nkey] {
+		return "", false
+	}
+	if !verifyNKeySignature(client, opts) {
+		return "", false
+	}
+	return agentID, true
+}
+
+// verifyNKeySignature checks the client's signature of the server's nonce
+func verifyNKeySignature(client server.ClientAuthentication, opts *server.ClientOpts) bool {
+	sig, err := base64.RawURLEncoding.DecodeString(opts.Sig)
+	if err != nil {
+		if sig, err = base64.StdEncoding.DecodeString(opts.Sig); err != nil {
+			return false
+		}
+	}
+	publicKey, err := nkeys.FromPublicKey(opts.Nkey)
+	if err != nil {
+		return false
+	}
+	return publicKey.Verify(client.GetNonce(), sig) == nil
+}
+
+// secretsEqual compares secrets in constant time
+func secretsEqual(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// configureNATSSecurity sets up TLS and authentication on the embedded server
+func (c *Coordinator) configureNATSSecurity(opts *server.Options) error {
+	config := c.config.NATS
+
+	auth, err := newNATSAuthenticator(config)
+	if err != nil {
+		return err
+	}
+	c.natsAuth = auth
+	opts.CustomClientAuthentication = auth
+	opts.AlwaysEnableNonce = len(auth.agentNKeys) > 0 || len(auth.boundNKeys) > 0 // Agents sign the nonce with their NKey
+
+	if config.TLS {
+		if config.CertFile == "" {
+			return fmt.Errorf("nats cert_file and key_file are required for TLS")
+		}
+		tlsConfig, err := server.GenTLSConfig(&server.TLSConfigOpts{
+			CertFile: config.CertFile,
+			KeyFile:  config.KeyFile,
+			CaFile:   config.CAFile,
+			Verify:   config.VerifyClients,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to load NATS TLS certificate: %w", err)
+		}
+		opts.TLS = true
+		opts.TLSConfig = tlsConfig
+		opts.TLSVerify = config.VerifyClients
+	} else if config.VerifyClients {
+		return fmt.Errorf("nats verify_clients requires tls")
+	}
+
+	shared := config.Token != "" || len(config.AgentNKeys) > 0
+	switch {
+	case len(config.Agents) > 0 && shared:
+		LogInfo("NATS agents authenticate with credentials bound to %d agent IDs", len(config.Agents))
+		LogWarn("NATS agents with the shared token or agent_nkeys can use any agent ID not listed in nats.agents")
+	case len(config.Agents) > 0:
+		LogInfo("NATS agents authenticate with credentials bound to %d agent IDs", len(config.Agents))
+	case shared:
+		LogInfo("NATS agents authenticate with a shared token or NKey")
+		LogWarn("NATS agent credentials are not bound to agent IDs; list agents under nats.agents so an agent cannot pose as another")
+	case config.VerifyClients:
+		LogWarn("NATS agents authenticate only with client certificates; any agent can use any agent ID")
+	default:
+		LogWarn("NATS agent authentication disabled; any client can connect as any agent. Set nats.agents, nats.token or nats.agent_nkeys")
+	}
+	return nil
+}
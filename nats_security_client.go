@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/cobra"
+)
+
+// natsSecurityFromFlags overrides the config file's NATS security settings
+// with the agent's command line flags
+func natsSecurityFromFlags(cmd *cobra.Command, config NATSConfig) (NATSConfig, error) {
+	if tlsEnabled, _ := cmd.Flags().GetBool("nats-tls"); cmd.Flags().Changed("nats-tls") {
+		config.TLS = tlsEnabled
+	}
+	if caFile, _ := cmd.Flags().GetString("nats-ca"); caFile != "" {
+		config.CAFile = caFile
+	}
+	if certFile, _ := cmd.Flags().GetString("nats-cert"); certFile != "" {
+		config.ClientCertFile = certFile
+	}
+	if keyFile, _ := cmd.Flags().GetString("nats-key"); keyFile != "" {
+		config.ClientKeyFile = keyFile
+	}
+	if token, _ := cmd.Flags().GetString("nats-token"); token != "" {
+		config.Token = token
+	}
+	if seedFile, _ := cmd.Flags().GetString("nats-nkey-seed"); seedFile != "" {
+		config.NKeySeedFile = seedFile
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// natsSecurityOptions returns the TLS and credential options for connecting
+// to the coordinator. An NKey seed takes precedence over a token.
+func (a *Agent) natsSecurityOptions() ([]nats.Option, error) {
+	config := a.natsSecurity
+	var opts []nats.Option
+
+	if config.TLS || config.ClientCertFile != "" {
+		opts = append(opts, nats.Secure(&tls.Config{
+			ServerName: config.ServerName,
+			MinVersion: tls.VersionTLS12,
+		}))
+		if config.CAFile != "" {
+			opts = append(opts, nats.RootCAs(config.CAFile))
+		}
+		if config.ClientCertFile != "" {
+			opts = append(opts, nats.ClientCert(config.ClientCertFile, config.ClientKeyFile))
+		}
+	}
+
+	switch {
+	case config.NKeySeedFile != "":
+		nkeyOpt, err := nats.NkeyOptionFromSeed(config.NKeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load NKey seed: %w", err)
+		}
+		opts = append(opts, nkeyOpt)
+	case config.Token != "":
+		opts = append(opts, nats.Token(config.Token))
+	}
+
+	// Replies come to an inbox only this agent may subscribe to
+	opts = append(opts, nats.CustomInboxPrefix(agentInboxPrefix(a.id)))
+
+	// Subjects outside an agent's permissions are reported asynchronously
+	opts = append(opts, nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+		LogWarn("NATS error: %v", err)
+	}))
+	return opts, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// testNATSClient is a connecting client as the authenticator sees it
+type testNATSClient struct {
+	opts  server.ClientOpts
+	nonce []byte
+	user  *server.User
+}
+
+func (c *testNATSClient) GetOpts() *server.ClientOpts                 { return &c.opts }
+func (c *testNATSClient) GetTLSConnectionState() *tls.ConnectionState { return nil }
+func (c *testNATSClient) RegisterUser(user *server.User)              { c.user = user }
+func (c *testNATSClient) RemoteAddress() net.Addr                     { return &net.TCPAddr{} }
+func (c *testNATSClient) GetNonce() []byte                            { return c.nonce }
+func (c *testNATSClient) Kind() int                                   { return server.CLIENT }
+
+func TestNATSAuthenticatorIdentity(t *testing.T) {
+	newKey := func() (nkeys.KeyPair, string) {
+		kp, err := nkeys.CreateUser()
+		if err != nil {
+			t.Fatal(err)
+		}
+		public, _ := kp.PublicKey()
+		return kp, public
+	}
+	boundKey, boundPublic := newKey()
+	sharedKey, sharedPublic := newKey()
+	strangerKey, strangerPublic := newKey()
+
+	nonce := []byte("server-nonce")
+	sign := func(kp nkeys.KeyPair) string {
+		sig, err := kp.Sign(nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	config := NATSConfig{
+		Token:      "shared-token",
+		AgentNKeys: []string{sharedPublic},
+		Agents: []NATSAgentCredential{
+			{ID: "a1", Token: "a1-token"},
+			{ID: "a2", NKey: boundPublic},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		config   NATSConfig
+		opts     server.ClientOpts
+		wantOK   bool
+		wantUser string
+	}{
+		{"bound token", config, server.ClientOpts{Token: "a1-token"}, true, "agent:a1"},
+		{"bound NKey", config, server.ClientOpts{Nkey: boundPublic, Sig: sign(boundKey)}, true, "agent:a2"},
+		{"shared token", config, server.ClientOpts{Token: "shared-token"}, true, "agent"},
+		{"shared NKey", config, server.ClientOpts{Nkey: sharedPublic, Sig: sign(sharedKey)}, true, "agent"},
+		{"wrong token", config, server.ClientOpts{Token: "guess"}, false, ""},
+		{"no credentials", config, server.ClientOpts{}, false, ""},
+		{"unknown NKey", config, server.ClientOpts{Nkey: strangerPublic, Sig: sign(strangerKey)}, false, ""},
+		{"bound NKey signed by another key", config, server.ClientOpts{Nkey: boundPublic, Sig: sign(strangerKey)}, false, ""},
+		{"bound NKey without a signature", config, server.ClientOpts{Nkey: boundPublic}, false, ""},
+		{"open without credentials", NATSConfig{}, server.ClientOpts{}, true, "agent"},
+		{"only bound agents configured", NATSConfig{Agents: config.Agents}, server.ClientOpts{Token: "shared-token"}, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := newNATSAuthenticator(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &testNATSClient{opts: tt.opts, nonce: nonce}
+			if ok := auth.Check(client); ok != tt.wantOK {
+				t.Fatalf("Check = %t, want %t", ok, tt.wantOK)
+			}
+			if !tt.wantOK {
+				return
+			}
+			if client.user == nil || client.user.Username != tt.wantUser {
+				t.Errorf("registered user %+v, want %s", client.user, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestAgentPermissions(t *testing.T) {
+	auth, err := newNATSAuthenticator(NATSConfig{
+		Token:  "shared-token",
+		Agents: []NATSAgentCredential{{ID: "a1", Token: "a1-token"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, CustomClientAuthentication: auth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		subscribe bool // Otherwise publish
+		subject   string
+		allowed   bool
+	}{
+		{"bound agent publishes as itself", "a1-token", false, agentSubject("a1", agentTelemetryKind), true},
+		{"bound agent completes phases as itself", "a1-token", false, agentSubject("a1", agentPhaseKind+".run-1"), true},
+		{"bound agent cannot publish as another", "a1-token", false, agentSubject("a2", agentRegisterKind), false},
+		{"bound agent cannot send commands to itself", "a1-token", false, agentSubject("a1", "command"), false},
+		{"bound agent cannot send test commands", "a1-token", false, "armonite.test.command", false},
+		{"bound agent receives its commands", "a1-token", true, agentSubject("a1", "command"), true},
+		{"bound agent cannot receive another's commands", "a1-token", true, agentSubject("a2", "command"), false},
+		{"bound agent cannot read telemetry", "a1-token", true, agentSubject("*", agentTelemetryKind), false},
+		{"bound agent reads its inbox", "a1-token", true, agentInboxPrefix("a1") + ".x", true},
+		{"bound agent cannot read another inbox", "a1-token", true, agentInboxPrefix("a2") + ".x", false},
+		{"bound agent cannot read the coordinator's inbox", "a1-token", true, coordinatorInboxPrefix + ".x", false},
+		{"shared agent publishes as an unbound agent", "shared-token", false, agentSubject("a2", agentRegisterKind), true},
+		{"shared agent cannot publish as a bound agent", "shared-token", false, agentSubject("a1", agentHeartbeatKind), false},
+		{"shared agent receives an unbound agent's commands", "shared-token", true, agentSubject("a2", "command"), true},
+		{"shared agent cannot receive a bound agent's commands", "shared-token", true, agentSubject("a1", "command"), false},
+		{"shared agent cannot read a bound agent's inbox", "shared-token", true, agentInboxPrefix("a1") + ".x", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nc, err := nats.Connect(ns.ClientURL(), nats.Token(tt.token))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer nc.Close()
+
+			if tt.subscribe {
+				_, err = nc.SubscribeSync(tt.subject)
+			} else {
+				err = nc.Publish(tt.subject, []byte("{}"))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Violations are reported by the server before it answers the flush
+			if err := nc.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			err = nc.LastError()
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Errorf("allowed = %t, want %t (%v)", allowed, tt.allowed, err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "Permissions Violation") {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckSender(t *testing.T) {
+	tests := []struct {
+		subject string
+		agentID string
+		want    bool
+	}{
+		{agentSubject("a1", agentRegisterKind), "a1", true},
+		{agentSubject("a1", agentPhaseKind+".run-1"), "a1", true},
+		{agentSubject("a1", agentFinalKind), "a1", true},
+		{agentSubject("a1", agentHeartbeatKind), "a2", false},
+		{agentSubject("a1", agentHeartbeatKind), "", false},
+		{"armonite.telemetry", "a1", false},
+		{"armonite.agent.a1", "a1", false},
+	}
+
+	for _, tt := range tests {
+		if got := checkSender(&nats.Msg{Subject: tt.subject}, tt.agentID); got != tt.want {
+			t.Errorf("checkSender(%s, %q) = %t, want %t", tt.subject, tt.agentID, got, tt.want)
+		}
+	}
+}
+
+func TestNATSConfigValidateAgents(t *testing.T) {
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _ := kp.PublicKey()
+
+	tests := []struct {
+		name    string
+		config  NATSConfig
+		wantErr string
+	}{
+		{"token and NKey agents", NATSConfig{Agents: []NATSAgentCredential{{ID: "a1", Token: "t1"}, {ID: "a2", NKey: public}}}, ""},
+		{"ID with a dot", NATSConfig{Agents: []NATSAgentCredential{{ID: "a.1", Token: "t1"}}}, "invalid nats agent id"},
+		{"wildcard ID", NATSConfig{Agents: []NATSAgentCredential{{ID: "*", Token: "t1"}}}, "invalid nats agent id"},
+		{"empty ID", NATSConfig{Agents: []NATSAgentCredential{{Token: "t1"}}}, "invalid nats agent id"},
+		{"listed twice", NATSConfig{Agents: []NATSAgentCredential{{ID: "a1", Token: "t1"}, {ID: "a1", Token: "t2"}}}, "listed twice"},
+		{"no credential", NATSConfig{Agents: []NATSAgentCredential{{ID: "a1"}}}, "exactly one of token and nkey"},
+		{"both credentials", NATSConfig{Agents: []NATSAgentCredential{{ID: "a1", Token: "t1", NKey: public}}}, "exactly one of token and nkey"},
+		{"invalid NKey", NATSConfig{Agents: []NATSAgentCredential{{ID: "a1", NKey: "UNOTAKEY"}}}, "invalid nkey for nats agent a1"},
+		{"shared token reused", NATSConfig{Token: "t1", Agents: []NATSAgentCredential{{ID: "a1", Token: "t1"}}}, "shares its credential"},
+		{"shared NKey reused", NATSConfig{AgentNKeys: []string{public}, Agents: []NATSAgentCredential{{ID: "a1", NKey: public}}}, "shares its credential"},
+		{"token of two agents", NATSConfig{Agents: []NATSAgentCredential{{ID: "a1", Token: "t1"}, {ID: "a2", Token: "t1"}}}, "shares its credential"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
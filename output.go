@@ -39,6 +39,15 @@ type AgentResult struct {
 	MinLatencyMs float64          `json:"min_latency_ms" xml:"min_latency_ms" yaml:"min_latency_ms"`
 	MaxLatencyMs float64          `json:"max_latency_ms" xml:"max_latency_ms" yaml:"max_latency_ms"`
 	StatusCodes  map[string]int64 `json:"status_codes" xml:"status_codes" yaml:"status_codes"`
+	Saturated    bool             `json:"saturated,omitempty" xml:"saturated,omitempty" yaml:"saturated,omitempty"` // Agent passed resource thresholds during the run
+	Missing      bool             `json:"missing,omitempty" xml:"missing,omitempty" yaml:"missing,omitempty"`       // Agent never sent its final metrics
+
+	// Reporting intervals that never reached the coordinator; totals of agents
+	// that sent final metrics are exact regardless
+	MissingIntervals int `json:"missing_intervals,omitempty" xml:"missing_intervals,omitempty" yaml:"missing_intervals,omitempty"`
+
+	// Latency carries the agent's latency distribution until region percentiles are computed
+	Latency *LatencyHistogram `json:"latency_histogram,omitempty" xml:"-" yaml:"-"`
 }
 
 type TestSummary struct {
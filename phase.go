@@ -14,12 +14,15 @@ type PhaseOrchestrator struct {
 	testRunID string
 	testPlan  *TestPlan
 	natsConn  *nats.Conn
+	js        nats.JetStreamContext // Phase completions come from a stream when set
+	signer    *commandSigner
 
 	// Phase tracking
 	currentPhase    int
 	phaseStartTime  time.Time
 	activeAgents    map[string]*AgentInfo
 	completedAgents map[string]bool
+	allocations     map[string]AgentAllocation
 	mu              sync.RWMutex
 
 	// Control channels
@@ -27,24 +30,39 @@ type PhaseOrchestrator struct {
 	phaseDoneCh chan int
 }
 
-func NewPhaseOrchestrator(testRunID string, testPlan *TestPlan, natsConn *nats.Conn, agents map[string]*AgentInfo) *PhaseOrchestrator {
+func NewPhaseOrchestrator(testRunID string, testPlan *TestPlan, natsConn *nats.Conn, js nats.JetStreamContext, signer *commandSigner, agents map[string]*AgentInfo, allocations []AgentAllocation) *PhaseOrchestrator {
 	activeAgents := make(map[string]*AgentInfo)
 	for id, agent := range agents {
 		activeAgents[id] = agent
 	}
 
+	allocationsByAgent := make(map[string]AgentAllocation, len(allocations))
+	for _, allocation := range allocations {
+		allocationsByAgent[allocation.AgentID] = allocation
+	}
+
 	return &PhaseOrchestrator{
 		testRunID:       testRunID,
 		testPlan:        testPlan,
 		natsConn:        natsConn,
+		js:              js,
+		signer:          signer,
 		activeAgents:    activeAgents,
 		completedAgents: make(map[string]bool),
+		allocations:     allocationsByAgent,
 		stopCh:          make(chan struct{}),
 		phaseDoneCh:     make(chan int, 10),
 	}
 }
 
 func (po *PhaseOrchestrator) Start() {
+	// The run may have been stopped before its start time
+	select {
+	case <-po.stopCh:
+		return
+	default:
+	}
+
 	if po.testPlan.RampUpStrategy == nil || len(po.testPlan.RampUpStrategy.Phases) == 0 {
 		LogInfo("No custom phases defined, using standard ramp-up")
 		return
@@ -184,16 +202,27 @@ func (po *PhaseOrchestrator) executeParallelPhase(phaseIndex int, phase RampPhas
 		Duration:    phase.Duration,
 	}
 
-	// Send to all agents
+	// Send to all agents, each with its share of the phase concurrency
 	agentCount := 0
 	for agentID := range po.activeAgents {
-		po.sendPhaseCommand(agentID, "START_PHASE", &phaseInfo)
+		agentPhase := phaseInfo
+		agentPhase.Concurrency = po.agentConcurrency(agentID, phase.Concurrency)
+		po.sendPhaseCommand(agentID, "START_PHASE", &agentPhase)
 		agentCount++
 	}
 
 	LogInfo("Parallel phase %d: Started %d agents simultaneously", phaseIndex, agentCount)
 }
 
+// agentConcurrency scales a plan-wide phase concurrency to the agent's allocated share
+func (po *PhaseOrchestrator) agentConcurrency(agentID string, concurrency int) int {
+	allocation, exists := po.allocations[agentID]
+	if !exists {
+		return concurrency
+	}
+	return scaleConcurrency(concurrency, allocation.Concurrency, po.testPlan.Concurrency)
+}
+
 func (po *PhaseOrchestrator) sendPhaseCommand(agentID string, command string, phase *PhaseInfo) {
 	cmd := TestStartCommand{
 		TestRunID:    po.testRunID,
@@ -209,21 +238,25 @@ func (po *PhaseOrchestrator) sendPhaseCommand(agentID string, command string, ph
 
 	// Send to agent-specific subject for precise control
 	subject := fmt.Sprintf("armonite.agent.%s.command", agentID)
-	if err := po.natsConn.Publish(subject, data); err != nil {
+	if err := po.signer.publish(po.natsConn, subject, data); err != nil {
 		LogError("Failed to send phase command to agent %s: %v", agentID, err)
 	}
 }
 
 func (po *PhaseOrchestrator) monitorPhaseCompletion() {
-	// Subscribe to phase completion messages for this test run
-	subject := fmt.Sprintf("armonite.phase.complete.%s", po.testRunID)
+	// Subscribe to phase completion messages for this test run, including
+	// completions agents replay after reconnecting
+	subject := agentSubject("*", agentPhaseKind+"."+po.testRunID)
 
-	_, err := po.natsConn.Subscribe(subject, func(msg *nats.Msg) {
+	sub, err := subscribeStream(po.natsConn, po.js, subject, func(msg *nats.Msg) {
 		var completion PhaseCompletion
 		if err := json.Unmarshal(msg.Data, &completion); err != nil {
 			LogError("Failed to unmarshal phase completion: %v", err)
 			return
 		}
+		if !checkSender(msg, completion.AgentID) {
+			return
+		}
 
 		po.mu.Lock()
 		defer po.mu.Unlock()
@@ -250,5 +283,9 @@ func (po *PhaseOrchestrator) monitorPhaseCompletion() {
 
 	if err != nil {
 		LogError("Failed to subscribe to phase completion: %v", err)
+		return
 	}
+
+	<-po.stopCh
+	sub.Unsubscribe()
 }
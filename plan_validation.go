@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PlanValidationError describes a single problem found in a test plan
+type PlanValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e PlanValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// PlanValidationErrors collects every problem found in a test plan
+type PlanValidationErrors []PlanValidationError
+
+func (e PlanValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *PlanValidationErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, PlanValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+var validHTTPMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// ValidateTestPlan strictly validates a test plan and returns every problem
+// found, or nil if the plan is valid. minAgents is the number of agents the
+// run will wait for; pass 0 to skip the concurrency check against it.
+func ValidateTestPlan(plan TestPlan, minAgents int) PlanValidationErrors {
+	var errs PlanValidationErrors
+
+	// Total duration
+	var totalDuration time.Duration
+	if plan.Duration == "" {
+		errs.add("duration", "is required")
+	} else if d, err := time.ParseDuration(plan.Duration); err != nil {
+		errs.add("duration", "invalid duration %q", plan.Duration)
+	} else if d <= 0 {
+		errs.add("duration", "must be greater than zero")
+	} else {
+		totalDuration = d
+	}
+
+	// Concurrency
+	if plan.Concurrency < 0 {
+		errs.add("concurrency", "must not be negative")
+	} else if plan.Concurrency > 0 && minAgents > plan.Concurrency {
+		errs.add("concurrency", "%d is lower than min_agents (%d); some agents would have no workers",
+			plan.Concurrency, minAgents)
+	}
+
+	if plan.TargetRPS < 0 {
+		errs.add("target_rps", "must not be negative")
+	}
+
+	switch plan.Allocation {
+	case "", AllocationEven, AllocationWeight, AllocationCapacity:
+	default:
+		errs.add("allocation", "must be %s, %s or %s", AllocationEven, AllocationWeight, AllocationCapacity)
+	}
+
+	switch plan.Rebalance {
+	case "", RebalanceNone, RebalanceConstant:
+	default:
+		errs.add("rebalance", "must be %s or %s", RebalanceNone, RebalanceConstant)
+	}
+
+	validateRegions(plan, &errs)
+
+	if plan.WaitTimeout != "" {
+		if d, err := time.ParseDuration(plan.WaitTimeout); err != nil {
+			errs.add("wait_timeout", "invalid duration %q", plan.WaitTimeout)
+		} else if d <= 0 {
+			errs.add("wait_timeout", "must be greater than zero")
+		}
+	} else if plan.StartWithAvailable {
+		errs.add("start_with_available", "requires wait_timeout")
+	}
+
+	// Legacy ramp-up
+	if plan.RampUp != "" {
+		if d, err := time.ParseDuration(plan.RampUp); err != nil {
+			errs.add("ramp_up", "invalid duration %q", plan.RampUp)
+		} else if totalDuration > 0 && d > totalDuration {
+			errs.add("ramp_up", "%s exceeds total duration %s", plan.RampUp, plan.Duration)
+		}
+	}
+
+	if plan.RampUpStrategy != nil {
+		validateRampUpAgainstPlan(*plan.RampUpStrategy, totalDuration, &errs)
+	}
+
+	// Endpoints
+	if len(plan.Endpoints) == 0 {
+		errs.add("endpoints", "at least one endpoint is required")
+	}
+	for i, endpoint := range plan.Endpoints {
+		validateEndpoint(endpoint, fmt.Sprintf("endpoints[%d]", i), &errs)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateRampUpAgainstPlan(strategy RampUpStrategy, totalDuration time.Duration, errs *PlanValidationErrors) {
+	if err := ValidateRampUpStrategy(strategy); err != nil {
+		errs.add("ramp_up_strategy", "%v", err)
+		return
+	}
+
+	rampDuration, _ := time.ParseDuration(strategy.Duration)
+	if totalDuration > 0 && rampDuration > totalDuration {
+		errs.add("ramp_up_strategy.duration", "%s exceeds total duration %s",
+			strategy.Duration, totalDuration)
+	}
+
+	var phasesDuration time.Duration
+	for _, phase := range strategy.Phases {
+		d, _ := time.ParseDuration(phase.Duration)
+		phasesDuration += d
+	}
+	if totalDuration > 0 && phasesDuration > totalDuration {
+		errs.add("ramp_up_strategy.phases", "phases last %s in total, longer than total duration %s",
+			phasesDuration, totalDuration)
+	}
+}
+
+func validateEndpoint(endpoint Endpoint, path string, errs *PlanValidationErrors) {
+	// Method
+	method := strings.ToUpper(endpoint.Method)
+	if method == "" {
+		errs.add(path+".method", "is required")
+	} else if !contains(validHTTPMethods, method) {
+		errs.add(path+".method", "unsupported method %q", endpoint.Method)
+	}
+
+	// URL, rendered first so templated URLs are checked as agents will send them
+	if endpoint.URL == "" {
+		errs.add(path+".url", "is required")
+	} else if rendered, err := validateTemplateValue(endpoint.URL, endpoint.Templates); err != nil {
+		errs.add(path+".url", "invalid template: %v", err)
+	} else if parsed, err := url.Parse(rendered); err != nil {
+		errs.add(path+".url", "invalid URL: %v", err)
+	} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		errs.add(path+".url", "scheme must be http or https")
+	} else if parsed.Host == "" {
+		errs.add(path+".url", "host is required")
+	}
+
+	// Headers, in a stable order so error output is deterministic
+	headerNames := make([]string, 0, len(endpoint.Headers))
+	for name := range endpoint.Headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		if strings.TrimSpace(name) == "" {
+			errs.add(path+".headers", "header name must not be empty")
+			continue
+		}
+		if _, err := validateTemplateValue(endpoint.Headers[name], endpoint.Templates); err != nil {
+			errs.add(fmt.Sprintf("%s.headers.%s", path, name), "invalid template: %v", err)
+		}
+	}
+
+	// Body
+	if endpoint.Body != nil {
+		if method == "GET" || method == "HEAD" {
+			errs.add(path+".body", "%s requests cannot have a body", method)
+		}
+		validateBodyTemplates(endpoint.Body, path+".body", endpoint.Templates, errs)
+	}
+
+	// Think time
+	if endpoint.ThinkTime != "" {
+		if d, err := time.ParseDuration(endpoint.ThinkTime); err != nil {
+			errs.add(path+".think_time", "invalid duration %q", endpoint.ThinkTime)
+		} else if d < 0 {
+			errs.add(path+".think_time", "must not be negative")
+		}
+	}
+}
+
+// validateTemplateValue parses and renders a value to surface template and
+// secret reference errors. Without templates, {{ is sent as written. Secret
+// references are left for agents to resolve.
+func validateTemplateValue(text string, templates bool) (string, error) {
+	if err := validateSecretReferences(text); err != nil {
+		return "", err
+	}
+	if !templates {
+		return text, nil
+	}
+	return renderEndpointTemplate(text)
+}
+
+func validateBodyTemplates(value interface{}, path string, templates bool, errs *PlanValidationErrors) {
+	switch v := value.(type) {
+	case string:
+		if _, err := validateTemplateValue(v, templates); err != nil {
+			errs.add(path, "invalid template: %v", err)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			validateBodyTemplates(v[key], path+"."+key, templates, errs)
+		}
+	case []interface{}:
+		for i, item := range v {
+			validateBodyTemplates(item, fmt.Sprintf("%s[%d]", path, i), templates, errs)
+		}
+	}
+}
+
+// LoadTestPlanFile reads a YAML test plan, rejecting unknown fields
+func LoadTestPlanFile(path string) (*TestPlan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open test plan %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var plan TestPlan
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&plan); err != nil {
+		return nil, fmt.Errorf("failed to parse test plan %s: %w", path, err)
+	}
+
+	return &plan, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateTestPlan(t *testing.T) {
+	endpoint := Endpoint{Method: "GET", URL: "https://api.example.com/health"}
+	valid := TestPlan{Name: "plan", Duration: "5m", Concurrency: 10, Endpoints: []Endpoint{endpoint}}
+
+	// with returns the valid plan after change
+	with := func(change func(plan *TestPlan)) TestPlan {
+		plan := valid
+		plan.Endpoints = []Endpoint{endpoint}
+		change(&plan)
+		return plan
+	}
+	withEndpoint := func(change func(endpoint *Endpoint)) TestPlan {
+		return with(func(plan *TestPlan) { change(&plan.Endpoints[0]) })
+	}
+
+	tests := []struct {
+		name      string
+		plan      TestPlan
+		minAgents int
+		want      []string // Fields with errors, in order
+	}{
+		{name: "valid", plan: valid},
+		{name: "missing duration", plan: with(func(p *TestPlan) { p.Duration = "" }), want: []string{"duration"}},
+		{name: "invalid duration", plan: with(func(p *TestPlan) { p.Duration = "5 minutes" }), want: []string{"duration"}},
+		{name: "zero duration", plan: with(func(p *TestPlan) { p.Duration = "0s" }), want: []string{"duration"}},
+		{name: "negative concurrency", plan: with(func(p *TestPlan) { p.Concurrency = -1 }), want: []string{"concurrency"}},
+		{name: "concurrency below min_agents", plan: valid, minAgents: 11, want: []string{"concurrency"}},
+		{name: "concurrency at min_agents", plan: valid, minAgents: 10},
+		{name: "per-agent concurrency with min_agents", plan: with(func(p *TestPlan) { p.Concurrency = 0 }), minAgents: 3},
+		{name: "negative target_rps", plan: with(func(p *TestPlan) { p.TargetRPS = -5 }), want: []string{"target_rps"}},
+		{name: "unknown allocation", plan: with(func(p *TestPlan) { p.Allocation = "random" }), want: []string{"allocation"}},
+		{name: "unknown rebalance", plan: with(func(p *TestPlan) { p.Rebalance = "always" }), want: []string{"rebalance"}},
+		{name: "region without a weight", plan: with(func(p *TestPlan) { p.Regions = []PlanRegion{{Name: "eu"}} }), want: []string{"regions[0].weight"}},
+		{name: "invalid wait_timeout", plan: with(func(p *TestPlan) { p.WaitTimeout = "soon" }), want: []string{"wait_timeout"}},
+		{name: "start_with_available without wait_timeout", plan: with(func(p *TestPlan) { p.StartWithAvailable = true }), want: []string{"start_with_available"}},
+		{name: "legacy ramp-up longer than the test", plan: with(func(p *TestPlan) { p.RampUp = "10m" }), want: []string{"ramp_up"}},
+		{
+			name: "ramp-up longer than the test",
+			plan: with(func(p *TestPlan) {
+				p.RampUpStrategy = &RampUpStrategy{Type: RampUpTypeLinear, Duration: "10m"}
+			}),
+			want: []string{"ramp_up_strategy.duration"},
+		},
+		{
+			name: "ramp-up phases longer than the test",
+			plan: with(func(p *TestPlan) {
+				p.RampUpStrategy = &RampUpStrategy{Type: RampUpTypeCustom, Duration: "1m", Phases: []RampPhase{
+					{Duration: "3m", Concurrency: 5, Mode: "parallel"},
+					{Duration: "3m", Concurrency: 10, Mode: "parallel"},
+				}}
+			}),
+			want: []string{"ramp_up_strategy.phases"},
+		},
+		{
+			name: "invalid ramp-up phase",
+			plan: with(func(p *TestPlan) {
+				p.RampUpStrategy = &RampUpStrategy{Type: RampUpTypeStep, Duration: "1m", Phases: []RampPhase{{Duration: "30s", Mode: "both"}}}
+			}),
+			want: []string{"ramp_up_strategy"},
+		},
+		{name: "no endpoints", plan: with(func(p *TestPlan) { p.Endpoints = nil }), want: []string{"endpoints"}},
+		{name: "missing method", plan: withEndpoint(func(e *Endpoint) { e.Method = "" }), want: []string{"endpoints[0].method"}},
+		{name: "unsupported method", plan: withEndpoint(func(e *Endpoint) { e.Method = "FETCH" }), want: []string{"endpoints[0].method"}},
+		{name: "lower case method", plan: withEndpoint(func(e *Endpoint) { e.Method = "get" })},
+		{name: "missing URL", plan: withEndpoint(func(e *Endpoint) { e.URL = "" }), want: []string{"endpoints[0].url"}},
+		{name: "URL without a scheme", plan: withEndpoint(func(e *Endpoint) { e.URL = "api.example.com/health" }), want: []string{"endpoints[0].url"}},
+		{name: "URL with another scheme", plan: withEndpoint(func(e *Endpoint) { e.URL = "ftp://api.example.com" }), want: []string{"endpoints[0].url"}},
+		{name: "empty header name", plan: withEndpoint(func(e *Endpoint) { e.Headers = map[string]string{" ": "x"} }), want: []string{"endpoints[0].headers"}},
+		{name: "GET with a body", plan: withEndpoint(func(e *Endpoint) { e.Body = map[string]interface{}{"a": 1} }), want: []string{"endpoints[0].body"}},
+		{name: "invalid think_time", plan: withEndpoint(func(e *Endpoint) { e.ThinkTime = "a bit" }), want: []string{"endpoints[0].think_time"}},
+		{name: "negative think_time", plan: withEndpoint(func(e *Endpoint) { e.ThinkTime = "-1s" }), want: []string{"endpoints[0].think_time"}},
+		{
+			name: "templates",
+			plan: withEndpoint(func(e *Endpoint) {
+				e.Templates = true
+				e.URL = "https://api.example.com/users/{{ randInt 1 100 }}"
+				e.Headers = map[string]string{"X-Request-ID": "{{ uuid }}"}
+			}),
+		},
+		{
+			name: "invalid template syntax",
+			plan: withEndpoint(func(e *Endpoint) {
+				e.Method = "POST"
+				e.Templates = true
+				e.Headers = map[string]string{"X-Request-ID": "{{ uuid"}
+				e.Body = map[string]interface{}{"items": []interface{}{"{{ nope }}"}}
+			}),
+			want: []string{"endpoints[0].headers.X-Request-ID", "endpoints[0].body.items[0]"},
+		},
+		{name: "templated URL without a host", plan: withEndpoint(func(e *Endpoint) { e.Templates = true; e.URL = "https://{{ \"\" }}/" }), want: []string{"endpoints[0].url"}},
+		{
+			name: "braces without templates are sent as written",
+			plan: withEndpoint(func(e *Endpoint) {
+				e.Method = "POST"
+				e.Headers = map[string]string{"X-Literal": "{{ uuid"}
+				e.Body = map[string]interface{}{"mustache": "Hello {{name}}"}
+			}),
+		},
+		{name: "secret reference", plan: withEndpoint(func(e *Endpoint) { e.Headers = map[string]string{"Authorization": "Bearer ${secret:api-token}"} })},
+		{name: "invalid secret reference", plan: withEndpoint(func(e *Endpoint) { e.Headers = map[string]string{"Authorization": "${secret:api token}"} }), want: []string{"endpoints[0].headers.Authorization"}},
+		{
+			name: "every problem reported",
+			plan: TestPlan{Duration: "forever", Concurrency: -1, Endpoints: []Endpoint{{Method: "FETCH"}}},
+			want: []string{"duration", "concurrency", "endpoints[0].method", "endpoints[0].url"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateTestPlan(tt.plan, tt.minAgents)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.want) {
+				t.Errorf("ValidateTestPlan fields = %v, want %v (%v)", fields, tt.want, errs)
+			}
+		})
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	agentSecrets.mu.Lock()
+	agentSecrets.values = map[string]string{"token": "{{ uuid }}"}
+	agentSecrets.mu.Unlock()
+	t.Cleanup(func() {
+		agentSecrets.mu.Lock()
+		agentSecrets.values = nil
+		agentSecrets.mu.Unlock()
+	})
+
+	tests := []struct {
+		name     string
+		endpoint Endpoint
+		want     Endpoint // URL and headers compared; "*" matches any non-empty value
+		wantErr  string
+	}{
+		{
+			name:     "plain values",
+			endpoint: Endpoint{URL: "https://a.example.com/x", Headers: map[string]string{"H": "v"}},
+			want:     Endpoint{URL: "https://a.example.com/x", Headers: map[string]string{"H": "v"}},
+		},
+		{
+			name:     "braces without templates",
+			endpoint: Endpoint{URL: "https://a.example.com/{{ uuid }}", Headers: map[string]string{"H": "{{ broken"}},
+			want:     Endpoint{URL: "https://a.example.com/{{ uuid }}", Headers: map[string]string{"H": "{{ broken"}},
+		},
+		{
+			name:     "templates",
+			endpoint: Endpoint{Templates: true, URL: "https://a.example.com/{{ randInt 7 8 }}", Headers: map[string]string{"H": "{{ uuid }}"}},
+			want:     Endpoint{URL: "https://a.example.com/7", Headers: map[string]string{"H": "*"}},
+		},
+		{
+			name:     "secret values are not rendered",
+			endpoint: Endpoint{Templates: true, URL: "https://a.example.com/", Headers: map[string]string{"Authorization": "${secret:token}"}},
+			want:     Endpoint{URL: "https://a.example.com/", Headers: map[string]string{"Authorization": "{{ uuid }}"}},
+		},
+		{
+			name:     "missing secret",
+			endpoint: Endpoint{URL: "https://a.example.com/", Headers: map[string]string{"Authorization": "${secret:other}"}},
+			wantErr:  "secret other is not available",
+		},
+		{
+			name:     "invalid template",
+			endpoint: Endpoint{Templates: true, URL: "https://a.example.com/{{ nope }}"},
+			wantErr:  "url:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := resolveEndpoint(tt.endpoint)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("resolveEndpoint error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveEndpoint: %v", err)
+			}
+			if resolved.URL != tt.want.URL {
+				t.Errorf("URL = %q, want %q", resolved.URL, tt.want.URL)
+			}
+			for name, want := range tt.want.Headers {
+				if got := resolved.Headers[name]; got != want && !(want == "*" && got != "") {
+					t.Errorf("header %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareEndpoints(t *testing.T) {
+	agentSecrets.mu.Lock()
+	agentSecrets.values = map[string]string{"token": "s3cret"}
+	agentSecrets.mu.Unlock()
+	t.Cleanup(func() {
+		agentSecrets.mu.Lock()
+		agentSecrets.values = nil
+		agentSecrets.mu.Unlock()
+	})
+
+	prepared := prepareEndpoints([]Endpoint{
+		{URL: "https://a.example.com/", Headers: map[string]string{"Authorization": "${secret:token}"}},
+		{URL: "https://a.example.com/", Headers: map[string]string{"Authorization": "${secret:missing}"}},
+		{Templates: true, URL: "https://a.example.com/{{ uuid }}"},
+	})
+
+	// Resolved once, then sent as it is
+	if !prepared[0].static || prepared[0].Headers["Authorization"] != "s3cret" {
+		t.Errorf("plain endpoint prepared as %+v, want it resolved and static", prepared[0])
+	}
+	if resolved, _ := resolveEndpoint(prepared[0]); reflect.ValueOf(resolved.Headers).Pointer() != reflect.ValueOf(prepared[0].Headers).Pointer() {
+		t.Error("static endpoint was copied on resolution")
+	}
+
+	// Failures are left for each request to report
+	if prepared[1].static {
+		t.Error("endpoint with a missing secret prepared as static")
+	}
+	if _, err := resolveEndpoint(prepared[1]); err == nil {
+		t.Error("endpoint with a missing secret resolved")
+	}
+
+	// Templates are rendered per request
+	if prepared[2].static {
+		t.Error("templated endpoint prepared as static")
+	}
+	first, _ := resolveEndpoint(prepared[2])
+	second, _ := resolveEndpoint(prepared[2])
+	if first.URL == second.URL {
+		t.Errorf("templated URL rendered once: %s", first.URL)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// preflightTimeout bounds how long the coordinator waits for agent reports.
+// It stays below the API server's write timeout.
+const preflightTimeout = 20 * time.Second
+
+// preflightEndpointTimeout bounds a single preflight request on an agent
+const preflightEndpointTimeout = 10 * time.Second
+
+type PreflightCheck struct {
+	Name    string `json:"name"` // "template", "dns", "request", "status"
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+type PreflightEndpointResult struct {
+	Index       int              `json:"index"`
+	Method      string           `json:"method"`
+	URL         string           `json:"url"`
+	Success     bool             `json:"success"`
+	StatusCode  int              `json:"status_code,omitempty"`
+	LatencyMs   float64          `json:"latency_ms,omitempty"`
+	ResolvedDNS []string         `json:"resolved_dns,omitempty"`
+	Checks      []PreflightCheck `json:"checks"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// PreflightReport is what a single agent sends back for a preflight request
+type PreflightReport struct {
+	AgentID   string                    `json:"agent_id"`
+	Region    string                    `json:"region"`
+	TestRunID string                    `json:"test_run_id"`
+	Success   bool                      `json:"success"`
+	Endpoints []PreflightEndpointResult `json:"endpoints"`
+	Error     string                    `json:"error,omitempty"`
+}
+
+type PreflightResponse struct {
+	TestRunID   string            `json:"test_run_id"`
+	Success     bool              `json:"success"`
+	AgentCount  int               `json:"agent_count"`
+	FailedCount int               `json:"failed_count"`
+	Agents      []PreflightReport `json:"agents"`
+}
+
+func (c *Coordinator) handlePreflightTestRun(ctx *gin.Context) {
+	testRunID := ctx.Param("id")
+
+	c.mu.RLock()
+	testRun, exists := c.testRuns[testRunID]
+	var agents []*AgentInfo
+	if exists {
+		agents = c.selectAgents(testRun)
+	}
+	c.mu.RUnlock()
+
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Test run not found"})
+		return
+	}
+
+	if len(agents) == 0 {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "No matching agents connected"})
+		return
+	}
+
+	LogInfo("Running preflight for test run %s on %d agents", testRun.Name, len(agents))
+
+	response := c.runPreflight(testRun, agents)
+	auditSummary(ctx, "preflight of test run %q: %d of %d agents failed", testRun.Name, response.FailedCount, response.AgentCount)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// runPreflight asks every agent to send each endpoint once and collects the reports
+func (c *Coordinator) runPreflight(testRun *TestRun, agents []*AgentInfo) PreflightResponse {
+	command := TestStartCommand{
+		TestRunID: testRun.ID,
+		TestPlan:  testRun.TestPlan,
+		Command:   "PREFLIGHT",
+		Safety:    c.runSafety(),
+		Confirmed: testRun.Confirmed,
+	}
+
+	data, err := json.Marshal(command)
+	if err != nil {
+		LogError("Failed to marshal preflight command: %v", err)
+	}
+
+	// Agents may fetch the run's secrets while their preflight is in progress
+	c.mu.Lock()
+	for _, agent := range agents {
+		c.preflights[agent.ID] = testRun.ID
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		for _, agent := range agents {
+			if c.preflights[agent.ID] == testRun.ID {
+				delete(c.preflights, agent.ID)
+			}
+		}
+		c.mu.Unlock()
+	}()
+
+	reports := make([]PreflightReport, len(agents))
+	var wg sync.WaitGroup
+
+	for i, agent := range agents {
+		wg.Add(1)
+		go func(i int, agent *AgentInfo) {
+			defer wg.Done()
+
+			report := PreflightReport{
+				AgentID:   agent.ID,
+				Region:    agent.Region,
+				TestRunID: testRun.ID,
+			}
+
+			if data == nil {
+				report.Error = "failed to encode preflight command"
+				reports[i] = report
+				return
+			}
+
+			subject := fmt.Sprintf("armonite.agent.%s.command", agent.ID)
+			msg, err := c.signer.request(c.natsConn, subject, data, preflightTimeout)
+			if err != nil {
+				report.Error = fmt.Sprintf("no preflight report from agent: %v", err)
+				reports[i] = report
+				return
+			}
+
+			if err := json.Unmarshal(msg.Data, &report); err != nil {
+				report.Error = fmt.Sprintf("invalid preflight report: %v", err)
+			}
+			reports[i] = report
+		}(i, agent)
+	}
+
+	wg.Wait()
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].AgentID < reports[j].AgentID
+	})
+
+	response := PreflightResponse{
+		TestRunID:  testRun.ID,
+		Success:    true,
+		AgentCount: len(reports),
+		Agents:     reports,
+	}
+	for _, report := range reports {
+		if !report.Success {
+			response.Success = false
+			response.FailedCount++
+		}
+	}
+
+	LogInfo("Preflight for test run %s finished: %d/%d agents passed",
+		testRun.Name, response.AgentCount-response.FailedCount, response.AgentCount)
+
+	return response
+}
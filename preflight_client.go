@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// handlePreflight sends every endpoint in the plan once and replies with the results
+func (a *Agent) handlePreflight(msg *nats.Msg, command TestStartCommand) {
+	LogInfo("Running preflight for test plan: %s (%d endpoints)",
+		command.TestPlan.Name, len(command.TestPlan.Endpoints))
+
+	report := PreflightReport{
+		AgentID:   a.id,
+		Region:    a.region,
+		TestRunID: command.TestRunID,
+		Success:   true,
+		Endpoints: make([]PreflightEndpointResult, len(command.TestPlan.Endpoints)),
+	}
+
+	// Check the endpoints this agent will actually hit in its region
+	plan, err := command.TestPlan.ForRegion(a.region)
+	if err != nil {
+		report.Success = false
+		report.Error = err.Error()
+		plan = command.TestPlan
+	}
+
+	// Endpoints referring to secrets that cannot be loaded fail their template check
+	if err := a.loadSecrets(command.TestRunID, plan); err != nil {
+		report.Success = false
+		report.Error = err.Error()
+	}
+
+	// Blocked targets also fail their requests, as the policy is checked on connecting
+	if err := a.applySafety(command, plan); err != nil && report.Error == "" {
+		report.Success = false
+		report.Error = err.Error()
+	}
+
+	var wg sync.WaitGroup
+	for i, endpoint := range plan.Endpoints {
+		wg.Add(1)
+		go func(i int, endpoint Endpoint) {
+			defer wg.Done()
+			report.Endpoints[i] = a.preflightEndpoint(i, endpoint)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	for i := range report.Endpoints {
+		if !report.Endpoints[i].Success {
+			report.Success = false
+		}
+		report.Endpoints[i].redact()
+	}
+	report.Error = redactSecrets(report.Error)
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		LogError("Failed to marshal preflight report: %v", err)
+		return
+	}
+
+	if err := msg.Respond(data); err != nil {
+		LogError("Failed to send preflight report: %v", err)
+	}
+
+	LogInfo("Preflight finished (success: %t)", report.Success)
+}
+
+// redact masks secret values in a result, such as in resolved URLs and the
+// errors quoting them
+func (r *PreflightEndpointResult) redact() {
+	r.URL = redactSecrets(r.URL)
+	r.Error = redactSecrets(r.Error)
+	for i := range r.Checks {
+		r.Checks[i].Message = redactSecrets(r.Checks[i].Message)
+	}
+}
+
+func (a *Agent) preflightEndpoint(index int, endpoint Endpoint) PreflightEndpointResult {
+	result := PreflightEndpointResult{
+		Index:  index,
+		Method: strings.ToUpper(endpoint.Method),
+		URL:    endpoint.URL,
+	}
+
+	fail := func(check, message string) PreflightEndpointResult {
+		result.Checks = append(result.Checks, PreflightCheck{Name: check, Passed: false, Message: message})
+		result.Error = message
+		return result
+	}
+
+	// Resolve templates exactly as a real request would
+	resolved, err := resolveEndpoint(endpoint)
+	if err != nil {
+		return fail("template", err.Error())
+	}
+	result.URL = resolved.URL
+	result.Checks = append(result.Checks, PreflightCheck{Name: "template", Passed: true})
+
+	parsed, err := url.Parse(resolved.URL)
+	if err != nil {
+		return fail("dns", fmt.Sprintf("invalid URL: %v", err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), preflightEndpointTimeout)
+	defer cancel()
+
+	// Resolve DNS from this agent's point of view
+	addrs, err := net.DefaultResolver.LookupHost(ctx, parsed.Hostname())
+	if err != nil {
+		return fail("dns", fmt.Sprintf("DNS lookup failed for %s: %v", parsed.Hostname(), err))
+	}
+	result.ResolvedDNS = addrs
+	result.Checks = append(result.Checks, PreflightCheck{
+		Name:    "dns",
+		Passed:  true,
+		Message: strings.Join(addrs, ", "),
+	})
+
+	var body io.Reader
+	if resolved.Body != nil {
+		bodyData, _ := json.Marshal(resolved.Body)
+		body = bytes.NewReader(bodyData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, result.Method, resolved.URL, body)
+	if err != nil {
+		return fail("request", fmt.Sprintf("failed to create request: %v", err))
+	}
+	for key, value := range resolved.Headers {
+		req.Header.Set(key, value)
+	}
+
+	start := time.Now()
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		result.LatencyMs = float64(time.Since(start).Nanoseconds()) / 1e6
+		return fail("request", fmt.Sprintf("request failed: %v", err))
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	result.LatencyMs = float64(time.Since(start).Nanoseconds()) / 1e6
+	result.StatusCode = resp.StatusCode
+	result.Checks = append(result.Checks, PreflightCheck{Name: "request", Passed: true})
+
+	// Auth mistakes and missing routes show up as 4xx/5xx
+	if resp.StatusCode >= 400 {
+		return fail("status", fmt.Sprintf("unexpected status code %d", resp.StatusCode))
+	}
+	result.Checks = append(result.Checks, PreflightCheck{
+		Name:    "status",
+		Passed:  true,
+		Message: fmt.Sprintf("%d", resp.StatusCode),
+	})
+
+	result.Success = true
+	return result
+}
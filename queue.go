@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestRunEventQueueExpired is recorded when a queued run misses its deadline
+const TestRunEventQueueExpired = "queue_expired"
+
+// QueueEntry describes a pending test run in GET /api/v1/queue
+type QueueEntry struct {
+	Position        int           `json:"position"`
+	TestRunID       string        `json:"test_run_id"`
+	Name            string        `json:"name"`
+	Status          TestRunStatus `json:"status"`
+	Priority        int           `json:"priority"`
+	QueuedAt        *time.Time    `json:"queued_at,omitempty"`
+	Deadline        *time.Time    `json:"deadline,omitempty"`
+	AgentCount      int           `json:"agent_count"`
+	AvailableAgents int           `json:"available_agents"` // Matching agents free for this run
+}
+
+// queueTime is when a pending run joined the queue; runs waiting without
+// queue: true count from when they were started
+func queueTime(testRun *TestRun) time.Time {
+	if testRun.QueuedAt != nil {
+		return *testRun.QueuedAt
+	}
+	if testRun.StartedAt != nil {
+		return *testRun.StartedAt
+	}
+	return testRun.CreatedAt
+}
+
+// runQueuedBefore orders pending runs by priority, then first in first out
+func runQueuedBefore(a, b *TestRun) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return queueTime(a).Before(queueTime(b))
+}
+
+// pendingRuns returns the waiting and queued runs in the order they may
+// start. Caller must hold c.mu.
+func (c *Coordinator) pendingRuns() []*activeRun {
+	pending := make([]*activeRun, 0, len(c.activeRuns))
+	for _, run := range c.activeRuns {
+		if run.testRun.IsPending() {
+			pending = append(pending, run)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return runQueuedBefore(pending[i].testRun, pending[j].testRun)
+	})
+	return pending
+}
+
+// heldAgents returns the free agents matched by pending runs, which a newly
+// started run must not take ahead of them. Caller must hold c.mu.
+func (c *Coordinator) heldAgents() map[string]bool {
+	held := make(map[string]bool)
+	for _, run := range c.pendingRuns() {
+		for _, agent := range c.selectAgentsExcluding(run.testRun, held) {
+			held[agent.ID] = true
+		}
+	}
+	return held
+}
+
+// agentsBusy reports whether any connected agent matching the run is in use
+// by, or held for, another run. Caller must hold c.mu.
+func (c *Coordinator) agentsBusy(testRun *TestRun, held map[string]bool) bool {
+	for id, agent := range c.connectedAgents {
+		if !testRun.Selector.Matches(agent) {
+			continue
+		}
+		if reservedFor, reserved := c.agentReservations[id]; reserved && reservedFor != testRun.ID {
+			return true
+		}
+		if held[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleQueueDeadline cancels a queued run that has not started by its deadline
+func (c *Coordinator) scheduleQueueDeadline(testRun *TestRun) {
+	if testRun.QueueDeadline == nil {
+		return
+	}
+
+	time.AfterFunc(time.Until(*testRun.QueueDeadline), func() {
+		c.expireQueuedRun(testRun.ID)
+	})
+}
+
+func (c *Coordinator) expireQueuedRun(testRunID string) {
+	c.mu.Lock()
+	testRun, exists := c.testRuns[testRunID]
+	if !exists || testRun.Status != TestRunStatusQueued {
+		c.mu.Unlock()
+		return
+	}
+
+	c.deactivateTestRun(testRunID)
+	testRun.AddEvent(TestRunEvent{
+		Type: TestRunEventQueueExpired,
+		Message: fmt.Sprintf("not started by the queue deadline %s; fewer than %d matching agents were free",
+			testRun.QueueDeadline.Format(time.RFC3339), testRun.AgentCount),
+	})
+	testRun.Cancel()
+
+	// Agents held for this run may now go to runs behind it
+	c.startWaitingRuns()
+	c.mu.Unlock()
+
+	if err := c.database.SaveTestRun(testRun); err != nil {
+		LogError("Failed to save expired test run to database: %v", err)
+	}
+	LogWarn("Queued test run expired: %s (ID: %s)", testRun.Name, testRun.ID)
+}
+
+// handleGetQueue lists waiting and queued test runs in start order
+func (c *Coordinator) handleGetQueue(ctx *gin.Context) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	held := make(map[string]bool)
+	entries := make([]QueueEntry, 0)
+	for i, run := range c.pendingRuns() {
+		available := c.selectAgentsExcluding(run.testRun, held)
+		entries = append(entries, QueueEntry{
+			Position:        i + 1,
+			TestRunID:       run.testRun.ID,
+			Name:            run.testRun.Name,
+			Status:          run.testRun.Status,
+			Priority:        run.testRun.Priority,
+			QueuedAt:        run.testRun.QueuedAt,
+			Deadline:        run.testRun.QueueDeadline,
+			AgentCount:      run.testRun.AgentCount,
+			AvailableAgents: len(available),
+		})
+		for _, agent := range available {
+			held[agent.ID] = true
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"queue": entries,
+		"count": len(entries),
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Rebalance policies for agents joining or leaving a running test
+const (
+	RebalanceNone     = "none"     // Late joiners stay idle and lost load is not replaced
+	RebalanceConstant = "constant" // Keep total load constant by redistributing shares
+)
+
+// Test run event types
+const (
+	TestRunEventAgentJoined = "agent_joined"
+	TestRunEventAgentLeft   = "agent_left"
+)
+
+// minRebalanceRemaining is the least remaining run time worth rebalancing for
+const minRebalanceRemaining = 2 * time.Second
+
+// rebalanceTestRun redistributes a running test's load after an agent joined
+// or left. connectedAgents must already reflect the change. Caller must hold c.mu.
+func (c *Coordinator) rebalanceTestRun(testRun *TestRun, eventType, agentID string) {
+	if testRun == nil || testRun.Status != TestRunStatusRunning || testRun.TestPlan.Rebalance != RebalanceConstant {
+		return
+	}
+	run, active := c.activeRuns[testRun.ID]
+	if !active {
+		return
+	}
+
+	previous := make(map[string]AgentAllocation, len(testRun.Allocation))
+	total := 0
+	for _, allocation := range testRun.Allocation {
+		previous[allocation.AgentID] = allocation
+		total += allocation.Concurrency
+	}
+
+	// A departing agent that had no share leaves nothing to redistribute
+	if (eventType == TestRunEventAgentLeft || eventType == TestRunEventAgentExcluded) && previous[agentID].Concurrency == 0 {
+		return
+	}
+
+	if run.phaseOrchestrator != nil {
+		LogWarn("Not rebalancing test run %s: phase-orchestrated runs cannot be rebalanced", testRun.Name)
+		return
+	}
+
+	duration, err := time.ParseDuration(testRun.TestPlan.Duration)
+	if err != nil {
+		return
+	}
+	remaining := duration - time.Since(testRun.runningSince)
+	if remaining < minRebalanceRemaining {
+		LogDebug("Not rebalancing test run %s: only %s remaining", testRun.Name, remaining)
+		return
+	}
+	remaining = remaining.Truncate(time.Second)
+
+	// Plans without a global concurrency keep the total the run started with
+	plan := testRun.TestPlan
+	if plan.Concurrency == 0 {
+		plan.Concurrency = total
+	}
+
+	// Free matching agents may be drawn in alongside the run's own, except
+	// those that already refused to start it
+	agents := c.selectAgentsExcluding(testRun, run.excluded)
+
+	// A joiner outside the selector, or beyond its max_agents, changes nothing
+	if eventType == TestRunEventAgentJoined || eventType == TestRunEventAgentResumed {
+		selected := false
+		for _, agent := range agents {
+			if agent.ID == agentID {
+				selected = true
+				break
+			}
+		}
+		if !selected {
+			return
+		}
+	}
+
+	allocations, warnings := allocateLoad(plan, agents)
+
+	// Late joiners run the rest of the test at their full share straight away
+	latePlan := testRun.TestPlan
+	latePlan.Duration = remaining.String()
+	latePlan.RampUpStrategy = nil
+
+	startTime := time.Now().UTC().Format(time.RFC3339)
+	for i := range allocations {
+		allocation := allocations[i]
+		before, wasRunning := previous[allocation.AgentID]
+		wasRunning = wasRunning && before.Concurrency > 0
+
+		// A resumed agent kept running its old share while it was away
+		if eventType == TestRunEventAgentResumed && allocation.AgentID == agentID {
+			wasRunning = true
+		}
+
+		if allocation.Concurrency > 0 {
+			c.reserveAgent(run, allocation.AgentID)
+			run.participants[allocation.AgentID] = true
+		} else {
+			// A running agent left without a share stops before another run
+			// can have it. Its final report still counts; the run no longer
+			// waits for it.
+			c.releaseAgent(run, allocation.AgentID)
+			delete(run.participants, allocation.AgentID)
+		}
+
+		switch {
+		case wasRunning && allocation.Concurrency == 0:
+			c.sendAllocationCommand(testRun.ID, "STOP", &allocation, startTime)
+		case wasRunning && (before.Concurrency != allocation.Concurrency || before.TargetRPS != allocation.TargetRPS):
+			c.sendAllocationCommand(testRun.ID, "REBALANCE", &allocation, startTime)
+		case !wasRunning && allocation.Concurrency > 0:
+			c.sendLateStart(testRun, latePlan, allocation, startTime)
+		}
+	}
+
+	var message string
+	switch eventType {
+	case TestRunEventAgentJoined:
+		message = fmt.Sprintf("agent %s joined with %s remaining; load rebalanced across %d agents",
+			agentID, remaining, countAllocated(allocations))
+	case TestRunEventAgentLeft:
+		message = fmt.Sprintf("agent %s left with %s remaining; its %d workers were redistributed across %d agents",
+			agentID, remaining, previous[agentID].Concurrency, countAllocated(allocations))
+	case TestRunEventAgentResumed:
+		message = fmt.Sprintf("agent %s reconnected with %s remaining; load rebalanced across %d agents",
+			agentID, remaining, countAllocated(allocations))
+	case TestRunEventAgentExcluded:
+		message = fmt.Sprintf("agent %s did not start with %s remaining; its %d workers were redistributed across %d agents",
+			agentID, remaining, previous[agentID].Concurrency, countAllocated(allocations))
+	}
+	for _, warning := range warnings {
+		message += "; " + warning
+	}
+
+	testRun.setAllocation(allocations)
+	testRun.AddEvent(TestRunEvent{
+		Type:       eventType,
+		AgentID:    agentID,
+		Message:    message,
+		Allocation: allocations,
+	})
+	LogInfo("Rebalanced test run %s: %s", testRun.Name, message)
+}
+
+// sendAllocationCommand sends a REBALANCE or STOP command carrying an agent's
+// new share without waiting for an acknowledgement
+func (c *Coordinator) sendAllocationCommand(testRunID, command string, allocation *AgentAllocation, startTime string) {
+	cmd := TestStartCommand{
+		TestRunID:  testRunID,
+		Command:    command,
+		StartTime:  startTime,
+		Allocation: allocation,
+	}
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		LogError("Failed to marshal %s command: %v", command, err)
+		return
+	}
+
+	subject := fmt.Sprintf("armonite.agent.%s.command", allocation.AgentID)
+	if err := c.signer.publish(c.natsConn, subject, data); err != nil {
+		LogError("Failed to send %s command to agent %s: %v", command, allocation.AgentID, err)
+	}
+}
+
+func countAllocated(allocations []AgentAllocation) int {
+	count := 0
+	for _, allocation := range allocations {
+		if allocation.Concurrency > 0 {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// testCommandCoordinator is a coordinator whose commands to agents are
+// delivered to the returned channel, keyed by agent ID. Agents acknowledge
+// commands sent as requests, except that those in refuse reply with its error.
+func testCommandCoordinator(t *testing.T, refuse map[string]string) (*Coordinator, <-chan [2]string) {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	t.Cleanup(ns.Shutdown)
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	signer, err := loadCommandSigner(filepath.Join(t.TempDir(), "signing.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := make(chan [2]string, 16)
+	_, err = nc.Subscribe(agentSubject("*", "command"), func(msg *nats.Msg) {
+		var command TestStartCommand
+		if err := json.Unmarshal(msg.Data, &command); err != nil {
+			t.Error(err)
+			return
+		}
+		agentID := strings.Split(msg.Subject, ".")[2]
+		commands <- [2]string{agentID, command.Command}
+
+		if msg.Reply != "" {
+			ack := CommandAck{AgentID: agentID, TestRunID: command.TestRunID, Command: command.Command, Error: refuse[agentID]}
+			ack.Ready = ack.Error == ""
+			data, _ := json.Marshal(ack)
+			msg.Respond(data)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Coordinator{
+		natsConn:          nc,
+		signer:            signer,
+		connectedAgents:   make(map[string]*AgentInfo),
+		activeRuns:        make(map[string]*activeRun),
+		agentReservations: make(map[string]string),
+	}, commands
+}
+
+// receiveCommands collects the commands sent to agents until none arrive for a while
+func receiveCommands(commands <-chan [2]string) map[string]string {
+	received := make(map[string]string)
+	for {
+		select {
+		case command := <-commands:
+			received[command[0]] = command[1]
+		case <-time.After(200 * time.Millisecond):
+			return received
+		}
+	}
+}
+
+func TestRebalanceStopsAgentsLeftWithoutAShare(t *testing.T) {
+	c, commands := testCommandCoordinator(t, nil)
+
+	testRun := &TestRun{
+		ID:           "r1",
+		Name:         "rebalanced",
+		Status:       TestRunStatusRunning,
+		runningSince: time.Now(),
+		TestPlan: TestPlan{
+			Duration:    "1m",
+			Concurrency: 2,
+			Allocation:  AllocationWeight,
+			Rebalance:   RebalanceConstant,
+			Endpoints:   []Endpoint{{Method: "GET", URL: "http://127.0.0.1/"}},
+		},
+	}
+	testRun.setAllocation([]AgentAllocation{
+		{AgentID: "a1", Weight: 1, Concurrency: 1},
+		{AgentID: "a2", Weight: 1, Concurrency: 1},
+	})
+
+	c.mu.Lock()
+	run := c.activateTestRun(testRun)
+	for _, agent := range []*AgentInfo{
+		{ID: "a1", Concurrency: 10, Weight: 1},
+		{ID: "a2", Concurrency: 10, Weight: 1},
+		{ID: "a3", Concurrency: 10, Weight: 100},
+	} {
+		c.connectedAgents[agent.ID] = agent
+	}
+	for _, agentID := range []string{"a1", "a2"} {
+		c.reserveAgent(run, agentID)
+		run.participants[agentID] = true
+	}
+
+	// a3 outweighs the others so much that it takes the whole load
+	c.rebalanceTestRun(testRun, TestRunEventAgentJoined, "a3")
+
+	_, a1Reserved := c.agentReservations["a1"]
+	participants := run.participants
+	c.mu.Unlock()
+
+	want := map[string]string{"a1": "STOP", "a2": "STOP", "a3": "START"}
+	if got := receiveCommands(commands); len(got) != len(want) || got["a1"] != "STOP" || got["a2"] != "STOP" || got["a3"] != "START" {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+	if a1Reserved {
+		t.Error("a1 still reserved for the run")
+	}
+	if len(participants) != 1 || !participants["a3"] {
+		t.Errorf("participants = %v, want only a3", participants)
+	}
+}
+
+func TestRebalanceExcludesLateJoinersThatRefuseStart(t *testing.T) {
+	c, commands := testCommandCoordinator(t, map[string]string{"a3": "still running the previous test"})
+
+	testRun := &TestRun{
+		ID:           "r1",
+		Name:         "rebalanced",
+		Status:       TestRunStatusRunning,
+		runningSince: time.Now(),
+		TestPlan: TestPlan{
+			Duration:    "1m",
+			Concurrency: 4,
+			Rebalance:   RebalanceConstant,
+			Endpoints:   []Endpoint{{Method: "GET", URL: "http://127.0.0.1/"}},
+		},
+	}
+	testRun.setAllocation([]AgentAllocation{
+		{AgentID: "a1", Concurrency: 2},
+		{AgentID: "a2", Concurrency: 2},
+	})
+
+	c.mu.Lock()
+	run := c.activateTestRun(testRun)
+	for _, agentID := range []string{"a1", "a2", "a3"} {
+		c.connectedAgents[agentID] = &AgentInfo{ID: agentID, Concurrency: 10}
+	}
+	for _, agentID := range []string{"a1", "a2"} {
+		c.reserveAgent(run, agentID)
+		run.participants[agentID] = true
+	}
+	c.rebalanceTestRun(testRun, TestRunEventAgentJoined, "a3")
+	c.mu.Unlock()
+
+	// a3 refuses its START, is stopped in case, and its share goes back to
+	// whichever agent gave one up when it joined
+	got := receiveCommands(commands)
+	rebalanced := 0
+	for _, agentID := range []string{"a1", "a2"} {
+		switch got[agentID] {
+		case "REBALANCE":
+			rebalanced++
+		case "":
+		default:
+			t.Errorf("%s last sent %s", agentID, got[agentID])
+		}
+	}
+	if got["a3"] != "STOP" || rebalanced == 0 {
+		t.Errorf("last commands = %v, want STOP to a3 and REBALANCE to the others", got)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, reserved := c.agentReservations["a3"]; reserved || run.participants["a3"] || !run.excluded["a3"] {
+		t.Errorf("a3 reserved %t, participant %t, excluded %t; want only excluded", reserved, run.participants["a3"], run.excluded["a3"])
+	}
+	shares := make(map[string]int)
+	for _, allocation := range testRun.Allocation {
+		shares[allocation.AgentID] = allocation.Concurrency
+	}
+	if shares["a1"] != 2 || shares["a2"] != 2 || shares["a3"] != 0 {
+		t.Errorf("allocation = %v, want a1 and a2 back at 2 workers", shares)
+	}
+
+	// It is not drawn back in by the next rebalance
+	c.rebalanceTestRun(testRun, TestRunEventAgentJoined, "a3")
+	if run.participants["a3"] {
+		t.Error("a3 drawn back into the run")
+	}
+}
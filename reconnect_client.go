@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Reconnect backoff
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// reconnectDelay doubles the wait after each failed round of reconnect
+// attempts up to reconnectMaxDelay, with jitter so agents cut off together do
+// not reconnect together
+func reconnectDelay(attempts int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempts <= 1 {
+		delay = reconnectMinDelay
+	} else if attempts < 6 {
+		delay = reconnectMinDelay << uint(attempts-1)
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/5)+1))
+}
+
+// rejoinCoordinator re-registers after a reconnect, reporting the run in
+// progress, then replays what could not be delivered while disconnected. The
+// coordinator may have restarted with a new signing key.
+func (a *Agent) rejoinCoordinator() {
+	a.setupDurablePublishing()
+	if err := a.fetchCoordinatorKey(); err != nil {
+		LogWarn("Commands cannot be verified: %v", err)
+	}
+	if err := a.registerWithCoordinator(); err != nil {
+		LogWarn("Failed to re-register with coordinator: %v", err)
+	}
+	a.replayDurableBuffer()
+}
+
+// testRunID returns the test run this agent is taking part in, if any
+func (a *Agent) testRunID() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.currentTestRunID
+}
+
+// runState describes the run this agent is taking part in, if any
+func (a *Agent) runState() *AgentRunState {
+	a.mu.RLock()
+	testRunID := a.currentTestRunID
+	running := a.running
+	a.mu.RUnlock()
+
+	if testRunID == "" {
+		return nil
+	}
+
+	state := &AgentRunState{
+		TestRunID: testRunID,
+		SessionID: a.metrics.SessionID,
+		State:     AgentRunPrepared,
+	}
+	switch {
+	case a.finishedRun(testRunID):
+		state.State = AgentRunFinished
+	case running:
+		state.State = AgentRunRunning
+	}
+	return state
+}
+
+// finishedRun reports whether this agent already sent its final metrics for a run
+func (a *Agent) finishedRun(testRunID string) bool {
+	a.metrics.mu.Lock()
+	defer a.metrics.mu.Unlock()
+	return testRunID != "" && a.metrics.TestRunID == testRunID && a.metrics.finalSent
+}
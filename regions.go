@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// unassignedRegion groups results from agents registered without --region
+const unassignedRegion = "unassigned"
+
+// PlanRegion gives a region its share of the plan load and optional
+// overrides applied to every endpoint run by agents in that region
+type PlanRegion struct {
+	Name    string            `yaml:"name" json:"name"`
+	Weight  float64           `yaml:"weight" json:"weight"`
+	BaseURL string            `yaml:"base_url,omitempty" json:"base_url,omitempty"` // Replaces scheme and host of endpoint URLs
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`   // Added to, or replacing, endpoint headers
+}
+
+// RegionResult aggregates the results of every agent in one region
+type RegionResult struct {
+	Region       string           `json:"region"`
+	Agents       int              `json:"agents"`
+	Requests     int64            `json:"requests"`
+	Errors       int64            `json:"errors"`
+	SuccessRate  float64          `json:"success_rate"`
+	AvgLatencyMs float64          `json:"avg_latency_ms"`
+	MinLatencyMs float64          `json:"min_latency_ms"`
+	MaxLatencyMs float64          `json:"max_latency_ms"`
+	P50LatencyMs float64          `json:"p50_latency_ms"`
+	P90LatencyMs float64          `json:"p90_latency_ms"`
+	P95LatencyMs float64          `json:"p95_latency_ms"`
+	P99LatencyMs float64          `json:"p99_latency_ms"`
+	StatusCodes  map[string]int64 `json:"status_codes"`
+}
+
+// findRegion returns the plan's configuration for a region, if any
+func (plan TestPlan) findRegion(name string) *PlanRegion {
+	for i := range plan.Regions {
+		if plan.Regions[i].Name == name {
+			return &plan.Regions[i]
+		}
+	}
+	return nil
+}
+
+// ForRegion returns a copy of the plan with the region's endpoint overrides applied
+func (plan TestPlan) ForRegion(region string) (TestPlan, error) {
+	regionConfig := plan.findRegion(region)
+	if regionConfig == nil || (regionConfig.BaseURL == "" && len(regionConfig.Headers) == 0) {
+		return plan, nil
+	}
+
+	regional := plan
+	regional.Endpoints = make([]Endpoint, len(plan.Endpoints))
+	for i, endpoint := range plan.Endpoints {
+		if regionConfig.BaseURL != "" {
+			rewritten, err := rebaseURL(endpoint.URL, regionConfig.BaseURL)
+			if err != nil {
+				return plan, fmt.Errorf("region %s: %w", region, err)
+			}
+			endpoint.URL = rewritten
+		}
+
+		if len(regionConfig.Headers) > 0 {
+			headers := make(map[string]string, len(endpoint.Headers)+len(regionConfig.Headers))
+			for key, value := range endpoint.Headers {
+				headers[key] = value
+			}
+			for key, value := range regionConfig.Headers {
+				headers[key] = value
+			}
+			endpoint.Headers = headers
+		}
+
+		regional.Endpoints[i] = endpoint
+	}
+	return regional, nil
+}
+
+// rebaseURL swaps the scheme and host of an endpoint URL for those of base,
+// prefixing any base path. Templated URLs are rewritten textually.
+func rebaseURL(endpointURL, base string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid base_url: %w", err)
+	}
+	basePrefix := strings.TrimSuffix(baseURL.Scheme+"://"+baseURL.Host+baseURL.Path, "/")
+
+	// Keep everything after the host, which may contain template actions
+	rest := endpointURL
+	if i := strings.Index(rest, "://"); i >= 0 {
+		rest = rest[i+3:]
+		if j := strings.Index(rest, "/"); j >= 0 {
+			rest = rest[j:]
+		} else {
+			rest = ""
+		}
+	}
+	return basePrefix + rest, nil
+}
+
+// allocateRegions splits the plan load between regions by weight, then
+// within each region using the plan's allocation strategy
+func allocateRegions(plan TestPlan, agents []*AgentInfo) ([]AgentAllocation, []string) {
+	var warnings []string
+
+	byRegion := make(map[string][]*AgentInfo)
+	for _, agent := range agents {
+		if plan.findRegion(agent.Region) == nil {
+			warnings = append(warnings, fmt.Sprintf(
+				"agent %s is in region %q, which the plan does not list; it will stay idle", agent.ID, agent.Region))
+			continue
+		}
+		byRegion[agent.Region] = append(byRegion[agent.Region], agent)
+	}
+
+	// Only regions with agents share the load
+	var regions []PlanRegion
+	var weights []float64
+	var caps []int
+	var totalWeight float64
+	for _, region := range plan.Regions {
+		regionAgents := byRegion[region.Name]
+		if len(regionAgents) == 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"no agents in region %s; its share is spread across the other regions", region.Name))
+			continue
+		}
+		capacity := 0
+		for _, agent := range regionAgents {
+			capacity += agent.Concurrency
+		}
+		regions = append(regions, region)
+		weights = append(weights, region.Weight)
+		caps = append(caps, capacity)
+		totalWeight += region.Weight
+	}
+
+	var concurrencyShares []int
+	if plan.Concurrency > 0 {
+		var unallocated int
+		concurrencyShares, unallocated = splitConcurrency(plan.Concurrency, weights, caps)
+		if unallocated > 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"plan concurrency %d exceeds the combined capacity of the selected agents; %d workers could not be allocated",
+				plan.Concurrency, unallocated))
+		}
+	}
+
+	var allocations []AgentAllocation
+	for i, region := range regions {
+		regionPlan := plan
+		regionPlan.Regions = nil
+		if plan.Concurrency > 0 {
+			regionPlan.Concurrency = concurrencyShares[i]
+			if regionPlan.Concurrency == 0 {
+				// Too few workers to reach this region; leave its agents idle
+				for _, agent := range byRegion[region.Name] {
+					allocations = append(allocations, AgentAllocation{AgentID: agent.ID, Region: region.Name})
+				}
+				continue
+			}
+		}
+		if plan.TargetRPS > 0 && totalWeight > 0 {
+			regionPlan.TargetRPS = plan.TargetRPS * region.Weight / totalWeight
+		}
+
+		regionAllocations, regionWarnings := allocateLoad(regionPlan, byRegion[region.Name])
+		for j := range regionAllocations {
+			regionAllocations[j].Region = region.Name
+		}
+		allocations = append(allocations, regionAllocations...)
+		warnings = append(warnings, regionWarnings...)
+	}
+
+	// Idle agents outside the plan's regions are still listed
+	for _, agent := range agents {
+		if plan.findRegion(agent.Region) == nil {
+			allocations = append(allocations, AgentAllocation{AgentID: agent.ID, Region: agent.Region})
+		}
+	}
+
+	sort.Slice(allocations, func(i, j int) bool {
+		return allocations[i].AgentID < allocations[j].AgentID
+	})
+	return allocations, warnings
+}
+
+// aggregateRegions groups agent results by region, merging latency
+// histograms for region-level percentiles
+func aggregateRegions(agentResults []AgentResult) []RegionResult {
+	type regionTotals struct {
+		result       RegionResult
+		totalLatency float64
+		histogram    *LatencyHistogram
+	}
+
+	totals := make(map[string]*regionTotals)
+	for _, agentResult := range agentResults {
+		name := agentResult.Region
+		if name == "" {
+			name = unassignedRegion
+		}
+
+		region, exists := totals[name]
+		if !exists {
+			region = &regionTotals{
+				result:    RegionResult{Region: name, StatusCodes: make(map[string]int64)},
+				histogram: NewLatencyHistogram(),
+			}
+			totals[name] = region
+		}
+
+		region.result.Agents++
+		region.result.Requests += agentResult.Requests
+		region.result.Errors += agentResult.Errors
+		region.totalLatency += agentResult.AvgLatencyMs * float64(agentResult.Requests)
+		if agentResult.MinLatencyMs > 0 && (region.result.MinLatencyMs == 0 || agentResult.MinLatencyMs < region.result.MinLatencyMs) {
+			region.result.MinLatencyMs = agentResult.MinLatencyMs
+		}
+		if agentResult.MaxLatencyMs > region.result.MaxLatencyMs {
+			region.result.MaxLatencyMs = agentResult.MaxLatencyMs
+		}
+		for code, count := range agentResult.StatusCodes {
+			region.result.StatusCodes[code] += count
+		}
+		region.histogram.Merge(agentResult.Latency)
+	}
+
+	results := make([]RegionResult, 0, len(totals))
+	for _, region := range totals {
+		result := region.result
+		result.SuccessRate = 100
+		if result.Requests > 0 {
+			result.AvgLatencyMs = region.totalLatency / float64(result.Requests)
+			result.SuccessRate = float64(result.Requests-result.Errors) / float64(result.Requests) * 100
+		}
+		result.P50LatencyMs = region.histogram.Percentile(50)
+		result.P90LatencyMs = region.histogram.Percentile(90)
+		result.P95LatencyMs = region.histogram.Percentile(95)
+		result.P99LatencyMs = region.histogram.Percentile(99)
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Region < results[j].Region
+	})
+	return results
+}
+
+// validateRegions checks the plan's regions section
+func validateRegions(plan TestPlan, errs *PlanValidationErrors) {
+	seen := make(map[string]bool)
+	for i, region := range plan.Regions {
+		path := fmt.Sprintf("regions[%d]", i)
+		if strings.TrimSpace(region.Name) == "" {
+			errs.add(path+".name", "is required")
+		} else if seen[region.Name] {
+			errs.add(path+".name", "duplicate region %q", region.Name)
+		}
+		seen[region.Name] = true
+
+		if region.Weight <= 0 {
+			errs.add(path+".weight", "must be greater than zero")
+		}
+
+		if region.BaseURL != "" {
+			if parsed, err := url.Parse(region.BaseURL); err != nil {
+				errs.add(path+".base_url", "invalid URL: %v", err)
+			} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+				errs.add(path+".base_url", "scheme must be http or https")
+			} else if parsed.Host == "" {
+				errs.add(path+".base_url", "host is required")
+			}
+		}
+
+		for name := range region.Headers {
+			if strings.TrimSpace(name) == "" {
+				errs.add(path+".headers", "header name must not be empty")
+			}
+		}
+	}
+}
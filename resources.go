@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// AgentResourceUsage is a snapshot of the agent host's own resource usage,
+// sent with every heartbeat so an overloaded load generator can be spotted
+type AgentResourceUsage struct {
+	CPUPercent       float64 `json:"cpu_percent"` // Share of all host cores, 0-100
+	RSSBytes         uint64  `json:"rss_bytes"`
+	Goroutines       int     `json:"goroutines"`
+	OpenFDs          int     `json:"open_fds"` // -1 when unavailable on this platform
+	InFlightRequests int64   `json:"in_flight_requests"`
+	GCPauseMaxMs     float64 `json:"gc_pause_max_ms"`   // Longest GC pause since the previous sample
+	GCPauseTotalMs   float64 `json:"gc_pause_total_ms"` // Total GC pause since the previous sample
+	NumGC            uint32  `json:"num_gc"`
+}
+
+// resourceSampler computes deltas (CPU, GC pauses) between successive samples
+type resourceSampler struct {
+	mu       sync.Mutex
+	lastCPU  time.Duration
+	lastWall time.Time
+	lastGC   uint32
+}
+
+func newResourceSampler() *resourceSampler {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	return &resourceSampler{
+		lastCPU:  processCPUTime(),
+		lastWall: time.Now(),
+		lastGC:   memStats.NumGC,
+	}
+}
+
+func (s *resourceSampler) Sample(inFlight int64) *AgentResourceUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	cpu := processCPUTime()
+
+	var cpuPercent float64
+	if wall := now.Sub(s.lastWall); wall > 0 {
+		cpuPercent = float64(cpu-s.lastCPU) / float64(wall) / float64(runtime.NumCPU()) * 100
+	}
+	s.lastCPU = cpu
+	s.lastWall = now
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	// PauseNs is a circular buffer of the most recent 256 pauses
+	var pauseMax, pauseTotal uint64
+	newGCs := memStats.NumGC - s.lastGC
+	if newGCs > uint32(len(memStats.PauseNs)) {
+		newGCs = uint32(len(memStats.PauseNs))
+	}
+	for i := uint32(0); i < newGCs; i++ {
+		pause := memStats.PauseNs[(memStats.NumGC-i+255)%256]
+		pauseTotal += pause
+		if pause > pauseMax {
+			pauseMax = pause
+		}
+	}
+	s.lastGC = memStats.NumGC
+
+	rss := processRSSBytes()
+	if rss == 0 {
+		rss = memStats.Sys
+	}
+
+	return &AgentResourceUsage{
+		CPUPercent:       cpuPercent,
+		RSSBytes:         rss,
+		Goroutines:       runtime.NumGoroutine(),
+		OpenFDs:          processOpenFDs(),
+		InFlightRequests: inFlight,
+		GCPauseMaxMs:     float64(pauseMax) / 1e6,
+		GCPauseTotalMs:   float64(pauseTotal) / 1e6,
+		NumGC:            memStats.NumGC,
+	}
+}
+
+// Evaluate returns the reasons an agent counts as saturated, or nil.
+// Zero thresholds are disabled.
+func (s SaturationConfig) Evaluate(usage *AgentResourceUsage) []string {
+	if usage == nil {
+		return nil
+	}
+
+	var reasons []string
+	if s.CPUPercent > 0 && usage.CPUPercent >= s.CPUPercent {
+		reasons = append(reasons, fmt.Sprintf("cpu %.0f%% >= %.0f%%", usage.CPUPercent, s.CPUPercent))
+	}
+	if s.MaxRSSMB > 0 && usage.RSSBytes >= uint64(s.MaxRSSMB)*1024*1024 {
+		reasons = append(reasons, fmt.Sprintf("rss %dMB >= %dMB", usage.RSSBytes/1024/1024, s.MaxRSSMB))
+	}
+	if s.MaxGoroutines > 0 && usage.Goroutines >= s.MaxGoroutines {
+		reasons = append(reasons, fmt.Sprintf("goroutines %d >= %d", usage.Goroutines, s.MaxGoroutines))
+	}
+	if s.MaxOpenFDs > 0 && usage.OpenFDs >= s.MaxOpenFDs {
+		reasons = append(reasons, fmt.Sprintf("open fds %d >= %d", usage.OpenFDs, s.MaxOpenFDs))
+	}
+	if s.GCPauseMs > 0 && usage.GCPauseMaxMs >= s.GCPauseMs {
+		reasons = append(reasons, fmt.Sprintf("gc pause %.1fms >= %.1fms", usage.GCPauseMaxMs, s.GCPauseMs))
+	}
+	return reasons
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// safetyLookupTimeout bounds resolving a target when address entries need it
+const safetyLookupTimeout = 2 * time.Second
+
+// targetDialer connects to targets with the settings of Go's default transport
+var targetDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// TestRunEventTargetBlocked records an agent refusing to connect to a target.
+// Agents report it as an execution update with this status.
+const TestRunEventTargetBlocked = "target_blocked"
+
+// SafetyViolation is one way a test plan or connection breaks the safety policy
+type SafetyViolation struct {
+	Host        string `json:"host"`
+	Rule        string `json:"rule"` // Setting broken, such as deny_targets or max_rps
+	Message     string `json:"message"`
+	Confirmable bool   `json:"confirmable,omitempty"` // Allowed when the run is created with confirm: true
+}
+
+func (v SafetyViolation) Error() string {
+	return v.Message
+}
+
+// SafetyViolations collects every way a test plan breaks the safety policy
+type SafetyViolations []SafetyViolation
+
+func (v SafetyViolations) Error() string {
+	messages := make([]string, len(v))
+	for i, violation := range v {
+		messages[i] = violation.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// needConfirmation reports whether confirming the run would clear every violation
+func (v SafetyViolations) needConfirmation() bool {
+	for _, violation := range v {
+		if !violation.Confirmable {
+			return false
+		}
+	}
+	return len(v) > 0
+}
+
+// targetPattern matches target hosts: a host name, *.domain for its
+// subdomains, an IP address or CIDR, or "*" for any
+type targetPattern struct {
+	text    string
+	any     bool
+	host    string     // Exact host name
+	suffix  string     // ".example.com" for *.example.com
+	network *net.IPNet // IP address or CIDR
+}
+
+func parseTargetPattern(text string) (targetPattern, error) {
+	pattern := targetPattern{text: text}
+	value := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(text)), ".")
+
+	switch {
+	case value == "*":
+		pattern.any = true
+	case strings.Contains(value, "/"):
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return pattern, fmt.Errorf("invalid CIDR %q", text)
+		}
+		pattern.network = network
+	case net.ParseIP(value) != nil:
+		ip := net.ParseIP(value)
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		pattern.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	case strings.HasPrefix(value, "*.") && validPatternHost(value[2:]):
+		pattern.suffix = value[1:]
+	case validPatternHost(value):
+		pattern.host = value
+	default:
+		return pattern, fmt.Errorf("invalid target %q: expected a host, *.domain, IP address, CIDR or \"*\"", text)
+	}
+	return pattern, nil
+}
+
+// validPatternHost reports whether a name is a host without a port or wildcard
+func validPatternHost(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "*:/ ")
+}
+
+// matches reports whether a host, or any of its addresses, matches
+func (p targetPattern) matches(host string, ips []net.IP) bool {
+	switch {
+	case p.any:
+		return true
+	case p.network != nil:
+		for _, ip := range ips {
+			if p.network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	case p.suffix != "":
+		return strings.HasSuffix(host, p.suffix)
+	default:
+		return host == p.host
+	}
+}
+
+// safetyPolicy is a SafetyConfig compiled for checking. A nil policy allows
+// everything.
+type safetyPolicy struct {
+	config SafetyConfig
+	allow  []targetPattern
+	deny   []targetPattern
+	limits []targetLimit
+}
+
+type targetLimit struct {
+	TargetLimit
+	pattern         targetPattern
+	maxDuration     time.Duration
+	confirmDuration time.Duration
+}
+
+// newSafetyPolicy compiles a safety configuration; one without entries
+// compiles to nil
+func newSafetyPolicy(config *SafetyConfig) (*safetyPolicy, error) {
+	if config == nil || (len(config.AllowTargets) == 0 && len(config.DenyTargets) == 0 && len(config.Limits) == 0) {
+		return nil, nil
+	}
+
+	policy := &safetyPolicy{config: *config}
+	for _, text := range config.AllowTargets {
+		pattern, err := parseTargetPattern(text)
+		if err != nil {
+			return nil, fmt.Errorf("allow_targets: %w", err)
+		}
+		policy.allow = append(policy.allow, pattern)
+	}
+	for _, text := range config.DenyTargets {
+		pattern, err := parseTargetPattern(text)
+		if err != nil {
+			return nil, fmt.Errorf("deny_targets: %w", err)
+		}
+		policy.deny = append(policy.deny, pattern)
+	}
+
+	for i, limit := range config.Limits {
+		field := fmt.Sprintf("limits[%d]", i)
+		pattern, err := parseTargetPattern(limit.Host)
+		if err != nil {
+			return nil, fmt.Errorf("%s.host: %w", field, err)
+		}
+		if limit.MaxConcurrency < 0 || limit.ConfirmConcurrency < 0 || limit.MaxRPS < 0 || limit.ConfirmRPS < 0 {
+			return nil, fmt.Errorf("%s: limits cannot be negative", field)
+		}
+
+		compiled := targetLimit{TargetLimit: limit, pattern: pattern}
+		if compiled.maxDuration, err = parseLimitDuration(limit.MaxDuration); err != nil {
+			return nil, fmt.Errorf("%s.max_duration: %w", field, err)
+		}
+		if compiled.confirmDuration, err = parseLimitDuration(limit.ConfirmDuration); err != nil {
+			return nil, fmt.Errorf("%s.confirm_duration: %w", field, err)
+		}
+		policy.limits = append(policy.limits, compiled)
+	}
+	return policy, nil
+}
+
+func parseLimitDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+// needsAddresses reports whether any entry matches the addresses targets
+// resolve to rather than their names
+func (p *safetyPolicy) needsAddresses() bool {
+	if p == nil {
+		return false
+	}
+	for _, patterns := range [][]targetPattern{p.allow, p.deny} {
+		for _, pattern := range patterns {
+			if pattern.network != nil {
+				return true
+			}
+		}
+	}
+	for _, limit := range p.limits {
+		if limit.pattern.network != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTarget checks that a host may be targeted. ips are the addresses it
+// resolved to: nil when not looked up, which leaves address entries to
+// agents as they connect, and empty when it could not be resolved.
+func (p *safetyPolicy) checkTarget(host string, ips []net.IP) *SafetyViolation {
+	if p == nil {
+		return nil
+	}
+	host, ips = targetIdentity(host, ips)
+
+	for _, pattern := range p.deny {
+		if pattern.matches(host, ips) {
+			return &SafetyViolation{
+				Host:    host,
+				Rule:    "deny_targets",
+				Message: fmt.Sprintf("%s is denied by deny_targets entry %q", describeTarget(host, ips), pattern.text),
+			}
+		}
+	}
+	if len(p.allow) > 0 && !p.allowed(host, ips) {
+		message := fmt.Sprintf("%s is not in allow_targets", describeTarget(host, ips))
+		if ips != nil && len(ips) == 0 {
+			message += " and could not be resolved to check its addresses"
+		}
+		return &SafetyViolation{Host: host, Rule: "allow_targets", Message: message}
+	}
+	return nil
+}
+
+// allowed reports whether a host matches allow_targets by name, or all of
+// its addresses do. Addresses not looked up pass when address entries exist.
+func (p *safetyPolicy) allowed(host string, ips []net.IP) bool {
+	var networks []targetPattern
+	for _, pattern := range p.allow {
+		if pattern.network != nil {
+			networks = append(networks, pattern)
+		} else if pattern.matches(host, nil) {
+			return true
+		}
+	}
+	if len(networks) == 0 {
+		return false
+	}
+	if ips == nil {
+		return true
+	}
+	if len(ips) == 0 {
+		return false
+	}
+
+	for _, ip := range ips {
+		inside := false
+		for _, network := range networks {
+			if network.matches(host, []net.IP{ip}) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPlan checks a plan's targets and load against the policy. hosts are
+// the plan's target hosts; lookup, which may be nil, resolves them when
+// address entries need it. Confirmation limits are not checked for
+// confirmed runs.
+func (p *safetyPolicy) checkPlan(plan TestPlan, hosts []string, confirmed bool, lookup func(string) []net.IP) SafetyViolations {
+	if p == nil {
+		return nil
+	}
+
+	var violations SafetyViolations
+	load := loadOfPlan(plan)
+	for _, host := range hosts {
+		var ips []net.IP
+		if lookup != nil && p.needsAddresses() {
+			ips = lookup(host)
+		}
+		if violation := p.checkTarget(host, ips); violation != nil {
+			violations = append(violations, *violation)
+			continue
+		}
+
+		host, ips = targetIdentity(host, ips)
+		for _, limit := range p.limits {
+			if limit.pattern.matches(host, ips) {
+				violations = append(violations, limit.check(host, load, confirmed)...)
+			}
+		}
+	}
+	return violations
+}
+
+// planLoad is the most load a plan can put on one target. Each target is
+// assumed to take all of it, as a plan may send most requests to one host.
+type planLoad struct {
+	concurrency int     // 0 when agents use their own
+	rps         float64 // 0 when unlimited
+	duration    time.Duration
+}
+
+func loadOfPlan(plan TestPlan) planLoad {
+	load := planLoad{concurrency: plan.Concurrency, rps: plan.TargetRPS}
+	if plan.RampUpStrategy != nil {
+		for _, phase := range plan.RampUpStrategy.Phases {
+			load.concurrency = max(load.concurrency, phase.Concurrency)
+		}
+	}
+	load.duration, _ = time.ParseDuration(plan.Duration)
+	return load
+}
+
+// check compares a plan's load on one host with the limit. A confirmation
+// limit is not reported for a quantity already over its maximum.
+func (l targetLimit) check(host string, load planLoad, confirmed bool) SafetyViolations {
+	formatCount := func(value float64) string { return strconv.FormatFloat(value, 'f', -1, 64) }
+	formatDuration := func(value float64) string { return time.Duration(value).String() }
+
+	checks := []struct {
+		rule      string
+		quantity  string // Plan setting compared
+		value     float64
+		limit     float64
+		unbounded string // Why the plan's value is unbounded, if it is
+		format    func(float64) string
+	}{
+		{"max_concurrency", "concurrency", float64(load.concurrency), float64(l.MaxConcurrency), unsetConcurrency(load), formatCount},
+		{"confirm_concurrency", "concurrency", float64(load.concurrency), float64(l.ConfirmConcurrency), unsetConcurrency(load), formatCount},
+		{"max_rps", "target_rps", load.rps, l.MaxRPS, unsetRPS(load), formatCount},
+		{"confirm_rps", "target_rps", load.rps, l.ConfirmRPS, unsetRPS(load), formatCount},
+		{"max_duration", "duration", float64(load.duration), float64(l.maxDuration), "", formatDuration},
+		{"confirm_duration", "duration", float64(load.duration), float64(l.confirmDuration), "", formatDuration},
+	}
+
+	var violations SafetyViolations
+	exceeded := make(map[string]bool)
+	for _, check := range checks {
+		confirmable := strings.HasPrefix(check.rule, "confirm_")
+		if check.limit == 0 || exceeded[check.quantity] || (confirmable && confirmed) {
+			continue
+		}
+		if check.unbounded == "" && check.value <= check.limit {
+			continue
+		}
+		exceeded[check.quantity] = true
+
+		var message string
+		if check.unbounded != "" {
+			message = fmt.Sprintf("%s for %s is not set, so %s; %s is %s",
+				check.quantity, host, check.unbounded, check.rule, check.format(check.limit))
+		} else {
+			message = fmt.Sprintf("%s %s for %s exceeds %s %s",
+				check.quantity, check.format(check.value), host, check.rule, check.format(check.limit))
+		}
+		message += fmt.Sprintf(" (limit for %q)", l.Host)
+		if confirmable {
+			message += "; create the run with confirm: true to allow it"
+		}
+
+		violations = append(violations, SafetyViolation{Host: host, Rule: check.rule, Message: message, Confirmable: confirmable})
+	}
+	return violations
+}
+
+func unsetConcurrency(load planLoad) string {
+	if load.concurrency == 0 {
+		return "agents use their own"
+	}
+	return ""
+}
+
+func unsetRPS(load planLoad) string {
+	if load.rps == 0 {
+		return "the request rate is unlimited"
+	}
+	return ""
+}
+
+// targetIdentity normalizes a host name; an IP literal is its own address
+func targetIdentity(host string, ips []net.IP) (string, []net.IP) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return host, []net.IP{ip}
+	}
+	return host, ips
+}
+
+// describeTarget names a host with the addresses it resolved to
+func describeTarget(host string, ips []net.IP) string {
+	if len(ips) == 0 || net.ParseIP(host) != nil {
+		return host
+	}
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = ip.String()
+	}
+	return fmt.Sprintf("%s (%s)", host, strings.Join(addresses, ", "))
+}
+
+// targetHost returns the host an endpoint URL connects to. Hosts built from
+// templates or secrets are only known once resolved.
+func targetHost(rawURL string) (string, bool) {
+	authority := rawURL
+	if _, rest, found := strings.Cut(authority, "://"); found {
+		authority = rest
+	}
+	if end := strings.IndexAny(authority, "/?#"); end >= 0 {
+		authority = authority[:end]
+	}
+	if strings.Contains(authority, "{{") || strings.Contains(authority, "${") {
+		return "", false
+	}
+
+	parsed, err := url.Parse("http://" + authority)
+	if err != nil || parsed.Hostname() == "" {
+		return "", false
+	}
+	return strings.ToLower(parsed.Hostname()), true
+}
+
+// planTargetHosts returns the hosts a plan sends load to, in every region
+func planTargetHosts(plan TestPlan) []string {
+	plans := []TestPlan{plan}
+	for _, region := range plan.Regions {
+		if regional, err := plan.ForRegion(region.Name); err == nil {
+			plans = append(plans, regional)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, regional := range plans {
+		for _, endpoint := range regional.Endpoints {
+			if host, ok := targetHost(endpoint.URL); ok && !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// lookupTargetIPs resolves a target host, returning no addresses when it cannot
+func lookupTargetIPs(host string) []net.IP {
+	ctx, cancel := context.WithTimeout(context.Background(), safetyLookupTimeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return []net.IP{}
+	}
+	return ips
+}
+
+// dialChecked connects to an address once check allows its host and the
+// addresses it resolves to. It dials the addresses it checked, so the host
+// cannot resolve elsewhere in between. A refusal is a *SafetyViolation.
+func dialChecked(ctx context.Context, network, address string, check func(host string, ips []net.IP) *SafetyViolation) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	if violation := check(host, ips); violation != nil {
+		return nil, violation
+	}
+
+	var conn net.Conn
+	for _, ip := range ips {
+		if conn, err = targetDialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// checkConnectionTarget refuses a connection test to a host the safety
+// policy does not allow, with 403. Resolves the host, so call it without
+// holding c.mu.
+func (c *Coordinator) checkConnectionTarget(ctx *gin.Context, rawURL string) bool {
+	host, ok := targetHost(rawURL)
+	if c.safety == nil || !ok {
+		return true // Hosts only known once connecting are checked by the dialer
+	}
+
+	var ips []net.IP
+	if c.safety.needsAddresses() {
+		ips = lookupTargetIPs(host)
+	}
+	violation := c.safety.checkTarget(host, ips)
+	if violation == nil {
+		return true
+	}
+
+	LogWarn("Refused connection test: %s", violation.Message)
+	auditSummary(ctx, "connection test refused by the safety policy: %s", violation.Message)
+	ctx.JSON(http.StatusForbidden, gin.H{"error": "Target violates the safety policy", "details": SafetyViolations{*violation}})
+	return false
+}
+
+// runSafety returns the safety policy agents enforce for runs, nil without one
+func (c *Coordinator) runSafety() *SafetyConfig {
+	if c.safety == nil {
+		return nil
+	}
+	return &c.safety.config
+}
+
+// checkSafety checks a plan against the safety policy and refuses the
+// request when it breaks it: 409 when confirming the run would allow it, 403
+// otherwise. Resolves targets, so call it without holding c.mu.
+func (c *Coordinator) checkSafety(ctx *gin.Context, plan TestPlan, confirmed bool) bool {
+	violations := c.safety.checkPlan(plan, planTargetHosts(plan), confirmed, lookupTargetIPs)
+	if len(violations) == 0 {
+		return true
+	}
+
+	LogWarn("Refused test plan %s under the safety policy: %v", plan.Name, violations)
+	auditSummary(ctx, "refused by the safety policy: %v", violations)
+	if violations.needConfirmation() {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "Test plan needs confirm: true under the safety policy", "details": violations})
+	} else {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Test plan violates the safety policy", "details": violations})
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// targetGuard holds the safety policies an agent checks each connection to a
+// target against: its own and the one sent with the current run
+type targetGuard struct {
+	local *safetyPolicy
+
+	mu        sync.RWMutex
+	testRunID string
+	run       *safetyPolicy
+	reported  map[string]bool // Hosts already reported blocked during the run
+}
+
+func newTargetGuard(local *safetyPolicy) *targetGuard {
+	return &targetGuard{local: local, reported: make(map[string]bool)}
+}
+
+// setRun puts a run's policy in force in place of the previous run's
+func (g *targetGuard) setRun(testRunID string, policy *safetyPolicy) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if testRunID != g.testRunID {
+		g.reported = make(map[string]bool)
+	}
+	g.testRunID = testRunID
+	g.run = policy
+}
+
+func (g *targetGuard) policies() []*safetyPolicy {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return []*safetyPolicy{g.local, g.run}
+}
+
+// checkTarget checks a host and its addresses against both policies
+func (g *targetGuard) checkTarget(host string, ips []net.IP) *SafetyViolation {
+	for _, policy := range g.policies() {
+		if violation := policy.checkTarget(host, ips); violation != nil {
+			return violation
+		}
+	}
+	return nil
+}
+
+// firstReport reports whether a blocked host has not been reported during the run
+func (g *targetGuard) firstReport(host string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.reported[host] {
+		return false
+	}
+	g.reported[host] = true
+	return true
+}
+
+// applySafety puts a run's safety policy in force and checks the plan, as
+// resolved for the agent's region, against it and the agent's own policy
+func (a *Agent) applySafety(command TestStartCommand, plan TestPlan) error {
+	policy, err := newSafetyPolicy(command.Safety)
+	if err != nil {
+		return fmt.Errorf("invalid safety policy: %w", err)
+	}
+	a.targets.setRun(command.TestRunID, policy)
+
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, endpoint := range plan.Endpoints {
+		resolved, err := resolveEndpoint(endpoint)
+		if err != nil {
+			continue // Reported where the endpoint is used
+		}
+		if host, ok := targetHost(resolved.URL); ok && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+
+	var violations SafetyViolations
+	for _, policy := range a.targets.policies() {
+		violations = append(violations, policy.checkPlan(plan, hosts, command.Confirmed, lookupTargetIPs)...)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("refused by the safety policy: %w", violations)
+	}
+	return nil
+}
+
+// dialTarget connects to a target once the safety policies allow it
+func (a *Agent) dialTarget(ctx context.Context, network, address string) (net.Conn, error) {
+	policies := a.targets.policies()
+	if policies[0] == nil && policies[1] == nil {
+		return targetDialer.DialContext(ctx, network, address)
+	}
+
+	conn, err := dialChecked(ctx, network, address, a.targets.checkTarget)
+	var violation *SafetyViolation
+	if errors.As(err, &violation) {
+		a.reportBlockedTarget(violation)
+	}
+	return conn, err
+}
+
+// reportBlockedTarget logs a blocked host and tells the coordinator, once per run
+func (a *Agent) reportBlockedTarget(violation *SafetyViolation) {
+	if !a.targets.firstReport(violation.Host) {
+		return
+	}
+	LogWarn("Blocked connection: %s", violation.Message)
+	a.sendExecutionUpdate(TestRunEventTargetBlocked, violation.Message)
+}
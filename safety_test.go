@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testSafetyPolicy compiles a policy or fails the test
+func testSafetyPolicy(t *testing.T, config SafetyConfig) *safetyPolicy {
+	t.Helper()
+	policy, err := newSafetyPolicy(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+// ips parses addresses; no arguments gives an empty, not nil, list
+func ips(addresses ...string) []net.IP {
+	list := []net.IP{}
+	for _, address := range addresses {
+		list = append(list, net.ParseIP(address))
+	}
+	return list
+}
+
+func TestParseTargetPattern(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		wantErr   bool
+		matches   []string // Hosts that match, with "host=ip" to give an address
+		unmatched []string
+	}{
+		{pattern: "*", matches: []string{"example.com", "10.0.0.1"}},
+		{pattern: "api.example.com", matches: []string{"api.example.com"}, unmatched: []string{"example.com", "x.api.example.com"}},
+		{pattern: "API.Example.com.", matches: []string{"api.example.com"}},
+		{pattern: "*.example.com", matches: []string{"api.example.com", "a.b.example.com"}, unmatched: []string{"example.com", "badexample.com"}},
+		{pattern: "10.0.0.0/8", matches: []string{"10.1.2.3", "db.internal=10.9.9.9"}, unmatched: []string{"11.0.0.1", "db.internal=11.0.0.1", "db.internal"}},
+		{pattern: "192.168.1.10", matches: []string{"192.168.1.10", "host=192.168.1.10"}, unmatched: []string{"192.168.1.11"}},
+		{pattern: "::1", matches: []string{"::1"}, unmatched: []string{"::2", "127.0.0.1"}},
+		{pattern: "fd00::/8", matches: []string{"fd12::1"}, unmatched: []string{"fe80::1"}},
+		{pattern: "10.0.0.0/33", wantErr: true},
+		{pattern: "not/a/cidr", wantErr: true},
+		{pattern: "api.*.com", wantErr: true},
+		{pattern: "*.*.com", wantErr: true},
+		{pattern: "host:8080", wantErr: true},
+		{pattern: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			pattern, err := parseTargetPattern(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTargetPattern(%q) error = %v, want error %t", tt.pattern, err, tt.wantErr)
+			}
+
+			check := func(target string, want bool) {
+				host, address, _ := strings.Cut(target, "=")
+				var resolved []net.IP
+				if address != "" {
+					resolved = ips(address)
+				}
+				host, resolved = targetIdentity(host, resolved)
+				if got := pattern.matches(host, resolved); got != want {
+					t.Errorf("%q matches %s = %t, want %t", tt.pattern, target, got, want)
+				}
+			}
+			for _, target := range tt.matches {
+				check(target, true)
+			}
+			for _, target := range tt.unmatched {
+				check(target, false)
+			}
+		})
+	}
+}
+
+func TestSafetyPolicyCheckTarget(t *testing.T) {
+	names := testSafetyPolicy(t, SafetyConfig{
+		AllowTargets: []string{"*.example.com", "staging.internal"},
+		DenyTargets:  []string{"prod.example.com"},
+	})
+	networks := testSafetyPolicy(t, SafetyConfig{
+		AllowTargets: []string{"10.0.0.0/8", "api.partner.com"},
+		DenyTargets:  []string{"10.66.0.0/16"},
+	})
+	anything := testSafetyPolicy(t, SafetyConfig{
+		AllowTargets: []string{"*"},
+		DenyTargets:  []string{"169.254.0.0/16"},
+	})
+	denyOnly := testSafetyPolicy(t, SafetyConfig{DenyTargets: []string{"*.corp"}})
+
+	tests := []struct {
+		name     string
+		policy   *safetyPolicy
+		host     string
+		ips      []net.IP
+		wantRule string // Empty when allowed
+	}{
+		{"no policy", nil, "anything.com", nil, ""},
+		{"allowed by wildcard", names, "api.example.com", nil, ""},
+		{"allowed by name", names, "staging.internal", nil, ""},
+		{"name is normalized", names, "API.Example.COM.", nil, ""},
+		{"deny wins over allow", names, "prod.example.com", nil, "deny_targets"},
+		{"wildcard excludes the bare domain", names, "example.com", nil, "allow_targets"},
+		{"not allowed", names, "other.org", nil, "allow_targets"},
+		{"all addresses in an allowed CIDR", networks, "db.local", ips("10.1.1.1", "10.2.2.2"), ""},
+		{"one address outside the allowed CIDR", networks, "db.local", ips("10.1.1.1", "8.8.8.8"), "allow_targets"},
+		{"address in a denied CIDR inside an allowed one", networks, "db.local", ips("10.1.1.1", "10.66.1.1"), "deny_targets"},
+		{"IP literal in an allowed CIDR", networks, "10.3.3.3", nil, ""},
+		{"IP literal in a denied CIDR", networks, "10.66.0.5", nil, "deny_targets"},
+		{"unresolved host is not allowed by CIDR", networks, "db.local", ips(), "allow_targets"},
+		{"addresses not looked up pass to the dialer", networks, "db.local", nil, ""},
+		{"allowed name with addresses outside the CIDRs", networks, "api.partner.com", ips("8.8.8.8"), ""},
+		{"star allows any host", anything, "whatever.io", ips("8.8.8.8"), ""},
+		{"deny wins over star", anything, "metadata", ips("169.254.169.254"), "deny_targets"},
+		{"deny only allows the rest", denyOnly, "site.com", nil, ""},
+		{"deny only", denyOnly, "wiki.corp", nil, "deny_targets"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violation := tt.policy.checkTarget(tt.host, tt.ips)
+			switch {
+			case tt.wantRule == "" && violation != nil:
+				t.Errorf("checkTarget refused: %s", violation.Message)
+			case tt.wantRule != "" && violation == nil:
+				t.Errorf("checkTarget allowed, want a %s violation", tt.wantRule)
+			case violation != nil && violation.Rule != tt.wantRule:
+				t.Errorf("violation rule = %s, want %s (%s)", violation.Rule, tt.wantRule, violation.Message)
+			}
+		})
+	}
+
+	if violation := networks.checkTarget("db.local", ips()); violation == nil || !strings.Contains(violation.Message, "could not be resolved") {
+		t.Errorf("unresolved host violation = %v, want it to say the host could not be resolved", violation)
+	}
+}
+
+func TestSafetyPolicyCheckPlan(t *testing.T) {
+	policy := testSafetyPolicy(t, SafetyConfig{
+		AllowTargets: []string{"*.example.com"},
+		Limits: []TargetLimit{
+			{Host: "*", MaxConcurrency: 100, ConfirmConcurrency: 20, MaxDuration: "1h", ConfirmDuration: "10m"},
+			{Host: "slow.example.com", MaxRPS: 50},
+		},
+	})
+
+	tests := []struct {
+		name        string
+		plan        TestPlan
+		hosts       []string
+		confirmed   bool
+		wantRules   []string
+		wantConfirm bool
+	}{
+		{
+			name:  "within limits",
+			plan:  TestPlan{Concurrency: 10, Duration: "1m"},
+			hosts: []string{"api.example.com"},
+		},
+		{
+			name:        "over a confirmation limit",
+			plan:        TestPlan{Concurrency: 50, Duration: "1m"},
+			hosts:       []string{"api.example.com"},
+			wantRules:   []string{"confirm_concurrency"},
+			wantConfirm: true,
+		},
+		{
+			name:      "confirmed",
+			plan:      TestPlan{Concurrency: 50, Duration: "20m"},
+			hosts:     []string{"api.example.com"},
+			confirmed: true,
+		},
+		{
+			name:      "over a maximum is not confirmable",
+			plan:      TestPlan{Concurrency: 150, Duration: "1m"},
+			hosts:     []string{"api.example.com"},
+			confirmed: true,
+			wantRules: []string{"max_concurrency"},
+		},
+		{
+			name:      "maximum reported instead of the confirmation limit",
+			plan:      TestPlan{Concurrency: 150, Duration: "2h"},
+			hosts:     []string{"api.example.com"},
+			wantRules: []string{"max_concurrency", "max_duration"},
+		},
+		{
+			name:      "unset concurrency is unbounded",
+			plan:      TestPlan{Duration: "1m"},
+			hosts:     []string{"api.example.com"},
+			wantRules: []string{"max_concurrency"},
+		},
+		{
+			name:      "ramp-up phases count",
+			plan:      TestPlan{Concurrency: 10, Duration: "1m", RampUpStrategy: &RampUpStrategy{Phases: []RampPhase{{Concurrency: 120}}}},
+			hosts:     []string{"api.example.com"},
+			confirmed: true,
+			wantRules: []string{"max_concurrency"},
+		},
+		{
+			name:      "host limits add up",
+			plan:      TestPlan{Concurrency: 10, Duration: "1m"},
+			hosts:     []string{"slow.example.com"},
+			wantRules: []string{"max_rps"},
+		},
+		{
+			name:      "limits are not checked for refused hosts",
+			plan:      TestPlan{Concurrency: 150, Duration: "1m"},
+			hosts:     []string{"other.org"},
+			wantRules: []string{"allow_targets"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := policy.checkPlan(tt.plan, tt.hosts, tt.confirmed, nil)
+			var rules []string
+			for _, violation := range violations {
+				rules = append(rules, violation.Rule)
+			}
+			if strings.Join(rules, ",") != strings.Join(tt.wantRules, ",") {
+				t.Errorf("violations = %v, want rules %v (%v)", rules, tt.wantRules, violations)
+			}
+			if got := violations.needConfirmation(); got != tt.wantConfirm {
+				t.Errorf("needConfirmation = %t, want %t", got, tt.wantConfirm)
+			}
+		})
+	}
+}
+
+func TestSafetyPolicyCheckPlanLookup(t *testing.T) {
+	var looked []string
+	lookup := func(host string) []net.IP {
+		looked = append(looked, host)
+		return ips("10.0.0.1")
+	}
+
+	// Names only: no lookups
+	names := testSafetyPolicy(t, SafetyConfig{AllowTargets: []string{"api.example.com"}})
+	names.checkPlan(TestPlan{}, []string{"api.example.com"}, false, lookup)
+	if len(looked) != 0 {
+		t.Errorf("looked up %v for a policy without address entries", looked)
+	}
+
+	networks := testSafetyPolicy(t, SafetyConfig{DenyTargets: []string{"10.0.0.0/8"}})
+	violations := networks.checkPlan(TestPlan{}, []string{"db.local"}, false, lookup)
+	if len(looked) != 1 || len(violations) != 1 || violations[0].Rule != "deny_targets" {
+		t.Errorf("lookups %v and violations %v, want db.local denied by its address", looked, violations)
+	}
+}
+
+func TestTargetGuard(t *testing.T) {
+	local := testSafetyPolicy(t, SafetyConfig{DenyTargets: []string{"payments.example.com"}})
+	run := testSafetyPolicy(t, SafetyConfig{AllowTargets: []string{"*.example.com"}})
+	guard := newTargetGuard(local)
+
+	if violation := guard.checkTarget("other.org", nil); violation != nil {
+		t.Errorf("without a run policy: %s", violation.Message)
+	}
+	if violation := guard.checkTarget("payments.example.com", nil); violation == nil || violation.Rule != "deny_targets" {
+		t.Errorf("agent's own deny list not applied: %v", violation)
+	}
+
+	guard.setRun("r1", run)
+	if violation := guard.checkTarget("other.org", nil); violation == nil || violation.Rule != "allow_targets" {
+		t.Errorf("run's allow list not applied: %v", violation)
+	}
+	if violation := guard.checkTarget("payments.example.com", nil); violation == nil || violation.Rule != "deny_targets" {
+		t.Errorf("run policy overrode the agent's deny list: %v", violation)
+	}
+	if violation := guard.checkTarget("api.example.com", nil); violation != nil {
+		t.Errorf("allowed by both: %s", violation.Message)
+	}
+
+	if !guard.firstReport("other.org") || guard.firstReport("other.org") {
+		t.Error("a blocked host should be reported once per run")
+	}
+	guard.setRun("r1", run)
+	if guard.firstReport("other.org") {
+		t.Error("the same run reported a blocked host again")
+	}
+	guard.setRun("r2", nil)
+	if !guard.firstReport("other.org") {
+		t.Error("a new run did not report the blocked host")
+	}
+	if violation := guard.checkTarget("other.org", nil); violation != nil {
+		t.Errorf("previous run's policy still applied: %s", violation.Message)
+	}
+}
+
+func TestDialChecked(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	allowed := testSafetyPolicy(t, SafetyConfig{AllowTargets: []string{"127.0.0.0/8"}})
+	conn, err := dialChecked(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", port), allowed.checkTarget)
+	if err != nil {
+		t.Fatalf("allowed dial: %v", err)
+	}
+	conn.Close()
+
+	denied := testSafetyPolicy(t, SafetyConfig{DenyTargets: []string{"127.0.0.1"}})
+	_, err = dialChecked(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", port), denied.checkTarget)
+	var violation *SafetyViolation
+	if !errors.As(err, &violation) || violation.Rule != "deny_targets" {
+		t.Errorf("denied dial error = %v, want a deny_targets violation", err)
+	}
+}
+
+func TestHandleTestConnectionSafety(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(target.URL, "http://"))
+	byName := "http://localhost:" + port
+	byAddress := "http://127.0.0.1:" + port
+
+	c := &Coordinator{safety: testSafetyPolicy(t, SafetyConfig{AllowTargets: []string{"localhost"}})}
+	router := gin.New()
+	router.POST("/test-connection", c.handleTestConnection)
+
+	tests := []struct {
+		name        string
+		url         string
+		wantStatus  int
+		wantSuccess bool
+		wantError   string
+	}{
+		{"allowed target", byName + "/", http.StatusOK, true, ""},
+		{"target outside the policy", byAddress + "/", http.StatusForbidden, false, ""},
+		{"redirect outside the policy", byName + "/redirect?to=" + url.QueryEscape(byAddress+"/"), http.StatusOK, false, "not in allow_targets"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(TestConnectionRequest{URL: tt.url})
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/test-connection", bytes.NewReader(body)))
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !strings.Contains(recorder.Body.String(), "allow_targets") {
+					t.Errorf("refusal does not name the rule: %s", recorder.Body)
+				}
+				return
+			}
+
+			var result TestConnectionResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Success != tt.wantSuccess || !strings.Contains(result.Error, tt.wantError) {
+				t.Errorf("result = %+v, want success %t and an error containing %q", result, tt.wantSuccess, tt.wantError)
+			}
+		})
+	}
+}
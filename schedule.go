@@ -0,0 +1,436 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // Schedules name IANA time zones, which hosts may not ship
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Overlap policies for a schedule firing while its previous run is still active
+const (
+	ScheduleOverlapSkip  = "skip"  // Do not start another run
+	ScheduleOverlapQueue = "queue" // Queue the new run behind the previous one
+)
+
+// Schedule outcomes recorded on each fire
+const (
+	ScheduleOutcomeStarted = "started"
+	ScheduleOutcomeQueued  = "queued"
+	ScheduleOutcomeSkipped = "skipped"
+)
+
+// schedulerInterval is how often the scheduler checks for due schedules
+const schedulerInterval = time.Second
+
+// Schedule creates and starts test runs from a plan on a cron schedule
+type Schedule struct {
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	TestPlan     TestPlan               `json:"test_plan"`
+	Cron         string                 `json:"cron"`
+	TimeZone     string                 `json:"time_zone"`
+	MinAgents    int                    `json:"min_agents"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	Selector     *AgentSelector         `json:"selector,omitempty"`
+	Overlap      string                 `json:"overlap"`                 // skip or queue
+	Priority     int                    `json:"priority,omitempty"`      // Queue priority of created runs
+	QueueTimeout string                 `json:"queue_timeout,omitempty"` // Cancel a created run not started within this time
+	Enabled      bool                   `json:"enabled"`
+	Confirmed    bool                   `json:"confirmed,omitempty"` // Created runs are confirmed for the safety policy
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+
+	LastFireAt    *time.Time `json:"last_fire_at,omitempty"`
+	NextFireAt    *time.Time `json:"next_fire_at,omitempty"`
+	LastTestRunID string     `json:"last_test_run_id,omitempty"`
+	LastOutcome   string     `json:"last_outcome,omitempty"` // started, queued or skipped
+	LastMessage   string     `json:"last_message,omitempty"`
+
+	cron     *CronSchedule
+	location *time.Location
+}
+
+// ScheduleRequest creates or replaces a schedule
+type ScheduleRequest struct {
+	Name         string                 `json:"name"`
+	TestPlan     TestPlan               `json:"test_plan"`
+	Cron         string                 `json:"cron"`
+	TimeZone     string                 `json:"time_zone,omitempty"`
+	MinAgents    int                    `json:"min_agents"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	Selector     *AgentSelector         `json:"selector,omitempty"`
+	Overlap      string                 `json:"overlap,omitempty"`
+	Priority     int                    `json:"priority,omitempty"`
+	QueueTimeout string                 `json:"queue_timeout,omitempty"`
+	Enabled      *bool                  `json:"enabled,omitempty"` // Defaults to true
+	Confirm      bool                   `json:"confirm,omitempty"` // Allow load above the safety policy's confirmation limits
+}
+
+// validate checks the request and fills in defaults
+func (req *ScheduleRequest) validate(defaultMinAgents int) PlanValidationErrors {
+	var errs PlanValidationErrors
+
+	if strings.TrimSpace(req.Name) == "" {
+		errs.add("name", "is required")
+	}
+	if req.MinAgents == 0 {
+		req.MinAgents = defaultMinAgents
+	}
+	if req.TimeZone == "" {
+		req.TimeZone = "UTC"
+	}
+	if req.Overlap == "" {
+		req.Overlap = ScheduleOverlapSkip
+	}
+
+	if _, err := ParseCron(req.Cron); err != nil {
+		errs.add("cron", "%v", err)
+	}
+	if _, err := time.LoadLocation(req.TimeZone); err != nil {
+		errs.add("time_zone", "unknown time zone %q", req.TimeZone)
+	}
+	if req.Overlap != ScheduleOverlapSkip && req.Overlap != ScheduleOverlapQueue {
+		errs.add("overlap", "must be %s or %s", ScheduleOverlapSkip, ScheduleOverlapQueue)
+	}
+	if req.QueueTimeout != "" {
+		if d, err := time.ParseDuration(req.QueueTimeout); err != nil || d <= 0 {
+			errs.add("queue_timeout", "must be a positive duration")
+		}
+	}
+
+	for _, err := range ValidateTestPlan(req.TestPlan, req.MinAgents) {
+		errs.add("test_plan."+err.Field, "%s", err.Message)
+	}
+	errs = append(errs, req.Selector.Validate(req.MinAgents)...)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// apply copies the request onto the schedule and computes its next fire time
+func (s *Schedule) apply(req ScheduleRequest) error {
+	s.Name = req.Name
+	s.TestPlan = req.TestPlan
+	s.Cron = req.Cron
+	s.TimeZone = req.TimeZone
+	s.MinAgents = req.MinAgents
+	s.Parameters = req.Parameters
+	s.Selector = req.Selector
+	s.Overlap = req.Overlap
+	s.Priority = req.Priority
+	s.QueueTimeout = req.QueueTimeout
+	s.Enabled = req.Enabled == nil || *req.Enabled
+	s.Confirmed = req.Confirm
+	s.UpdatedAt = time.Now()
+
+	if err := s.compile(); err != nil {
+		return err
+	}
+	s.advance(time.Now())
+	return nil
+}
+
+// compile parses the schedule's cron expression and time zone
+func (s *Schedule) compile() error {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return fmt.Errorf("invalid cron expression: %w", err)
+	}
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return fmt.Errorf("invalid time zone: %w", err)
+	}
+	s.cron = cron
+	s.location = location
+	return nil
+}
+
+// advance sets the next fire time after now, or clears it for disabled schedules
+func (s *Schedule) advance(now time.Time) {
+	s.NextFireAt = nil
+	if !s.Enabled {
+		return
+	}
+	if next := s.cron.Next(now.In(s.location)); !next.IsZero() {
+		s.NextFireAt = &next
+	}
+}
+
+// due reports whether the schedule should fire at now
+func (s *Schedule) due(now time.Time) bool {
+	return s.Enabled && s.NextFireAt != nil && !now.Before(*s.NextFireAt)
+}
+
+// startScheduler fires due schedules until the coordinator exits
+func (c *Coordinator) startScheduler() {
+	go func() {
+		ticker := time.NewTicker(schedulerInterval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			c.fireDueSchedules(now)
+		}
+	}()
+
+	LogInfo("Scheduler started")
+}
+
+func (c *Coordinator) fireDueSchedules(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, schedule := range c.schedules {
+		if schedule.due(now) {
+			c.fireSchedule(schedule, now)
+		}
+	}
+}
+
+// fireSchedule creates and starts one run of the schedule. Caller must hold c.mu.
+func (c *Coordinator) fireSchedule(schedule *Schedule, now time.Time) {
+	schedule.LastFireAt = &now
+	schedule.advance(now)
+
+	// The previous run may still be going
+	previous, exists := c.testRuns[schedule.LastTestRunID]
+	if exists && previous.IsActive() && schedule.Overlap == ScheduleOverlapSkip {
+		schedule.LastOutcome = ScheduleOutcomeSkipped
+		schedule.LastMessage = fmt.Sprintf("previous run %s is still %s", previous.ID, previous.Status)
+		LogWarn("Schedule %s skipped: %s", schedule.Name, schedule.LastMessage)
+		c.saveSchedule(schedule)
+		return
+	}
+
+	// The policy may have changed since the schedule was saved. Targets are
+	// not resolved here, with c.mu held; agents check addresses on connecting.
+	if violations := c.safety.checkPlan(schedule.TestPlan, planTargetHosts(schedule.TestPlan), schedule.Confirmed, nil); len(violations) > 0 {
+		schedule.LastOutcome = ScheduleOutcomeSkipped
+		schedule.LastMessage = fmt.Sprintf("refused by the safety policy: %v", violations)
+		LogWarn("Schedule %s skipped: %s", schedule.Name, schedule.LastMessage)
+		c.saveSchedule(schedule)
+		return
+	}
+
+	name := fmt.Sprintf("%s %s", schedule.Name, now.In(schedule.location).Format("2006-01-02 15:04 MST"))
+	testRun := NewTestRun(name, schedule.TestPlan, schedule.MinAgents, schedule.Parameters)
+	testRun.Selector = schedule.Selector
+	testRun.Confirmed = schedule.Confirmed
+	testRun.ScheduleID = schedule.ID
+	c.testRuns[testRun.ID] = testRun
+
+	// Scheduled runs queue rather than fail when their agents are busy
+	req := StartTestRunRequest{Queue: true, Priority: schedule.Priority}
+	if schedule.QueueTimeout != "" {
+		if timeout, err := time.ParseDuration(schedule.QueueTimeout); err == nil {
+			deadline := now.Add(timeout)
+			req.Deadline = &deadline
+		}
+	}
+	c.launchTestRun(testRun, req)
+
+	schedule.LastTestRunID = testRun.ID
+	schedule.LastMessage = ""
+	if testRun.Status == TestRunStatusQueued {
+		schedule.LastOutcome = ScheduleOutcomeQueued
+	} else {
+		schedule.LastOutcome = ScheduleOutcomeStarted
+	}
+	LogInfo("Schedule %s fired: test run %s %s", schedule.Name, testRun.ID, schedule.LastOutcome)
+	c.saveSchedule(schedule)
+}
+
+func (c *Coordinator) saveSchedule(schedule *Schedule) {
+	if err := c.database.SaveSchedule(schedule); err != nil {
+		LogError("Failed to save schedule to database: %v", err)
+	}
+}
+
+func (c *Coordinator) loadSchedulesFromDatabase() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	schedules, err := c.database.ListSchedules()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, schedule := range schedules {
+		if err := schedule.compile(); err != nil {
+			LogWarn("Disabling schedule %s: %v", schedule.Name, err)
+			schedule.Enabled = false
+		} else if schedule.NextFireAt != nil && schedule.NextFireAt.Before(now) {
+			// Fires missed while the coordinator was down are not made up
+			LogWarn("Schedule %s missed its fire time %s while the coordinator was down",
+				schedule.Name, schedule.NextFireAt.Format(time.RFC3339))
+		}
+		if schedule.cron != nil {
+			schedule.advance(now)
+		}
+		c.schedules[schedule.ID] = schedule
+	}
+
+	LogInfo("Loaded %d schedules from database", len(schedules))
+	return nil
+}
+
+func (c *Coordinator) handleCreateSchedule(ctx *gin.Context) {
+	var req ScheduleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+	if errs := req.validate(c.config.Defaults.MinAgents); errs != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule", "details": errs})
+		return
+	}
+	if !c.checkSafety(ctx, req.TestPlan, req.Confirm) {
+		return
+	}
+
+	now := time.Now()
+	schedule := &Schedule{ID: uuid.New().String(), CreatedAt: now}
+	if err := schedule.apply(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule", "details": err.Error()})
+		return
+	}
+
+	c.mu.Lock()
+	c.schedules[schedule.ID] = schedule
+	c.saveSchedule(schedule)
+	c.mu.Unlock()
+
+	LogInfo("Schedule created: %s (%s %s)", schedule.Name, schedule.Cron, schedule.TimeZone)
+	auditTarget(ctx, schedule.ID)
+	auditSummary(ctx, "created schedule %q (%s %s)", schedule.Name, schedule.Cron, schedule.TimeZone)
+	ctx.JSON(http.StatusCreated, schedule)
+}
+
+func (c *Coordinator) handleListSchedules(ctx *gin.Context) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	schedules := make([]*Schedule, 0, len(c.schedules))
+	for _, schedule := range c.schedules {
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].CreatedAt.Before(schedules[j].CreatedAt)
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"schedules": schedules,
+		"total":     len(schedules),
+	})
+}
+
+func (c *Coordinator) handleGetSchedule(ctx *gin.Context) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	schedule, exists := c.schedules[ctx.Param("id")]
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	// Runs created by the schedule, newest first
+	runs := make([]*TestRun, 0)
+	for _, testRun := range c.testRuns {
+		if testRun.ScheduleID == schedule.ID {
+			runs = append(runs, testRun)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"schedule":  schedule,
+		"test_runs": runs,
+	})
+}
+
+func (c *Coordinator) handleUpdateSchedule(ctx *gin.Context) {
+	var req ScheduleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+	if errs := req.validate(c.config.Defaults.MinAgents); errs != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule", "details": errs})
+		return
+	}
+	if !c.checkSafety(ctx, req.TestPlan, req.Confirm) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	schedule, exists := c.schedules[ctx.Param("id")]
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+	if err := schedule.apply(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule", "details": err.Error()})
+		return
+	}
+	c.saveSchedule(schedule)
+
+	LogInfo("Schedule updated: %s (%s %s)", schedule.Name, schedule.Cron, schedule.TimeZone)
+	auditSummary(ctx, "updated schedule %q (%s %s, enabled %t)", schedule.Name, schedule.Cron, schedule.TimeZone, schedule.Enabled)
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+func (c *Coordinator) handleDeleteSchedule(ctx *gin.Context) {
+	scheduleID := ctx.Param("id")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	schedule, exists := c.schedules[scheduleID]
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	if err := c.database.DeleteSchedule(scheduleID); err != nil {
+		LogError("Failed to delete schedule from database: %v", err)
+	}
+	delete(c.schedules, scheduleID)
+
+	LogInfo("Schedule deleted: %s (ID: %s)", schedule.Name, scheduleID)
+	auditSummary(ctx, "deleted schedule %q", schedule.Name)
+	ctx.JSON(http.StatusOK, gin.H{"message": "Schedule deleted"})
+}
+
+// handleTriggerSchedule fires a schedule now, such as after a deploy,
+// following its overlap policy. The regular fire times are unchanged.
+func (c *Coordinator) handleTriggerSchedule(ctx *gin.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	schedule, exists := c.schedules[ctx.Param("id")]
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return
+	}
+
+	next := schedule.NextFireAt
+	c.fireSchedule(schedule, time.Now())
+	schedule.NextFireAt = next
+	c.saveSchedule(schedule)
+
+	auditSummary(ctx, "triggered schedule %q: test run %s %s", schedule.Name, schedule.LastTestRunID, schedule.LastOutcome)
+	ctx.JSON(http.StatusOK, schedule)
+}
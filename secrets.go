@@ -0,0 +1,464 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	secretsKeyEnv         = "ARMONITE_SECRETS_KEY" // Coordinator: base64 key for stored secrets
+	secretEnvPrefix       = "ARMONITE_SECRET_"     // Agent: ARMONITE_SECRET_<NAME> provides a secret locally
+	secretRedacted        = "***"
+	secretMinRedactLength = 4 // Shorter values would mask unrelated text in logs
+)
+
+// secretReferencePattern matches ${secret:name} in URLs, headers and bodies
+var secretReferencePattern = regexp.MustCompile(`\$\{secret:([^}]*)\}`)
+
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Secret is a named value test plans refer to as ${secret:name}. The value is
+// never returned by the API.
+type Secret struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SecretRequest is the body of PUT /api/v1/secrets/:name
+type SecretRequest struct {
+	Value string `json:"value" binding:"required"`
+}
+
+// SecretsFetchRequest asks the coordinator for the secrets a test run uses.
+// Values are sealed to the agent's one-time X25519 key, as replies are
+// delivered on inboxes other agents may subscribe to.
+type SecretsFetchRequest struct {
+	AgentID   string   `json:"agent_id"`
+	TestRunID string   `json:"test_run_id"`
+	Names     []string `json:"names"`
+	PublicKey string   `json:"public_key"`
+}
+
+type SecretsFetchResponse struct {
+	PublicKey string            `json:"public_key,omitempty"` // Coordinator's one-time X25519 key
+	Secrets   map[string]string `json:"secrets,omitempty"`    // Sealed values by name
+	Missing   []string          `json:"missing,omitempty"`    // Names not in the secret store
+	Error     string            `json:"error,omitempty"`
+}
+
+func validSecretName(name string) bool {
+	return secretNamePattern.MatchString(name)
+}
+
+// validateSecretReferences rejects references without a valid secret name
+func validateSecretReferences(text string) error {
+	for _, match := range secretReferencePattern.FindAllStringSubmatch(text, -1) {
+		if !validSecretName(match[1]) {
+			return fmt.Errorf("invalid secret reference %q: names use letters, digits, '_' and '-'", match[0])
+		}
+	}
+	return nil
+}
+
+// planSecretReferences returns the names of the secrets a plan refers to,
+// in its endpoints and region overrides alike
+func planSecretReferences(plan TestPlan) []string {
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, match := range secretReferencePattern.FindAllStringSubmatch(string(data), -1) {
+		if validSecretName(match[1]) && !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// secretRedactor masks known secret values. Values are kept for the life of
+// the process so later log lines about an old run are masked too.
+type secretRedactor struct {
+	mu       sync.Mutex
+	values   map[string]bool
+	replacer atomic.Pointer[strings.Replacer]
+}
+
+var redactor = &secretRedactor{values: make(map[string]bool)}
+
+func (r *secretRedactor) add(value string) {
+	if len(value) < secretMinRedactLength {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values[value] {
+		return
+	}
+	r.values[value] = true
+
+	// Longer values first, so a value containing another is masked whole
+	values := make([]string, 0, len(r.values))
+	for v := range r.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, secretRedacted)
+	}
+	r.replacer.Store(strings.NewReplacer(pairs...))
+}
+
+// redactSecrets masks every known secret value in text
+func redactSecrets(text string) string {
+	replacer := redactor.replacer.Load()
+	if replacer == nil {
+		return text
+	}
+	return replacer.Replace(text)
+}
+
+// secretBox encrypts stored secrets with AES-256-GCM, bound to their names
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+// loadSecretBox reads the key from ARMONITE_SECRETS_KEY or keyFile,
+// generating the file on first start
+func loadSecretBox(keyFile string) (*secretBox, error) {
+	if encoded := os.Getenv(secretsKeyEnv); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s must be a base64 32-byte key", secretsKeyEnv)
+		}
+		return newSecretBox(key)
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return generateSecretBox(keyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets key %s: %w", keyFile, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("secrets key %s must hold a base64 32-byte key", keyFile)
+	}
+	return newSecretBox(key)
+}
+
+func generateSecretBox(keyFile string) (*secretBox, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate secrets key: %w", err)
+	}
+
+	if dir := filepath.Dir(keyFile); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create secrets key directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write secrets key %s: %w", keyFile, err)
+	}
+	LogInfo("Generated secrets key in %s", keyFile)
+	return newSecretBox(key)
+}
+
+func newSecretBox(key []byte) (*secretBox, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSecret encrypts value with a random nonce, which is prepended
+func sealSecret(aead cipher.AEAD, name, value string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(value), []byte(name)), nil
+}
+
+func openSecret(aead cipher.AEAD, name string, sealed []byte) (string, error) {
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("secret %s is truncated", name)
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("secret %s cannot be decrypted with the current key", name)
+	}
+	return string(value), nil
+}
+
+// transportAEAD derives the cipher for secrets sent to one agent from an
+// X25519 exchange
+func transportAEAD(private *ecdh.PrivateKey, encodedPeer string) (cipher.AEAD, error) {
+	peerBytes, err := base64.StdEncoding.DecodeString(encodedPeer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	peer, err := ecdh.X25519().NewPublicKey(peerBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	shared, err := private.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256(shared)
+	return newAEAD(key[:])
+}
+
+// setupSecrets loads the secret store key, masks stored values in logs and
+// answers agents asking for the secrets of their test run
+func (c *Coordinator) setupSecrets() error {
+	box, err := loadSecretBox(c.config.Secrets.KeyFile)
+	if err != nil {
+		return err
+	}
+	c.secrets = box
+
+	secrets, err := c.database.ListSecrets()
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+	for _, secret := range secrets {
+		if _, err := c.secretValue(secret.Name); err != nil {
+			LogWarn("%v", err)
+		}
+	}
+
+	if _, err := c.natsConn.Subscribe(agentSubject("*", agentSecretsKind), c.handleSecretsFetch); err != nil {
+		return fmt.Errorf("failed to subscribe to secrets requests: %w", err)
+	}
+	LogInfo("Secret store ready with %d secrets", len(secrets))
+	return nil
+}
+
+// secretValue decrypts a stored secret, or returns "" if there is none
+func (c *Coordinator) secretValue(name string) (string, error) {
+	_, sealed, err := c.database.GetSecret(name)
+	if err != nil || sealed == nil {
+		return "", err
+	}
+	value, err := openSecret(c.secrets.aead, name, sealed)
+	if err != nil {
+		return "", err
+	}
+	redactor.add(value)
+	return value, nil
+}
+
+// handleSecretsFetch sends an agent the secrets its test run refers to.
+// The request must come from the agent it names, which must be taking part
+// in the run or running its preflight, and only names in the run's plan are
+// served.
+func (c *Coordinator) handleSecretsFetch(msg *nats.Msg) {
+	var req SecretsFetchRequest
+	var resp SecretsFetchResponse
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		resp.Error = "invalid request"
+	} else if !checkSender(msg, req.AgentID) {
+		resp.Error = "agent ID does not match the connection"
+	} else if err := c.sealSecretsFor(req, &resp); err != nil {
+		resp.Error = err.Error()
+		LogWarn("Refused secrets to agent %s for test run %s: %v", req.AgentID, req.TestRunID, err)
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		LogError("Failed to marshal secrets response: %v", err)
+		return
+	}
+	if msg.Reply == "" {
+		return
+	}
+	// Signed like a command, so agents know the values came from the coordinator
+	reply, err := c.signer.signedMessage(msg.Reply, data)
+	if err != nil {
+		LogError("Failed to sign secrets response: %v", err)
+		return
+	}
+	if err := c.natsConn.PublishMsg(reply); err != nil {
+		LogWarn("Failed to send secrets to agent %s: %v", req.AgentID, err)
+	}
+}
+
+func (c *Coordinator) sealSecretsFor(req SecretsFetchRequest, resp *SecretsFetchResponse) error {
+	c.mu.RLock()
+	_, registered := c.connectedAgents[req.AgentID]
+	testRun, exists := c.testRuns[req.TestRunID]
+	reserved := c.agentReservations[req.AgentID] == req.TestRunID
+	preflighting := c.preflights[req.AgentID] == req.TestRunID
+	var plan TestPlan
+	var status TestRunStatus
+	if exists {
+		plan = testRun.TestPlan
+		status = testRun.Status
+	}
+	c.mu.RUnlock()
+
+	if !registered {
+		return fmt.Errorf("agent is not registered")
+	}
+	if !exists {
+		return fmt.Errorf("test run not found")
+	}
+	switch {
+	case preflighting:
+	case !reserved:
+		return fmt.Errorf("agent is not taking part in the test run")
+	case status != TestRunStatusRunning:
+		return fmt.Errorf("test run is %s", status)
+	}
+
+	referenced := make(map[string]bool)
+	for _, name := range planSecretReferences(plan) {
+		referenced[name] = true
+	}
+	for _, name := range req.Names {
+		if !referenced[name] {
+			return fmt.Errorf("secret %s is not used by the test run", name)
+		}
+	}
+
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	aead, err := transportAEAD(private, req.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	resp.PublicKey = base64.StdEncoding.EncodeToString(private.PublicKey().Bytes())
+	resp.Secrets = make(map[string]string, len(req.Names))
+	for _, name := range req.Names {
+		value, err := c.secretValue(name)
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			resp.Missing = append(resp.Missing, name)
+			continue
+		}
+		sealed, err := sealSecret(aead, name, value)
+		if err != nil {
+			return err
+		}
+		resp.Secrets[name] = base64.StdEncoding.EncodeToString(sealed)
+	}
+	LogDebug("Sent %d secrets to agent %s for test run %s", len(resp.Secrets), req.AgentID, req.TestRunID)
+	return nil
+}
+
+func (c *Coordinator) handleListSecrets(ctx *gin.Context) {
+	secrets, err := c.database.ListSecrets()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list secrets", "details": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"secrets": secrets,
+		"count":   len(secrets),
+	})
+}
+
+// handlePutSecret creates or replaces a secret
+func (c *Coordinator) handlePutSecret(ctx *gin.Context) {
+	name := ctx.Param("name")
+	if !validSecretName(name) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret name", "details": "names use letters, digits, '_' and '-'"})
+		return
+	}
+	var req SecretRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	existing, _, err := c.database.GetSecret(name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret", "details": err.Error()})
+		return
+	}
+	now := time.Now()
+	secret := &Secret{Name: name, CreatedAt: now, UpdatedAt: now}
+	status, verb := http.StatusCreated, "created"
+	if existing != nil {
+		secret.CreatedAt = existing.CreatedAt
+		status, verb = http.StatusOK, "replaced"
+	}
+
+	sealed, err := sealSecret(c.secrets.aead, name, req.Value)
+	if err == nil {
+		err = c.database.SaveSecret(secret, sealed)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret", "details": err.Error()})
+		return
+	}
+	redactor.add(req.Value)
+
+	LogInfo("Secret saved: %s", name)
+	auditSummary(ctx, "%s secret %s", verb, name)
+	ctx.JSON(status, secret)
+}
+
+func (c *Coordinator) handleDeleteSecret(ctx *gin.Context) {
+	name := ctx.Param("name")
+	deleted, err := c.database.DeleteSecret(name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete secret", "details": err.Error()})
+		return
+	}
+	if deleted == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
+		return
+	}
+
+	LogInfo("Secret deleted: %s", name)
+	auditSummary(ctx, "deleted secret %s", name)
+	ctx.JSON(http.StatusOK, gin.H{"message": "Secret deleted", "name": name})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretsFetchTimeout bounds the wait for the coordinator's secrets
+const secretsFetchTimeout = 5 * time.Second
+
+// agentSecrets holds the secret values of the test run an agent is running.
+// Endpoints are resolved from it by resolveEndpoint.
+var agentSecrets = struct {
+	mu        sync.RWMutex
+	testRunID string
+	values    map[string]string
+}{}
+
+// secretEnvName is the variable an agent reads a secret from, e.g.
+// ARMONITE_SECRET_API_TOKEN for api-token
+func secretEnvName(name string) string {
+	return secretEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// describeSecrets names secrets as the subject of an error message
+func describeSecrets(names []string) string {
+	if len(names) == 1 {
+		return "secret " + names[0] + " is"
+	}
+	return "secrets " + strings.Join(names, ", ") + " are"
+}
+
+// substituteSecrets replaces ${secret:name} references with their values
+func substituteSecrets(text string) (string, error) {
+	if !strings.Contains(text, "${secret:") {
+		return text, nil
+	}
+
+	agentSecrets.mu.RLock()
+	defer agentSecrets.mu.RUnlock()
+
+	var missing string
+	resolved := secretReferencePattern.ReplaceAllStringFunc(text, func(reference string) string {
+		name := secretReferencePattern.FindStringSubmatch(reference)[1]
+		value, ok := agentSecrets.values[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("secret %s is not available", missing)
+	}
+	return resolved, nil
+}
+
+// loadSecrets resolves the secrets a plan refers to, from ARMONITE_SECRET_*
+// variables first and the coordinator's secret store otherwise. Secrets
+// already loaded for the run are kept.
+func (a *Agent) loadSecrets(testRunID string, plan TestPlan) error {
+	names := planSecretReferences(plan)
+	if len(names) == 0 {
+		return nil
+	}
+
+	agentSecrets.mu.RLock()
+	loaded := testRunID != "" && agentSecrets.testRunID == testRunID
+	agentSecrets.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+
+	values := make(map[string]string, len(names))
+	var remote []string
+	for _, name := range names {
+		if value, ok := os.LookupEnv(secretEnvName(name)); ok {
+			values[name] = value
+		} else {
+			remote = append(remote, name)
+		}
+	}
+
+	if len(remote) > 0 {
+		if testRunID == "" {
+			return fmt.Errorf("%s not set in %s* variables", describeSecrets(remote), secretEnvPrefix)
+		}
+		fetched, err := a.fetchSecrets(testRunID, remote)
+		if err != nil {
+			return err
+		}
+		for name, value := range fetched {
+			values[name] = value
+		}
+	}
+
+	for _, value := range values {
+		redactor.add(value)
+	}
+	agentSecrets.mu.Lock()
+	agentSecrets.testRunID = testRunID
+	agentSecrets.values = values
+	agentSecrets.mu.Unlock()
+
+	LogInfo("Loaded %d secrets for test run %s (%d from the coordinator)", len(values), testRunID, len(remote))
+	return nil
+}
+
+// fetchSecrets asks the coordinator for secrets, sealed to a one-time key
+func (a *Agent) fetchSecrets(testRunID string, names []string) (map[string]string, error) {
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(SecretsFetchRequest{
+		AgentID:   a.id,
+		TestRunID: testRunID,
+		Names:     names,
+		PublicKey: base64.StdEncoding.EncodeToString(private.PublicKey().Bytes()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := a.natsConn.Request(agentSubject(a.id, agentSecretsKind), data, secretsFetchTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("coordinator did not send secrets: %w", err)
+	}
+	if err := a.commands.verify(msg); err != nil {
+		return nil, fmt.Errorf("rejected secrets: %w", err)
+	}
+
+	var resp SecretsFetchResponse
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		return nil, fmt.Errorf("invalid secrets response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("coordinator refused secrets: %s", resp.Error)
+	}
+	if len(resp.Missing) > 0 {
+		return nil, fmt.Errorf("%s not in the coordinator's secret store or %s* variables",
+			describeSecrets(resp.Missing), secretEnvPrefix)
+	}
+
+	aead, err := transportAEAD(private, resp.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		sealed, err := base64.StdEncoding.DecodeString(resp.Secrets[name])
+		if err != nil || resp.Secrets[name] == "" {
+			return nil, fmt.Errorf("coordinator did not send secret %s", name)
+		}
+		value, err := openSecret(aead, name, sealed)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// testSecretsCoordinator has a stored secret api-key and test runs using it:
+// r-running with a1 reserved, r-completing with a2 reserved and r-created
+// with a3 running its preflight. a4 is connected without a run.
+func testSecretsCoordinator(t *testing.T) *Coordinator {
+	t.Helper()
+	database, err := NewDatabase(DatabaseConfig{DSN: filepath.Join(t.TempDir(), "armonite.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	box, err := newSecretBox(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealSecret(box.aead, "api-key", "s3cret-value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := database.SaveSecret(&Secret{Name: "api-key", CreatedAt: time.Now(), UpdatedAt: time.Now()}, sealed); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := TestPlan{Endpoints: []Endpoint{{
+		Method:  "GET",
+		URL:     "http://127.0.0.1/",
+		Headers: map[string]string{"Authorization": "Bearer ${secret:api-key}"},
+	}}}
+	c := &Coordinator{
+		database:        database,
+		secrets:         box,
+		connectedAgents: make(map[string]*AgentInfo),
+		testRuns: map[string]*TestRun{
+			"r-running":    {ID: "r-running", Status: TestRunStatusRunning, TestPlan: plan},
+			"r-completing": {ID: "r-completing", Status: TestRunStatusCompleting, TestPlan: plan},
+			"r-created":    {ID: "r-created", Status: TestRunStatusCreated, TestPlan: plan},
+		},
+		agentReservations: map[string]string{"a1": "r-running", "a2": "r-completing"},
+		preflights:        map[string]string{"a3": "r-created"},
+	}
+	for _, id := range []string{"a1", "a2", "a3", "a4"} {
+		c.connectedAgents[id] = &AgentInfo{ID: id}
+	}
+	return c
+}
+
+// testSecretsRequest asks for names with a new one-time key
+func testSecretsRequest(t *testing.T, agentID, testRunID string, names ...string) (SecretsFetchRequest, *ecdh.PrivateKey) {
+	t.Helper()
+	private, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SecretsFetchRequest{
+		AgentID:   agentID,
+		TestRunID: testRunID,
+		Names:     names,
+		PublicKey: base64.StdEncoding.EncodeToString(private.PublicKey().Bytes()),
+	}, private
+}
+
+func TestSealSecretsFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		agentID   string
+		testRunID string
+		names     []string
+		wantErr   string // Empty when the secret is served
+	}{
+		{"reserved agent of a running run", "a1", "r-running", []string{"api-key"}, ""},
+		{"agent running the run's preflight", "a3", "r-created", []string{"api-key"}, ""},
+		{"agent reserved for another run", "a1", "r-created", []string{"api-key"}, "not taking part"},
+		{"agent without a run", "a4", "r-running", []string{"api-key"}, "not taking part"},
+		{"preflight of another run", "a3", "r-running", []string{"api-key"}, "not taking part"},
+		{"reserved agent of a completing run", "a2", "r-completing", []string{"api-key"}, "test run is completing"},
+		{"unregistered agent", "a9", "r-running", []string{"api-key"}, "not registered"},
+		{"unknown run", "a1", "r-unknown", []string{"api-key"}, "test run not found"},
+		{"no run", "a4", "", []string{"api-key"}, "test run not found"},
+		{"secret not in the plan", "a1", "r-running", []string{"other"}, "not used by the test run"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testSecretsCoordinator(t)
+			req, private := testSecretsRequest(t, tt.agentID, tt.testRunID, tt.names...)
+
+			var resp SecretsFetchResponse
+			err := c.sealSecretsFor(req, &resp)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("sealSecretsFor error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				if len(resp.Secrets) > 0 {
+					t.Errorf("refused request still returned secrets %v", resp.Secrets)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sealSecretsFor: %v", err)
+			}
+
+			// Only the requester's one-time key opens the value
+			aead, err := transportAEAD(private, resp.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealed, err := base64.StdEncoding.DecodeString(resp.Secrets["api-key"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value, err := openSecret(aead, "api-key", sealed); err != nil || value != "s3cret-value" {
+				t.Errorf("opened %q, %v; want the stored value", value, err)
+			}
+		})
+	}
+}
+
+func TestHandleSecretsFetchBindsTheSender(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	c := testSecretsCoordinator(t)
+	c.natsConn = nc
+	c.signer, err = loadCommandSigner(filepath.Join(t.TempDir(), "signing.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		subject string
+		wantErr string
+	}{
+		{"request on the agent's own subject", agentSubject("a1", agentSecretsKind), ""},
+		{"request naming another agent", agentSubject("a4", agentSecretsKind), "agent ID does not match the connection"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := testSecretsRequest(t, "a1", "r-running", "api-key")
+			data, err := json.Marshal(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inbox := nats.NewInbox()
+			replies, err := nc.SubscribeSync(inbox)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c.handleSecretsFetch(&nats.Msg{Subject: tt.subject, Reply: inbox, Data: data})
+
+			reply, err := replies.NextMsg(time.Second)
+			if err != nil {
+				t.Fatalf("no reply: %v", err)
+			}
+			var resp SecretsFetchResponse
+			if err := json.Unmarshal(reply.Data, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Error != tt.wantErr {
+				t.Errorf("response error = %q, want %q", resp.Error, tt.wantErr)
+			}
+			if served := len(resp.Secrets) > 0; served != (tt.wantErr == "") {
+				t.Errorf("served %d secrets, want them only without an error", len(resp.Secrets))
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Label selector operators
+const (
+	SelectorOpIn           = "In"
+	SelectorOpNotIn        = "NotIn"
+	SelectorOpExists       = "Exists"
+	SelectorOpDoesNotExist = "DoesNotExist"
+)
+
+// AgentSelector restricts a test run to a subset of the connected agents.
+// All conditions must hold; an empty selector matches every agent.
+type AgentSelector struct {
+	MatchLabels      map[string]string    `json:"match_labels,omitempty"`      // Exact label values
+	MatchExpressions []SelectorExpression `json:"match_expressions,omitempty"` // Set-based label requirements
+	AgentIDs         []string             `json:"agent_ids,omitempty"`         // Explicit agent IDs
+	MaxAgents        int                  `json:"max_agents,omitempty"`        // Upper bound on agents used, 0 for no limit
+}
+
+// SelectorExpression is a set-based label requirement
+type SelectorExpression struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"` // In, NotIn, Exists or DoesNotExist
+	Values   []string `json:"values,omitempty"`
+}
+
+// Validate checks the selector for malformed expressions and for limits
+// that could never satisfy minAgents
+func (s *AgentSelector) Validate(minAgents int) PlanValidationErrors {
+	if s == nil {
+		return nil
+	}
+
+	var errs PlanValidationErrors
+
+	for key := range s.MatchLabels {
+		if strings.TrimSpace(key) == "" {
+			errs.add("selector.match_labels", "label key must not be empty")
+		}
+	}
+
+	for i, expr := range s.MatchExpressions {
+		path := fmt.Sprintf("selector.match_expressions[%d]", i)
+		if strings.TrimSpace(expr.Key) == "" {
+			errs.add(path+".key", "is required")
+		}
+		switch expr.Operator {
+		case SelectorOpIn, SelectorOpNotIn:
+			if len(expr.Values) == 0 {
+				errs.add(path+".values", "%s requires at least one value", expr.Operator)
+			}
+		case SelectorOpExists, SelectorOpDoesNotExist:
+			if len(expr.Values) > 0 {
+				errs.add(path+".values", "%s does not take values", expr.Operator)
+			}
+		default:
+			errs.add(path+".operator", "must be %s, %s, %s or %s",
+				SelectorOpIn, SelectorOpNotIn, SelectorOpExists, SelectorOpDoesNotExist)
+		}
+	}
+
+	if s.MaxAgents < 0 {
+		errs.add("selector.max_agents", "must not be negative")
+	} else if s.MaxAgents > 0 && minAgents > s.MaxAgents {
+		errs.add("selector.max_agents", "%d is lower than min_agents (%d)", s.MaxAgents, minAgents)
+	}
+
+	if len(s.AgentIDs) > 0 && minAgents > len(s.AgentIDs) {
+		errs.add("selector.agent_ids", "lists %d agents but min_agents is %d", len(s.AgentIDs), minAgents)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Matches reports whether a single agent satisfies the selector, ignoring MaxAgents
+func (s *AgentSelector) Matches(agent *AgentInfo) bool {
+	if s == nil {
+		return true
+	}
+
+	if len(s.AgentIDs) > 0 && !contains(s.AgentIDs, agent.ID) {
+		return false
+	}
+
+	for key, value := range s.MatchLabels {
+		if actual, ok := agent.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+
+	for _, expr := range s.MatchExpressions {
+		value, ok := agent.Labels[expr.Key]
+		switch expr.Operator {
+		case SelectorOpIn:
+			if !ok || !contains(expr.Values, value) {
+				return false
+			}
+		case SelectorOpNotIn:
+			if ok && contains(expr.Values, value) {
+				return false
+			}
+		case SelectorOpExists:
+			if !ok {
+				return false
+			}
+		case SelectorOpDoesNotExist:
+			if ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Select returns the matching agents ordered by ID. When MaxAgents caps the
+// result, agents in preferred are kept first so a running test does not
+// lose agents to later arrivals.
+func (s *AgentSelector) Select(agents map[string]*AgentInfo, preferred map[string]bool) []*AgentInfo {
+	selected := make([]*AgentInfo, 0, len(agents))
+	for _, agent := range agents {
+		if s.Matches(agent) {
+			selected = append(selected, agent)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		if preferred[selected[i].ID] != preferred[selected[j].ID] {
+			return preferred[selected[i].ID]
+		}
+		return selected[i].ID < selected[j].ID
+	})
+
+	if s != nil && s.MaxAgents > 0 && len(selected) > s.MaxAgents {
+		selected = selected[:s.MaxAgents]
+	}
+	return selected
+}
+
+// selectAgents returns the connected agents a test run may use: those
+// matching its selector and not reserved by another run. Caller must hold c.mu.
+func (c *Coordinator) selectAgents(testRun *TestRun) []*AgentInfo {
+	return c.selectAgentsExcluding(testRun, nil)
+}
+
+// selectAgentsExcluding is selectAgents that also skips the excluded agents,
+// such as those held for runs ahead in the queue. Caller must hold c.mu.
+func (c *Coordinator) selectAgentsExcluding(testRun *TestRun, excluded map[string]bool) []*AgentInfo {
+	available := make(map[string]*AgentInfo, len(c.connectedAgents))
+	for id, agent := range c.connectedAgents {
+		if reservedFor, reserved := c.agentReservations[id]; reserved && reservedFor != testRun.ID {
+			continue
+		}
+		if excluded[id] {
+			continue
+		}
+		available[id] = agent
+	}
+
+	preferred := make(map[string]bool, len(testRun.Allocation))
+	for _, allocation := range testRun.Allocation {
+		if allocation.Concurrency > 0 {
+			preferred[allocation.AgentID] = true
+		}
+	}
+	return testRun.Selector.Select(available, preferred)
+}
+
+// parseLabels parses key=value pairs given on the command line
+func parseLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", pair)
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
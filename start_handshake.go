@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Start handshake timing
+const (
+	prepareAckTimeout = agentIdleTimeout + 5*time.Second  // Agents first let a previous test wind down
+	commandAckTimeout = 5 * time.Second                   // For START and STOP acknowledgements
+	startLeadTime     = commandAckTimeout + 2*time.Second // Covers the START round trip and redistributing refused shares
+)
+
+// Test run event types for the start handshake
+const (
+	TestRunEventAgentExcluded         = "agent_excluded"
+	TestRunEventCommandUnacknowledged = "command_unacknowledged"
+)
+
+// errRunNotActive is returned when a run was stopped while it was starting
+var errRunNotActive = errors.New("test run is no longer active")
+
+// CommandAck is an agent's reply to a PREPARE, START or STOP command
+type CommandAck struct {
+	AgentID   string   `json:"agent_id"`
+	TestRunID string   `json:"test_run_id,omitempty"`
+	Command   string   `json:"command"`
+	Ready     bool     `json:"ready"`
+	Error     string   `json:"error,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+	PrepareMs float64  `json:"prepare_ms,omitempty"` // Time spent getting ready for a PREPARE
+}
+
+// requestAcks sends each agent its command as a NATS request and waits for
+// the replies. Agents that do not answer in time get an ack with Error set.
+func (c *Coordinator) requestAcks(agentIDs []string, timeout time.Duration, build func(agentID string) TestStartCommand) map[string]CommandAck {
+	acks := make(map[string]CommandAck, len(agentIDs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, agentID := range agentIDs {
+		wg.Add(1)
+		go func(agentID string) {
+			defer wg.Done()
+
+			command := build(agentID)
+			ack := CommandAck{AgentID: agentID, TestRunID: command.TestRunID, Command: command.Command}
+
+			data, err := json.Marshal(command)
+			if err != nil {
+				ack.Error = fmt.Sprintf("failed to encode %s command: %v", command.Command, err)
+			} else {
+				subject := fmt.Sprintf("armonite.agent.%s.command", agentID)
+				msg, err := c.signer.request(c.natsConn, subject, data, timeout)
+				if err != nil {
+					ack.Error = fmt.Sprintf("no %s acknowledgement: %v", command.Command, err)
+				} else if err := json.Unmarshal(msg.Data, &ack); err != nil {
+					ack.Ready = false
+					ack.Error = fmt.Sprintf("invalid %s acknowledgement: %v", command.Command, err)
+				}
+			}
+
+			mu.Lock()
+			acks[agentID] = ack
+			mu.Unlock()
+		}(agentID)
+	}
+
+	wg.Wait()
+	return acks
+}
+
+// prepareAgents sends PREPARE to the run's allocated agents and returns those
+// that acknowledged they are ready. The others are released from the run and
+// recorded as excluded.
+func (c *Coordinator) prepareAgents(testRun *TestRun, allocations []AgentAllocation) ([]*AgentInfo, error) {
+	byAgent := make(map[string]AgentAllocation, len(allocations))
+	agentIDs := make([]string, 0, len(allocations))
+	for _, allocation := range allocations {
+		if allocation.Concurrency > 0 {
+			byAgent[allocation.AgentID] = allocation
+			agentIDs = append(agentIDs, allocation.AgentID)
+		}
+	}
+	sort.Strings(agentIDs)
+
+	acks := c.requestAcks(agentIDs, prepareAckTimeout, func(agentID string) TestStartCommand {
+		allocation := byAgent[agentID]
+		return TestStartCommand{
+			TestRunID:  testRun.ID,
+			TestPlan:   testRun.TestPlan,
+			Command:    "PREPARE",
+			Allocation: &allocation,
+			Safety:     c.runSafety(),
+			Confirmed:  testRun.Confirmed,
+		}
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	run, active := c.activeRuns[testRun.ID]
+	if !active {
+		return nil, errRunNotActive
+	}
+
+	ready := make([]*AgentInfo, 0, len(agentIDs))
+	for _, agentID := range agentIDs {
+		ack := acks[agentID]
+		agent, connected := c.connectedAgents[agentID]
+		if ack.Ready && ack.Error == "" && connected {
+			for _, warning := range ack.Warnings {
+				LogWarn("Agent %s preparing test run %s: %s", agentID, testRun.Name, warning)
+			}
+			LogDebug("Agent %s ready for test run %s in %.0fms", agentID, testRun.Name, ack.PrepareMs)
+			ready = append(ready, agent)
+			continue
+		}
+
+		reason := ack.Error
+		if reason == "" && !connected {
+			reason = "disconnected while preparing"
+		} else if reason == "" {
+			reason = "not ready"
+		}
+		c.releaseAgent(run, agentID)
+		testRun.AddEvent(TestRunEvent{
+			Type:    TestRunEventAgentExcluded,
+			AgentID: agentID,
+			Message: fmt.Sprintf("agent %s excluded from the start: %s", agentID, reason),
+		})
+		LogWarn("Agent %s excluded from test run %s: %s", agentID, testRun.Name, reason)
+	}
+
+	if len(ready) == 0 {
+		return nil, fmt.Errorf("no agents acknowledged the start")
+	}
+	if len(ready) < testRun.AgentCount && !testRun.TestPlan.StartWithAvailable {
+		return nil, fmt.Errorf("only %d of %d required agents acknowledged the start", len(ready), testRun.AgentCount)
+	}
+	return ready, nil
+}
+
+// sendStartCommands sends each agent its share of the load and the common
+// start time. Agents that do not acknowledge it are excluded from the run.
+func (c *Coordinator) sendStartCommands(testRun *TestRun, allocations []AgentAllocation, startAt time.Time) error {
+	byAgent := make(map[string]AgentAllocation, len(allocations))
+	agentIDs := make([]string, 0, len(allocations))
+	for _, allocation := range allocations {
+		if allocation.Concurrency <= 0 {
+			LogInfo("Agent %s has no share of test run %s and will stay idle", allocation.AgentID, testRun.Name)
+			continue
+		}
+		byAgent[allocation.AgentID] = allocation
+		agentIDs = append(agentIDs, allocation.AgentID)
+
+		if allocation.TargetRPS > 0 {
+			LogDebug("Agent %s allocated %d workers at %.1f req/s", allocation.AgentID, allocation.Concurrency, allocation.TargetRPS)
+		} else {
+			LogDebug("Agent %s allocated %d workers", allocation.AgentID, allocation.Concurrency)
+		}
+	}
+	if len(agentIDs) == 0 {
+		return fmt.Errorf("no agents were allocated any load")
+	}
+
+	startTime := startAt.UTC().Format(time.RFC3339Nano)
+	acks := c.requestAcks(agentIDs, commandAckTimeout, func(agentID string) TestStartCommand {
+		allocation := byAgent[agentID]
+		return c.startCommand(testRun, &testRun.TestPlan, &allocation, startTime)
+	})
+
+	acknowledged, err := c.excludeUnstarted(testRun, agentIDs, acks)
+	if err != nil {
+		return err
+	}
+
+	LogInfo("Test start acknowledged by %d/%d agents for test run %s, starting at %s",
+		acknowledged, len(agentIDs), testRun.Name, startTime)
+	return nil
+}
+
+// sendStopCommands sends STOP to a run's agents and waits for them to acknowledge it
+func (c *Coordinator) sendStopCommands(testRun *TestRun) {
+	c.mu.RLock()
+	agents := c.reservedAgents(testRun.ID)
+	c.mu.RUnlock()
+
+	agentIDs := make([]string, 0, len(agents))
+	for _, agent := range agents {
+		agentIDs = append(agentIDs, agent.ID)
+	}
+
+	acks := c.requestAcks(agentIDs, commandAckTimeout, func(string) TestStartCommand {
+		return TestStartCommand{
+			TestRunID: testRun.ID,
+			Command:   "STOP",
+		}
+	})
+	acknowledged := c.recordUnacknowledged(testRun, agentIDs, acks)
+	LogInfo("Stop command acknowledged by %d/%d agents for test run: %s", acknowledged, len(agentIDs), testRun.Name)
+}
+
+// startCommand is the START carrying an agent's share of a run
+func (c *Coordinator) startCommand(testRun *TestRun, plan *TestPlan, allocation *AgentAllocation, startTime string) TestStartCommand {
+	return TestStartCommand{
+		TestRunID:  testRun.ID,
+		TestPlan:   *plan,
+		StartTime:  startTime,
+		Command:    "START",
+		Allocation: allocation,
+		Safety:     c.runSafety(),
+		Confirmed:  testRun.Confirmed,
+	}
+}
+
+// excludeUnstarted takes the agents that did not accept START out of the run
+// and spreads their share across the agents that did. It returns how many
+// accepted, failing when none did.
+func (c *Coordinator) excludeUnstarted(testRun *TestRun, agentIDs []string, acks map[string]CommandAck) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	run, active := c.activeRuns[testRun.ID]
+	if !active {
+		return 0, errRunNotActive
+	}
+
+	refused := c.unacknowledged(testRun, agentIDs, acks)
+	if len(refused) == 0 {
+		return len(agentIDs), nil
+	}
+	for _, agentID := range refused {
+		c.excludeAgent(run, agentID)
+	}
+
+	started := make([]*AgentInfo, 0, len(agentIDs))
+	for _, agentID := range agentIDs {
+		if agent, connected := c.connectedAgents[agentID]; connected && run.participants[agentID] {
+			started = append(started, agent)
+		}
+	}
+	if len(started) == 0 {
+		return 0, fmt.Errorf("no agents acknowledged the START command")
+	}
+
+	previous := make(map[string]AgentAllocation, len(testRun.Allocation))
+	for _, allocation := range testRun.Allocation {
+		previous[allocation.AgentID] = allocation
+	}
+	allocations, warnings := allocateLoad(testRun.TestPlan, started)
+	for _, warning := range warnings {
+		LogWarn("Allocation warning for test run %s: %s", testRun.Name, warning)
+	}
+	for i := range allocations {
+		allocation := allocations[i]
+		before := previous[allocation.AgentID]
+		switch {
+		case allocation.Concurrency == 0:
+			c.releaseAgent(run, allocation.AgentID)
+			delete(run.participants, allocation.AgentID)
+			c.sendAllocationCommand(testRun.ID, "STOP", &allocation, "")
+		case before.Concurrency != allocation.Concurrency || before.TargetRPS != allocation.TargetRPS:
+			c.sendAllocationCommand(testRun.ID, "REBALANCE", &allocation, "")
+		}
+	}
+	testRun.setAllocation(allocations)
+	testRun.AddEvent(TestRunEvent{
+		Type:       TestRunEventAgentExcluded,
+		Message:    fmt.Sprintf("%d agents did not acknowledge START; load reallocated across %d agents", len(refused), countAllocated(allocations)),
+		Allocation: allocations,
+	})
+	LogInfo("Reallocated test run %s across the %d agents that acknowledged START", testRun.Name, countAllocated(allocations))
+
+	return countAllocated(allocations), nil
+}
+
+// excludeAgent takes an agent out of a run: it is released, stopped in case
+// it started after all, and not drawn back in. Caller must hold c.mu.
+func (c *Coordinator) excludeAgent(run *activeRun, agentID string) {
+	c.releaseAgent(run, agentID)
+	delete(run.participants, agentID)
+	run.excluded[agentID] = true
+	c.sendAgentCommand(agentID, TestStartCommand{TestRunID: run.testRun.ID, Command: "STOP"})
+}
+
+// sendLateStart sends START to an agent joining a running test and excludes
+// it if it does not acknowledge. Caller must hold c.mu; the acknowledgement is
+// awaited in the background.
+func (c *Coordinator) sendLateStart(testRun *TestRun, plan TestPlan, allocation AgentAllocation, startTime string) {
+	command := c.startCommand(testRun, &plan, &allocation, startTime)
+	go func() {
+		acks := c.requestAcks([]string{allocation.AgentID}, commandAckTimeout, func(string) TestStartCommand {
+			return command
+		})
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		run, active := c.activeRuns[testRun.ID]
+		if !active || !run.participants[allocation.AgentID] || len(c.unacknowledged(testRun, []string{allocation.AgentID}, acks)) == 0 {
+			return
+		}
+		c.excludeAgent(run, allocation.AgentID)
+		c.rebalanceTestRun(testRun, TestRunEventAgentExcluded, allocation.AgentID)
+	}()
+}
+
+// recordUnacknowledged adds an event for each agent that did not accept its
+// command and returns how many did
+func (c *Coordinator) recordUnacknowledged(testRun *TestRun, agentIDs []string, acks map[string]CommandAck) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(agentIDs) - len(c.unacknowledged(testRun, agentIDs, acks))
+}
+
+// unacknowledged adds an event for each agent that did not accept its command
+// and returns those agents. Caller must hold c.mu.
+func (c *Coordinator) unacknowledged(testRun *TestRun, agentIDs []string, acks map[string]CommandAck) []string {
+	var refused []string
+	for _, agentID := range agentIDs {
+		ack := acks[agentID]
+		if ack.Ready && ack.Error == "" {
+			continue
+		}
+		refused = append(refused, agentID)
+
+		reason := ack.Error
+		if reason == "" {
+			reason = "rejected"
+		}
+		testRun.AddEvent(TestRunEvent{
+			Type:    TestRunEventCommandUnacknowledged,
+			AgentID: agentID,
+			Message: fmt.Sprintf("agent %s did not acknowledge %s: %s", agentID, ack.Command, reason),
+		})
+		LogWarn("Agent %s did not acknowledge %s for test run %s: %s", agentID, ack.Command, testRun.Name, reason)
+	}
+	return refused
+}
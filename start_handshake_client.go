@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// warmUpTimeout bounds connecting to each target host while preparing
+const warmUpTimeout = 3 * time.Second
+
+// handlePrepare gets ready for a test run and acknowledges the coordinator's PREPARE
+func (a *Agent) handlePrepare(msg *nats.Msg, command TestStartCommand) {
+	started := time.Now()
+	ack := CommandAck{AgentID: a.id, TestRunID: command.TestRunID, Command: command.Command}
+
+	if err := a.prepareTestPlan(command, &ack); err != nil {
+		ack.Error = err.Error()
+		LogWarn("Not ready for test plan %s: %v", command.TestPlan.Name, err)
+	} else {
+		ack.Ready = true
+		LogInfo("Ready for test plan: %s (Test Run ID: %s)", command.TestPlan.Name, command.TestRunID)
+	}
+	ack.PrepareMs = float64(time.Since(started).Nanoseconds()) / 1e6
+
+	a.respondAck(msg, ack)
+}
+
+// prepareTestPlan waits for a previous test to end, applies region overrides,
+// loads secrets, checks the safety policy, resolves endpoint templates and
+// connects to each target host once. An unreachable target is reported as a
+// warning; it is for the test to find.
+func (a *Agent) prepareTestPlan(command TestStartCommand, ack *CommandAck) error {
+	if !a.waitUntilIdle(agentIdleTimeout) {
+		return fmt.Errorf("still running the previous test")
+	}
+
+	plan, err := command.TestPlan.ForRegion(a.region)
+	if err != nil {
+		return fmt.Errorf("region overrides: %w", err)
+	}
+	if _, err := time.ParseDuration(plan.Duration); err != nil {
+		return fmt.Errorf("invalid duration %q", plan.Duration)
+	}
+	if len(plan.Endpoints) == 0 {
+		return fmt.Errorf("plan has no endpoints")
+	}
+	if err := a.loadSecrets(command.TestRunID, plan); err != nil {
+		return err
+	}
+	if err := a.applySafety(command, plan); err != nil {
+		return err
+	}
+
+	hosts := make(map[string]bool)
+	for i, endpoint := range plan.Endpoints {
+		resolved, err := resolveEndpoint(endpoint)
+		if err != nil {
+			return fmt.Errorf("endpoints[%d]: %w", i, err)
+		}
+		parsed, err := url.Parse(resolved.URL)
+		if err != nil || parsed.Hostname() == "" {
+			return fmt.Errorf("endpoints[%d]: invalid URL %q", i, resolved.URL)
+		}
+		hosts[targetAddress(parsed)] = true
+	}
+
+	addresses := make([]string, 0, len(hosts))
+	for address := range hosts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		if err := a.warmUpHost(address); err != nil {
+			ack.Warnings = append(ack.Warnings, fmt.Sprintf("%s: %v", address, err))
+		}
+	}
+
+	a.mu.Lock()
+	a.currentTestRunID = command.TestRunID
+	a.currentPlan = &plan // Phase-orchestrated runs execute from the prepared plan
+	a.mu.Unlock()
+
+	// Phase-orchestrated runs report against the run from here on
+	a.resetMetrics(command.TestRunID)
+	return nil
+}
+
+// targetAddress returns the host:port a URL connects to
+func targetAddress(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// warmUpHost resolves and connects to a target so DNS and routes are warm
+// before the first request. The connection is checked like the test's own.
+func (a *Agent) warmUpHost(address string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), warmUpTimeout)
+	defer cancel()
+
+	conn, err := a.dialTarget(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// respondAck replies to a command sent as a request. Commands that were
+// published have no reply subject and get no acknowledgement.
+func (a *Agent) respondAck(msg *nats.Msg, ack CommandAck) {
+	if msg.Reply == "" {
+		return
+	}
+
+	// Errors may quote resolved URLs
+	ack.Error = redactSecrets(ack.Error)
+	for i, warning := range ack.Warnings {
+		ack.Warnings[i] = redactSecrets(warning)
+	}
+
+	data, err := json.Marshal(ack)
+	if err != nil {
+		LogError("Failed to marshal %s acknowledgement: %v", ack.Command, err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		LogError("Failed to send %s acknowledgement: %v", ack.Command, err)
+	}
+}
+
+// parseStartTime returns the common start time of a START command, or the
+// zero time to start straight away
+func parseStartTime(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	startAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		LogWarn("Invalid start time %q, starting now", value)
+		return time.Time{}
+	}
+	return startAt
+}
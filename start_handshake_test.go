@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendStartCommandsExcludesAgentsThatRefuse(t *testing.T) {
+	tests := []struct {
+		name       string
+		refuse     map[string]string
+		wantErr    string
+		wantShares map[string]int // Final allocation of the agents that started
+		wantLast   map[string]string
+	}{
+		{
+			name:       "every agent accepts",
+			wantShares: map[string]int{"a1": 2, "a2": 2, "a3": 2},
+			wantLast:   map[string]string{"a1": "START", "a2": "START", "a3": "START"},
+		},
+		{
+			name:       "one agent refuses",
+			refuse:     map[string]string{"a2": "refused by the safety policy"},
+			wantShares: map[string]int{"a1": 3, "a3": 3},
+			wantLast:   map[string]string{"a1": "REBALANCE", "a2": "STOP", "a3": "REBALANCE"},
+		},
+		{
+			name:    "every agent refuses",
+			refuse:  map[string]string{"a1": "busy", "a2": "busy", "a3": "busy"},
+			wantErr: "no agents acknowledged the START command",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, commands := testCommandCoordinator(t, tt.refuse)
+
+			testRun := &TestRun{
+				ID:       "r1",
+				Name:     "handshake",
+				Status:   TestRunStatusRunning,
+				TestPlan: TestPlan{Duration: "1m", Concurrency: 6, Endpoints: []Endpoint{{Method: "GET", URL: "http://127.0.0.1/"}}},
+			}
+			allocations := []AgentAllocation{
+				{AgentID: "a1", Concurrency: 2},
+				{AgentID: "a2", Concurrency: 2},
+				{AgentID: "a3", Concurrency: 2},
+			}
+			testRun.setAllocation(allocations)
+
+			c.mu.Lock()
+			run := c.activateTestRun(testRun)
+			for _, allocation := range allocations {
+				c.connectedAgents[allocation.AgentID] = &AgentInfo{ID: allocation.AgentID, Concurrency: 10}
+				c.reserveAgent(run, allocation.AgentID)
+				run.participants[allocation.AgentID] = true
+			}
+			c.mu.Unlock()
+
+			err := c.sendStartCommands(testRun, allocations, time.Now().Add(startLeadTime))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("sendStartCommands error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sendStartCommands: %v", err)
+			}
+
+			if got := receiveCommands(commands); len(got) != len(tt.wantLast) || got["a1"] != tt.wantLast["a1"] ||
+				got["a2"] != tt.wantLast["a2"] || got["a3"] != tt.wantLast["a3"] {
+				t.Errorf("last commands = %v, want %v", got, tt.wantLast)
+			}
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			shares := make(map[string]int)
+			for _, allocation := range testRun.Allocation {
+				if allocation.Concurrency > 0 {
+					shares[allocation.AgentID] = allocation.Concurrency
+				}
+			}
+			if len(shares) != len(tt.wantShares) || len(run.participants) != len(tt.wantShares) || len(run.agents) != len(tt.wantShares) {
+				t.Errorf("allocation %v, participants %v, reserved %v; want %v", shares, run.participants, run.agents, tt.wantShares)
+			}
+			for agentID, want := range tt.wantShares {
+				if shares[agentID] != want || !run.participants[agentID] || !run.agents[agentID] {
+					t.Errorf("agent %s: %d workers, participant %t, reserved %t; want %d workers in the run",
+						agentID, shares[agentID], run.participants[agentID], run.agents[agentID], want)
+				}
+			}
+		})
+	}
+}
+
+func TestStartLeadTimeCoversTheStartRoundTrip(t *testing.T) {
+	if startLeadTime <= commandAckTimeout {
+		t.Errorf("start lead time %s does not cover the %s START acknowledgement timeout", startLeadTime, commandAckTimeout)
+	}
+}
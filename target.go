@@ -0,0 +1,509 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// TargetConfig configures the built-in target server used to calibrate
+// agents and to exercise test plans without a real service
+type TargetConfig struct {
+	Listen string        `yaml:"listen" json:"listen"`
+	Routes []TargetRoute `yaml:"routes" json:"routes"`
+}
+
+type TargetRoute struct {
+	Path         string            `yaml:"path" json:"path"`                                       // Exact path, or prefix when ending in "/*"
+	Method       string            `yaml:"method,omitempty" json:"method,omitempty"`               // Empty matches any method
+	Latency      *TargetLatency    `yaml:"latency,omitempty" json:"latency,omitempty"`             // Artificial response latency
+	ErrorRate    float64           `yaml:"error_rate,omitempty" json:"error_rate,omitempty"`       // Fraction of requests answered with ErrorStatus
+	ErrorStatus  int               `yaml:"error_status,omitempty" json:"error_status,omitempty"`   // Defaults to 500
+	StatusCodes  map[int]int       `yaml:"status_codes,omitempty" json:"status_codes,omitempty"`   // Weighted status code mix
+	ResponseSize int               `yaml:"response_size,omitempty" json:"response_size,omitempty"` // Response body size in bytes
+	Echo         bool              `yaml:"echo,omitempty" json:"echo,omitempty"`                   // Echo the request back as JSON
+	Auth         *TargetAuth       `yaml:"auth,omitempty" json:"auth,omitempty"`                   // Simulated authentication
+	Headers      map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`             // Extra response headers
+}
+
+type TargetLatency struct {
+	Distribution string `yaml:"distribution" json:"distribution"` // fixed, uniform, normal, exponential
+	Fixed        string `yaml:"fixed,omitempty" json:"fixed,omitempty"`
+	Min          string `yaml:"min,omitempty" json:"min,omitempty"`
+	Max          string `yaml:"max,omitempty" json:"max,omitempty"`
+	Mean         string `yaml:"mean,omitempty" json:"mean,omitempty"`
+	StdDev       string `yaml:"stddev,omitempty" json:"stddev,omitempty"`
+
+	fixed, min, max, mean, stddev time.Duration
+}
+
+type TargetAuth struct {
+	Type     string `yaml:"type" json:"type"` // bearer, basic, api_key
+	Token    string `yaml:"token,omitempty" json:"token,omitempty"`
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password string `yaml:"password,omitempty" json:"password,omitempty"`
+	Header   string `yaml:"header,omitempty" json:"header,omitempty"` // api_key header, defaults to X-API-Key
+}
+
+// TargetStats are the counters exposed on /_target/stats
+type TargetStats struct {
+	StartedAt      time.Time                    `json:"started_at"`
+	TotalRequests  int64                        `json:"total_requests"`
+	InjectedErrors int64                        `json:"injected_errors"`
+	AuthFailures   int64                        `json:"auth_failures"`
+	NotFound       int64                        `json:"not_found"`
+	BytesSent      int64                        `json:"bytes_sent"`
+	StatusCodes    map[string]int64             `json:"status_codes"`
+	Routes         map[string]*TargetRouteStats `json:"routes"`
+}
+
+type TargetRouteStats struct {
+	Requests       int64   `json:"requests"`
+	InjectedErrors int64   `json:"injected_errors"`
+	AuthFailures   int64   `json:"auth_failures"`
+	TotalLatencyMs float64 `json:"total_latency_ms"`
+}
+
+// TargetServer is an http.Handler serving the configured routes
+type TargetServer struct {
+	config TargetConfig
+	mu     sync.Mutex
+	stats  TargetStats
+	rng    *rand.Rand
+}
+
+// DefaultTargetConfig returns a target with a plain OK route and an echo route
+func DefaultTargetConfig() TargetConfig {
+	return TargetConfig{
+		Listen: ":9090",
+		Routes: []TargetRoute{
+			{Path: "/echo", Echo: true},
+			{Path: "/*"},
+		},
+	}
+}
+
+// LoadTargetConfig reads and validates a target server YAML file
+func LoadTargetConfig(path string) (*TargetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target config %s: %w", path, err)
+	}
+
+	config := DefaultTargetConfig()
+	config.Routes = nil
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse target config %s: %w", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid target config: %w", err)
+	}
+	return &config, nil
+}
+
+// Validate checks the target config and parses latency durations in place
+func (tc *TargetConfig) Validate() error {
+	if len(tc.Routes) == 0 {
+		return fmt.Errorf("at least one route is required")
+	}
+
+	for i := range tc.Routes {
+		route := &tc.Routes[i]
+		if !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("routes[%d].path must start with /", i)
+		}
+		if strings.HasPrefix(route.Path, "/_target/") {
+			return fmt.Errorf("routes[%d].path: /_target/ is reserved", i)
+		}
+		if route.ErrorRate < 0 || route.ErrorRate > 1 {
+			return fmt.Errorf("routes[%d].error_rate must be between 0 and 1", i)
+		}
+		if route.ErrorStatus == 0 {
+			route.ErrorStatus = http.StatusInternalServerError
+		}
+		if route.ResponseSize < 0 {
+			return fmt.Errorf("routes[%d].response_size must not be negative", i)
+		}
+		for code, weight := range route.StatusCodes {
+			if code < 100 || code > 599 {
+				return fmt.Errorf("routes[%d].status_codes: invalid status code %d", i, code)
+			}
+			if weight < 0 {
+				return fmt.Errorf("routes[%d].status_codes: weight for %d must not be negative", i, code)
+			}
+		}
+		if route.Latency != nil {
+			if err := route.Latency.parse(); err != nil {
+				return fmt.Errorf("routes[%d].latency: %w", i, err)
+			}
+		}
+		if route.Auth != nil {
+			switch route.Auth.Type {
+			case "bearer", "api_key":
+				if route.Auth.Token == "" {
+					return fmt.Errorf("routes[%d].auth.token is required for %s auth", i, route.Auth.Type)
+				}
+				if route.Auth.Type == "api_key" && route.Auth.Header == "" {
+					route.Auth.Header = "X-API-Key"
+				}
+			case "basic":
+				if route.Auth.Username == "" {
+					return fmt.Errorf("routes[%d].auth.username is required for basic auth", i)
+				}
+			default:
+				return fmt.Errorf("routes[%d].auth.type must be bearer, basic or api_key", i)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *TargetLatency) parse() error {
+	parse := func(name, value string, target *time.Duration) error {
+		if value == "" {
+			return fmt.Errorf("%s is required for %s distribution", name, l.Distribution)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", name, value, err)
+		}
+		*target = d
+		return nil
+	}
+
+	switch l.Distribution {
+	case "fixed", "":
+		l.Distribution = "fixed"
+		return parse("fixed", l.Fixed, &l.fixed)
+	case "uniform":
+		if err := parse("min", l.Min, &l.min); err != nil {
+			return err
+		}
+		if err := parse("max", l.Max, &l.max); err != nil {
+			return err
+		}
+		if l.max < l.min {
+			return fmt.Errorf("max must not be lower than min")
+		}
+	case "normal":
+		if err := parse("mean", l.Mean, &l.mean); err != nil {
+			return err
+		}
+		return parse("stddev", l.StdDev, &l.stddev)
+	case "exponential":
+		return parse("mean", l.Mean, &l.mean)
+	default:
+		return fmt.Errorf("unknown distribution %q", l.Distribution)
+	}
+	return nil
+}
+
+// NewTargetServer creates a target server from a validated config
+func NewTargetServer(config TargetConfig) *TargetServer {
+	return &TargetServer{
+		config: config,
+		stats:  newTargetStats(),
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func newTargetStats() TargetStats {
+	return TargetStats{
+		StartedAt:   time.Now().UTC(),
+		StatusCodes: make(map[string]int64),
+		Routes:      make(map[string]*TargetRouteStats),
+	}
+}
+
+func (ts *TargetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/_target/stats":
+		ts.handleStats(w, r)
+		return
+	case "/_target/reset":
+		ts.mu.Lock()
+		ts.stats = newTargetStats()
+		ts.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	route := ts.matchRoute(r)
+	if route == nil {
+		ts.record(nil, http.StatusNotFound, 0, 0, false, false)
+		http.NotFound(w, r)
+		return
+	}
+
+	start := time.Now()
+
+	// Latency and random draws happen under the lock since rand.Rand is not
+	// safe for concurrent use
+	ts.mu.Lock()
+	delay := ts.sampleLatency(route.Latency)
+	injectError := route.ErrorRate > 0 && ts.rng.Float64() < route.ErrorRate
+	status := ts.sampleStatus(route.StatusCodes)
+	ts.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	for key, value := range route.Headers {
+		w.Header().Set(key, value)
+	}
+
+	if route.Auth != nil && !checkTargetAuth(route.Auth, r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		ts.record(route, http.StatusUnauthorized, 0, time.Since(start), false, true)
+		return
+	}
+
+	if injectError {
+		status = route.ErrorStatus
+	}
+
+	var written int
+	if route.Echo {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		echo := map[string]interface{}{
+			"method":  r.Method,
+			"path":    r.URL.Path,
+			"query":   r.URL.Query(),
+			"headers": r.Header,
+			"body":    string(body),
+		}
+		data, _ := json.Marshal(echo)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		written, _ = w.Write(data)
+	} else {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(status)
+		if route.ResponseSize > 0 {
+			written, _ = w.Write(targetPayload(route.ResponseSize))
+		}
+	}
+
+	ts.record(route, status, written, time.Since(start), injectError, false)
+}
+
+func (ts *TargetServer) matchRoute(r *http.Request) *TargetRoute {
+	for i := range ts.config.Routes {
+		route := &ts.config.Routes[i]
+		if route.Method != "" && !strings.EqualFold(route.Method, r.Method) {
+			continue
+		}
+		if strings.HasSuffix(route.Path, "/*") {
+			if strings.HasPrefix(r.URL.Path, strings.TrimSuffix(route.Path, "*")) {
+				return route
+			}
+			continue
+		}
+		if route.Path == r.URL.Path {
+			return route
+		}
+	}
+	return nil
+}
+
+func (ts *TargetServer) sampleLatency(latency *TargetLatency) time.Duration {
+	if latency == nil {
+		return 0
+	}
+
+	var d time.Duration
+	switch latency.Distribution {
+	case "fixed":
+		d = latency.fixed
+	case "uniform":
+		d = latency.min + time.Duration(ts.rng.Int63n(int64(latency.max-latency.min)+1))
+	case "normal":
+		d = latency.mean + time.Duration(ts.rng.NormFloat64()*float64(latency.stddev))
+	case "exponential":
+		d = time.Duration(ts.rng.ExpFloat64() * float64(latency.mean))
+	}
+	return time.Duration(math.Max(0, float64(d)))
+}
+
+func (ts *TargetServer) sampleStatus(weights map[int]int) int {
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total == 0 {
+		return http.StatusOK
+	}
+
+	// Iterate in a stable order so a seeded generator is reproducible
+	codes := make([]int, 0, len(weights))
+	for code := range weights {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	pick := ts.rng.Intn(total)
+	for _, code := range codes {
+		pick -= weights[code]
+		if pick < 0 {
+			return code
+		}
+	}
+	return http.StatusOK
+}
+
+func checkTargetAuth(auth *TargetAuth, r *http.Request) bool {
+	equal := func(a, b string) bool {
+		return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+	}
+
+	switch auth.Type {
+	case "bearer":
+		return equal(r.Header.Get("Authorization"), "Bearer "+auth.Token)
+	case "api_key":
+		return equal(r.Header.Get(auth.Header), auth.Token)
+	case "basic":
+		username, password, ok := r.BasicAuth()
+		return ok && equal(username, auth.Username) && equal(password, auth.Password)
+	}
+	return false
+}
+
+func (ts *TargetServer) record(route *TargetRoute, status, bytes int, latency time.Duration, injected, authFailed bool) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.stats.TotalRequests++
+	ts.stats.BytesSent += int64(bytes)
+	ts.stats.StatusCodes[fmt.Sprintf("%d", status)]++
+
+	if route == nil {
+		ts.stats.NotFound++
+		return
+	}
+
+	key := route.Path
+	if route.Method != "" {
+		key = strings.ToUpper(route.Method) + " " + route.Path
+	}
+	routeStats, exists := ts.stats.Routes[key]
+	if !exists {
+		routeStats = &TargetRouteStats{}
+		ts.stats.Routes[key] = routeStats
+	}
+
+	routeStats.Requests++
+	routeStats.TotalLatencyMs += float64(latency.Nanoseconds()) / 1e6
+	if injected {
+		ts.stats.InjectedErrors++
+		routeStats.InjectedErrors++
+	}
+	if authFailed {
+		ts.stats.AuthFailures++
+		routeStats.AuthFailures++
+	}
+}
+
+func (ts *TargetServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	ts.mu.Lock()
+	data, err := json.MarshalIndent(ts.stats, "", "  ")
+	ts.mu.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Stats returns a copy of the current counters
+func (ts *TargetServer) Stats() TargetStats {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	stats := ts.stats
+	stats.StatusCodes = make(map[string]int64, len(ts.stats.StatusCodes))
+	for code, count := range ts.stats.StatusCodes {
+		stats.StatusCodes[code] = count
+	}
+	stats.Routes = make(map[string]*TargetRouteStats, len(ts.stats.Routes))
+	for key, routeStats := range ts.stats.Routes {
+		copied := *routeStats
+		stats.Routes[key] = &copied
+	}
+	return stats
+}
+
+var targetPayloadCache sync.Map // size -> []byte
+
+func targetPayload(size int) []byte {
+	if cached, ok := targetPayloadCache.Load(size); ok {
+		return cached.([]byte)
+	}
+	payload := []byte(strings.Repeat("x", size))
+	targetPayloadCache.Store(size, payload)
+	return payload
+}
+
+func runTarget(cmd *cobra.Command, args []string) error {
+	config := DefaultTargetConfig()
+
+	if file, _ := cmd.Flags().GetString("file"); file != "" {
+		loaded, err := LoadTargetConfig(file)
+		if err != nil {
+			return err
+		}
+		config = *loaded
+	} else if err := config.Validate(); err != nil {
+		return err
+	}
+
+	if listen, _ := cmd.Flags().GetString("listen"); listen != "" {
+		config.Listen = listen
+	}
+	if config.Listen == "" {
+		config.Listen = ":9090"
+	}
+
+	target := NewTargetServer(config)
+	server := &http.Server{
+		Addr:        config.Listen,
+		Handler:     target,
+		IdleTimeout: 120 * time.Second,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			LogError("Target server failed: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	LogInfo("Target server listening on %s with %d routes", config.Listen, len(config.Routes))
+	LogInfo("Counters available at /_target/stats (reset with /_target/reset)")
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	<-sigChan
+
+	LogInfo("Shutting down target server...")
+	return server.Close()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// TelemetryDelta is what an agent measured over one reporting interval.
+// Agents send one per interval while they have a run, including empty ones,
+// so the coordinator can tell a quiet interval from a lost one.
+type TelemetryDelta struct {
+	AgentID        string            `json:"agent_id"`
+	TestRunID      string            `json:"test_run_id"`
+	SessionID      string            `json:"session_id"` // Changes when the agent process restarts
+	Seq            uint64            `json:"seq"`        // 1 for a run's first interval, then one more each interval
+	Timestamp      string            `json:"timestamp"`
+	Requests       int64             `json:"requests"`
+	Errors         int64             `json:"errors"`
+	TotalLatencyMs float64           `json:"total_latency_ms"`
+	MinLatencyMs   float64           `json:"min_latency_ms"`
+	MaxLatencyMs   float64           `json:"max_latency_ms"`
+	StatusCodes    map[string]int64  `json:"status_codes"`
+	Latency        *LatencyHistogram `json:"latency_histogram,omitempty"`
+}
+
+// newTelemetryDelta starts an empty reporting interval
+func newTelemetryDelta() *TelemetryDelta {
+	return &TelemetryDelta{
+		StatusCodes: make(map[string]int64),
+		Latency:     NewLatencyHistogram(),
+	}
+}
+
+// recordRequest adds a completed request to the interval
+func (d *TelemetryDelta) recordRequest(status string, latency time.Duration, latencyMs float64) {
+	d.Requests++
+	d.TotalLatencyMs += latencyMs
+	if d.Requests == 1 || latencyMs < d.MinLatencyMs {
+		d.MinLatencyMs = latencyMs
+	}
+	if latencyMs > d.MaxLatencyMs {
+		d.MaxLatencyMs = latencyMs
+	}
+	d.StatusCodes[status]++
+	d.Latency.Record(latency)
+}
+
+// telemetrySession accumulates the intervals one agent process reported for
+// a run. Each sequence number is counted once, however often it arrives.
+type telemetrySession struct {
+	received map[uint64]bool
+	lastSeq  uint64 // Highest interval received or, once final, reported
+	final    bool   // Totals come from the final snapshot
+
+	requests       int64
+	errors         int64
+	totalLatencyMs float64
+	minLatencyMs   float64
+	maxLatencyMs   float64
+	statusCodes    map[string]int64
+	latency        *LatencyHistogram
+}
+
+// agentTelemetry is everything an agent reported for a run, by session
+type agentTelemetry struct {
+	sessions map[string]*telemetrySession
+	final    bool
+}
+
+func newAgentTelemetry() *agentTelemetry {
+	return &agentTelemetry{sessions: make(map[string]*telemetrySession)}
+}
+
+// session returns the accumulator for a session, creating it on first use
+func (t *agentTelemetry) session(sessionID string) (*telemetrySession, bool) {
+	if session, exists := t.sessions[sessionID]; exists {
+		return session, false
+	}
+	session := &telemetrySession{
+		received:    make(map[uint64]bool),
+		statusCodes: make(map[string]int64),
+		latency:     NewLatencyHistogram(),
+	}
+	t.sessions[sessionID] = session
+	return session, true
+}
+
+// apply adds an interval to the session. It returns the number of intervals
+// skipped before this one, and false for an interval already counted.
+// Intervals arriving after the final snapshot are already in its totals.
+func (s *telemetrySession) apply(delta *TelemetryDelta) (uint64, bool) {
+	if delta.Seq == 0 || s.received[delta.Seq] {
+		return 0, false
+	}
+	s.received[delta.Seq] = true
+	if s.final {
+		return 0, true
+	}
+
+	var skipped uint64
+	if delta.Seq > s.lastSeq+1 {
+		skipped = delta.Seq - s.lastSeq - 1
+	}
+	if delta.Seq > s.lastSeq {
+		s.lastSeq = delta.Seq
+	}
+
+	if delta.Requests > 0 {
+		if s.requests == 0 || delta.MinLatencyMs < s.minLatencyMs {
+			s.minLatencyMs = delta.MinLatencyMs
+		}
+		if delta.MaxLatencyMs > s.maxLatencyMs {
+			s.maxLatencyMs = delta.MaxLatencyMs
+		}
+	}
+	s.requests += delta.Requests
+	s.errors += delta.Errors
+	s.totalLatencyMs += delta.TotalLatencyMs
+	for code, count := range delta.StatusCodes {
+		s.statusCodes[code] += count
+	}
+	s.latency.Merge(delta.Latency)
+	return skipped, true
+}
+
+// reconcile replaces the session's totals with the agent's final snapshot and
+// reports whether the intervals received added up to it
+func (s *telemetrySession) reconcile(metrics *AgentMetrics) bool {
+	matched := s.requests == metrics.Requests && s.errors == metrics.Errors
+
+	s.final = true
+	if metrics.Seq > s.lastSeq {
+		s.lastSeq = metrics.Seq
+	}
+	s.requests = metrics.Requests
+	s.errors = metrics.Errors
+	s.totalLatencyMs = metrics.AvgLatencyMs * float64(metrics.Requests)
+	s.minLatencyMs = metrics.MinLatencyMs
+	s.maxLatencyMs = metrics.MaxLatencyMs
+	s.statusCodes = make(map[string]int64, len(metrics.StatusCodes))
+	for code, count := range metrics.StatusCodes {
+		s.statusCodes[code] = count
+	}
+	s.latency = NewLatencyHistogram()
+	s.latency.Merge(metrics.Latency)
+	return matched
+}
+
+// missingIntervals counts the intervals up to the last one known of that
+// never arrived
+func (s *telemetrySession) missingIntervals() int {
+	missing := int(s.lastSeq)
+	for seq := range s.received {
+		if seq <= s.lastSeq {
+			missing--
+		}
+	}
+	return missing
+}
+
+// result sums the agent's sessions into its run result
+func (t *agentTelemetry) result(agentID, region string) AgentResult {
+	result := AgentResult{
+		AgentID:     agentID,
+		Region:      region,
+		StatusCodes: make(map[string]int64),
+		Latency:     NewLatencyHistogram(),
+	}
+
+	var totalLatencyMs float64
+	for _, session := range t.sessions {
+		if session.requests > 0 {
+			if result.Requests == 0 || session.minLatencyMs < result.MinLatencyMs {
+				result.MinLatencyMs = session.minLatencyMs
+			}
+			if session.maxLatencyMs > result.MaxLatencyMs {
+				result.MaxLatencyMs = session.maxLatencyMs
+			}
+		}
+		result.Requests += session.requests
+		result.Errors += session.errors
+		totalLatencyMs += session.totalLatencyMs
+		for code, count := range session.statusCodes {
+			result.StatusCodes[code] += count
+		}
+		result.Latency.Merge(session.latency)
+		result.MissingIntervals += session.missingIntervals()
+	}
+	if result.Requests > 0 {
+		result.AvgLatencyMs = totalLatencyMs / float64(result.Requests)
+	}
+	return result
+}
+
+// handleTelemetryDelta passes an interval to the internal handler, which
+// owns the run totals
+func (c *Coordinator) handleTelemetryDelta(delta *TelemetryDelta) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":  "telemetry_delta",
+		"delta": delta,
+	})
+	if err != nil {
+		LogError("Failed to marshal telemetry delta: %v", err)
+		return
+	}
+
+	c.natsConn.Publish("armonite.coordinator.internal", data)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testDelta builds an interval with requests at the given latencies in
+// milliseconds and one error
+func testDelta(seq uint64, latenciesMs ...float64) *TelemetryDelta {
+	delta := newTelemetryDelta()
+	delta.Seq = seq
+	for _, ms := range latenciesMs {
+		delta.recordRequest("200", time.Duration(ms*float64(time.Millisecond)), ms)
+	}
+	delta.Errors = 1
+	return delta
+}
+
+func TestTelemetrySessionApply(t *testing.T) {
+	type step struct {
+		delta       *TelemetryDelta
+		wantSkipped uint64
+		wantApplied bool
+	}
+
+	tests := []struct {
+		name         string
+		steps        []step
+		wantRequests int64
+		wantErrors   int64
+		wantMin      float64
+		wantMax      float64
+		wantLastSeq  uint64
+		wantMissing  int
+	}{
+		{
+			name: "in order",
+			steps: []step{
+				{testDelta(1, 10, 20), 0, true},
+				{testDelta(2, 5), 0, true},
+				{testDelta(3), 0, true},
+			},
+			wantRequests: 3, wantErrors: 3, wantMin: 5, wantMax: 20, wantLastSeq: 3,
+		},
+		{
+			name: "duplicate sequence is counted once",
+			steps: []step{
+				{testDelta(1, 10), 0, true},
+				{testDelta(2, 20), 0, true},
+				{testDelta(2, 20), 0, false},
+				{testDelta(1, 10), 0, false},
+			},
+			wantRequests: 2, wantErrors: 2, wantMin: 10, wantMax: 20, wantLastSeq: 2,
+		},
+		{
+			name: "gap is reported and counted missing",
+			steps: []step{
+				{testDelta(1, 10), 0, true},
+				{testDelta(4, 20), 2, true},
+			},
+			wantRequests: 2, wantErrors: 2, wantMin: 10, wantMax: 20, wantLastSeq: 4, wantMissing: 2,
+		},
+		{
+			name: "out of order fills the gap",
+			steps: []step{
+				{testDelta(1, 10), 0, true},
+				{testDelta(3, 30), 1, true},
+				{testDelta(2, 5), 0, true},
+			},
+			wantRequests: 3, wantErrors: 3, wantMin: 5, wantMax: 30, wantLastSeq: 3,
+		},
+		{
+			name: "first interval lost",
+			steps: []step{
+				{testDelta(2, 10), 1, true},
+			},
+			wantRequests: 1, wantErrors: 1, wantMin: 10, wantMax: 10, wantLastSeq: 2, wantMissing: 1,
+		},
+		{
+			name: "sequence zero is ignored",
+			steps: []step{
+				{testDelta(0, 10), 0, false},
+			},
+		},
+		{
+			name: "empty interval keeps the latency range",
+			steps: []step{
+				{testDelta(1, 10, 20), 0, true},
+				{testDelta(2), 0, true},
+			},
+			wantRequests: 2, wantErrors: 2, wantMin: 10, wantMax: 20, wantLastSeq: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, _ := newAgentTelemetry().session("s1")
+			for i, step := range tt.steps {
+				skipped, applied := session.apply(step.delta)
+				if skipped != step.wantSkipped || applied != step.wantApplied {
+					t.Errorf("step %d: apply = (%d, %t), want (%d, %t)",
+						i, skipped, applied, step.wantSkipped, step.wantApplied)
+				}
+			}
+
+			if session.requests != tt.wantRequests || session.errors != tt.wantErrors {
+				t.Errorf("requests, errors = %d, %d; want %d, %d", session.requests, session.errors, tt.wantRequests, tt.wantErrors)
+			}
+			if session.minLatencyMs != tt.wantMin || session.maxLatencyMs != tt.wantMax {
+				t.Errorf("latency range = %v-%v, want %v-%v", session.minLatencyMs, session.maxLatencyMs, tt.wantMin, tt.wantMax)
+			}
+			if session.latency.Total != tt.wantRequests {
+				t.Errorf("histogram holds %d observations, want %d", session.latency.Total, tt.wantRequests)
+			}
+			if session.lastSeq != tt.wantLastSeq {
+				t.Errorf("lastSeq = %d, want %d", session.lastSeq, tt.wantLastSeq)
+			}
+			if missing := session.missingIntervals(); missing != tt.wantMissing {
+				t.Errorf("missingIntervals = %d, want %d", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+// testSnapshot builds a final snapshot as an agent sends it
+func testSnapshot(seq uint64, requests, errors int64) *AgentMetrics {
+	latency := NewLatencyHistogram()
+	for i := int64(0); i < requests; i++ {
+		latency.Record(15 * time.Millisecond)
+	}
+	return &AgentMetrics{
+		Requests:     requests,
+		Errors:       errors,
+		AvgLatencyMs: 15,
+		MinLatencyMs: 1,
+		MaxLatencyMs: 99,
+		StatusCodes:  map[string]int64{"200": requests},
+		Latency:      latency,
+		Final:        true,
+		Seq:          seq,
+	}
+}
+
+func TestTelemetrySessionReconcile(t *testing.T) {
+	tests := []struct {
+		name         string
+		deltas       []*TelemetryDelta
+		snapshot     *AgentMetrics
+		wantMatched  bool
+		wantRequests int64
+		wantMissing  int
+	}{
+		{
+			name:         "intervals add up to the snapshot",
+			deltas:       []*TelemetryDelta{testDelta(1, 10), testDelta(2, 20)},
+			snapshot:     testSnapshot(2, 2, 2),
+			wantMatched:  true,
+			wantRequests: 2,
+		},
+		{
+			name:         "gap followed by the final snapshot",
+			deltas:       []*TelemetryDelta{testDelta(1, 10), testDelta(3, 30)},
+			snapshot:     testSnapshot(4, 5, 4),
+			wantRequests: 5,
+			wantMissing:  2, // Intervals 2 and 4
+		},
+		{
+			name:         "final snapshot without any intervals",
+			snapshot:     testSnapshot(3, 7, 1),
+			wantRequests: 7,
+			wantMissing:  3,
+		},
+		{
+			name:        "final snapshot of an idle agent",
+			snapshot:    testSnapshot(0, 0, 0),
+			wantMatched: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, _ := newAgentTelemetry().session("s1")
+			for _, delta := range tt.deltas {
+				session.apply(delta)
+			}
+
+			if matched := session.reconcile(tt.snapshot); matched != tt.wantMatched {
+				t.Errorf("reconcile = %t, want %t", matched, tt.wantMatched)
+			}
+			if session.requests != tt.wantRequests || session.errors != tt.snapshot.Errors {
+				t.Errorf("requests, errors = %d, %d; want the snapshot's %d, %d",
+					session.requests, session.errors, tt.wantRequests, tt.snapshot.Errors)
+			}
+			if session.minLatencyMs != 1 || session.maxLatencyMs != 99 {
+				t.Errorf("latency range = %v-%v, want the snapshot's 1-99", session.minLatencyMs, session.maxLatencyMs)
+			}
+			if session.latency.Total != tt.wantRequests {
+				t.Errorf("histogram holds %d observations, want %d", session.latency.Total, tt.wantRequests)
+			}
+			if missing := session.missingIntervals(); missing != tt.wantMissing {
+				t.Errorf("missingIntervals = %d, want %d", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestTelemetrySessionAfterFinal(t *testing.T) {
+	session, _ := newAgentTelemetry().session("s1")
+	session.apply(testDelta(1, 10))
+	session.reconcile(testSnapshot(2, 3, 2))
+
+	// A late interval is already in the snapshot's totals but is no longer missing
+	skipped, applied := session.apply(testDelta(2, 20))
+	if skipped != 0 || !applied {
+		t.Errorf("apply after final = (%d, %t), want (0, true)", skipped, applied)
+	}
+	if session.requests != 3 || session.errors != 2 {
+		t.Errorf("requests, errors = %d, %d; want the snapshot's 3, 2", session.requests, session.errors)
+	}
+	if missing := session.missingIntervals(); missing != 0 {
+		t.Errorf("missingIntervals = %d, want 0", missing)
+	}
+
+	// A duplicate after the final snapshot changes nothing either
+	if _, applied := session.apply(testDelta(2, 20)); applied {
+		t.Error("duplicate interval after final was applied")
+	}
+}
+
+func TestAgentTelemetryResult(t *testing.T) {
+	telemetry := newAgentTelemetry()
+
+	// The agent restarted: its first process reported two intervals, the
+	// second one interval and its final snapshot
+	first, created := telemetry.session("s1")
+	if !created {
+		t.Fatal("first session was not created")
+	}
+	first.apply(testDelta(1, 10))
+	first.apply(testDelta(3, 30))
+	second, _ := telemetry.session("s2")
+	second.apply(testDelta(1, 5))
+	second.reconcile(testSnapshot(1, 1, 1))
+
+	if again, created := telemetry.session("s1"); created || again != first {
+		t.Error("session lookup created a second accumulator for s1")
+	}
+
+	result := telemetry.result("agent-1", "eu")
+	if result.Requests != 3 || result.Errors != 3 {
+		t.Errorf("requests, errors = %d, %d; want 3, 3", result.Requests, result.Errors)
+	}
+	if result.MinLatencyMs != 1 || result.MaxLatencyMs != 99 {
+		t.Errorf("latency range = %v-%v, want 1-99", result.MinLatencyMs, result.MaxLatencyMs)
+	}
+	if result.MissingIntervals != 1 {
+		t.Errorf("MissingIntervals = %d, want 1", result.MissingIntervals)
+	}
+	if result.StatusCodes["200"] != 3 || result.Latency.Total != 3 {
+		t.Errorf("status codes %v and histogram total %d, want 3 of each", result.StatusCodes, result.Latency.Total)
+	}
+}
@@ -18,6 +18,31 @@ type TestRun struct {
 	Results     *TestRunResults        `json:"results,omitempty"`
 	AgentCount  int                    `json:"agent_count"`
 	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Selector    *AgentSelector         `json:"selector,omitempty"`    // Restricts which agents take part
+	Warnings    []string               `json:"warnings,omitempty"`    // Capacity and load generator warnings
+	Allocation  []AgentAllocation      `json:"allocation,omitempty"`  // Effective per-agent share of the plan load
+	Events      []TestRunEvent         `json:"events,omitempty"`      // Notable changes during the run, such as rebalances
+	ScheduleID  string                 `json:"schedule_id,omitempty"` // Schedule that created the run, if any
+
+	// Queueing, for runs started with queue: true
+	Priority      int        `json:"priority,omitempty"`       // Higher priorities start first
+	QueuedAt      *time.Time `json:"queued_at,omitempty"`      // When the run joined the queue
+	QueueDeadline *time.Time `json:"queue_deadline,omitempty"` // Cancel the run if it has not started by then
+
+	AgentsUsed    int    `json:"agents_used"`              // Most agents generating load at once, against agent_count requested
+	FailureReason string `json:"failure_reason,omitempty"` // Why a failed run failed, such as a wait timeout
+	Confirmed     bool   `json:"confirmed,omitempty"`      // Created with confirm: true, allowing load above the safety policy's confirmation limits
+
+	runningSince time.Time // When agents were told to start, for remaining-duration calculations
+}
+
+// TestRunEvent records something that changed while a test run was in progress
+type TestRunEvent struct {
+	Time       time.Time         `json:"time"`
+	Type       string            `json:"type"`
+	AgentID    string            `json:"agent_id,omitempty"`
+	Message    string            `json:"message"`
+	Allocation []AgentAllocation `json:"allocation,omitempty"`
 }
 
 type TestRunStatus string
@@ -25,6 +50,7 @@ type TestRunStatus string
 const (
 	TestRunStatusCreated    TestRunStatus = "created"
 	TestRunStatusWaiting    TestRunStatus = "waiting_for_agents"
+	TestRunStatusQueued     TestRunStatus = "queued"
 	TestRunStatusRunning    TestRunStatus = "running"
 	TestRunStatusCompleting TestRunStatus = "completing"
 	TestRunStatusCompleted  TestRunStatus = "completed"
@@ -42,6 +68,21 @@ type TestRunResults struct {
 	RequestsPerSec float64          `json:"requests_per_sec"`
 	StatusCodes    map[string]int64 `json:"status_codes"`
 	AgentResults   []AgentResult    `json:"agent_results"`
+	Regions        []RegionResult   `json:"regions,omitempty"` // Per-region aggregates with latency percentiles
+
+	// Suspect is set when a load generator was saturated during the run
+	Suspect         bool     `json:"suspect,omitempty"`
+	SaturatedAgents []string `json:"saturated_agents,omitempty"`
+
+	// Agents that took part but never sent their final metrics
+	MissingAgents []string `json:"missing_agents,omitempty"`
+
+	// Telemetry intervals lost across all agents
+	MissingIntervals int `json:"missing_intervals,omitempty"`
+
+	// Agents asked for with min_agents, and the most that generated load
+	AgentsRequested int `json:"agents_requested"`
+	AgentsUsed      int `json:"agents_used"`
 }
 
 type CreateTestRunRequest struct {
@@ -49,10 +90,21 @@ type CreateTestRunRequest struct {
 	TestPlan   TestPlan               `json:"test_plan"`
 	MinAgents  int                    `json:"min_agents"`
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Selector   *AgentSelector         `json:"selector,omitempty"`
+
+	// Override the plan's wait_timeout and start_with_available
+	WaitTimeout        string `json:"wait_timeout,omitempty"`
+	StartWithAvailable bool   `json:"start_with_available,omitempty"`
+
+	// Allow load above the safety policy's confirmation limits
+	Confirm bool `json:"confirm,omitempty"`
 }
 
 type StartTestRunRequest struct {
-	TestRunID string `json:"test_run_id"`
+	TestRunID string     `json:"test_run_id"`
+	Queue     bool       `json:"queue,omitempty"`    // Queue the run when its agents are busy instead of failing
+	Priority  int        `json:"priority,omitempty"` // Queue priority, higher first
+	Deadline  *time.Time `json:"deadline,omitempty"` // Cancel a queued run not started by then
 }
 
 func NewTestRun(name string, testPlan TestPlan, minAgents int, parameters map[string]interface{}) *TestRun {
@@ -73,8 +125,52 @@ func (tr *TestRun) Start() {
 	tr.Status = TestRunStatusWaiting
 }
 
+// Enqueue puts the run in the start queue
+func (tr *TestRun) Enqueue(priority int, deadline *time.Time) {
+	now := time.Now()
+	tr.QueuedAt = &now
+	tr.Priority = priority
+	tr.QueueDeadline = deadline
+	tr.Status = TestRunStatusQueued
+}
+
 func (tr *TestRun) MarkRunning() {
+	now := time.Now()
+	if tr.StartedAt == nil {
+		tr.StartedAt = &now
+	}
 	tr.Status = TestRunStatusRunning
+	tr.runningSince = now
+}
+
+// IsActive reports whether the run is running or waiting to run
+func (tr *TestRun) IsActive() bool {
+	switch tr.Status {
+	case TestRunStatusRunning, TestRunStatusWaiting, TestRunStatusQueued:
+		return true
+	}
+	return false
+}
+
+// IsPending reports whether the run is waiting for agents or queued
+func (tr *TestRun) IsPending() bool {
+	return tr.Status == TestRunStatusWaiting || tr.Status == TestRunStatusQueued
+}
+
+// AddEvent appends an event to the run's history
+func (tr *TestRun) AddEvent(event TestRunEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	tr.Events = append(tr.Events, event)
+}
+
+// setAllocation records the run's load split and the most agents it has used
+func (tr *TestRun) setAllocation(allocations []AgentAllocation) {
+	tr.Allocation = allocations
+	if used := countAllocated(allocations); used > tr.AgentsUsed {
+		tr.AgentsUsed = used
+	}
 }
 
 func (tr *TestRun) Complete(results *TestRunResults) {
@@ -93,6 +189,7 @@ func (tr *TestRun) Fail(reason string) {
 	now := time.Now()
 	tr.CompletedAt = &now
 	tr.Status = TestRunStatusFailed
+	tr.FailureReason = reason
 
 	if tr.StartedAt != nil {
 		duration := now.Sub(*tr.StartedAt).String()
@@ -2,11 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,18 +30,36 @@ func (c *Coordinator) handleCreateTestRun(ctx *gin.Context) {
 		return
 	}
 
-	if len(req.TestPlan.Endpoints) == 0 {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Test plan must have at least one endpoint"})
-		return
-	}
-
 	// Set default min agents if not specified
 	if req.MinAgents == 0 {
 		req.MinAgents = c.config.Defaults.MinAgents
 	}
 
+	if req.WaitTimeout != "" {
+		req.TestPlan.WaitTimeout = req.WaitTimeout
+	}
+	if req.StartWithAvailable {
+		req.TestPlan.StartWithAvailable = true
+	}
+
+	if errs := ValidateTestPlan(req.TestPlan, req.MinAgents); errs != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid test plan", "details": errs})
+		return
+	}
+
+	if errs := req.Selector.Validate(req.MinAgents); errs != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid agent selector", "details": errs})
+		return
+	}
+
+	if !c.checkSafety(ctx, req.TestPlan, req.Confirm) {
+		return
+	}
+
 	// Create test run
 	testRun := NewTestRun(req.Name, req.TestPlan, req.MinAgents, req.Parameters)
+	testRun.Selector = req.Selector
+	testRun.Confirmed = req.Confirm
 
 	c.mu.Lock()
 	c.testRuns[testRun.ID] = testRun
@@ -50,10 +72,41 @@ func (c *Coordinator) handleCreateTestRun(ctx *gin.Context) {
 	}
 
 	LogInfo("Test run created: %s (ID: %s)", testRun.Name, testRun.ID)
+	auditTarget(ctx, testRun.ID)
+	auditSummary(ctx, "created test run %q: %d endpoints, concurrency %d, duration %s",
+		testRun.Name, len(testRun.TestPlan.Endpoints), testRun.TestPlan.Concurrency, testRun.TestPlan.Duration)
 
 	ctx.JSON(http.StatusCreated, testRun)
 }
 
+func (c *Coordinator) handleValidateTestPlan(ctx *gin.Context) {
+	var plan TestPlan
+	if err := ctx.ShouldBindJSON(&plan); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	minAgents := c.config.Defaults.MinAgents
+	if value := ctx.Query("min_agents"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_agents parameter"})
+			return
+		}
+		minAgents = parsed
+	}
+
+	errs := ValidateTestPlan(plan, minAgents)
+	if errs == nil {
+		errs = PlanValidationErrors{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"valid":  len(errs) == 0,
+		"errors": errs,
+	})
+}
+
 func (c *Coordinator) handleListTestRuns(ctx *gin.Context) {
 	// Try to get from database first, fall back to in-memory
 	testRuns, err := c.database.ListTestRuns(100, 0)
@@ -126,6 +179,17 @@ func (c *Coordinator) handleGetTestRunResults(ctx *gin.Context) {
 func (c *Coordinator) handleStartTestRun(ctx *gin.Context) {
 	testRunID := ctx.Param("id")
 
+	// The body is optional; it only carries queueing options
+	var req StartTestRunRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+	if req.Deadline != nil && !req.Deadline.After(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid queue deadline", "details": "deadline must be in the future"})
+		return
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -144,41 +208,56 @@ func (c *Coordinator) handleStartTestRun(ctx *gin.Context) {
 		return
 	}
 
-	// Check if another test is already running
-	if c.currentTestRun != nil && (c.currentTestRun.Status == TestRunStatusRunning || c.currentTestRun.Status == TestRunStatusWaiting) {
+	if !c.launchTestRun(testRun, req) {
 		ctx.JSON(http.StatusConflict, gin.H{
-			"error":           "Another test run is already active",
-			"active_test_run": c.currentTestRun.ID,
+			"error":            "Matching agents are busy with other test runs",
+			"details":          "start with queue: true to queue the run until they are free",
+			"active_test_runs": c.activeRunSummaries(),
 		})
 		return
 	}
 
-	// Start the test run
-	testRun.Start()
-	c.currentTestRun = testRun
+	LogInfo("Test run started: %s (ID: %s)", testRun.Name, testRun.ID)
+	auditSummary(ctx, "started test run %q (%s)", testRun.Name, testRun.Status)
+
+	ctx.JSON(http.StatusOK, testRun)
+}
+
+// launchTestRun starts a created test run, or queues it when asked to. A run
+// that is not queued waits only for agents to connect: it is refused, and
+// false returned, when matching agents are busy with other runs. Caller must
+// hold c.mu.
+func (c *Coordinator) launchTestRun(testRun *TestRun, req StartTestRunRequest) bool {
+	if req.Queue {
+		testRun.Enqueue(req.Priority, req.Deadline)
+	} else {
+		held := c.heldAgents()
+		if len(c.selectAgentsExcluding(testRun, held)) < testRun.AgentCount && c.agentsBusy(testRun, held) {
+			return false
+		}
+		testRun.Start()
+	}
+	c.activateTestRun(testRun)
 
 	// Save to database
 	if err := c.database.SaveTestRun(testRun); err != nil {
 		LogError("Failed to save started test run to database: %v", err)
 	}
 
-	// Check if we have enough agents to start immediately
-	if len(c.connectedAgents) >= testRun.AgentCount {
-		LogInfo("Starting test run immediately: %s (%d agents available)", testRun.Name, len(c.connectedAgents))
-		go c.startTestRun(testRun)
-	} else {
-		testRun.Status = TestRunStatusWaiting
-		LogInfo("Test run waiting for agents: %s (%d/%d agents)", testRun.Name, len(c.connectedAgents), testRun.AgentCount)
+	// Reserve free matching agents, in queue order, and start if there are enough
+	c.startWaitingRuns()
 
-		// Save status update to database
-		if err := c.database.SaveTestRun(testRun); err != nil {
-			LogError("Failed to save waiting test run to database: %v", err)
-		}
+	switch testRun.Status {
+	case TestRunStatusQueued:
+		LogInfo("Test run queued: %s (priority %d)", testRun.Name, testRun.Priority)
+		c.scheduleQueueDeadline(testRun)
+	case TestRunStatusWaiting:
+		LogInfo("Test run waiting for agents: %s (%d agents required)", testRun.Name, testRun.AgentCount)
 	}
-
-	LogInfo("Test run started: %s (ID: %s)", testRun.Name, testRun.ID)
-
-	ctx.JSON(http.StatusOK, testRun)
+	if testRun.IsPending() {
+		c.scheduleWaitTimeout(testRun)
+	}
+	return true
 }
 
 func (c *Coordinator) handleStopTestRun(ctx *gin.Context) {
@@ -193,7 +272,7 @@ func (c *Coordinator) handleStopTestRun(ctx *gin.Context) {
 		return
 	}
 
-	if testRun.Status != TestRunStatusRunning && testRun.Status != TestRunStatusWaiting {
+	if !testRun.IsActive() {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error":  "Test run is not running",
 			"status": testRun.Status,
@@ -205,6 +284,7 @@ func (c *Coordinator) handleStopTestRun(ctx *gin.Context) {
 	go c.stopTestRun(testRun)
 
 	LogInfo("Test run stop requested: %s (ID: %s)", testRun.Name, testRun.ID)
+	auditSummary(ctx, "stopped test run %q", testRun.Name)
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Test run stop initiated"})
 }
@@ -222,7 +302,7 @@ func (c *Coordinator) handleDeleteTestRun(ctx *gin.Context) {
 	}
 
 	// Only allow deletion of completed, failed, or cancelled test runs
-	if testRun.Status == TestRunStatusRunning || testRun.Status == TestRunStatusWaiting {
+	if testRun.IsActive() {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error":  "Cannot delete active test run",
 			"status": testRun.Status,
@@ -230,11 +310,6 @@ func (c *Coordinator) handleDeleteTestRun(ctx *gin.Context) {
 		return
 	}
 
-	// Clear current test run if this is it
-	if c.currentTestRun != nil && c.currentTestRun.ID == testRunID {
-		c.currentTestRun = nil
-	}
-
 	// Remove from database
 	if err := c.database.DeleteTestRun(testRunID); err != nil {
 		LogError("Failed to delete test run from database: %v", err)
@@ -245,6 +320,7 @@ func (c *Coordinator) handleDeleteTestRun(ctx *gin.Context) {
 	delete(c.agentResults, testRunID)
 
 	LogInfo("Test run deleted: %s (ID: %s)", testRun.Name, testRun.ID)
+	auditSummary(ctx, "deleted test run %q (%s)", testRun.Name, testRun.Status)
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Test run deleted"})
 }
@@ -287,7 +363,7 @@ func (c *Coordinator) handleBulkDeleteTestRuns(ctx *gin.Context) {
 		for id, testRun := range c.testRuns {
 			if string(testRun.Status) == req.Status {
 				// Don't delete active test runs from memory
-				if testRun.Status != TestRunStatusRunning && testRun.Status != TestRunStatusWaiting {
+				if !testRun.IsActive() {
 					delete(c.testRuns, id)
 					delete(c.agentResults, id)
 				}
@@ -295,6 +371,7 @@ func (c *Coordinator) handleBulkDeleteTestRuns(ctx *gin.Context) {
 		}
 
 		LogInfo("Bulk deleted %d test runs with status: %s", deletedCount, req.Status)
+		auditSummary(ctx, "deleted %d test runs with status %s", deletedCount, req.Status)
 		ctx.JSON(http.StatusOK, gin.H{
 			"message":       fmt.Sprintf("Deleted %d test runs with status: %s", deletedCount, req.Status),
 			"deleted_count": deletedCount,
@@ -320,7 +397,7 @@ func (c *Coordinator) handleBulkDeleteTestRuns(ctx *gin.Context) {
 		for id, testRun := range c.testRuns {
 			if testRun.CreatedAt.Before(cutoffTime) {
 				// Don't delete active test runs from memory
-				if testRun.Status != TestRunStatusRunning && testRun.Status != TestRunStatusWaiting {
+				if !testRun.IsActive() {
 					delete(c.testRuns, id)
 					delete(c.agentResults, id)
 				}
@@ -328,6 +405,7 @@ func (c *Coordinator) handleBulkDeleteTestRuns(ctx *gin.Context) {
 		}
 
 		LogInfo("Bulk deleted %d test runs older than: %s", deletedCount, req.OlderThan)
+		auditSummary(ctx, "deleted %d test runs older than %s", deletedCount, req.OlderThan)
 		ctx.JSON(http.StatusOK, gin.H{
 			"message":       fmt.Sprintf("Deleted %d test runs older than %s", deletedCount, req.OlderThan),
 			"deleted_count": deletedCount,
@@ -364,10 +442,7 @@ func (c *Coordinator) handleTestRunStats(ctx *gin.Context) {
 	c.mu.RLock()
 	inMemoryCount := len(c.testRuns)
 	agentResultsCount := len(c.agentResults)
-	var currentTestRun *TestRun
-	if c.currentTestRun != nil {
-		currentTestRun = c.currentTestRun
-	}
+	activeTestRuns := c.activeRunSummaries()
 	c.mu.RUnlock()
 
 	stats := gin.H{
@@ -377,15 +452,7 @@ func (c *Coordinator) handleTestRunStats(ctx *gin.Context) {
 			"test_runs":     inMemoryCount,
 			"agent_results": agentResultsCount,
 		},
-		"current_test_run": nil,
-	}
-
-	if currentTestRun != nil {
-		stats["current_test_run"] = gin.H{
-			"id":     currentTestRun.ID,
-			"name":   currentTestRun.Name,
-			"status": currentTestRun.Status,
-		}
+		"active_test_runs": activeTestRuns,
 	}
 
 	ctx.JSON(http.StatusOK, stats)
@@ -394,17 +461,36 @@ func (c *Coordinator) handleTestRunStats(ctx *gin.Context) {
 func (c *Coordinator) handleRerunTestRun(ctx *gin.Context) {
 	testRunID := ctx.Param("id")
 
+	// The body is optional; it only carries queueing options
+	var req StartTestRunRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+	if req.Deadline != nil && !req.Deadline.After(time.Now()) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid queue deadline", "details": "deadline must be in the future"})
+		return
+	}
+
+	// The policy may have changed since the original run was created
+	c.mu.RLock()
+	originalTestRun, exists := c.testRuns[testRunID]
+	c.mu.RUnlock()
+	if exists && !c.checkSafety(ctx, originalTestRun.TestPlan, originalTestRun.Confirmed) {
+		return
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	originalTestRun, exists := c.testRuns[testRunID]
+	originalTestRun, exists = c.testRuns[testRunID]
 	if !exists {
 		ctx.JSON(http.StatusNotFound, gin.H{"error": "Test run not found"})
 		return
 	}
 
 	// Only allow rerun of completed, failed, or cancelled test runs
-	if originalTestRun.Status == TestRunStatusRunning || originalTestRun.Status == TestRunStatusWaiting || originalTestRun.Status == TestRunStatusCreated {
+	if originalTestRun.IsActive() || originalTestRun.Status == TestRunStatusCreated {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error":  "Can only rerun completed, failed, or cancelled test runs",
 			"status": originalTestRun.Status,
@@ -412,15 +498,6 @@ func (c *Coordinator) handleRerunTestRun(ctx *gin.Context) {
 		return
 	}
 
-	// Check if another test is already running
-	if c.currentTestRun != nil && (c.currentTestRun.Status == TestRunStatusRunning || c.currentTestRun.Status == TestRunStatusWaiting) {
-		ctx.JSON(http.StatusConflict, gin.H{
-			"error":           "Another test run is already active",
-			"active_test_run": c.currentTestRun.ID,
-		})
-		return
-	}
-
 	// Create a new test run based on the original
 	newTestRun := NewTestRun(
 		originalTestRun.Name+" (Rerun)",
@@ -428,39 +505,24 @@ func (c *Coordinator) handleRerunTestRun(ctx *gin.Context) {
 		originalTestRun.AgentCount,
 		originalTestRun.Parameters,
 	)
+	newTestRun.Selector = originalTestRun.Selector
+	newTestRun.Confirmed = originalTestRun.Confirmed
 
-	// Store the new test run
-	c.testRuns[newTestRun.ID] = newTestRun
-
-	// Save to database
-	if err := c.database.SaveTestRun(newTestRun); err != nil {
-		LogError("Failed to save rerun test run to database: %v", err)
-	}
-
-	// Start the new test run immediately
-	newTestRun.Start()
-	c.currentTestRun = newTestRun
-
-	// Save start status to database
-	if err := c.database.SaveTestRun(newTestRun); err != nil {
-		LogError("Failed to save started rerun test run to database: %v", err)
+	// Start the new test run immediately, or queue it
+	if !c.launchTestRun(newTestRun, req) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error":            "Matching agents are busy with other test runs",
+			"details":          "rerun with queue: true to queue the run until they are free",
+			"active_test_runs": c.activeRunSummaries(),
+		})
+		return
 	}
 
-	// Check if we have enough agents to start immediately
-	if len(c.connectedAgents) >= newTestRun.AgentCount {
-		LogInfo("Starting rerun test immediately: %s (%d agents available)", newTestRun.Name, len(c.connectedAgents))
-		go c.startTestRun(newTestRun)
-	} else {
-		newTestRun.Status = TestRunStatusWaiting
-		LogInfo("Rerun test waiting for agents: %s (%d/%d agents)", newTestRun.Name, len(c.connectedAgents), newTestRun.AgentCount)
-
-		// Save waiting status to database
-		if err := c.database.SaveTestRun(newTestRun); err != nil {
-			LogError("Failed to save waiting rerun test run to database: %v", err)
-		}
-	}
+	// Store the new test run
+	c.testRuns[newTestRun.ID] = newTestRun
 
 	LogInfo("Test run rerun started: %s (Original ID: %s, New ID: %s)", newTestRun.Name, testRunID, newTestRun.ID)
+	auditSummary(ctx, "reran test run %q as %s (%s)", originalTestRun.Name, newTestRun.ID, newTestRun.Status)
 
 	ctx.JSON(http.StatusCreated, newTestRun)
 }
@@ -497,6 +559,9 @@ func (c *Coordinator) handleTestConnection(ctx *gin.Context) {
 	if req.Method == "" {
 		req.Method = "GET"
 	}
+	if !c.checkConnectionTarget(ctx, req.URL) {
+		return
+	}
 
 	// Test the connection
 	result := c.testEndpointConnection(req)
@@ -507,11 +572,18 @@ func (c *Coordinator) testEndpointConnection(req TestConnectionRequest) TestConn
 	start := time.Now()
 
 	// Create HTTP client with timeout
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // Allow self-signed certificates for testing
+	}
+	if c.safety != nil {
+		// Redirects are checked against the safety policy too
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialChecked(ctx, network, address, c.safety.checkTarget)
+		}
+	}
 	client := &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // Allow self-signed certificates for testing
-		},
+		Timeout:   10 * time.Second,
+		Transport: transport,
 	}
 
 	// Prepare request body
@@ -591,41 +663,69 @@ func (c *Coordinator) testEndpointConnection(req TestConnectionRequest) TestConn
 }
 
 func (c *Coordinator) startTestRun(testRun *TestRun) {
-	testRun.MarkRunning()
+	// Warn when the plan asks more of an agent than it measured it can deliver
+	c.mu.RLock()
+	agents := c.reservedAgents(testRun.ID)
+	c.mu.RUnlock()
+
+	for _, warning := range capacityWarnings(testRun.TestPlan, agents) {
+		LogWarn("Capacity warning for test run %s: %s", testRun.Name, warning)
+		testRun.Warnings = append(testRun.Warnings, warning)
+	}
 
-	// Send test plan to all connected agents
+	// Send test plan to the run's reserved agents
 	if err := c.broadcastTestStart(testRun); err != nil {
+		// A run stopped while starting has already been dealt with
+		if errors.Is(err, errRunNotActive) {
+			LogInfo("Test run %s was stopped while starting", testRun.Name)
+			return
+		}
 		LogError("Failed to start test run %s: %v", testRun.ID, err)
-		testRun.Fail("Failed to broadcast test start")
+		c.mu.Lock()
+		c.deactivateTestRun(testRun.ID)
+		testRun.Fail(fmt.Sprintf("failed to start: %v", err))
+		c.startWaitingRuns()
+		c.mu.Unlock()
+		if err := c.database.SaveTestRun(testRun); err != nil {
+			LogError("Failed to save failed test run to database: %v", err)
+		}
 		return
 	}
 
-	// Parse test duration and schedule completion
-	duration, err := time.ParseDuration(testRun.TestPlan.Duration)
-	if err != nil {
-		LogWarn("Invalid duration %s, using 1m", testRun.TestPlan.Duration)
-		duration = time.Minute
+	if err := c.database.SaveTestRun(testRun); err != nil {
+		LogError("Failed to save running test run to database: %v", err)
 	}
 
 	// Schedule test completion
-	time.AfterFunc(duration, func() {
-		c.completeTestRun(testRun.ID)
-	})
+	duration := c.scheduleCompletion(testRun)
 
-	LogInfo("Test commands sent to %d agents for test run: %s", len(c.connectedAgents), testRun.Name)
+	LogInfo("Test commands sent to %d agents for test run: %s", countAllocated(testRun.Allocation), testRun.Name)
 	LogInfo("Test will complete automatically in %s", duration)
 }
 
 func (c *Coordinator) stopTestRun(testRun *TestRun) {
-	// Send stop command to agents
-	stopCommand := TestStartCommand{
-		TestRunID: testRun.ID,
-		Command:   "STOP",
-	}
+	// A run still waiting for agents or queued has nothing to stop
+	c.mu.Lock()
+	if testRun.IsPending() {
+		c.deactivateTestRun(testRun.ID)
+		testRun.Cancel()
+
+		// Agents held for this run may now go to runs behind it
+		c.startWaitingRuns()
+		c.mu.Unlock()
 
-	data, _ := json.Marshal(stopCommand)
-	c.natsConn.Publish("armonite.test.command", data)
+		if err := c.database.SaveTestRun(testRun); err != nil {
+			LogError("Failed to save cancelled test run to database: %v", err)
+		}
+		LogInfo("Waiting test run cancelled: %s", testRun.Name)
+		return
+	}
 
 	testRun.Status = TestRunStatusCompleting
-	LogInfo("Stop command sent for test run: %s", testRun.Name)
+	c.mu.Unlock()
+
+	c.sendStopCommands(testRun)
+
+	// Complete once agents have wound down and reported, so they are released for other runs
+	c.awaitFinalReports(testRun.ID)
 }
@@ -45,12 +45,18 @@ export const apiCall = async (endpoint, options = {}) => {
           'Content-Type': 'application/json',
           ...options.headers
         },
+        // Sends the API key cookie set when signing in to the UI
+        credentials: 'include',
         signal: controller.signal,
         ...options
       })
       
       clearTimeout(timeoutId)
       
+      if (response.status === 401) {
+        window.location.href = '/login'
+      }
+
       if (!response.ok) {
         throw new ApiError(`HTTP ${response.status}: ${response.statusText}`, response.status)
       }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var uiLoginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Armonite - Sign in</title></head>
+<body style="font-family: sans-serif; max-width: 360px; margin: 80px auto;">
+<h2>Armonite</h2>
+<form method="POST" action="/login">
+<p><label>API key<br><input type="password" name="api_key" style="width: 100%" autofocus></label></p>
+{{if .}}<p style="color: #b00">{{.}}</p>{{end}}
+<p><button type="submit">Sign in</button></p>
+</form>
+</body>
+</html>`))
+
+// requireUILogin sends browsers without a valid API key cookie to the login page
+func (c *Coordinator) requireUILogin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.config.API.AuthEnabled || strings.HasPrefix(ctx.Request.URL.Path, "/login") {
+			ctx.Next()
+			return
+		}
+
+		if _, err := c.authenticateAPIKey(ctx); err != nil {
+			ctx.Redirect(http.StatusFound, "/login")
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func (c *Coordinator) handleUILoginPage(ctx *gin.Context) {
+	ctx.Status(http.StatusOK)
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	uiLoginPage.Execute(ctx.Writer, "")
+}
+
+// handleUILogin checks the API key and keeps it in a cookie the browser also
+// sends to the API. Any role can sign in; the API enforces what it may do.
+func (c *Coordinator) handleUILogin(ctx *gin.Context) {
+	secret := strings.TrimSpace(ctx.PostForm("api_key"))
+	ctx.Request.Header.Set("X-API-Key", secret)
+	key, err := c.authenticateAPIKey(ctx)
+	if err != nil {
+		ctx.Status(http.StatusUnauthorized)
+		ctx.Header("Content-Type", "text/html; charset=utf-8")
+		uiLoginPage.Execute(ctx.Writer, "Invalid API key")
+		return
+	}
+
+	c.setAPIKeyCookie(ctx, secret, 0)
+	LogInfo("UI sign-in with API key %s (%s)", key.Name, key.Role)
+	ctx.Redirect(http.StatusFound, "/")
+}
+
+func (c *Coordinator) handleUILogout(ctx *gin.Context) {
+	c.setAPIKeyCookie(ctx, "", -1)
+	ctx.Redirect(http.StatusFound, "/login")
+}
+
+// setAPIKeyCookie sets the UI's API key cookie, or deletes it when maxAge is
+// negative. Cookies are not bound to a port, so it reaches the API as well.
+func (c *Coordinator) setAPIKeyCookie(ctx *gin.Context, secret string, maxAge int) {
+	ctx.SetSameSite(http.SameSiteStrictMode)
+	ctx.SetCookie(apiKeyCookie, secret, maxAge, "/", "", ctx.Request.TLS != nil, true)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TestRunEventWaitTimeout is recorded when a run gives up waiting for min_agents
+const TestRunEventWaitTimeout = "wait_timeout"
+
+// scheduleWaitTimeout fails, or starts with the agents available, a pending
+// run that has not found enough agents within the plan's wait_timeout
+func (c *Coordinator) scheduleWaitTimeout(testRun *TestRun) {
+	if testRun.TestPlan.WaitTimeout == "" {
+		return
+	}
+	timeout, err := time.ParseDuration(testRun.TestPlan.WaitTimeout)
+	if err != nil {
+		LogWarn("Invalid wait timeout %s for test run %s", testRun.TestPlan.WaitTimeout, testRun.Name)
+		return
+	}
+
+	time.AfterFunc(time.Until(queueTime(testRun).Add(timeout)), func() {
+		c.expireWaitingRun(testRun.ID)
+	})
+}
+
+func (c *Coordinator) expireWaitingRun(testRunID string) {
+	c.mu.Lock()
+	run, active := c.activeRuns[testRunID]
+	if !active || !run.testRun.IsPending() {
+		c.mu.Unlock()
+		return
+	}
+	testRun := run.testRun
+
+	// Agents held for runs ahead in the queue stay theirs
+	held := make(map[string]bool)
+	for _, pending := range c.pendingRuns() {
+		if pending == run {
+			break
+		}
+		for _, agent := range c.selectAgentsExcluding(pending.testRun, held) {
+			held[agent.ID] = true
+		}
+	}
+	agents := c.selectAgentsExcluding(testRun, held)
+
+	if testRun.TestPlan.StartWithAvailable && len(agents) > 0 {
+		testRun.AddEvent(TestRunEvent{
+			Type: TestRunEventWaitTimeout,
+			Message: fmt.Sprintf("started with %d of %d requested agents after waiting %s",
+				len(agents), testRun.AgentCount, testRun.TestPlan.WaitTimeout),
+		})
+		c.startWithAgents(run, agents)
+		c.mu.Unlock()
+
+		LogWarn("Test run %s started with %d of %d agents after its wait timeout",
+			testRun.Name, len(agents), testRun.AgentCount)
+		return
+	}
+
+	reason := fmt.Sprintf("wait timeout: %d of %d requested agents available after %s",
+		len(agents), testRun.AgentCount, testRun.TestPlan.WaitTimeout)
+	c.deactivateTestRun(testRunID)
+	testRun.AddEvent(TestRunEvent{Type: TestRunEventWaitTimeout, Message: reason})
+	testRun.Fail(reason)
+
+	// Agents held for this run may now go to runs behind it
+	c.startWaitingRuns()
+	c.mu.Unlock()
+
+	if err := c.database.SaveTestRun(testRun); err != nil {
+		LogError("Failed to save timed out test run to database: %v", err)
+	}
+	LogWarn("Test run failed waiting for agents: %s (ID: %s): %s", testRun.Name, testRun.ID, reason)
+}